@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	adminServer    string
+	adminToken     string
+	adminGrantRole string
+)
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Administer users and access on a coordination server",
+	Long: `Manage user roles and workspace grants and read the audit log of a running
+coordination server.
+
+Roles:
+  admin      manage users, roles and server state
+  operator   manage nodes and every workspace
+  developer  create workspaces and use owned or granted ones (default)
+  viewer     read-only access to nodes and granted workspaces
+
+Requests authenticate with --token, NEXUS_COORD_TOKEN or the current login.`,
+}
+
+var adminRoleCmd = &cobra.Command{
+	Use:   "role",
+	Short: "Manage user roles",
+}
+
+var adminRoleSetCmd = &cobra.Command{
+	Use:   "set <username> <role>",
+	Short: "Change the role of a user",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(_ *cobra.Command, args []string) error {
+		if !coordination.IsValidRole(args[1]) {
+			return fmt.Errorf("invalid role %q, must be one of admin, operator, developer, viewer", args[1])
+		}
+
+		if _, err := adminClient().SetUserRole(context.Background(), args[0], args[1]); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ %s is now %s\n", args[0], args[1])
+		return nil
+	},
+}
+
+var adminRoleListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List users and their roles",
+	Args:  cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		users, err := adminClient().ListUsers(context.Background())
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("👥 Users")
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		if len(users) == 0 {
+			fmt.Println("  No users registered")
+		}
+		for _, user := range users {
+			fmt.Printf("  %-30s %s\n", user.Username, user.Role)
+		}
+		return nil
+	},
+}
+
+var adminGrantCmd = &cobra.Command{
+	Use:   "grant",
+	Short: "Manage workspace collaborators",
+}
+
+var adminGrantAddCmd = &cobra.Command{
+	Use:   "add <workspace-id> <username>",
+	Short: "Grant a user access to a workspace",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(_ *cobra.Command, args []string) error {
+		if !coordination.IsValidGrantRole(adminGrantRole) {
+			return fmt.Errorf("invalid grant role %q, must be collaborator or viewer", adminGrantRole)
+		}
+
+		if _, err := adminClient().AddGrant(context.Background(), args[0], args[1], adminGrantRole); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Granted %s %s access to %s\n", args[1], adminGrantRole, args[0])
+		return nil
+	},
+}
+
+var adminGrantRemoveCmd = &cobra.Command{
+	Use:   "remove <workspace-id> <username>",
+	Short: "Revoke a user's access to a workspace",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(_ *cobra.Command, args []string) error {
+		if err := adminClient().RemoveGrant(context.Background(), args[0], args[1]); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Revoked access of %s to %s\n", args[1], args[0])
+		return nil
+	},
+}
+
+var adminGrantListCmd = &cobra.Command{
+	Use:   "list <workspace-id>",
+	Short: "List the users granted access to a workspace",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		grants, err := adminClient().ListGrants(context.Background(), args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("🔑 Grants for %s\n", args[0])
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		if len(grants) == 0 {
+			fmt.Println("  No grants")
+		}
+		for _, grant := range grants {
+			fmt.Printf("  %-30s %-14s granted by %s\n", grant.Username, grant.Role, grant.GrantedBy)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+	adminCmd.AddCommand(adminRoleCmd)
+	adminCmd.AddCommand(adminGrantCmd)
+	adminRoleCmd.AddCommand(adminRoleSetCmd)
+	adminRoleCmd.AddCommand(adminRoleListCmd)
+	adminGrantCmd.AddCommand(adminGrantAddCmd)
+	adminGrantCmd.AddCommand(adminGrantRemoveCmd)
+	adminGrantCmd.AddCommand(adminGrantListCmd)
+
+	adminCmd.PersistentFlags().StringVar(&adminServer, "server", "http://localhost:3001", "Coordination server URL")
+	adminCmd.PersistentFlags().StringVar(&adminToken, "token", os.Getenv("NEXUS_COORD_TOKEN"), "Bearer token for the coordination server")
+	adminGrantAddCmd.Flags().StringVar(&adminGrantRole, "role", coordination.GrantCollaborator, "Grant role (collaborator or viewer)")
+}
+
+func adminClient() *client.Client {
+	return coordinationClient(adminServer, adminToken)
+}
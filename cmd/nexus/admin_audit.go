@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditActor    string
+	auditAction   string
+	auditResource string
+	auditResult   string
+	auditSince    string
+	auditUntil    string
+	auditLimit    int
+	auditJSON     bool
+)
+
+var adminAuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show the audit log of mutating API calls",
+	Long: `Show who changed what on the coordination server, newest first.
+
+Every mutating request is recorded with its actor, action, resource, request ID,
+source IP and result. --since and --until take an RFC 3339 timestamp or a
+duration back from now, such as 24h.`,
+	Example: `  nexus admin audit --actor alice --since 24h
+  nexus admin audit --resource workspaces/ws-1
+  nexus admin audit --action workspaces.delete --result success`,
+	Args: cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		entries, err := adminClient().ListAudit(context.Background(), client.AuditQuery{
+			Actor:    auditActor,
+			Action:   auditAction,
+			Resource: auditResource,
+			Result:   auditResult,
+			Since:    auditSince,
+			Until:    auditUntil,
+			Limit:    auditLimit,
+		})
+		if err != nil {
+			return err
+		}
+
+		if auditJSON {
+			out, _ := json.MarshalIndent(entries, "", "  ")
+			fmt.Println(string(out))
+			return nil
+		}
+
+		fmt.Println("📜 Audit Log")
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		if len(entries) == 0 {
+			fmt.Println("  No entries")
+		}
+		for _, entry := range entries {
+			fmt.Printf("  %s  %s %-16s %-26s %s\n",
+				entry.Timestamp.Local().Format(time.RFC3339), auditResultIcon(entry.Result), entry.Actor, entry.Action, entry.Resource)
+			fmt.Printf("    %s %s → %d  from %s  (%s)\n", entry.Method, entry.Path, entry.Status, entry.SourceIP, entry.RequestID)
+		}
+		return nil
+	},
+}
+
+func init() {
+	adminCmd.AddCommand(adminAuditCmd)
+
+	adminAuditCmd.Flags().StringVar(&auditActor, "actor", "", "Only show requests by this user")
+	adminAuditCmd.Flags().StringVar(&auditAction, "action", "", "Only show this action, e.g. workspaces.delete")
+	adminAuditCmd.Flags().StringVar(&auditResource, "resource", "", "Only show this resource and those below it, e.g. nodes/node-1")
+	adminAuditCmd.Flags().StringVar(&auditResult, "result", "", "Only show success, denied or failure")
+	adminAuditCmd.Flags().StringVar(&auditSince, "since", "", "Only show entries at or after this time")
+	adminAuditCmd.Flags().StringVar(&auditUntil, "until", "", "Only show entries before this time")
+	adminAuditCmd.Flags().IntVar(&auditLimit, "limit", 0, "Maximum number of entries (server default 100)")
+	adminAuditCmd.Flags().BoolVar(&auditJSON, "json", false, "Print entries as JSON")
+}
+
+func auditResultIcon(result string) string {
+	switch result {
+	case coordination.AuditResultSuccess:
+		return "✅"
+	case coordination.AuditResultDenied:
+		return "🚫"
+	default:
+		return "❌"
+	}
+}
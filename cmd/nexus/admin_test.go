@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminCmdsExist(t *testing.T) {
+	for _, args := range [][]string{
+		{"role", "set"}, {"role", "list"},
+		{"grant", "add"}, {"grant", "remove"}, {"grant", "list"},
+	} {
+		cmd, _, err := adminCmd.Find(args)
+		require.NoError(t, err)
+		assert.Equal(t, args[1], cmd.Name())
+	}
+	cmd, _, err := adminCmd.Find([]string{"audit"})
+	require.NoError(t, err)
+	assert.Equal(t, "audit", cmd.Name())
+}
+
+func TestAdminAudit(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/audit", r.URL.Path)
+		query = r.URL.Query()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"entries": []*coordination.AuditEntry{{
+				ID: 1, Actor: "alice", Action: "workspaces.delete", Resource: "workspaces/ws-1",
+				Method: http.MethodDelete, Path: "/api/v1/workspaces/ws-1", Status: 204, Result: coordination.AuditResultSuccess,
+			}},
+		})
+	}))
+	defer server.Close()
+
+	defer func() { adminServer, adminToken, auditActor, auditSince, auditLimit = "", "", "", "", 0 }()
+	adminServer, adminToken = server.URL, "admin-token"
+	auditActor, auditSince, auditLimit = "alice", "24h", 5
+
+	require.NoError(t, adminAuditCmd.RunE(adminAuditCmd, nil))
+	assert.Equal(t, "alice", query.Get("actor"))
+	assert.Equal(t, "24h", query.Get("since"))
+	assert.Equal(t, "5", query.Get("limit"))
+	assert.Empty(t, query.Get("resource"))
+}
+
+func TestAdminGrantAdd(t *testing.T) {
+	var got coordination.GrantRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/v1/workspaces/ws-1/grants", r.URL.Path)
+		assert.Equal(t, "Bearer admin-token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	defer func() { adminServer, adminToken, adminGrantRole = "", "", coordination.GrantCollaborator }()
+	adminServer, adminToken, adminGrantRole = server.URL, "admin-token", coordination.GrantViewer
+
+	require.NoError(t, adminGrantAddCmd.RunE(adminGrantAddCmd, []string{"ws-1", "bob"}))
+	assert.Equal(t, "bob", got.Username)
+	assert.Equal(t, coordination.GrantViewer, got.Role)
+
+	adminGrantRole = "owner"
+	assert.Error(t, adminGrantAddCmd.RunE(adminGrantAddCmd, []string{"ws-1", "bob"}))
+}
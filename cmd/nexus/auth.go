@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/nexus/nexus/pkg/auth"
 	"github.com/nexus/nexus/pkg/config"
 	"github.com/nexus/nexus/pkg/ssh"
 	"github.com/spf13/cobra"
@@ -13,7 +16,7 @@ import (
 var authCmd = &cobra.Command{
 	Use:   "auth",
 	Short: "Manage authentication",
-	Long:  `Manage SSH keys for secure access.`,
+	Long:  `Log in to the coordination server and manage SSH keys for secure access.`,
 }
 
 var authStatusCmd = &cobra.Command{
@@ -37,6 +40,18 @@ func runAuthStatus() error {
 
 	fmt.Println("ℹ️  GitHub authentication is handled via OAuth callback")
 
+	creds, err := credentialStore().Load()
+	switch {
+	case errors.Is(err, auth.ErrNotLoggedIn):
+		fmt.Println("⚠️  Not logged in (run 'nexus auth login')")
+	case err != nil:
+		fmt.Printf("⚠️  %v\n", err)
+	case creds.Expired(time.Now()) && creds.RefreshToken == "":
+		fmt.Printf("⚠️  Session for %s expired (run 'nexus auth login')\n", creds.Username)
+	default:
+		fmt.Printf("✅ Logged in as %s (%s)\n", creds.Username, creds.Issuer)
+	}
+
 	configPath := config.GetUserConfigPath()
 	if _, err := os.Stat(configPath); err == nil {
 		userCfg, err := config.LoadUserConfig(configPath)
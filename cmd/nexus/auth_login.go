@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/nexus/nexus/pkg/auth"
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
+)
+
+var (
+	loginIssuer   string
+	loginClientID string
+)
+
+var authLoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in to the coordination server",
+	Long: `Log in with the OAuth 2.0 device authorization flow.
+
+The command prints a URL and a code to enter in a browser on any device, so it
+works over SSH. Tokens are stored in ~/.config/nexus/credentials.json, readable
+only by you, and refreshed automatically when they expire.
+
+The issuer and client ID default to the auth.oidc section of the coordination
+config, falling back to the hosted Nexus identity provider.`,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return runAuthLogin()
+	},
+}
+
+var authLogoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Log out and revoke stored tokens",
+	Long:  `Revoke the stored refresh and access tokens at the issuer and remove them from this machine.`,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return runAuthLogout()
+	},
+}
+
+func init() {
+	authCmd.AddCommand(authLoginCmd)
+	authCmd.AddCommand(authLogoutCmd)
+
+	authLoginCmd.Flags().StringVar(&loginIssuer, "issuer", "", "OIDC issuer URL")
+	authLoginCmd.Flags().StringVar(&loginClientID, "client-id", "", "OIDC client ID")
+}
+
+// credentialStore returns the store holding the tokens of the current login
+func credentialStore() *auth.CredentialStore {
+	return auth.NewCredentialStore(auth.DefaultCredentialsPath())
+}
+
+// loginOIDCConfig resolves the issuer to log in against from flags and the coordination config
+func loginOIDCConfig() *auth.Config {
+	cfg := &auth.Config{}
+	if coordCfg, err := coordination.LoadConfig(coordination.GetConfigPath()); err == nil && coordCfg.Auth.OIDC != nil {
+		cfg.Issuer = coordCfg.Auth.OIDC.Issuer
+		cfg.ClientID = coordCfg.Auth.OIDC.ClientID
+		cfg.SkipTLSVerify = coordCfg.Auth.OIDC.SkipTLSVerify
+	}
+
+	if loginIssuer != "" {
+		cfg.Issuer = loginIssuer
+	}
+	if loginClientID != "" {
+		cfg.ClientID = loginClientID
+	}
+	cfg.Scopes = auth.DeviceScopes
+	return cfg
+}
+
+func runAuthLogin() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	provider, err := auth.NewOIDCProvider(ctx, loginOIDCConfig())
+	if err != nil {
+		return err
+	}
+
+	response, err := provider.DeviceAuth(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("🔐 Nexus Login")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("  Open:       %s\n", response.VerificationURI)
+	fmt.Printf("  Enter code: %s\n", response.UserCode)
+	if response.VerificationURIComplete != "" {
+		fmt.Println("")
+		fmt.Printf("  Or open:    %s\n", response.VerificationURIComplete)
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println("⏳ Waiting for approval...")
+
+	token, err := provider.DeviceAccessToken(ctx, response)
+	if err != nil {
+		return err
+	}
+
+	creds := auth.NewCredentials(provider.GetConfig(), token)
+	if creds.IDToken != "" {
+		claims, err := provider.VerifyIDToken(ctx, creds.IDToken)
+		if err != nil {
+			return err
+		}
+		creds.Username = claims.PreferredName()
+	}
+	if creds.RefreshToken == "" {
+		fmt.Println("⚠️  The issuer did not return a refresh token; you will need to log in again when the session expires")
+	}
+
+	store := credentialStore()
+	if err := store.Save(creds); err != nil {
+		return err
+	}
+
+	if creds.Username != "" {
+		fmt.Printf("✅ Logged in as %s\n", creds.Username)
+	} else {
+		fmt.Println("✅ Logged in")
+	}
+	fmt.Printf("  Credentials: %s\n", store.Path())
+	return nil
+}
+
+func runAuthLogout() error {
+	store := credentialStore()
+	creds, err := store.Load()
+	if errors.Is(err, auth.ErrNotLoggedIn) {
+		fmt.Println("ℹ️  Not logged in")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provider, err := auth.NewOIDCProvider(ctx, &auth.Config{Issuer: creds.Issuer, ClientID: creds.ClientID})
+	if err != nil {
+		fmt.Printf("⚠️  Could not reach issuer, tokens were not revoked: %v\n", err)
+	} else {
+		for _, token := range []string{creds.RefreshToken, creds.AccessToken} {
+			if token == "" {
+				continue
+			}
+			if err := provider.RevokeToken(ctx, token); err != nil {
+				fmt.Printf("⚠️  %v\n", err)
+			}
+		}
+	}
+
+	if err := store.Delete(); err != nil {
+		return err
+	}
+
+	fmt.Println("✅ Logged out")
+	if provider != nil && creds.IDToken != "" {
+		fmt.Println("")
+		fmt.Println("To also end your browser session, open:")
+		fmt.Printf("  %s\n", provider.EndSession(ctx, creds.IDToken, ""))
+	}
+	return nil
+}
+
+// refreshCredentials exchanges the stored refresh token at the issuer that issued it
+func refreshCredentials(ctx context.Context, creds *auth.Credentials) (*oauth2.Token, error) {
+	provider, err := auth.NewOIDCProvider(ctx, &auth.Config{Issuer: creds.Issuer, ClientID: creds.ClientID, Scopes: auth.DeviceScopes})
+	if err != nil {
+		return nil, err
+	}
+	return provider.RefreshToken(ctx, creds.RefreshToken)
+}
+
+// loginBearerToken returns the token of the current login, refreshed if needed,
+// or an empty string when the user has not logged in
+func loginBearerToken() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	token, err := credentialStore().Token(ctx, refreshCredentials)
+	if errors.Is(err, auth.ErrNotLoggedIn) {
+		return "", nil
+	}
+	return token, err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nexus/nexus/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthLoginCmdsExist(t *testing.T) {
+	for _, name := range []string{"login", "logout", "status"} {
+		cmd, _, err := authCmd.Find([]string{name})
+		require.NoError(t, err)
+		assert.Equal(t, name, cmd.Name())
+	}
+}
+
+func TestLoginBearerToken(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+
+	token, err := loginBearerToken()
+	require.NoError(t, err)
+	assert.Empty(t, token, "no token is sent before logging in")
+
+	store := credentialStore()
+	assert.Equal(t, filepath.Join(home, ".config", "nexus", "credentials.json"), store.Path())
+	idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, store.Save(&auth.Credentials{
+		Issuer:      "http://127.0.0.1:1",
+		AccessToken: "access",
+		IDToken:     idToken,
+		Expiry:      time.Now().Add(time.Hour),
+	}))
+
+	token, err = loginBearerToken()
+	require.NoError(t, err)
+	assert.Equal(t, idToken, token)
+
+	// Logging out without a reachable issuer still removes the local credentials
+	require.NoError(t, runAuthLogout())
+	_, err = store.Load()
+	assert.ErrorIs(t, err, auth.ErrNotLoggedIn)
+}
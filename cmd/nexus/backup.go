@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	backupDBPath  string
+	restoreForce  bool
+	exportServer  string
+	exportToken   string
+	exportDBPath  string
+	exportSecrets bool
+)
+
+var coordinationBackupCmd = &cobra.Command{
+	Use:   "backup <file>",
+	Short: "Back up the coordination database",
+	Long: `Copy the coordination SQLite database to a file using the SQLite online backup API.
+The server can keep running while the backup is taken.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		dbPath, err := resolveCoordinationDBPath(backupDBPath)
+		if err != nil {
+			return err
+		}
+		if err := coordination.BackupSQLite(dbPath, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Backed up %s to %s\n", dbPath, args[0])
+		return nil
+	},
+}
+
+var coordinationRestoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Restore the coordination database from a backup",
+	Long: `Replace the coordination SQLite database with the contents of a backup file.
+Stop the coordination server before restoring. An existing database is only
+overwritten with --force.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		dbPath, err := resolveCoordinationDBPath(backupDBPath)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(dbPath); err == nil && !restoreForce {
+			return fmt.Errorf("database already exists at %s, use --force to overwrite it", dbPath)
+		}
+		if err := coordination.RestoreSQLite(args[0], dbPath); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Restored %s from %s\n", dbPath, args[0])
+		return nil
+	},
+}
+
+var coordinationExportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Export coordination state as JSON",
+	Long: `Export nodes, users, workspaces, services, GitHub installations and forks as JSON.
+With --server the state is fetched from a running coordination server, which also
+works for the in-memory registry. Otherwise the SQLite database is read directly.
+GitHub installation tokens are left out unless --include-secrets is given; users of
+installations imported without a token connect GitHub again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		return runCoordinationExport(args[0])
+	},
+}
+
+var coordinationImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import coordination state from JSON",
+	Long: `Import a JSON export into a running coordination server (--server) or directly
+into the SQLite database. Existing users and workspaces are kept.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		return runCoordinationImport(args[0])
+	},
+}
+
+func init() {
+	coordinationCmd.AddCommand(coordinationBackupCmd)
+	coordinationCmd.AddCommand(coordinationRestoreCmd)
+	coordinationCmd.AddCommand(coordinationExportCmd)
+	coordinationCmd.AddCommand(coordinationImportCmd)
+
+	coordinationBackupCmd.Flags().StringVar(&backupDBPath, "db", "", "Path to the coordination database")
+	coordinationRestoreCmd.Flags().StringVar(&backupDBPath, "db", "", "Path to the coordination database")
+	coordinationRestoreCmd.Flags().BoolVar(&restoreForce, "force", false, "Overwrite an existing database")
+
+	for _, cmd := range []*cobra.Command{coordinationExportCmd, coordinationImportCmd} {
+		cmd.Flags().StringVar(&exportServer, "server", "", "Coordination server URL (e.g. http://localhost:3001)")
+		cmd.Flags().StringVar(&exportToken, "token", os.Getenv("NEXUS_COORD_TOKEN"), "Bearer token for the coordination server")
+		cmd.Flags().StringVar(&exportDBPath, "db", "", "Path to the coordination database")
+	}
+	coordinationExportCmd.Flags().BoolVar(&exportSecrets, "include-secrets", false, "Include GitHub installation tokens in the export")
+}
+
+// resolveCoordinationDBPath returns the explicit path or the one configured for the coordination server
+func resolveCoordinationDBPath(explicit string) (string, error) {
+	if explicit != "" {
+		return explicit, nil
+	}
+
+	cfg, err := coordination.LoadConfig(coordination.GetConfigPath())
+	if err != nil {
+		return "", fmt.Errorf("failed to load config: %w", err)
+	}
+	return coordination.DatabasePath(cfg.Registry.Storage), nil
+}
+
+func runCoordinationExport(file string) error {
+	var data []byte
+
+	if exportServer != "" {
+		snapshot, err := coordinationClient(exportServer, exportToken).ExportState(context.Background(), exportSecrets)
+		if err != nil {
+			return err
+		}
+		data, err = json.MarshalIndent(snapshot, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode snapshot: %w", err)
+		}
+	} else {
+		dbPath, err := resolveCoordinationDBPath(exportDBPath)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+			return fmt.Errorf("database not found: %s", dbPath)
+		}
+
+		registry, err := coordination.NewSQLiteRegistry(dbPath)
+		if err != nil {
+			return err
+		}
+		defer registry.Close()
+
+		snapshot, err := coordination.ExportSnapshot(registry, registry.GetWorkspaceRegistry())
+		if err != nil {
+			return err
+		}
+		if !exportSecrets {
+			snapshot.Redact()
+		}
+		data, err = json.MarshalIndent(snapshot, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode snapshot: %w", err)
+		}
+	}
+
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	fmt.Printf("✅ Exported coordination state to %s\n", file)
+	return nil
+}
+
+func runCoordinationImport(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read export: %w", err)
+	}
+
+	var snapshot coordination.Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse export: %w", err)
+	}
+
+	var result coordination.ImportResult
+	if exportServer != "" {
+		imported, err := coordinationClient(exportServer, exportToken).ImportState(context.Background(), &snapshot)
+		if err != nil {
+			return err
+		}
+		result = *imported
+	} else {
+		dbPath, err := resolveCoordinationDBPath(exportDBPath)
+		if err != nil {
+			return err
+		}
+		registry, err := coordination.NewSQLiteRegistry(dbPath)
+		if err != nil {
+			return err
+		}
+		defer registry.Close()
+
+		imported, err := coordination.ImportSnapshot(registry, registry.GetWorkspaceRegistry(), &snapshot)
+		if err != nil {
+			return err
+		}
+		result = *imported
+	}
+
+	fmt.Println("✅ Import complete")
+	fmt.Printf("  Nodes:                %d\n", result.Nodes)
+	fmt.Printf("  Users:                %d\n", result.Users)
+	fmt.Printf("  Workspaces:           %d\n", result.Workspaces)
+	fmt.Printf("  Services:             %d\n", result.Services)
+	fmt.Printf("  GitHub installations: %d\n", result.GitHubInstallations)
+	fmt.Printf("  GitHub forks:         %d\n", result.GitHubForks)
+	fmt.Printf("  Skipped (existing):   %d\n", result.Skipped)
+	return nil
+}
+
+// coordinationClient returns a client for the coordination server at server.
+// Without a token requests carry the bearer token of the current login.
+func coordinationClient(server, token string) *client.Client {
+	if token != "" {
+		return client.New(server, client.WithToken(token))
+	}
+	return client.New(server, client.WithTokenSource(loginBearerToken))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoordinationBackupCmdsExist(t *testing.T) {
+	for _, name := range []string{"backup", "restore", "export", "import"} {
+		cmd, _, err := coordinationCmd.Find([]string{name})
+		require.NoError(t, err)
+		assert.Equal(t, name, cmd.Name())
+	}
+}
+
+func TestCoordinationExportImportOffline(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.db")
+	destPath := filepath.Join(dir, "dest.db")
+	exportPath := filepath.Join(dir, "export.json")
+
+	registry, err := coordination.NewSQLiteRegistry(srcPath)
+	require.NoError(t, err)
+	require.NoError(t, registry.GetWorkspaceRegistry().Create(&coordination.DBWorkspace{
+		WorkspaceID:   "ws-1",
+		UserID:        "user-1",
+		WorkspaceName: "feature",
+		Status:        "running",
+	}))
+	require.NoError(t, registry.Close())
+
+	defer func() { exportDBPath, exportServer = "", "" }()
+
+	exportDBPath = srcPath
+	require.NoError(t, runCoordinationExport(exportPath))
+
+	exportDBPath = destPath
+	require.NoError(t, runCoordinationImport(exportPath))
+
+	imported, err := coordination.NewSQLiteRegistry(destPath)
+	require.NoError(t, err)
+	defer imported.Close()
+
+	ws, err := imported.GetWorkspaceRegistry().Get("ws-1")
+	require.NoError(t, err)
+	assert.Equal(t, "feature", ws.WorkspaceName)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	branchExtendServer string
+	branchExtendToken  string
+	branchExtendFor    time.Duration
+)
+
+var branchExtendCmd = &cobra.Command{
+	Use:   "extend <workspace-id>",
+	Short: "Keep a remote workspace alive for longer",
+	Long: `Keep a workspace on the coordination server alive regardless of inactivity.
+
+The server stops workspaces that stay idle and deletes workspaces that outlive their
+TTL. Extending a workspace defers both until the extension ends.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		c := coordinationClient(branchExtendServer, branchExtendToken)
+		resp, err := c.ExtendWorkspace(context.Background(), args[0], branchExtendFor.String())
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Extended workspace %s\n", resp.WorkspaceID)
+		fmt.Printf("  Kept alive until: %s\n", resp.KeepAliveUntil.Local().Format(time.RFC3339))
+		if resp.IdleStopAt != nil {
+			fmt.Printf("  Idle stop after:  %s\n", resp.IdleStopAt.Local().Format(time.RFC3339))
+		}
+		if resp.ExpiresAt != nil {
+			fmt.Printf("  Expires:          %s\n", resp.ExpiresAt.Local().Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+func init() {
+	branchCmd.AddCommand(branchExtendCmd)
+
+	branchExtendCmd.Flags().DurationVar(&branchExtendFor, "for", 8*time.Hour, "How long to keep the workspace alive")
+	branchExtendCmd.Flags().StringVar(&branchExtendServer, "server", "http://localhost:3001", "Coordination server URL")
+	branchExtendCmd.Flags().StringVar(&branchExtendToken, "token", os.Getenv("NEXUS_COORD_TOKEN"), "Bearer token for the coordination server")
+}
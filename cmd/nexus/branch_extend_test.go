@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBranchExtend(t *testing.T) {
+	cmd, _, err := branchCmd.Find([]string{"extend"})
+	require.NoError(t, err)
+	assert.Equal(t, "extend", cmd.Name())
+
+	var got coordination.ExtendWorkspaceRequest
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.Method + " " + r.URL.Path
+		assert.Equal(t, "Bearer user-token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		json.NewEncoder(w).Encode(coordination.ExtendWorkspaceResponse{WorkspaceID: "ws-1", KeepAliveUntil: time.Now().Add(time.Hour)})
+	}))
+	defer server.Close()
+
+	defer func() { branchExtendServer, branchExtendToken, branchExtendFor = "", "", 8*time.Hour }()
+	branchExtendServer, branchExtendToken, branchExtendFor = server.URL, "user-token", time.Hour
+
+	require.NoError(t, branchExtendCmd.RunE(branchExtendCmd, []string{"ws-1"}))
+	assert.Equal(t, "POST /api/v1/workspaces/ws-1/extend", path)
+	assert.Equal(t, "1h0m0s", got.Duration)
+}
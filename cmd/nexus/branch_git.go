@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/spf13/cobra"
+)
+
+var (
+	branchGitServer string
+	branchGitToken  string
+	branchGitRemote string
+	branchGitCreate bool
+)
+
+var branchGitCmd = &cobra.Command{
+	Use:   "git <workspace-id> <status|fetch|checkout|pull|push> [branch]",
+	Short: "Run git in a remote workspace",
+	Long: `Run a git operation in the checkout of a workspace on its node.
+
+The node authenticates to GitHub with the workspace owner's installation token, so
+fetch, pull and push work against private repositories. checkout requires a branch;
+pull and push default to the current branch.`,
+	Example: `  nexus branch git ws-123 status
+  nexus branch git ws-123 checkout -b feature/login
+  nexus branch git ws-123 push`,
+	Args:      cobra.RangeArgs(2, 3),
+	ValidArgs: []string{coordination.GitStatus, coordination.GitFetch, coordination.GitCheckout, coordination.GitPull, coordination.GitPush},
+	RunE: func(_ *cobra.Command, args []string) error {
+		req := &coordination.WorkspaceGitRequest{
+			Operation: args[1],
+			Remote:    branchGitRemote,
+			Create:    branchGitCreate,
+		}
+		if len(args) == 3 {
+			req.Branch = args[2]
+		}
+
+		c := coordinationClient(branchGitServer, branchGitToken)
+		result, err := c.WorkspaceGit(context.Background(), args[0], req)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("📋 git %s in workspace %s on node %s: %s\n", result.Operation, result.WorkspaceID, result.NodeID, result.Status)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Print(result.Output)
+		if len(result.Output) > 0 && result.Output[len(result.Output)-1] != '\n' {
+			fmt.Println()
+		}
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		if result.Status == "success" {
+			return nil
+		}
+		if result.Error != "" {
+			return fmt.Errorf("git %s %s: %s", result.Operation, result.Status, result.Error)
+		}
+		return fmt.Errorf("git %s %s", result.Operation, result.Status)
+	},
+}
+
+func init() {
+	branchCmd.AddCommand(branchGitCmd)
+
+	branchGitCmd.Flags().StringVar(&branchGitRemote, "remote", "origin", "Remote to fetch from, pull from or push to")
+	branchGitCmd.Flags().BoolVarP(&branchGitCreate, "create", "b", false, "Create the branch on checkout")
+	branchGitCmd.Flags().StringVar(&branchGitServer, "server", "http://localhost:3001", "Coordination server URL")
+	branchGitCmd.Flags().StringVar(&branchGitToken, "token", os.Getenv("NEXUS_COORD_TOKEN"), "Bearer token for the coordination server")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBranchGit(t *testing.T) {
+	var got coordination.WorkspaceGitRequest
+	var path string
+	status := "success"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.Method + " " + r.URL.Path
+		got = coordination.WorkspaceGitRequest{}
+		assert.Equal(t, "Bearer user-token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		json.NewEncoder(w).Encode(coordination.WorkspaceGitResult{
+			WorkspaceID: "ws-1",
+			NodeID:      "node-1",
+			Operation:   got.Operation,
+			Status:      status,
+			Output:      "Switched to a new branch 'feature'\n",
+			Error:       "exit status 1",
+		})
+	}))
+	defer server.Close()
+
+	defer func() { branchGitServer, branchGitToken, branchGitRemote, branchGitCreate = "", "", "origin", false }()
+	branchGitServer, branchGitToken, branchGitCreate = server.URL, "user-token", true
+
+	require.NoError(t, branchGitCmd.RunE(branchGitCmd, []string{"ws-1", "checkout", "feature"}))
+	assert.Equal(t, "POST /api/v1/workspaces/ws-1/git", path)
+	assert.Equal(t, coordination.WorkspaceGitRequest{Operation: "checkout", Branch: "feature", Remote: "origin", Create: true}, got)
+
+	status = "failed"
+	branchGitCreate = false
+	err := branchGitCmd.RunE(branchGitCmd, []string{"ws-1", "push"})
+	assert.EqualError(t, err, "git push failed: exit status 1")
+	assert.Empty(t, got.Branch)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,6 +17,7 @@ import (
 	"github.com/nexus/nexus/pkg/agent"
 	"github.com/nexus/nexus/pkg/config"
 	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
 	"github.com/nexus/nexus/pkg/ctrl"
 	"github.com/nexus/nexus/pkg/metrics"
 	"github.com/nexus/nexus/pkg/paths"
@@ -360,9 +360,9 @@ Stops any running server, then starts a new instance with the same configuration
 		defer cancel()
 
 		fmt.Println("Monitoring startup (15 seconds)...")
+		coord := client.New("http://localhost:3001")
 		for i := 0; i < 15; i++ {
-			resp, err := http.Get("http://localhost:3001/health")
-			if err == nil && resp.StatusCode == 200 {
+			if _, err := coord.Health(ctx); err == nil {
 				fmt.Println("✅ Server is healthy!")
 				return nil
 			}
@@ -395,18 +395,16 @@ var agentStartCmd = &cobra.Command{
 The node agent provides branch execution capabilities on this machine.`,
 	RunE: func(_ *cobra.Command, _ []string) error {
 		fmt.Println("Starting node agent...")
+		cfg, err := agent.LoadConfig(agent.GetConfigPath())
+		if err != nil {
+			return fmt.Errorf("failed to load agent config: %w", err)
+		}
 		// Get coordination URL from environment or config
-		coordURL := os.Getenv("LOOM_COORD_URL")
-		if coordURL == "" {
-			coordURL = "http://localhost:3001"
+		if coordURL := os.Getenv("LOOM_COORD_URL"); coordURL != "" {
+			cfg.CoordinationURL = coordURL
 		}
-		cfg := agent.NodeConfig{
-			CoordinationURL: coordURL,
-			Heartbeat: agent.HeartbeatConfig{
-				Interval: 30 * time.Second,
-				Timeout:  10 * time.Second,
-				Retries:  3,
-			},
+		if agentEnrollToken != "" {
+			cfg.EnrollmentToken = agentEnrollToken
 		}
 		agnt, err := agent.NewAgent(cfg)
 		if err != nil {
@@ -564,21 +562,10 @@ var sshRegisterCmd = &cobra.Command{
 		}
 
 		// Register user via API
-		url := fmt.Sprintf("http://%s:%d/api/v1/users", host, port)
-		payload := map[string]string{
-			"username":   username,
-			"public_key": strings.TrimSpace(pubKey),
-		}
-		jsonPayload, _ := json.Marshal(payload)
-
-		resp, err := http.Post(url, "application/json", strings.NewReader(string(jsonPayload)))
-		if err != nil {
-			return fmt.Errorf("failed to connect to server: %w", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-			return fmt.Errorf("registration failed with status: %d", resp.StatusCode)
+		coord := client.New(fmt.Sprintf("http://%s:%d", host, port))
+		user := &coordination.User{Username: username, PublicKey: strings.TrimSpace(pubKey)}
+		if _, err := coord.RegisterUser(context.Background(), user); err != nil {
+			return fmt.Errorf("registration failed: %w", err)
 		}
 
 		fmt.Println("✅ Successfully registered with coordination server!")
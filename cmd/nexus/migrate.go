@@ -0,0 +1,212 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateDBPath string
+	migrateDryRun bool
+	migrateTo     int
+)
+
+var coordinationMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage coordination database migrations",
+	Long: `Inspect, apply and roll back coordination server database migrations.
+The database defaults to the registry storage from the coordination config:
+PostgreSQL when storage.type is postgres, otherwise the SQLite path, then DB_PATH,
+then the project data directory.`,
+}
+
+var coordinationMigrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show applied and pending migrations",
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return runMigrateStatus()
+	},
+}
+
+var coordinationMigrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply pending migrations",
+	Long: `Apply pending migrations up to the latest version, or up to --to.
+Use --dry-run to list the migrations without applying them.`,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		return runMigrateUp()
+	},
+}
+
+var coordinationMigrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Roll back migrations",
+	Long: `Roll back the most recent migration, or every migration newer than --to.
+Use --dry-run to list the migrations without rolling them back.`,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		return runMigrateDown(cmd.Flags().Changed("to"))
+	},
+}
+
+func init() {
+	coordinationCmd.AddCommand(coordinationMigrateCmd)
+	coordinationMigrateCmd.AddCommand(coordinationMigrateStatusCmd)
+	coordinationMigrateCmd.AddCommand(coordinationMigrateUpCmd)
+	coordinationMigrateCmd.AddCommand(coordinationMigrateDownCmd)
+
+	coordinationMigrateCmd.PersistentFlags().StringVar(&migrateDBPath, "db", "", "Path to the coordination database")
+	coordinationMigrateUpCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "List migrations without applying them")
+	coordinationMigrateUpCmd.Flags().IntVar(&migrateTo, "to", 0, "Target version (default: latest)")
+	coordinationMigrateDownCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "List migrations without rolling them back")
+	coordinationMigrateDownCmd.Flags().IntVar(&migrateTo, "to", 0, "Target version (default: previous version)")
+}
+
+// openMigrator opens the coordination database and returns a migrator for it.
+// PostgreSQL is used when the coordination config selects it and --db isn't given.
+func openMigrator() (*coordination.Migrator, *sql.DB, string, error) {
+	dbPath := migrateDBPath
+	if dbPath == "" {
+		cfg, err := coordination.LoadConfig(coordination.GetConfigPath())
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to load config: %w", err)
+		}
+
+		if cfg.Registry.Storage.Type == "postgres" {
+			dsn := coordination.PostgresDSN(cfg.Registry.Storage)
+			if dsn == "" {
+				return nil, nil, "", fmt.Errorf("postgres storage requires a dsn")
+			}
+			db, err := coordination.OpenPostgresDatabase(dsn)
+			if err != nil {
+				return nil, nil, "", err
+			}
+			return coordination.NewPostgresMigrator(db, coordination.Migrations()), db, "postgres", nil
+		}
+
+		dbPath = coordination.DatabasePath(cfg.Registry.Storage)
+	}
+
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return nil, nil, "", fmt.Errorf("database not found: %s", dbPath)
+	}
+
+	db, err := coordination.OpenSQLiteDatabase(dbPath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return coordination.NewMigrator(db, coordination.Migrations()), db, dbPath, nil
+}
+
+func runMigrateStatus() error {
+	migrator, db, dbPath, err := openMigrator()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	current, err := migrator.CurrentVersion()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("🗄️  Coordination Database Migrations")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("Database: %s\n", dbPath)
+	fmt.Printf("Version:  %d (latest %d)\n", current, migrator.LatestVersion())
+	fmt.Println("")
+	for _, status := range statuses {
+		state := "pending"
+		switch {
+		case status.Unknown:
+			state = "unknown to this binary"
+		case status.Modified:
+			state = "applied, checksum mismatch"
+		case status.Applied:
+			state = fmt.Sprintf("applied %s", status.AppliedAt.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Printf("  %4d  %-24s %s\n", status.Version, status.Name, state)
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	return nil
+}
+
+func runMigrateUp() error {
+	migrator, db, dbPath, err := openMigrator()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := migrator.Up(migrateTo, migrateDryRun)
+	if err != nil {
+		return err
+	}
+
+	printMigrations(dbPath, "Applied", "Would apply", applied)
+	return nil
+}
+
+func runMigrateDown(targetSet bool) error {
+	migrator, db, dbPath, err := openMigrator()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	target := migrateTo
+	if !targetSet {
+		current, err := migrator.CurrentVersion()
+		if err != nil {
+			return err
+		}
+		target = previousMigrationVersion(coordination.Migrations(), current)
+	}
+
+	reverted, err := migrator.Down(target, migrateDryRun)
+	if err != nil {
+		return err
+	}
+
+	printMigrations(dbPath, "Rolled back", "Would roll back", reverted)
+	return nil
+}
+
+// previousMigrationVersion returns the highest known version below current
+func previousMigrationVersion(migrations []coordination.Migration, current int) int {
+	previous := 0
+	for _, migration := range migrations {
+		if migration.Version < current && migration.Version > previous {
+			previous = migration.Version
+		}
+	}
+	return previous
+}
+
+func printMigrations(dbPath, verb, dryRunVerb string, migrations []coordination.Migration) {
+	if migrateDryRun {
+		verb = dryRunVerb
+	}
+
+	fmt.Printf("Database: %s\n", dbPath)
+	if len(migrations) == 0 {
+		fmt.Println("✅ Nothing to do, database is up to date")
+		return
+	}
+	for _, migration := range migrations {
+		fmt.Printf("  %s %d %s\n", verb, migration.Version, migration.Name)
+	}
+	if !migrateDryRun {
+		fmt.Printf("✅ %s %d migration(s)\n", verb, len(migrations))
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoordinationMigrateCmdExists(t *testing.T) {
+	assert.NotNil(t, coordinationMigrateCmd)
+	assert.Equal(t, "migrate", coordinationMigrateCmd.Use)
+	assert.Len(t, coordinationMigrateCmd.Commands(), 3)
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	dbPath := t.TempDir() + "/nexus.db"
+	db, err := coordination.OpenSQLiteDatabase(dbPath)
+	require.NoError(t, err)
+	db.Close()
+
+	migrateDBPath = dbPath
+	defer func() { migrateDBPath, migrateDryRun, migrateTo = "", false, 0 }()
+
+	require.NoError(t, runMigrateUp())
+	require.NoError(t, runMigrateStatus())
+	require.NoError(t, runMigrateDown(false))
+
+	migrator, db, _, err := openMigrator()
+	require.NoError(t, err)
+	defer db.Close()
+
+	current, err := migrator.CurrentVersion()
+	require.NoError(t, err)
+	assert.Equal(t, migrator.LatestVersion()-1, current)
+}
+
+func TestPreviousMigrationVersion(t *testing.T) {
+	migrations := []coordination.Migration{{Version: 1}, {Version: 2}, {Version: 5}}
+	assert.Equal(t, 2, previousMigrationVersion(migrations, 5))
+	assert.Equal(t, 0, previousMigrationVersion(migrations, 1))
+	assert.Equal(t, 0, previousMigrationVersion(migrations, 0))
+}
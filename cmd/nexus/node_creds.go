@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/spf13/cobra"
+)
+
+var (
+	nodeCredServer   string
+	nodeCredToken    string
+	nodeEnrollID     string
+	nodeEnrollExpiry time.Duration
+	agentEnrollToken string
+)
+
+var nodeEnrollCmd = &cobra.Command{
+	Use:   "enroll",
+	Short: "Create a one-time enrollment token for a new node",
+	Long: `Create a one-time enrollment token for a node agent.
+
+Start the agent with the token (--enrollment-token or NEXUS_ENROLLMENT_TOKEN) and it
+registers once, receiving its own credential bound to its node ID. The agent stores
+the credential in its cache directory and rotates it automatically.`,
+	Args: cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		c := coordinationClient(nodeCredServer, nodeCredToken)
+		resp, err := c.CreateEnrollmentToken(context.Background(), &coordination.CreateEnrollmentTokenRequest{
+			NodeID:    nodeEnrollID,
+			ExpiresIn: nodeEnrollExpiry.String(),
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Created enrollment token %s\n", resp.EnrollmentToken.ID)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Printf("  %s\n", resp.Token)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		if resp.EnrollmentToken.NodeID != "" {
+			fmt.Printf("  Node:    %s\n", resp.EnrollmentToken.NodeID)
+		}
+		fmt.Printf("  Expires: %s\n", resp.EnrollmentToken.ExpiresAt.Local().Format(time.RFC3339))
+		fmt.Println("\nOn the new node run:")
+		fmt.Println("  nexus agent start --enrollment-token <token>")
+		return nil
+	},
+}
+
+var nodeRevokeCmd = &cobra.Command{
+	Use:   "revoke <node-id>",
+	Short: "Revoke the credential of a node",
+	Long: `Revoke the credential of a single node. The node is rejected immediately and
+must be enrolled again with a new enrollment token.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		c := coordinationClient(nodeCredServer, nodeCredToken)
+		if err := c.RevokeNodeCredential(context.Background(), args[0]); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Revoked credential of node %s\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	nodeCmd.AddCommand(nodeEnrollCmd)
+	nodeCmd.AddCommand(nodeRevokeCmd)
+
+	for _, cmd := range []*cobra.Command{nodeEnrollCmd, nodeRevokeCmd} {
+		cmd.Flags().StringVar(&nodeCredServer, "server", "http://localhost:3001", "Coordination server URL")
+		cmd.Flags().StringVar(&nodeCredToken, "token", os.Getenv("NEXUS_COORD_TOKEN"), "Bearer token for the coordination server")
+	}
+	nodeEnrollCmd.Flags().StringVar(&nodeEnrollID, "node-id", "", "Only allow the node with this ID to enroll")
+	nodeEnrollCmd.Flags().DurationVar(&nodeEnrollExpiry, "expires", time.Hour, "How long the token can be used")
+
+	agentStartCmd.Flags().StringVar(&agentEnrollToken, "enrollment-token", "", "One-time enrollment token issued by 'nexus node enroll'")
+}
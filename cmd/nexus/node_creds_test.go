@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeCredentialCmdsExist(t *testing.T) {
+	for _, name := range []string{"enroll", "revoke"} {
+		cmd, _, err := nodeCmd.Find([]string{name})
+		require.NoError(t, err)
+		assert.Equal(t, name, cmd.Name())
+	}
+	assert.NotNil(t, agentStartCmd.Flags().Lookup("enrollment-token"))
+}
+
+func TestNodeEnrollAndRevoke(t *testing.T) {
+	var got coordination.CreateEnrollmentTokenRequest
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "Bearer admin-token", r.Header.Get("Authorization"))
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(coordination.CreateEnrollmentTokenResponse{
+			Token:           "nxe_secret",
+			EnrollmentToken: &coordination.EnrollmentToken{ID: "enr_1", NodeID: got.NodeID, ExpiresAt: time.Now().Add(time.Hour)},
+		})
+	}))
+	defer server.Close()
+
+	defer func() { nodeCredServer, nodeCredToken, nodeEnrollID, nodeEnrollExpiry = "", "", "", time.Hour }()
+	nodeCredServer, nodeCredToken = server.URL, "admin-token"
+	nodeEnrollID, nodeEnrollExpiry = "node-1", 2*time.Hour
+
+	require.NoError(t, nodeEnrollCmd.RunE(nodeEnrollCmd, nil))
+	assert.Equal(t, "node-1", got.NodeID)
+	assert.Equal(t, "2h0m0s", got.ExpiresIn)
+
+	require.NoError(t, nodeRevokeCmd.RunE(nodeRevokeCmd, []string{"node-1"}))
+	assert.Equal(t, []string{"POST /api/v1/enrollment-tokens", "DELETE /api/v1/nodes/node-1/credential"}, paths)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/spf13/cobra"
+)
+
+var (
+	nodeExecServer  string
+	nodeExecToken   string
+	nodeExecFollow  bool
+	nodeExecTimeout time.Duration
+)
+
+var nodeExecCmd = &cobra.Command{
+	Use:   "exec <node-id> <session-id> -- <command> [args...]",
+	Short: "Run a command in a session on a node",
+	Long: `Run a command in a session on a node through the coordination server.
+
+Without --follow the command's output is printed once it has finished. With --follow
+stdout and stderr are printed as the node produces them, and the exit status reflects
+the command's result.`,
+	Args: cobra.MinimumNArgs(3),
+	RunE: func(_ *cobra.Command, args []string) error {
+		nodeID, sessionID := args[0], args[1]
+		argv, err := json.Marshal(args[2:])
+		if err != nil {
+			return fmt.Errorf("failed to encode command: %w", err)
+		}
+
+		command := &coordination.Command{
+			ID:      fmt.Sprintf("cmd_%d_%s", time.Now().UnixNano(), nodeID),
+			Type:    "session",
+			Target:  nodeID,
+			Action:  "exec",
+			Params:  map[string]interface{}{"session_id": sessionID, "command": string(argv)},
+			Timeout: nodeExecTimeout,
+		}
+
+		ctx := context.Background()
+		c := coordinationClient(nodeExecServer, nodeExecToken)
+
+		// Follow before sending so no output is missed; the server also buffers it. The
+		// node reports the result once it has run the command.
+		events, err := c.FollowCommandOutput(ctx, command.ID)
+		if err != nil {
+			return fmt.Errorf("failed to follow command output: %w", err)
+		}
+		if _, err := c.SendCommand(ctx, nodeID, command); err != nil {
+			return err
+		}
+
+		var seq int64
+		for event := range events {
+			if chunk := event.Output; chunk != nil && nodeExecFollow {
+				if lost := chunk.Seq - seq - 1; lost > 0 {
+					fmt.Fprintf(os.Stderr, "⚠️  %d output chunks were lost\n", lost)
+				}
+				seq = chunk.Seq
+				if chunk.Stream == "stderr" {
+					fmt.Fprint(os.Stderr, chunk.Data)
+				} else {
+					fmt.Print(chunk.Data)
+				}
+			}
+			if event.Result == nil {
+				continue
+			}
+			if !nodeExecFollow {
+				return printCommandResult(event.Result)
+			}
+			return commandResultError(event.Result)
+		}
+		return fmt.Errorf("output stream of command %s ended before its result", command.ID)
+	},
+}
+
+// printCommandResult prints the output of a finished command
+func printCommandResult(result *coordination.CommandResult) error {
+	fmt.Printf("📋 Command %s on node %s: %s\n", result.ID, result.NodeID, result.Status)
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Print(result.Output)
+	if len(result.Output) > 0 && result.Output[len(result.Output)-1] != '\n' {
+		fmt.Println()
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	return commandResultError(result)
+}
+
+// commandResultError returns an error unless the command succeeded
+func commandResultError(result *coordination.CommandResult) error {
+	if result.Status == "success" {
+		return nil
+	}
+	if result.Error != "" {
+		return fmt.Errorf("command %s: %s", result.Status, result.Error)
+	}
+	return fmt.Errorf("command %s", result.Status)
+}
+
+func init() {
+	nodeCmd.AddCommand(nodeExecCmd)
+
+	nodeExecCmd.Flags().StringVar(&nodeExecServer, "server", "http://localhost:3001", "Coordination server URL")
+	nodeExecCmd.Flags().StringVar(&nodeExecToken, "token", os.Getenv("NEXUS_COORD_TOKEN"), "Bearer token for the coordination server")
+	nodeExecCmd.Flags().BoolVarP(&nodeExecFollow, "follow", "f", false, "Stream output while the command runs")
+	nodeExecCmd.Flags().DurationVar(&nodeExecTimeout, "timeout", 0, "Stop the command after this long (0 for no limit)")
+}
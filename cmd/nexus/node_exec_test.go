@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeExecFollow(t *testing.T) {
+	cmd, _, err := nodeCmd.Find([]string{"exec"})
+	require.NoError(t, err)
+	assert.Equal(t, "exec", cmd.Name())
+
+	sent := make(chan coordination.Command, 1)
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		assert.Equal(t, "Bearer admin-token", r.Header.Get("Authorization"))
+		switch r.Method {
+		case http.MethodPost:
+			var command coordination.Command
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&command))
+			sent <- command
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(command)
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			command := <-sent
+			for _, event := range []coordination.CommandStreamEvent{
+				{Output: &coordination.CommandOutput{CommandID: command.ID, Stream: "stdout", Seq: 1, Data: "ok\n"}},
+				{Output: &coordination.CommandOutput{CommandID: command.ID, Stream: "stderr", Seq: 3, Data: "oops\n"}},
+				{Result: &coordination.CommandResult{ID: command.ID, Status: "failed", Error: "exit status 1"}},
+			} {
+				data, _ := json.Marshal(event)
+				fmt.Fprintf(w, "data: %s\n\n", data)
+			}
+			sent <- command
+		}
+	}))
+	defer server.Close()
+
+	defer func() { nodeExecServer, nodeExecToken, nodeExecFollow, nodeExecTimeout = "", "", false, 0 }()
+	nodeExecServer, nodeExecToken, nodeExecFollow, nodeExecTimeout = server.URL, "admin-token", true, time.Minute
+
+	err = nodeExecCmd.RunE(nodeExecCmd, []string{"node-1", "ws-1", "make", "test"})
+	assert.EqualError(t, err, "command failed: exit status 1", "the exit status follows the command's result")
+
+	command := <-sent
+	assert.Equal(t, "exec", command.Action)
+	assert.Equal(t, `["make","test"]`, command.Params["command"])
+	assert.Equal(t, "ws-1", command.Params["session_id"])
+	assert.Equal(t, time.Minute, command.Timeout)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"GET /api/v1/commands/" + command.ID + "/output",
+		"POST /api/v1/nodes/node-1/commands",
+	}, paths, "output is followed before the command is sent")
+}
+
+func TestNodeExecWaitsForResult(t *testing.T) {
+	sent := make(chan coordination.Command, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var command coordination.Command
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&command))
+			sent <- command
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(command)
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			command := <-sent
+			data, _ := json.Marshal(coordination.CommandStreamEvent{Result: &coordination.CommandResult{ID: command.ID, Status: "success", Output: "ok\n"}})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+	}))
+	defer server.Close()
+
+	defer func() { nodeExecServer, nodeExecToken = "", "" }()
+	nodeExecServer, nodeExecToken = server.URL, "admin-token"
+
+	assert.NoError(t, nodeExecCmd.RunE(nodeExecCmd, []string{"node-1", "ws-1", "true"}), "without --follow the node's result is awaited")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/spf13/cobra"
+)
+
+var (
+	nodeGCServer string
+	nodeGCToken  string
+	nodeGCDryRun bool
+	nodeGCGrace  time.Duration
+)
+
+var nodeGCCmd = &cobra.Command{
+	Use:   "gc <node-id>",
+	Short: "Clean up resources left behind by failed workspaces on a node",
+	Long: `Have the agent of a node find containers, LXC instances, VM disks and ports that belong
+to no workspace it manages or the coordination server placed on it, and workspaces whose
+creation failed.
+
+The agent collects the garbage itself, through the coordination server, so nothing it
+is using is taken for an orphan. Orphans are deleted once they have been seen for the
+grace period, the same way the agent's periodic garbage collection deletes them. With
+--dry-run they are only listed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		nodeID := args[0]
+		params := map[string]interface{}{"dry_run": nodeGCDryRun}
+		if nodeGCGrace > 0 {
+			params["grace"] = nodeGCGrace.String()
+		}
+		command := &coordination.Command{
+			ID:     fmt.Sprintf("gc_%d_%s", time.Now().UnixNano(), nodeID),
+			Type:   "system",
+			Target: nodeID,
+			Action: "gc",
+			Params: params,
+		}
+
+		ctx := context.Background()
+		c := coordinationClient(nodeGCServer, nodeGCToken)
+		events, err := c.FollowCommandOutput(ctx, command.ID)
+		if err != nil {
+			return fmt.Errorf("failed to follow garbage collection: %w", err)
+		}
+		if _, err := c.SendCommand(ctx, nodeID, command); err != nil {
+			return err
+		}
+
+		for event := range events {
+			result := event.Result
+			if result == nil {
+				continue
+			}
+			var orphans []coordination.OrphanedResource
+			if err := json.Unmarshal([]byte(result.Output), &orphans); err != nil {
+				return commandResultError(result)
+			}
+			if err := printOrphans(orphans, nodeGCDryRun); err != nil {
+				return err
+			}
+			if result.Status != "success" {
+				return fmt.Errorf("garbage collection failed: %s", result.Error)
+			}
+			return nil
+		}
+		return fmt.Errorf("output stream of command %s ended before its result", command.ID)
+	},
+}
+
+// printOrphans lists orphaned resources and what happened to them
+func printOrphans(orphans []coordination.OrphanedResource, dryRun bool) error {
+	if len(orphans) == 0 {
+		fmt.Println("✅ No orphaned resources found")
+		return nil
+	}
+
+	fmt.Printf("🧹 Orphaned resources (%d)\n", len(orphans))
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("%-10s %-8s %-24s %-20s %s\n", "KIND", "PROVIDER", "ID", "WORKSPACE", "STATUS")
+	failed := 0
+	for _, orphan := range orphans {
+		status := "deleted"
+		switch {
+		case orphan.Error != "":
+			status = "failed: " + orphan.Error
+			failed++
+		case !orphan.Deleted && dryRun:
+			status = "would be deleted after " + orphan.DeleteAfter.Local().Format(time.RFC3339)
+		case !orphan.Deleted:
+			status = "kept until " + orphan.DeleteAfter.Local().Format(time.RFC3339)
+		}
+		fmt.Printf("%-10s %-8s %-24s %-20s %s\n", orphan.Kind, orphan.Provider, orphan.ID, orphan.WorkspaceID, status)
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d orphaned resources", failed)
+	}
+	return nil
+}
+
+func init() {
+	nodeCmd.AddCommand(nodeGCCmd)
+
+	nodeGCCmd.Flags().StringVar(&nodeGCServer, "server", "http://localhost:3001", "Coordination server URL")
+	nodeGCCmd.Flags().StringVar(&nodeGCToken, "token", os.Getenv("NEXUS_COORD_TOKEN"), "Bearer token for the coordination server")
+	nodeGCCmd.Flags().BoolVar(&nodeGCDryRun, "dry-run", false, "List orphaned resources without deleting them")
+	nodeGCCmd.Flags().DurationVar(&nodeGCGrace, "grace", 0, "Delete orphans seen for at least this long (default from the agent config, 1h)")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeGCCmdExists(t *testing.T) {
+	cmd, _, err := nodeCmd.Find([]string{"gc"})
+	require.NoError(t, err)
+	assert.Equal(t, "gc", cmd.Name())
+	assert.NotNil(t, cmd.Flags().Lookup("dry-run"))
+	assert.NotNil(t, cmd.Flags().Lookup("grace"))
+}
+
+func TestNodeGCRunsInTheAgent(t *testing.T) {
+	sent := make(chan coordination.Command, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			assert.Equal(t, "/api/v1/nodes/node-1/commands", r.URL.Path)
+			var command coordination.Command
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&command))
+			sent <- command
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(command)
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			command := <-sent
+			output, _ := json.Marshal([]coordination.OrphanedResource{{Kind: coordination.OrphanPort, ID: "23001", DeleteAfter: time.Now()}})
+			data, _ := json.Marshal(coordination.CommandStreamEvent{Result: &coordination.CommandResult{ID: command.ID, Status: "success", Output: string(output)}})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			sent <- command
+		}
+	}))
+	defer server.Close()
+
+	defer func() { nodeGCServer, nodeGCToken, nodeGCDryRun, nodeGCGrace = "", "", false, 0 }()
+	nodeGCServer, nodeGCToken, nodeGCDryRun, nodeGCGrace = server.URL, "admin-token", true, 30*time.Minute
+
+	require.NoError(t, nodeGCCmd.RunE(nodeGCCmd, []string{"node-1"}))
+	command := <-sent
+	assert.Equal(t, "system", command.Type)
+	assert.Equal(t, "gc", command.Action)
+	assert.Equal(t, map[string]interface{}{"dry_run": true, "grace": "30m0s"}, command.Params)
+}
+
+func TestPrintOrphans(t *testing.T) {
+	assert.NoError(t, printOrphans(nil, false))
+
+	later := time.Now().Add(time.Hour)
+	orphans := []coordination.OrphanedResource{
+		{Kind: coordination.OrphanSession, Provider: "docker", ID: "c2", WorkspaceID: "ws-leaked", DeleteAfter: later},
+		{Kind: coordination.OrphanPort, ID: "23001", Deleted: true},
+	}
+	assert.NoError(t, printOrphans(orphans, true))
+
+	orphans = append(orphans, coordination.OrphanedResource{Kind: coordination.OrphanDisk, Provider: "qemu", ID: "ws-gone", Error: "permission denied"})
+	assert.ErrorContains(t, printOrphans(orphans, false), "failed to delete 1 orphaned resources")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenServer   string
+	tokenAuth     string
+	tokenScopes   []string
+	tokenExpires  time.Duration
+	tokenUsername string
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage personal API tokens",
+	Long: `Create, list and revoke personal API tokens for scripts and CI.
+
+Tokens are scoped to workspaces:read, workspaces:write, nodes:read or nodes:admin
+and never grant more than your role allows. The secret is shown once on creation
+and only a hash is kept by the server. Use it as a bearer token, for example
+through NEXUS_COORD_TOKEN.`,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create an API token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		req := coordination.CreateAPITokenRequest{
+			Name:     args[0],
+			Scopes:   tokenScopes,
+			Username: tokenUsername,
+		}
+		if tokenExpires > 0 {
+			req.ExpiresIn = tokenExpires.String()
+		}
+		for _, scope := range req.Scopes {
+			if !coordination.IsValidScope(scope) {
+				return fmt.Errorf("invalid scope %q, must be one of %v", scope, coordination.APITokenScopes)
+			}
+		}
+
+		resp, err := tokenClient().CreateAPIToken(context.Background(), &req)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Created token %s (%s)\n", resp.APIToken.Name, resp.APIToken.ID)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Printf("  %s\n", resp.Token)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Println("⚠️  Copy the token now, it will not be shown again")
+		if resp.APIToken.ExpiresAt != nil {
+			fmt.Printf("  Expires: %s\n", resp.APIToken.ExpiresAt.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your API tokens",
+	Args:  cobra.NoArgs,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		tokens, err := tokenClient().ListAPITokens(context.Background(), tokenUsername)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("🔑 API Tokens")
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		if len(tokens) == 0 {
+			fmt.Println("  No tokens")
+		}
+		for _, token := range tokens {
+			fmt.Printf("  %s  %-20s %s...\n", token.ID, token.Name, token.Prefix)
+			fmt.Printf("    Scopes:    %v\n", token.Scopes)
+			fmt.Printf("    Expires:   %s\n", formatTokenTime(token.ExpiresAt, "never"))
+			fmt.Printf("    Last used: %s\n", formatTokenTime(token.LastUsedAt, "never"))
+		}
+		return nil
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke an API token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		if err := tokenClient().RevokeAPIToken(context.Background(), args[0]); err != nil {
+			return err
+		}
+
+		fmt.Printf("✅ Revoked token %s\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenListCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
+
+	tokenCmd.PersistentFlags().StringVar(&tokenServer, "server", "http://localhost:3001", "Coordination server URL")
+	tokenCmd.PersistentFlags().StringVar(&tokenAuth, "token", os.Getenv("NEXUS_COORD_TOKEN"), "Bearer token for the coordination server")
+	tokenCreateCmd.Flags().StringSliceVar(&tokenScopes, "scope", []string{coordination.ActionWorkspacesRead}, "Token scope, repeatable")
+	tokenCreateCmd.Flags().DurationVar(&tokenExpires, "expires", 90*24*time.Hour, "Token lifetime, 0 for no expiry")
+	tokenCreateCmd.Flags().StringVar(&tokenUsername, "user", "", "Issue the token for another user (admin only)")
+	tokenListCmd.Flags().StringVar(&tokenUsername, "user", "", "List the tokens of another user (admin only)")
+}
+
+func tokenClient() *client.Client {
+	return coordinationClient(tokenServer, tokenAuth)
+}
+
+func formatTokenTime(t *time.Time, fallback string) string {
+	if t == nil {
+		return fallback
+	}
+	return t.Local().Format(time.RFC3339)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenCmdsExist(t *testing.T) {
+	for _, name := range []string{"create", "list", "revoke"} {
+		cmd, _, err := tokenCmd.Find([]string{name})
+		require.NoError(t, err)
+		assert.Equal(t, name, cmd.Name())
+	}
+}
+
+func TestTokenCreate(t *testing.T) {
+	var got coordination.CreateAPITokenRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/tokens", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(coordination.CreateAPITokenResponse{
+			Token:    "nxp_secret",
+			APIToken: &coordination.APIToken{ID: "tok_1", Name: got.Name, Scopes: got.Scopes},
+		})
+	}))
+	defer server.Close()
+
+	defer func() { tokenServer, tokenAuth, tokenScopes, tokenExpires = "", "", nil, 0 }()
+	tokenServer, tokenAuth = server.URL, "login-token"
+	tokenScopes = []string{coordination.ActionWorkspacesWrite}
+	tokenExpires = 48 * time.Hour
+
+	require.NoError(t, tokenCreateCmd.RunE(tokenCreateCmd, []string{"ci"}))
+	assert.Equal(t, "ci", got.Name)
+	assert.Equal(t, []string{coordination.ActionWorkspacesWrite}, got.Scopes)
+	assert.Equal(t, "48h0m0s", got.ExpiresIn)
+
+	tokenScopes = []string{"users:admin"}
+	assert.Error(t, tokenCreateCmd.RunE(tokenCreateCmd, []string{"ci"}))
+}
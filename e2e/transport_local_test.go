@@ -176,8 +176,8 @@ func TestLocalCoordinationServer(t *testing.T) {
 	}
 
 	t.Run("server_creation", func(t *testing.T) {
-		srv := coordination.NewServer(cfg)
-		require.NotNil(t, srv)
+		srv, err := coordination.NewServer(cfg)
+		require.NoError(t, err)
 
 		registry := srv.GetRegistry()
 		require.NotNil(t, registry)
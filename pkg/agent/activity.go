@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+// sshContainerPort is the port sshd listens on inside a workspace container
+const sshContainerPort = 22
+
+// connectionProbeTimeout bounds how long looking for a workspace's connections may take
+const connectionProbeTimeout = 5 * time.Second
+
+// RecordActivity notes that a workspace was just used, e.g. by an exec call or a request
+// to the workspace API. Activity is reported with the next heartbeat so the coordination
+// server does not stop the workspace as idle.
+func (a *Agent) RecordActivity(workspaceID string) {
+	if workspaceID == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.activity == nil {
+		a.activity = make(map[string]time.Time)
+	}
+	a.activity[workspaceID] = time.Now()
+}
+
+// observeConnections records activity for the running workspaces with an open SSH session
+// or service connection. Both reach the container through ports mapped straight to it,
+// bypassing the agent, so the container's established TCP connections are looked at instead.
+func (wm *WorkspaceManager) observeConnections(ctx context.Context) {
+	type probe struct {
+		workspaceID string
+		session     string
+		prov        provider.Provider
+		ports       map[int]bool
+	}
+
+	var probes []probe
+	wm.mu.RLock()
+	for id, workspace := range wm.workspaces {
+		workspace.mu.RLock()
+		if workspace.Status == WorkspaceStatusRunning {
+			if prov, ok := wm.providers[workspace.Command.Provider]; ok {
+				ports := map[int]bool{sshContainerPort: true}
+				for _, svc := range workspace.Services {
+					ports[svc.Port] = true
+				}
+				probes = append(probes, probe{workspaceID: id, session: workspace.session(), prov: prov, ports: ports})
+			}
+		}
+		workspace.mu.RUnlock()
+	}
+	wm.mu.RUnlock()
+
+	for _, p := range probes {
+		probeCtx, cancel := context.WithTimeout(ctx, connectionProbeTimeout)
+		var out bytes.Buffer
+		err := p.prov.Exec(probeCtx, p.session, provider.ExecOptions{
+			Cmd:          []string{"cat", "/proc/net/tcp", "/proc/net/tcp6"},
+			Stdout:       true,
+			StdoutWriter: &out,
+		})
+		cancel()
+		// cat fails when the container has no IPv6, after printing the IPv4 table
+		if err != nil && out.Len() == 0 {
+			log.Printf("Failed to look for connections to workspace %s: %v", p.workspaceID, err)
+			continue
+		}
+		if establishedOn(out.String(), p.ports) {
+			wm.agent.RecordActivity(p.workspaceID)
+		}
+	}
+}
+
+// establishedOn reports whether a /proc/net/tcp table holds an established connection
+// to one of ports
+func establishedOn(table string, ports map[int]bool) bool {
+	for _, line := range strings.Split(table, "\n") {
+		// sl local_address rem_address st ...; addresses are hex IP:port, 01 is ESTABLISHED
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[3] != "01" {
+			continue
+		}
+		i := strings.LastIndex(fields[1], ":")
+		port, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+		if err == nil && ports[int(port)] {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingActivity returns a copy of the activity not yet reported
+func (a *Agent) pendingActivity() map[string]time.Time {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	pending := make(map[string]time.Time, len(a.activity))
+	for id, at := range a.activity {
+		pending[id] = at
+	}
+	return pending
+}
+
+// activityReported forgets reported activity, keeping anything recorded since
+func (a *Agent) activityReported(reported map[string]time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for id, at := range reported {
+		if a.activity[id].Equal(at) {
+			delete(a.activity, id)
+		}
+	}
+}
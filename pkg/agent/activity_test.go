@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+func TestHeartbeatReportsActivity(t *testing.T) {
+	var reported []map[string]time.Time
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var heartbeat struct {
+			Activity map[string]time.Time `json:"activity"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&heartbeat))
+		reported = append(reported, heartbeat.Activity)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(NodeConfig{CoordinationURL: server.URL})
+	require.NoError(t, err)
+
+	agent.RecordActivity("ws-1")
+	agent.RecordActivity("")
+
+	status = http.StatusInternalServerError
+	require.Error(t, agent.sendHeartbeat())
+	status = http.StatusNoContent
+	require.NoError(t, agent.sendHeartbeat())
+	require.NoError(t, agent.sendHeartbeat())
+
+	require.Len(t, reported, 3)
+	assert.Contains(t, reported[0], "ws-1")
+	assert.Len(t, reported[0], 1)
+	assert.Equal(t, reported[0], reported[1], "activity is kept until a heartbeat succeeds")
+	assert.Empty(t, reported[2], "reported activity is not sent again")
+}
+
+func TestExecRecordsActivity(t *testing.T) {
+	agent, err := NewAgent(NodeConfig{})
+	require.NoError(t, err)
+	agent.providers["test"] = &execProvider{stdout: "ok\n"}
+	agent.sessions["ws-1"] = &provider.Session{ID: "ws-1", Provider: "test"}
+
+	result := agent.execInSession(Command{Params: map[string]interface{}{"session_id": "missing", "command": "ls"}}, CommandResult{})
+	assert.Equal(t, "failed", result.Status)
+	assert.Empty(t, agent.pendingActivity(), "failed exec calls are not activity")
+
+	result = agent.execInSession(Command{Params: map[string]interface{}{"session_id": "ws-1", "command": "ls"}}, CommandResult{})
+	assert.Equal(t, "success", result.Status)
+	assert.Contains(t, agent.pendingActivity(), "ws-1")
+}
+
+func TestWorkspaceAPIRecordsActivity(t *testing.T) {
+	wm := poolTestManager(&poolProvider{})
+	cmd := capacityTestCommand("ws-1", 1, "1GB")
+	cmd.Provider = "docker"
+	_, err := wm.CreateWorkspace(context.Background(), cmd)
+	require.NoError(t, err)
+	handler := NewWorkspaceHTTPHandler(wm, 0)
+
+	w := httptest.NewRecorder()
+	handler.handleDownloadFile(w, httptest.NewRequest(http.MethodGet, "/api/v1/workspaces/missing/files?path=a", nil), "missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, wm.agent.pendingActivity())
+
+	w = httptest.NewRecorder()
+	handler.handleDownloadFile(w, httptest.NewRequest(http.MethodGet, "/api/v1/workspaces/ws-1/files?path=a", nil), "ws-1")
+	assert.Contains(t, wm.agent.pendingActivity(), "ws-1")
+}
+
+// procNetTCP is a /proc/net/tcp table: sshd listening on 22 (0016), and a connection to port 3000 (0BB8)
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0 100 0 0 10 0
+   1: 0100007F:0BB8 0100007F:D2A4 01 00000000:00000000 00:00000000 00000000  1000        0 2 1 0 20 4 30 10 -1
+`
+
+func TestObserveConnections(t *testing.T) {
+	prov := &poolProvider{}
+	wm := poolTestManager(prov)
+	cmd := capacityTestCommand("ws-1", 1, "1GB")
+	cmd.Provider = "docker"
+	_, err := wm.CreateWorkspace(context.Background(), cmd)
+	require.NoError(t, err)
+	wm.workspaces["ws-2"] = &ManagedWorkspace{
+		Command:  &CreateWorkspaceCommand{WorkspaceID: "ws-2", Provider: "docker"},
+		Status:   WorkspaceStatusRunning,
+		Services: map[string]*ManagedService{"web": {Port: 3000}},
+	}
+	prov.output = map[string]string{"cat /proc/net/tcp /proc/net/tcp6": procNetTCP}
+
+	wm.observeConnections(context.Background())
+	activity := wm.agent.pendingActivity()
+	assert.Contains(t, activity, "ws-2", "a connection to a service is activity")
+	assert.NotContains(t, activity, "ws-1", "a listening sshd is not")
+
+	prov.output = map[string]string{"cat /proc/net/tcp /proc/net/tcp6": strings.Replace(procNetTCP, "0A", "01", 1)}
+	wm.observeConnections(context.Background())
+	assert.Contains(t, wm.agent.pendingActivity(), "ws-1", "an SSH session is activity")
+}
@@ -1,11 +1,18 @@
 package agent
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/nexus/nexus/pkg/coordination"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -111,3 +118,101 @@ func TestExecutorSystemCommand(t *testing.T) {
 	assert.Equal(t, "success", result.Status)
 	assert.NotEmpty(t, result.Output)
 }
+
+func TestCommandPollLoopAcknowledges(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		acks []string
+	)
+	// The second poll delivers cmd-1 again, as the server does when an ack is lost
+	batches := [][]coordination.Command{
+		{{ID: "cmd-1", Type: "system", Action: "status"}},
+		{{ID: "cmd-1", Type: "system", Action: "status"}, {ID: "cmd-2", Type: "system", Action: "status"}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		poll := len(acks)
+		acks = append(acks, r.URL.Query().Get("ack"))
+		mu.Unlock()
+		if poll >= len(batches) {
+			<-r.Context().Done()
+			return
+		}
+		json.NewEncoder(w).Encode(coordination.NodeCommands{Commands: batches[poll]})
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(NodeConfig{CoordinationURL: server.URL})
+	require.NoError(t, err)
+	agent.node.ID = "node-1"
+	agent.credential = &nodeCredential{NodeID: "node-1", Secret: "node-secret"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.commandPollLoop(ctx)
+
+	var received []string
+	for len(received) < 2 {
+		select {
+		case cmd := <-agent.commandCh:
+			received = append(received, cmd.ID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received only %v", received)
+		}
+	}
+	assert.Equal(t, []string{"cmd-1", "cmd-2"}, received, "redelivered commands run once")
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(acks) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"", "cmd-1", "cmd-1,cmd-2"}, acks, "each poll acknowledges the previous batch")
+	select {
+	case cmd := <-agent.commandCh:
+		t.Fatalf("unexpected command %s", cmd.ID)
+	default:
+	}
+}
+
+func TestCommandPollLoopWaitsForCredential(t *testing.T) {
+	defer func(interval time.Duration) { credentialWaitInterval = interval }(credentialWaitInterval)
+	credentialWaitInterval = 10 * time.Millisecond
+
+	var (
+		mu    sync.Mutex
+		polls []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		polls = append(polls, r.Header.Get("Authorization"))
+		mu.Unlock()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(NodeConfig{CoordinationURL: server.URL, AuthToken: "shared"})
+	require.NoError(t, err)
+	agent.node.ID = "node-1"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.commandPollLoop(ctx)
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	assert.Empty(t, polls, "the server refuses the shared token, so the agent does not poll with it")
+	mu.Unlock()
+
+	agent.mu.Lock()
+	agent.credential = &nodeCredential{NodeID: "node-1", Secret: "node-secret"}
+	agent.mu.Unlock()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(polls) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "Bearer node-secret", polls[0], "polls authenticate as the node once it enrolled")
+}
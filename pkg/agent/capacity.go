@@ -0,0 +1,241 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/nexus/nexus/pkg/coordination"
+)
+
+// workspaceRoot is where workspace files live on the node
+const workspaceRoot = "/var/lib/nexus/workspaces"
+
+// Admission error codes returned when a workspace does not fit on the node
+const (
+	AdmissionCPUExceeded        = "insufficient_cpu"
+	AdmissionMemoryExceeded     = "insufficient_memory"
+	AdmissionDiskExceeded       = "insufficient_disk"
+	AdmissionWorkspacesExceeded = "workspace_limit_reached"
+	AdmissionPortsExhausted     = "ports_exhausted"
+)
+
+// AdmissionError is returned when creating a workspace would exceed the node's capacity
+type AdmissionError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Resource  string `json:"resource"`
+	Requested int64  `json:"requested"`
+	Available int64  `json:"available"`
+}
+
+func (e *AdmissionError) Error() string {
+	return e.Message
+}
+
+// hostResources returns the CPU, memory and disk of the host, measuring disk on the
+// filesystem holding path. Replaced in tests.
+var hostResources = func(path string) coordination.NodeResources {
+	return coordination.NodeResources{
+		CPU:      runtime.NumCPU(),
+		MemoryMB: memoryTotalMB(),
+		DiskGB:   diskTotalGB(existingParent(path)),
+	}
+}
+
+// memoryTotalMB reads the host memory from /proc/meminfo, or returns 0 when it is unavailable
+func memoryTotalMB() int64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb / 1024
+		}
+	}
+	return 0
+}
+
+// existingParent returns path or its nearest ancestor that exists
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// parseSizeMB parses a size such as "512MB", "4GB" or "2Gi" into megabytes. A bare number
+// is taken as megabytes.
+func parseSizeMB(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1.0 / 1024
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1024
+	case strings.HasSuffix(s, "T"):
+		multiplier = 1024 * 1024
+	}
+	s = strings.TrimRight(s, "KMGT")
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(value * multiplier), nil
+}
+
+// requestedResources returns the resources a workspace asks for. Sizes that do not parse
+// count as zero; Validate has already required them to be set.
+func requestedResources(cmd *CreateWorkspaceCommand) coordination.NodeResources {
+	memoryMB, _ := parseSizeMB(cmd.Resources.Memory)
+	diskMB, _ := parseSizeMB(cmd.Resources.Disk)
+	return coordination.NodeResources{
+		CPU:      cmd.Resources.CPU,
+		MemoryMB: memoryMB,
+		DiskGB:   (diskMB + 1023) / 1024,
+	}
+}
+
+// free counts the ports between start and end that are not allocated
+func (pm *PortAllocationRange) free(start, end int) int {
+	free := 0
+	for port := start; port <= end; port++ {
+		if !pm.allocatedPorts[port] {
+			free++
+		}
+	}
+	return free
+}
+
+// reservations returns the configured reservations, or none without an agent
+func (wm *WorkspaceManager) reservations() ReservationConfig {
+	if wm.agent == nil {
+		return ReservationConfig{}
+	}
+	return wm.agent.config.Reservations
+}
+
+// Capacity reports the room the node has for more workspaces
+func (wm *WorkspaceManager) Capacity() *coordination.NodeCapacity {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	return wm.capacity()
+}
+
+// capacity computes Capacity. The caller holds wm.mu. CPU and memory are counted for
+// active workspaces, disk for every workspace the node keeps.
+func (wm *WorkspaceManager) capacity() *coordination.NodeCapacity {
+	reserved := wm.reservations()
+	total := hostResources(workspaceRoot)
+
+	var used coordination.NodeResources
+	running := 0
+	for _, workspace := range wm.workspaces {
+		workspace.mu.RLock()
+		requested := requestedResources(workspace.Command)
+		status := workspace.Status
+		workspace.mu.RUnlock()
+
+		used.DiskGB += requested.DiskGB
+		switch status {
+		case WorkspaceStatusCreating, WorkspaceStatusInitializing, WorkspaceStatusRunning:
+			running++
+			used.CPU += requested.CPU
+			used.MemoryMB += requested.MemoryMB
+		}
+	}
+
+	wm.portAllocationLock.Lock()
+	freeSSH := wm.portRange.free(wm.portRange.SSHStart, wm.portRange.SSHEnd)
+	freeService := wm.portRange.free(wm.portRange.ServiceStart, wm.portRange.ServiceEnd)
+	wm.portAllocationLock.Unlock()
+
+	return &coordination.NodeCapacity{
+		Total: total,
+		Allocatable: coordination.NodeResources{
+			CPU:      max(total.CPU-reserved.CPU-used.CPU, 0),
+			MemoryMB: max(total.MemoryMB-reserved.MemoryMB-used.MemoryMB, 0),
+			DiskGB:   max(total.DiskGB-reserved.DiskGB-used.DiskGB, 0),
+		},
+		Workspaces:       running,
+		MaxWorkspaces:    reserved.MaxWorkspaces,
+		FreeSSHPorts:     freeSSH,
+		FreeServicePorts: freeService,
+	}
+}
+
+// admit returns an AdmissionError when cmd does not fit in the node's remaining capacity.
+// Dimensions the node could not measure are not checked. The caller holds wm.mu.
+func (wm *WorkspaceManager) admit(cmd *CreateWorkspaceCommand) error {
+	capacity := wm.capacity()
+	requested := requestedResources(cmd)
+
+	if capacity.MaxWorkspaces > 0 && capacity.Workspaces >= capacity.MaxWorkspaces {
+		return &AdmissionError{
+			Code:      AdmissionWorkspacesExceeded,
+			Message:   fmt.Sprintf("node already runs %d of %d workspaces", capacity.Workspaces, capacity.MaxWorkspaces),
+			Resource:  "workspaces",
+			Requested: 1,
+			Available: 0,
+		}
+	}
+	if capacity.FreeSSHPorts == 0 || capacity.FreeServicePorts < len(cmd.Services) {
+		return &AdmissionError{
+			Code:      AdmissionPortsExhausted,
+			Message:   fmt.Sprintf("node has %d free SSH and %d free service ports", capacity.FreeSSHPorts, capacity.FreeServicePorts),
+			Resource:  "ports",
+			Requested: int64(1 + len(cmd.Services)),
+			Available: int64(min(capacity.FreeSSHPorts, 1) + capacity.FreeServicePorts),
+		}
+	}
+
+	dimensions := []struct {
+		code      string
+		resource  string
+		unit      string
+		total     int64
+		requested int64
+		available int64
+	}{
+		{AdmissionCPUExceeded, "cpu", "vCPUs", int64(capacity.Total.CPU), int64(requested.CPU), int64(capacity.Allocatable.CPU)},
+		{AdmissionMemoryExceeded, "memory_mb", "MB of memory", capacity.Total.MemoryMB, requested.MemoryMB, capacity.Allocatable.MemoryMB},
+		{AdmissionDiskExceeded, "disk_gb", "GB of disk", capacity.Total.DiskGB, requested.DiskGB, capacity.Allocatable.DiskGB},
+	}
+	for _, d := range dimensions {
+		if d.total > 0 && d.requested > d.available {
+			return &AdmissionError{
+				Code:      d.code,
+				Message:   fmt.Sprintf("workspace requests %d %s but the node has %d allocatable", d.requested, d.unit, d.available),
+				Resource:  d.resource,
+				Requested: d.requested,
+				Available: d.available,
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+func TestParseSizeMB(t *testing.T) {
+	tests := map[string]int64{
+		"512MB": 512,
+		"4GB":   4096,
+		"2Gi":   2048,
+		"1.5G":  1536,
+		"1TB":   1024 * 1024,
+		"256":   256,
+	}
+	for size, want := range tests {
+		got, err := parseSizeMB(size)
+		require.NoError(t, err, size)
+		assert.Equal(t, want, got, size)
+	}
+
+	_, err := parseSizeMB("lots")
+	assert.Error(t, err)
+}
+
+func capacityTestCommand(id string, cpu int, memory string) *CreateWorkspaceCommand {
+	return &CreateWorkspaceCommand{
+		WorkspaceID:   id,
+		WorkspaceName: id,
+		Provider:      "missing",
+		Image:         "ubuntu:22.04",
+		Repository:    RepositoryInfo{Owner: "org", Name: "repo", URL: "git@github.com:org/repo.git", Branch: "main"},
+		SSH:           SSHConfig{Port: 2222, User: "dev", PubKey: "ssh-ed25519 AAAA..."},
+		Resources:     ResourceConfig{CPU: cpu, Memory: memory, Disk: "20GB"},
+	}
+}
+
+func TestWorkspaceAdmission(t *testing.T) {
+	original := hostResources
+	hostResources = func(string) coordination.NodeResources {
+		return coordination.NodeResources{CPU: 8, MemoryMB: 16384, DiskGB: 100}
+	}
+	defer func() { hostResources = original }()
+
+	agent := &Agent{
+		node:      &Node{ID: "test-node"},
+		config:    NodeConfig{Reservations: ReservationConfig{CPU: 2, MemoryMB: 4096, DiskGB: 20, MaxWorkspaces: 2}},
+		providers: make(map[string]provider.Provider),
+	}
+	wm := NewWorkspaceManager(agent)
+	wm.workspaces["ws-1"] = &ManagedWorkspace{Command: capacityTestCommand("ws-1", 4, "8GB"), Status: WorkspaceStatusRunning}
+	wm.workspaces["ws-stopped"] = &ManagedWorkspace{Command: capacityTestCommand("ws-stopped", 4, "8GB"), Status: WorkspaceStatusStopped}
+
+	capacity := wm.Capacity()
+	assert.Equal(t, coordination.NodeResources{CPU: 2, MemoryMB: 4096, DiskGB: 40}, capacity.Allocatable,
+		"stopped workspaces only hold their disk")
+	assert.Equal(t, 1, capacity.Workspaces)
+	assert.Equal(t, 78, capacity.FreeSSHPorts)
+
+	_, err := wm.CreateWorkspace(context.Background(), capacityTestCommand("ws-2", 4, "2GB"))
+	var admissionErr *AdmissionError
+	require.ErrorAs(t, err, &admissionErr)
+	assert.Equal(t, AdmissionCPUExceeded, admissionErr.Code)
+	assert.Equal(t, int64(4), admissionErr.Requested)
+	assert.Equal(t, int64(2), admissionErr.Available)
+	assert.NotContains(t, wm.workspaces, "ws-2", "rejected workspaces hold nothing")
+
+	_, err = wm.CreateWorkspace(context.Background(), capacityTestCommand("ws-2", 1, "8GB"))
+	require.ErrorAs(t, err, &admissionErr)
+	assert.Equal(t, AdmissionMemoryExceeded, admissionErr.Code)
+
+	result, err := wm.CreateWorkspace(context.Background(), capacityTestCommand("ws-2", 1, "2GB"))
+	require.NoError(t, err, "a workspace that fits is admitted")
+	assert.Equal(t, WorkspaceStatusError, result.Status, "the test provider does not exist")
+
+	wm.workspaces["ws-2"].Status = WorkspaceStatusRunning
+	_, err = wm.CreateWorkspace(context.Background(), capacityTestCommand("ws-3", 1, "1GB"))
+	require.ErrorAs(t, err, &admissionErr)
+	assert.Equal(t, AdmissionWorkspacesExceeded, admissionErr.Code)
+
+	// The HTTP API answers with the structured error
+	body, err := json.Marshal(capacityTestCommand("ws-3", 1, "1GB"))
+	require.NoError(t, err)
+	handler := NewWorkspaceHTTPHandler(wm, 0)
+	w := httptest.NewRecorder()
+	handler.mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/workspaces/create", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var resp struct {
+		Error AdmissionError `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, AdmissionWorkspacesExceeded, resp.Error.Code)
+}
+
+func TestAdmissionRequiresFreePorts(t *testing.T) {
+	wm := createTestWorkspaceManager()
+	for port := wm.portRange.SSHStart; port <= wm.portRange.SSHEnd; port++ {
+		wm.portRange.allocatedPorts[port] = true
+	}
+
+	err := wm.admit(capacityTestCommand("ws-1", 1, "1GB"))
+	var admissionErr *AdmissionError
+	require.ErrorAs(t, err, &admissionErr)
+	assert.Equal(t, AdmissionPortsExhausted, admissionErr.Code)
+	assert.False(t, wm.Capacity().Fits(1, 1024, 1), "the coordination server sees the node is full")
+}
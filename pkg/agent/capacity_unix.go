@@ -0,0 +1,14 @@
+//go:build !windows
+
+package agent
+
+import "syscall"
+
+// diskTotalGB returns the size of the filesystem holding path, or 0 when it cannot be read
+func diskTotalGB(path string) int64 {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0
+	}
+	return int64(stat.Blocks*uint64(stat.Bsize)) >> 30
+}
@@ -0,0 +1,6 @@
+package agent
+
+// diskTotalGB is not measured on Windows; the disk dimension is then not enforced
+func diskTotalGB(path string) int64 {
+	return 0
+}
@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+)
+
+// maxResultOutput bounds the output kept for a command's final result; streamed chunks
+// are not limited
+const maxResultOutput = 1 << 20
+
+// outputQueueSize is how many chunks may wait for the coordination server before new
+// ones are dropped
+const outputQueueSize = 256
+
+// outputStream numbers the output of a running command, streams it to the coordination
+// server in order and collects it for the command's result
+type outputStream struct {
+	agent     *Agent
+	commandID string
+
+	mu        sync.Mutex
+	seq       int64
+	output    strings.Builder
+	truncated bool
+	dropped   int
+
+	pending chan coordination.CommandOutput
+	done    chan struct{}
+}
+
+// newOutputStream starts streaming the output of a command. Output is only collected when
+// the command has no ID or the agent has no coordination server.
+func (a *Agent) newOutputStream(commandID string) *outputStream {
+	s := &outputStream{agent: a, commandID: commandID}
+	if commandID != "" && a.config.CoordinationURL != "" {
+		s.pending = make(chan coordination.CommandOutput, outputQueueSize)
+		s.done = make(chan struct{})
+		go s.send(s.pending)
+	}
+	return s
+}
+
+// writer returns the writer for one of the command's streams, stdout or stderr
+func (s *outputStream) writer(stream string) io.Writer {
+	return &outputWriter{stream: s, name: stream}
+}
+
+type outputWriter struct {
+	stream *outputStream
+	name   string
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.stream.write(w.name, string(p))
+	return len(p), nil
+}
+
+// write records one chunk. Chunks the coordination server cannot take fast enough are
+// dropped from the stream but kept in the result.
+func (s *outputStream) write(stream, data string) {
+	if data == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if remaining := maxResultOutput - s.output.Len(); remaining < len(data) {
+		s.output.WriteString(data[:max(remaining, 0)])
+		s.truncated = true
+	} else {
+		s.output.WriteString(data)
+	}
+
+	s.seq++
+	if s.pending == nil {
+		return
+	}
+	chunk := coordination.CommandOutput{
+		CommandID: s.commandID,
+		NodeID:    s.agent.node.ID,
+		Stream:    stream,
+		Seq:       s.seq,
+		Data:      data,
+		Time:      time.Now(),
+	}
+	select {
+	case s.pending <- chunk:
+	default:
+		s.dropped++
+	}
+}
+
+// send posts queued chunks to the coordination server until the stream is closed
+func (s *outputStream) send(pending <-chan coordination.CommandOutput) {
+	defer close(s.done)
+	for chunk := range pending {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := s.agent.coord.ReportCommandOutput(ctx, &chunk)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to stream output of command %s: %v", s.commandID, err)
+		}
+	}
+}
+
+// Close waits for queued chunks to be sent and returns the collected output
+func (s *outputStream) Close() string {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if pending != nil {
+		close(pending)
+		<-s.done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dropped > 0 {
+		log.Printf("Dropped %d output chunks of command %s while the coordination server was slow", s.dropped, s.commandID)
+	}
+	if s.truncated {
+		return s.output.String() + "\n[output truncated]"
+	}
+	return s.output.String()
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+// execProvider is a provider whose only working call is Exec, which writes fixed output
+type execProvider struct {
+	provider.Provider
+	stdout, stderr string
+	err            error
+	cmd, env       []string
+}
+
+func (p *execProvider) Exec(ctx context.Context, sessionID string, opts provider.ExecOptions) error {
+	p.cmd, p.env = opts.Cmd, opts.Env
+	if p.stdout != "" {
+		io.WriteString(opts.StdoutWriter, p.stdout)
+	}
+	if p.stderr != "" {
+		io.WriteString(opts.StderrWriter, p.stderr)
+	}
+	return p.err
+}
+
+func TestExecStreamsOutput(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		chunks []coordination.CommandOutput
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/commands/cmd-1/output", r.URL.Path)
+		var chunk coordination.CommandOutput
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&chunk))
+		mu.Lock()
+		chunks = append(chunks, chunk)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(NodeConfig{CoordinationURL: server.URL})
+	require.NoError(t, err)
+	agent.node.ID = "node-1"
+	exec := &execProvider{stdout: "compiling\n", stderr: "warning: unused\n"}
+	agent.providers["docker"] = exec
+	agent.sessions["ws-1"] = &provider.Session{ID: "ws-1", Provider: "docker"}
+
+	cmd := Command{ID: "cmd-1", Type: "session", Action: "exec", Params: map[string]interface{}{
+		"session_id": "ws-1",
+		"command":    `["make", "build"]`,
+	}}
+	result := agent.executeCommand(cmd)
+	assert.Equal(t, "success", result.Status)
+	assert.Equal(t, []string{"make", "build"}, exec.cmd)
+	assert.Equal(t, "compiling\nwarning: unused\n", result.Output, "the result still carries the whole output")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, chunks, 2, "chunks are sent before the command finishes")
+	assert.Equal(t, coordination.CommandOutput{CommandID: "cmd-1", NodeID: "node-1", Stream: "stdout", Seq: 1, Data: "compiling\n", Time: chunks[0].Time}, chunks[0])
+	assert.Equal(t, "stderr", chunks[1].Stream)
+	assert.Equal(t, int64(2), chunks[1].Seq)
+}
+
+func TestExecReportsFailure(t *testing.T) {
+	agent, err := NewAgent(NodeConfig{})
+	require.NoError(t, err)
+	agent.providers["docker"] = &execProvider{stderr: "make: *** No rule\n", err: errors.New("exit status 2")}
+	agent.sessions["ws-1"] = &provider.Session{ID: "ws-1", Provider: "docker"}
+
+	result := agent.execInSession(Command{Params: map[string]interface{}{"session_id": "ws-1", "command": "make"}}, CommandResult{})
+	assert.Equal(t, "failed", result.Status)
+	assert.Contains(t, result.Error, "exit status 2")
+	assert.Equal(t, "make: *** No rule\n", result.Output)
+}
+
+func TestOutputStreamTruncatesResult(t *testing.T) {
+	agent, err := NewAgent(NodeConfig{})
+	require.NoError(t, err)
+
+	output := agent.newOutputStream("cmd-1")
+	w := output.writer("stdout")
+	chunk := make([]byte, maxResultOutput/2+1)
+	for i := 0; i < 3; i++ {
+		n, err := w.Write(chunk)
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	collected := output.Close()
+	assert.Len(t, collected, maxResultOutput+len("\n[output truncated]"))
+	assert.Equal(t, int64(3), output.seq)
+}
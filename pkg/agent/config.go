@@ -47,9 +47,15 @@ func LoadConfig(path string) (NodeConfig, error) {
 	if token := os.Getenv("NEXUS_AUTH_TOKEN"); token != "" {
 		config.AuthToken = token
 	}
+	if token := os.Getenv("NEXUS_ENROLLMENT_TOKEN"); token != "" {
+		config.EnrollmentToken = token
+	}
 	if provider := os.Getenv("NEXUS_PROVIDER"); provider != "" {
 		config.Provider = provider
 	}
+	if token := os.Getenv("NEXUS_AGENT_API_TOKEN"); token != "" {
+		config.API.Tokens = append(config.API.Tokens, token)
+	}
 
 	return config, nil
 }
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// credentialFile is the name of the node credential inside CacheDir
+const credentialFile = "credential.json"
+
+// nodeCredential is the per-node secret the coordination server issues at enrollment
+type nodeCredential struct {
+	NodeID    string    `json:"node_id"`
+	Secret    string    `json:"secret"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// needsRotation reports whether half of the credential's lifetime has passed
+func (c *nodeCredential) needsRotation(now time.Time) bool {
+	lifetime := c.ExpiresAt.Sub(c.IssuedAt)
+	return !now.Before(c.IssuedAt.Add(lifetime / 2))
+}
+
+// loadCredential reads the node credential saved in cacheDir. It returns nil when none was saved.
+func loadCredential(cacheDir string) (*nodeCredential, error) {
+	if cacheDir == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(cacheDir, credentialFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node credential: %w", err)
+	}
+
+	var credential nodeCredential
+	if err := json.Unmarshal(data, &credential); err != nil {
+		return nil, fmt.Errorf("failed to parse node credential: %w", err)
+	}
+	return &credential, nil
+}
+
+// saveCredential writes the node credential to cacheDir, readable only by the agent
+func saveCredential(cacheDir string, credential *nodeCredential) error {
+	if cacheDir == "" {
+		return fmt.Errorf("cache directory not configured")
+	}
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(credential, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal node credential: %w", err)
+	}
+
+	path := filepath.Join(cacheDir, credentialFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write node credential: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write node credential: %w", err)
+	}
+	return nil
+}
+
+// bearerToken returns the token the agent authenticates with: its node credential once
+// enrolled, otherwise the enrollment token or the shared auth token
+func (a *Agent) bearerToken() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.credential != nil {
+		return a.credential.Secret
+	}
+	if a.config.EnrollmentToken != "" {
+		return a.config.EnrollmentToken
+	}
+	return a.config.AuthToken
+}
+
+// enrolled reports whether the agent holds a node credential of its own
+func (a *Agent) enrolled() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.credential != nil
+}
+
+// storeCredential keeps an issued credential in memory and on disk
+func (a *Agent) storeCredential(credential *nodeCredential) error {
+	if credential.IssuedAt.IsZero() {
+		credential.IssuedAt = time.Now()
+	}
+
+	a.mu.Lock()
+	a.credential = credential
+	a.mu.Unlock()
+
+	return saveCredential(a.config.CacheDir, credential)
+}
+
+// rotateCredential exchanges the node credential for a new one once half its lifetime has passed
+func (a *Agent) rotateCredential() error {
+	a.mu.RLock()
+	current := a.credential
+	a.mu.RUnlock()
+	if current == nil || !current.needsRotation(time.Now()) {
+		return nil
+	}
+
+	issued, err := a.coord.RotateNodeCredential(context.Background(), current.NodeID)
+	if err != nil {
+		return fmt.Errorf("failed to rotate node credential: %w", err)
+	}
+
+	rotated := nodeCredential{NodeID: current.NodeID, Secret: issued.Secret, ExpiresAt: issued.ExpiresAt}
+	if err := a.storeCredential(&rotated); err != nil {
+		return err
+	}
+
+	log.Printf("Rotated node credential, valid until %s", rotated.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentEnrollment(t *testing.T) {
+	cacheDir := t.TempDir()
+	var authHeaders []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/api/v1/nodes":
+			var node map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&node))
+			if r.Header.Get("Authorization") == "Bearer nxe_enroll" {
+				node["credential"] = map[string]interface{}{
+					"node_id":    node["id"],
+					"secret":     "nxn_first",
+					"expires_at": time.Now().Add(time.Hour),
+				}
+			}
+			json.NewEncoder(w).Encode(node)
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"secret":     "nxn_second",
+				"expires_at": time.Now().Add(2 * time.Hour),
+			})
+		}
+	}))
+	defer server.Close()
+
+	config := NodeConfig{CoordinationURL: server.URL, CacheDir: cacheDir, EnrollmentToken: "nxe_enroll", AuthToken: "shared"}
+	agent, err := NewAgent(config)
+	require.NoError(t, err)
+	require.NoError(t, agent.registerWithServer())
+	assert.Equal(t, "Bearer nxe_enroll", authHeaders[0], "the enrollment token is preferred over the shared token")
+	assert.Equal(t, "nxn_first", agent.bearerToken())
+
+	info, err := os.Stat(filepath.Join(cacheDir, credentialFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	restarted, err := NewAgent(config)
+	require.NoError(t, err)
+	assert.Equal(t, agent.node.ID, restarted.node.ID, "the node keeps the ID its credential is bound to")
+	require.NoError(t, restarted.registerWithServer())
+	assert.Equal(t, "Bearer nxn_first", authHeaders[1])
+
+	require.NoError(t, restarted.rotateCredential())
+	assert.Len(t, authHeaders, 2, "fresh credentials are not rotated")
+
+	restarted.credential.IssuedAt = time.Now().Add(-time.Hour)
+	restarted.credential.ExpiresAt = time.Now().Add(time.Minute)
+	require.NoError(t, restarted.rotateCredential())
+	assert.Equal(t, "nxn_second", restarted.bearerToken())
+	saved, err := loadCredential(cacheDir)
+	require.NoError(t, err)
+	assert.Equal(t, "nxn_second", saved.Secret)
+	assert.Equal(t, agent.node.ID, saved.NodeID)
+}
@@ -230,7 +230,8 @@ func (e *Executor) execInSessionFunc(cmd Command, result CommandResult) CommandR
 		return result
 	}
 
-	if _, ok := e.providers[session.Provider]; !ok {
+	prov, ok := e.providers[session.Provider]
+	if !ok || prov == nil {
 		result.Status = "failed"
 		result.Error = fmt.Sprintf("provider %s not available", session.Provider)
 		return result
@@ -243,8 +244,33 @@ func (e *Executor) execInSessionFunc(cmd Command, result CommandResult) CommandR
 
 	log.Printf("Executing command %v in session %s", cmdParts, sessionID)
 
+	ctx := context.Background()
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+
+	// Output is streamed to the coordination server as it is produced
+	output := e.agent.newOutputStream(cmd.ID)
+	err := prov.Exec(ctx, sessionID, provider.ExecOptions{
+		Cmd:          cmdParts,
+		Stdout:       true,
+		Stderr:       true,
+		StdoutWriter: output.writer("stdout"),
+		StderrWriter: output.writer("stderr"),
+	})
+	result.Output = output.Close()
+	if err != nil {
+		result.Status = "failed"
+		result.Error = fmt.Sprintf("failed to execute command: %v", err)
+		if ctx.Err() == context.DeadlineExceeded {
+			result.Status = "timeout"
+		}
+		return result
+	}
+
 	result.Status = "success"
-	result.Output = fmt.Sprintf("Command executed in session %s", sessionID)
 	return result
 }
 
@@ -362,6 +388,8 @@ func (e *Executor) ExecuteSystemCommand(cmd Command) CommandResult {
 		return e.getSystemInfo(cmd, result)
 	case "health":
 		return e.getSystemHealth(cmd, result)
+	case "gc":
+		return e.collectGarbage(cmd, result)
 	default:
 		result.Status = "failed"
 		result.Error = fmt.Sprintf("unknown system command: %s", cmd.Action)
@@ -427,3 +455,105 @@ func (e *Executor) getSystemHealth(cmd Command, result CommandResult) CommandRes
 	result.Output = fmt.Sprintf("%+v", health)
 	return result
 }
+
+// collectGarbage runs garbage collection in the agent, which alone knows the workspaces and
+// pooled sessions it holds, and reports the orphans it found as JSON. Params dry_run and
+// grace adjust the run as GCOptions do.
+func (e *Executor) collectGarbage(cmd Command, result CommandResult) CommandResult {
+	var opts GCOptions
+	opts.DryRun, _ = cmd.Params["dry_run"].(bool)
+	if grace, _ := cmd.Params["grace"].(string); grace != "" {
+		d, err := time.ParseDuration(grace)
+		if err != nil {
+			result.Status = "failed"
+			result.Error = fmt.Sprintf("invalid grace period %q", grace)
+			return result
+		}
+		opts.GracePeriod = d
+	}
+
+	orphans, err := e.agent.CollectGarbage(context.Background(), opts)
+	data, _ := json.Marshal(orphans)
+	result.Output = string(data)
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = "success"
+	return result
+}
+
+// changeWorkspace starts, stops or deletes a workspace for the coordination server, which
+// only records the change once the node confirms it. Deleting a workspace this node does
+// not have succeeds: there is nothing left to free.
+func (e *Executor) changeWorkspace(cmd Command, result CommandResult) CommandResult {
+	workspaceID, _ := cmd.Params["workspace_id"].(string)
+	if workspaceID == "" {
+		result.Status = "failed"
+		result.Error = "workspace_id parameter required"
+		return result
+	}
+	wm := e.agent.workspaces
+	if cmd.Action == "delete" && wm.GetWorkspaceStatus(workspaceID) == nil {
+		result.Status = "success"
+		result.Output = fmt.Sprintf("workspace %s is not on this node", workspaceID)
+		return result
+	}
+
+	log.Printf("Running %s of workspace %s for the coordination server", cmd.Action, workspaceID)
+	var err error
+	switch cmd.Action {
+	case "start":
+		err = wm.StartWorkspace(context.Background(), workspaceID)
+	case "stop":
+		err = wm.StopWorkspace(context.Background(), workspaceID)
+	case "delete":
+		err = wm.DeleteWorkspace(context.Background(), workspaceID)
+	}
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Status = "success"
+	return result
+}
+
+// createWorkspace creates a workspace the coordination server placed on this node, such as
+// one rescheduled from a failed node. The output is the WorkspaceCreateResult as JSON.
+func (e *Executor) createWorkspace(cmd Command, result CommandResult) CommandResult {
+	data, err := json.Marshal(cmd.Params)
+	if err != nil {
+		result.Status = "failed"
+		result.Error = fmt.Sprintf("invalid parameters: %v", err)
+		return result
+	}
+	var create CreateWorkspaceCommand
+	if err := json.Unmarshal(data, &create); err != nil {
+		result.Status = "failed"
+		result.Error = fmt.Sprintf("invalid parameters: %v", err)
+		return result
+	}
+	create.ID = cmd.ID
+	create.CreatedAt = time.Now()
+
+	log.Printf("Creating workspace %s for the coordination server", create.WorkspaceID)
+	created, err := e.agent.workspaces.CreateWorkspace(context.Background(), &create)
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+		return result
+	}
+	output, _ := json.Marshal(created)
+	result.Output = string(output)
+	if created.Status != WorkspaceStatusRunning {
+		result.Status = "failed"
+		result.Error = created.Error
+		return result
+	}
+
+	result.Status = "success"
+	return result
+}
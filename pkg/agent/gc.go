@@ -0,0 +1,397 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+)
+
+// orphanStateFile is the name of the orphaned resources seen so far inside CacheDir
+const orphanStateFile = "orphans.json"
+
+const (
+	// defaultGCInterval is how often the agent looks for orphaned resources
+	defaultGCInterval = 10 * time.Minute
+	// defaultGCGracePeriod is how long an orphan is reported before it is deleted
+	defaultGCGracePeriod = time.Hour
+)
+
+// GCConfig controls the garbage collection of resources left behind by failed workspaces
+type GCConfig struct {
+	Disabled    bool          `yaml:"disabled" json:"disabled"`
+	Interval    time.Duration `yaml:"interval" json:"interval"`
+	GracePeriod time.Duration `yaml:"grace_period" json:"grace_period"`
+}
+
+// GCOptions adjusts a single garbage collection run
+type GCOptions struct {
+	DryRun      bool          // report orphans without deleting them or recording them as seen
+	GracePeriod time.Duration // overrides the configured grace period when set
+}
+
+// diskLister is implemented by providers that keep files for a session outside of the
+// session itself, such as QEMU disk images
+type diskLister interface {
+	Disks(ctx context.Context) ([]string, error)
+}
+
+// orphanKey identifies an orphan across garbage collection runs
+func orphanKey(orphan coordination.OrphanedResource) string {
+	return orphan.Kind + "/" + orphan.Provider + "/" + orphan.ID
+}
+
+// loadOrphanState reads when each known orphan was first seen
+func loadOrphanState(cacheDir string) (map[string]time.Time, error) {
+	seen := make(map[string]time.Time)
+	if cacheDir == "" {
+		return seen, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(cacheDir, orphanStateFile))
+	if os.IsNotExist(err) {
+		return seen, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read orphan state: %w", err)
+	}
+	if err := json.Unmarshal(data, &seen); err != nil {
+		return nil, fmt.Errorf("failed to parse orphan state: %w", err)
+	}
+	return seen, nil
+}
+
+// saveOrphanState atomically replaces the orphan state in cacheDir
+func saveOrphanState(cacheDir string, seen map[string]time.Time) error {
+	if cacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(seen, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode orphan state: %w", err)
+	}
+	path := filepath.Join(cacheDir, orphanStateFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write orphan state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write orphan state: %w", err)
+	}
+	return nil
+}
+
+// registeredWorkspaces returns the workspaces the coordination server placed on this node
+func (a *Agent) registeredWorkspaces(ctx context.Context) (map[string]bool, error) {
+	registered := make(map[string]bool)
+	if a.config.CoordinationURL == "" {
+		return registered, nil
+	}
+	ids, err := a.coord.ListNodeWorkspaces(ctx, a.node.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		registered[id] = true
+	}
+	return registered, nil
+}
+
+// CollectGarbage finds resources labelled for workspaces neither the agent nor the
+// coordination server knows about, workspaces whose creation failed, and ports no
+// workspace holds. Orphans are deleted once they have been seen for the grace period; the
+// ones found are returned either way. Sessions and disks are kept while the server cannot
+// say which workspaces it placed here.
+func (a *Agent) CollectGarbage(ctx context.Context, opts GCOptions) ([]coordination.OrphanedResource, error) {
+	grace := opts.GracePeriod
+	if grace <= 0 {
+		grace = a.config.GC.GracePeriod
+	}
+	if grace <= 0 {
+		grace = defaultGCGracePeriod
+	}
+
+	seen, err := loadOrphanState(a.config.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	registered, err := a.registeredWorkspaces(ctx)
+	if err != nil {
+		log.Printf("Keeping orphaned sessions and disks: failed to get the workspaces registered on this node: %v", err)
+	}
+
+	now := time.Now()
+	orphans := a.findOrphans(ctx, registered)
+	current := make(map[string]time.Time, len(orphans))
+	for i := range orphans {
+		orphan := &orphans[i]
+		key := orphanKey(*orphan)
+		firstSeen, ok := seen[key]
+		if !ok {
+			firstSeen = now
+		}
+		orphan.FirstSeen = firstSeen
+		orphan.DeleteAfter = firstSeen.Add(grace)
+		current[key] = firstSeen
+
+		if opts.DryRun || now.Before(orphan.DeleteAfter) {
+			continue
+		}
+		if registered == nil && (orphan.Kind == coordination.OrphanSession || orphan.Kind == coordination.OrphanDisk) {
+			continue
+		}
+		if err := a.deleteOrphan(ctx, *orphan); err != nil {
+			orphan.Error = err.Error()
+			log.Printf("Failed to delete orphaned %s %s: %v", orphan.Kind, orphan.ID, err)
+			continue
+		}
+		orphan.Deleted = true
+		delete(current, key)
+		log.Printf("Deleted orphaned %s %s", orphan.Kind, orphan.ID)
+	}
+
+	if opts.DryRun {
+		return orphans, nil
+	}
+	// Orphans that went away on their own are forgotten
+	if err := saveOrphanState(a.config.CacheDir, current); err != nil {
+		return orphans, err
+	}
+	return orphans, nil
+}
+
+// findOrphans lists the resources no workspace or session accounts for. Workspaces in
+// registered are known even if the agent lost track of them.
+func (a *Agent) findOrphans(ctx context.Context, registered map[string]bool) []coordination.OrphanedResource {
+	wm := a.workspaces
+	known := make(map[string]bool)
+	for id := range registered {
+		known[id] = true
+	}
+	held := make(map[int]bool)
+	var orphans []coordination.OrphanedResource
+
+	wm.mu.RLock()
+	for id, workspace := range wm.workspaces {
+		known[id] = true
+		workspace.mu.RLock()
+		known[workspace.session()] = true
+		if workspace.Status == WorkspaceStatusError {
+			orphans = append(orphans, coordination.OrphanedResource{
+				Kind:        coordination.OrphanWorkspace,
+				Provider:    workspace.Command.Provider,
+				ID:          id,
+				WorkspaceID: id,
+			})
+		}
+		held[workspace.SSHPort] = true
+		for _, svc := range workspace.Services {
+			held[svc.MappedPort] = true
+		}
+		workspace.mu.RUnlock()
+	}
+	wm.portAllocationLock.Lock()
+	for port := range wm.portRange.allocatedPorts {
+		if !held[port] {
+			orphans = append(orphans, coordination.OrphanedResource{
+				Kind: coordination.OrphanPort,
+				ID:   fmt.Sprint(port),
+			})
+		}
+	}
+	wm.portAllocationLock.Unlock()
+	wm.mu.RUnlock()
+
+	a.mu.RLock()
+	for id := range a.sessions {
+		known[id] = true
+	}
+	a.mu.RUnlock()
+	for _, id := range wm.pool.sessions() {
+		known[id] = true
+	}
+
+	for name, prov := range a.providers {
+		if prov == nil {
+			continue
+		}
+		orphaned := make(map[string]bool)
+		sessions, err := prov.List(ctx)
+		if err != nil {
+			// Without a listing nothing of this provider can be told apart from garbage
+			log.Printf("Failed to list %s sessions: %v", name, err)
+			continue
+		}
+		for _, session := range sessions {
+			workspaceID := session.Labels[sessionLabel]
+			if workspaceID == "" || known[workspaceID] {
+				continue
+			}
+			orphaned[workspaceID] = true
+			orphans = append(orphans, coordination.OrphanedResource{
+				Kind:        coordination.OrphanSession,
+				Provider:    name,
+				ID:          session.ID,
+				WorkspaceID: workspaceID,
+			})
+		}
+
+		lister, ok := prov.(diskLister)
+		if !ok {
+			continue
+		}
+		disks, err := lister.Disks(ctx)
+		if err != nil {
+			log.Printf("Failed to list %s disks: %v", name, err)
+			continue
+		}
+		for _, workspaceID := range disks {
+			// Destroying an orphaned session removes its disk too
+			if known[workspaceID] || orphaned[workspaceID] {
+				continue
+			}
+			orphans = append(orphans, coordination.OrphanedResource{
+				Kind:        coordination.OrphanDisk,
+				Provider:    name,
+				ID:          workspaceID,
+				WorkspaceID: workspaceID,
+			})
+		}
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].Kind != orphans[j].Kind {
+			return orphans[i].Kind < orphans[j].Kind
+		}
+		return orphans[i].ID < orphans[j].ID
+	})
+	return orphans
+}
+
+// deleteOrphan removes an orphaned resource, checking first that it is still orphaned
+func (a *Agent) deleteOrphan(ctx context.Context, orphan coordination.OrphanedResource) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	switch orphan.Kind {
+	case coordination.OrphanSession, coordination.OrphanDisk:
+		prov, ok := a.providers[orphan.Provider]
+		if !ok || prov == nil {
+			return fmt.Errorf("provider %s not available", orphan.Provider)
+		}
+		a.mu.RLock()
+		_, registered := a.sessions[orphan.WorkspaceID]
+		a.mu.RUnlock()
+		if registered || a.workspaces.sessionInUse(orphan.WorkspaceID) {
+			return fmt.Errorf("workspace %s is in use again", orphan.WorkspaceID)
+		}
+		return prov.Destroy(ctx, orphan.WorkspaceID)
+
+	case coordination.OrphanWorkspace:
+		return a.workspaces.removeFailedWorkspace(ctx, orphan.ID)
+
+	case coordination.OrphanPort:
+		var port int
+		if _, err := fmt.Sscan(orphan.ID, &port); err != nil {
+			return fmt.Errorf("invalid port %q", orphan.ID)
+		}
+		return a.workspaces.releaseUnheldPort(port)
+	}
+	return fmt.Errorf("unknown orphan kind %q", orphan.Kind)
+}
+
+// removeFailedWorkspace destroys what a failed create left of a workspace, releases its
+// ports and forgets it
+func (wm *WorkspaceManager) removeFailedWorkspace(ctx context.Context, workspaceID string) error {
+	wm.mu.Lock()
+	workspace, exists := wm.workspaces[workspaceID]
+	if !exists {
+		wm.mu.Unlock()
+		return nil
+	}
+	workspace.mu.RLock()
+	failed := workspace.Status == WorkspaceStatusError
+	workspace.mu.RUnlock()
+	if !failed {
+		wm.mu.Unlock()
+		return fmt.Errorf("workspace %s is no longer failed", workspaceID)
+	}
+	delete(wm.workspaces, workspaceID)
+
+	wm.portAllocationLock.Lock()
+	wm.portRange.ReleasePort(workspace.SSHPort)
+	for _, svc := range workspace.Services {
+		wm.portRange.ReleasePort(svc.MappedPort)
+	}
+	wm.portAllocationLock.Unlock()
+	wm.mu.Unlock()
+	wm.saveState()
+
+	// Whatever survives this is labelled for an unknown workspace now and collected as such
+	if prov, ok := wm.providers[workspace.Command.Provider]; ok && prov != nil {
+		if err := prov.Destroy(ctx, workspace.session()); err != nil {
+			log.Printf("Failed to destroy failed workspace %s: %v", workspaceID, err)
+		}
+	}
+	return nil
+}
+
+// releaseUnheldPort frees port unless a workspace took it since it was found orphaned
+func (wm *WorkspaceManager) releaseUnheldPort(port int) error {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	for id, workspace := range wm.workspaces {
+		workspace.mu.RLock()
+		held := workspace.SSHPort == port
+		for _, svc := range workspace.Services {
+			held = held || svc.MappedPort == port
+		}
+		workspace.mu.RUnlock()
+		if held {
+			return fmt.Errorf("port %d is held by workspace %s", port, id)
+		}
+	}
+
+	wm.portAllocationLock.Lock()
+	wm.portRange.ReleasePort(port)
+	wm.portAllocationLock.Unlock()
+	return nil
+}
+
+// gcLoop periodically collects garbage and reports what it found with the next heartbeat
+func (a *Agent) gcLoop(ctx context.Context) {
+	interval := a.config.GC.Interval
+	if interval <= 0 {
+		interval = defaultGCInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			orphans, err := a.CollectGarbage(ctx, GCOptions{})
+			if err != nil {
+				log.Printf("Garbage collection failed: %v", err)
+			}
+			if len(orphans) > 0 {
+				a.mu.Lock()
+				a.orphans = orphans
+				a.mu.Unlock()
+			}
+		}
+	}
+}
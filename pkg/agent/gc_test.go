@@ -0,0 +1,230 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+// gcProvider lists sessions and disks and records what is destroyed
+type gcProvider struct {
+	provider.Provider
+	sessions  []provider.Session
+	disks     []string
+	destroyed []string
+}
+
+func (p *gcProvider) List(ctx context.Context) ([]provider.Session, error) {
+	return p.sessions, nil
+}
+
+func (p *gcProvider) Disks(ctx context.Context) ([]string, error) {
+	return p.disks, nil
+}
+
+func (p *gcProvider) Destroy(ctx context.Context, sessionID string) error {
+	p.destroyed = append(p.destroyed, sessionID)
+	return nil
+}
+
+// gcTestAgent runs one managed workspace, one failed one, and leaks a container, a VM
+// disk and a port
+func gcTestAgent(t *testing.T) (*Agent, *gcProvider, *gcProvider) {
+	docker := &gcProvider{sessions: []provider.Session{
+		{ID: "c1", Labels: map[string]string{sessionLabel: "ws-running"}},
+		{ID: "c2", Labels: map[string]string{sessionLabel: "ws-leaked"}},
+		{ID: "unmanaged"},
+	}}
+	qemu := &gcProvider{disks: []string{"ws-vm", "ws-gone"}}
+
+	agent := &Agent{
+		node:      &Node{ID: "test-node"},
+		config:    NodeConfig{CacheDir: t.TempDir()},
+		providers: map[string]provider.Provider{"docker": docker, "qemu": qemu},
+		sessions:  map[string]*provider.Session{"ws-vm": {ID: "ws-vm"}},
+	}
+	agent.workspaces = NewWorkspaceManager(agent)
+	agent.workspaces.workspaces["ws-running"] = &ManagedWorkspace{
+		Command:  &CreateWorkspaceCommand{WorkspaceID: "ws-running", Provider: "docker"},
+		Status:   WorkspaceStatusRunning,
+		SSHPort:  2222,
+		Services: map[string]*ManagedService{"web": {MappedPort: 23000}},
+	}
+	agent.workspaces.workspaces["ws-failed"] = &ManagedWorkspace{
+		Command:  &CreateWorkspaceCommand{WorkspaceID: "ws-failed", Provider: "docker"},
+		Status:   WorkspaceStatusError,
+		SSHPort:  2223,
+		Services: map[string]*ManagedService{},
+	}
+	for _, port := range []int{2222, 2223, 23000, 23001} {
+		agent.workspaces.portRange.allocatedPorts[port] = true
+	}
+	return agent, docker, qemu
+}
+
+func TestCollectGarbageFindsOrphans(t *testing.T) {
+	agent, docker, qemu := gcTestAgent(t)
+
+	orphans, err := agent.CollectGarbage(context.Background(), GCOptions{DryRun: true})
+	require.NoError(t, err)
+
+	type found struct{ kind, provider, id string }
+	var got []found
+	for _, orphan := range orphans {
+		got = append(got, found{orphan.Kind, orphan.Provider, orphan.ID})
+		assert.False(t, orphan.Deleted)
+		assert.Equal(t, defaultGCGracePeriod, orphan.DeleteAfter.Sub(orphan.FirstSeen))
+	}
+	assert.Equal(t, []found{
+		{coordination.OrphanDisk, "qemu", "ws-gone"},
+		{coordination.OrphanPort, "", "23001"},
+		{coordination.OrphanSession, "docker", "c2"},
+		{coordination.OrphanWorkspace, "docker", "ws-failed"},
+	}, got)
+
+	assert.Empty(t, docker.destroyed, "dry runs delete nothing")
+	assert.Empty(t, qemu.destroyed)
+	_, err = os.Stat(filepath.Join(agent.config.CacheDir, orphanStateFile))
+	assert.True(t, os.IsNotExist(err), "dry runs do not start the grace period")
+}
+
+func TestCollectGarbageDeletesAfterGracePeriod(t *testing.T) {
+	agent, docker, qemu := gcTestAgent(t)
+	ctx := context.Background()
+
+	orphans, err := agent.CollectGarbage(ctx, GCOptions{})
+	require.NoError(t, err)
+	require.Len(t, orphans, 4)
+	assert.Empty(t, docker.destroyed, "orphans are only reported within the grace period")
+
+	// A second run keeps the time the orphans were first seen
+	firstSeen := orphans[0].FirstSeen
+	orphans, err = agent.CollectGarbage(ctx, GCOptions{GracePeriod: time.Hour})
+	require.NoError(t, err)
+	assert.True(t, firstSeen.Equal(orphans[0].FirstSeen))
+
+	orphans, err = agent.CollectGarbage(ctx, GCOptions{GracePeriod: time.Nanosecond})
+	require.NoError(t, err)
+	for _, orphan := range orphans {
+		assert.True(t, orphan.Deleted, "%s %s", orphan.Kind, orphan.ID)
+		assert.Empty(t, orphan.Error)
+	}
+
+	assert.ElementsMatch(t, []string{"ws-leaked", "ws-failed"}, docker.destroyed)
+	assert.Equal(t, []string{"ws-gone"}, qemu.destroyed)
+	assert.NotContains(t, agent.workspaces.workspaces, "ws-failed")
+	assert.Contains(t, agent.workspaces.workspaces, "ws-running")
+	assert.Equal(t, map[int]bool{2222: true, 23000: true}, agent.workspaces.portRange.allocatedPorts)
+
+	seen, err := loadOrphanState(agent.config.CacheDir)
+	require.NoError(t, err)
+	assert.Empty(t, seen, "deleted orphans are forgotten")
+}
+
+func TestCollectGarbageSparesReusedResources(t *testing.T) {
+	agent, docker, _ := gcTestAgent(t)
+	ctx := context.Background()
+
+	_, err := agent.CollectGarbage(ctx, GCOptions{})
+	require.NoError(t, err)
+
+	// The failed workspace was retried and a new workspace took the leaked port
+	agent.workspaces.workspaces["ws-failed"].Status = WorkspaceStatusRunning
+	agent.workspaces.workspaces["ws-new"] = &ManagedWorkspace{
+		Command:  &CreateWorkspaceCommand{WorkspaceID: "ws-new", Provider: "docker"},
+		Status:   WorkspaceStatusRunning,
+		SSHPort:  23001,
+		Services: map[string]*ManagedService{},
+	}
+
+	orphans, err := agent.CollectGarbage(ctx, GCOptions{GracePeriod: time.Nanosecond})
+	require.NoError(t, err)
+	for _, orphan := range orphans {
+		assert.NotEqual(t, coordination.OrphanWorkspace, orphan.Kind)
+		assert.NotEqual(t, coordination.OrphanPort, orphan.Kind)
+	}
+	assert.Equal(t, []string{"ws-leaked"}, docker.destroyed)
+	assert.True(t, agent.workspaces.portRange.allocatedPorts[23001])
+}
+
+func TestCollectGarbageKeepsRegisteredWorkspaces(t *testing.T) {
+	registered := []string{"ws-leaked"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/nodes/test-node/workspaces", r.URL.Path)
+		if registered == nil {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(coordination.NodeWorkspaces{WorkspaceIDs: registered})
+	}))
+	defer server.Close()
+
+	agent, docker, qemu := gcTestAgent(t)
+	agent.config.CoordinationURL = server.URL
+	agent.coord = client.New(server.URL)
+	ctx := context.Background()
+
+	_, err := agent.CollectGarbage(ctx, GCOptions{})
+	require.NoError(t, err)
+	orphans, err := agent.CollectGarbage(ctx, GCOptions{GracePeriod: time.Nanosecond})
+	require.NoError(t, err)
+	for _, orphan := range orphans {
+		assert.NotEqual(t, "ws-leaked", orphan.WorkspaceID, "the server still places ws-leaked here")
+	}
+	assert.Equal(t, []string{"ws-failed"}, docker.destroyed)
+	assert.Equal(t, []string{"ws-gone"}, qemu.destroyed)
+
+	// Without the server's word, sessions and disks are only reported
+	registered = nil
+	_, err = agent.CollectGarbage(ctx, GCOptions{})
+	require.NoError(t, err)
+	orphans, err = agent.CollectGarbage(ctx, GCOptions{GracePeriod: time.Nanosecond})
+	require.NoError(t, err)
+	var sessions []string
+	for _, orphan := range orphans {
+		assert.False(t, orphan.Deleted, "%s %s", orphan.Kind, orphan.ID)
+		if orphan.Kind == coordination.OrphanSession {
+			sessions = append(sessions, orphan.WorkspaceID)
+		}
+	}
+	assert.Equal(t, []string{"ws-leaked"}, sessions)
+	assert.Equal(t, []string{"ws-failed"}, docker.destroyed)
+	assert.Equal(t, []string{"ws-gone"}, qemu.destroyed)
+}
+
+func TestGarbageCollectionCommand(t *testing.T) {
+	agent, docker, _ := gcTestAgent(t)
+
+	result := agent.executeCommand(Command{ID: "gc-1", Type: "system", Action: "gc", Params: map[string]interface{}{"dry_run": true}})
+	require.Equal(t, "success", result.Status, result.Error)
+	var orphans []coordination.OrphanedResource
+	require.NoError(t, json.Unmarshal([]byte(result.Output), &orphans))
+	assert.Len(t, orphans, 4)
+	assert.Empty(t, docker.destroyed)
+
+	result = agent.executeCommand(Command{ID: "gc-2", Type: "system", Action: "gc", Params: map[string]interface{}{"grace": "soon"}})
+	assert.Equal(t, "failed", result.Status)
+	assert.Contains(t, result.Error, "invalid grace period")
+}
+
+func TestHeartbeatReportsOrphans(t *testing.T) {
+	queued := &coordination.NodeHeartbeat{Orphans: []coordination.OrphanedResource{{Kind: coordination.OrphanPort, ID: "23001"}}}
+	mergeHeartbeat(queued, &coordination.NodeHeartbeat{Status: "active"})
+	assert.Len(t, queued.Orphans, 1, "heartbeats without orphans keep the queued report")
+
+	newer := []coordination.OrphanedResource{{Kind: coordination.OrphanSession, Provider: "docker", ID: "c2"}}
+	mergeHeartbeat(queued, &coordination.NodeHeartbeat{Orphans: newer})
+	assert.Equal(t, newer, queued.Orphans, "the newest report replaces older ones")
+}
@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+// ExecuteWorkspaceCommand executes commands against the workspaces this node manages
+func (e *Executor) ExecuteWorkspaceCommand(cmd Command) CommandResult {
+	start := time.Now()
+	result := CommandResult{
+		ID:      cmd.ID,
+		NodeID:  e.agent.node.ID,
+		Command: cmd,
+		Status:  "running",
+	}
+
+	defer func() {
+		result.Duration = time.Since(start)
+		result.Finished = time.Now()
+	}()
+
+	switch cmd.Action {
+	case "create":
+		result = e.createWorkspace(cmd, result)
+	case "start", "stop", "delete":
+		result = e.changeWorkspace(cmd, result)
+	case "git":
+		result = e.runGit(cmd, result)
+	default:
+		result.Status = "failed"
+		result.Error = fmt.Sprintf("unknown workspace command: %s", cmd.Action)
+	}
+	return result
+}
+
+// runGit runs a git operation in the checkout of a workspace
+func (e *Executor) runGit(cmd Command, result CommandResult) CommandResult {
+	workspaceID, _ := cmd.Params["workspace_id"].(string)
+	if workspaceID == "" {
+		result.Status = "failed"
+		result.Error = "workspace_id parameter required"
+		return result
+	}
+	args, err := gitArgs(cmd.Params)
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+		return result
+	}
+	prov, workspace, err := e.agent.workspaces.workspaceProvider(workspaceID)
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+		return result
+	}
+
+	token, err := e.agent.gitHubToken(cmd.ID)
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+		return result
+	}
+
+	log.Printf("Running git %s in workspace %s", args[0], workspaceID)
+
+	ctx := context.Background()
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+
+	output := e.agent.newOutputStream(cmd.ID)
+	err = prov.Exec(ctx, workspace.session(), provider.ExecOptions{
+		Cmd:          append([]string{"git", "-C", workspaceDir}, args...),
+		Env:          gitEnv(token),
+		Stdout:       true,
+		Stderr:       true,
+		StdoutWriter: output.writer("stdout"),
+		StderrWriter: output.writer("stderr"),
+	})
+	result.Output = output.Close()
+	if err != nil {
+		result.Status = "failed"
+		result.Error = fmt.Sprintf("git %s failed: %v", args[0], err)
+		if ctx.Err() == context.DeadlineExceeded {
+			result.Status = "timeout"
+		}
+		return result
+	}
+
+	result.Status = "success"
+	return result
+}
+
+// gitArgs builds the git arguments of an operation. Branch and remote names were
+// validated by the coordination server; "--" keeps them from being read as options anyway.
+func gitArgs(params map[string]interface{}) ([]string, error) {
+	operation, _ := params["operation"].(string)
+	branch, _ := params["branch"].(string)
+	remote, _ := params["remote"].(string)
+	create, _ := params["create"].(bool)
+	if remote == "" {
+		remote = "origin"
+	}
+
+	switch operation {
+	case coordination.GitStatus:
+		return []string{"status", "--porcelain=v1", "--branch"}, nil
+	case coordination.GitFetch:
+		return []string{"fetch", "--prune", "--", remote}, nil
+	case coordination.GitCheckout:
+		if branch == "" {
+			return nil, fmt.Errorf("checkout requires a branch")
+		}
+		if create {
+			return []string{"checkout", "-b", branch}, nil
+		}
+		return []string{"checkout", branch, "--"}, nil
+	case coordination.GitPull:
+		args := []string{"pull", "--ff-only", "--", remote}
+		if branch != "" {
+			args = append(args, branch)
+		}
+		return args, nil
+	case coordination.GitPush:
+		if branch == "" {
+			branch = "HEAD"
+		}
+		return []string{"push", "--set-upstream", "--", remote, branch}, nil
+	default:
+		return nil, fmt.Errorf("unknown git operation %q", operation)
+	}
+}
+
+// gitEnv authenticates git to GitHub with token without writing it to the repository's
+// config or putting it on the command line
+func gitEnv(token string) []string {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if token == "" {
+		return env
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+	return append(env,
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.https://github.com/.extraheader",
+		"GIT_CONFIG_VALUE_0=AUTHORIZATION: basic "+credentials,
+	)
+}
+
+// gitHubToken fetches the GitHub token for a workspace command from the coordination
+// server. It is empty when the workspace owner has no GitHub installation, and git then
+// runs unauthenticated.
+func (a *Agent) gitHubToken(commandID string) (string, error) {
+	if commandID == "" || a.config.CoordinationURL == "" {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	token, err := a.coord.GetCommandGitHubToken(ctx, commandID)
+	if client.StatusCode(err) == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch GitHub token: %w", err)
+	}
+	return token.Token, nil
+}
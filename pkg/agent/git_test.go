@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
+)
+
+func TestWorkspaceGitCommand(t *testing.T) {
+	// The coordination server hands out a token for git-1 only
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/commands/git-1/github-token":
+			json.NewEncoder(w).Encode(coordination.CommandGitHubToken{Token: "ghs_secret"})
+		case "/api/v1/commands/git-3/github-token":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case "/api/v1/commands/git-1/output", "/api/v1/commands/git-2/output":
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	prov := &execProvider{stdout: "## main...origin/main\n"}
+	manager := workspaceAPITestHandler(prov).manager
+	agent := manager.agent
+	agent.workspaces = manager
+	agent.config.CoordinationURL = server.URL
+	agent.coord = client.New(server.URL)
+
+	runID := func(id string, params map[string]interface{}) CommandResult {
+		return agent.executeCommand(Command{ID: id, Type: "workspace", Action: "git", Params: params})
+	}
+	run := func(params map[string]interface{}) CommandResult {
+		return runID("git-2", params)
+	}
+
+	result := runID("git-1", map[string]interface{}{"workspace_id": "ws-1", "operation": "status"})
+	require.Equal(t, "success", result.Status, result.Error)
+	assert.Equal(t, "## main...origin/main\n", result.Output)
+	assert.Equal(t, []string{"git", "-C", workspaceDir, "status", "--porcelain=v1", "--branch"}, prov.cmd)
+	credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:ghs_secret"))
+	assert.Contains(t, prov.env, "GIT_CONFIG_VALUE_0=AUTHORIZATION: basic "+credentials, "the node fetches the owner's token")
+
+	result = runID("git-3", map[string]interface{}{"workspace_id": "ws-1", "operation": "pull"})
+	assert.Equal(t, "failed", result.Status, "git does not run unauthenticated when the token cannot be fetched")
+	assert.Contains(t, result.Error, "failed to fetch GitHub token")
+
+	run(map[string]interface{}{"workspace_id": "ws-1", "operation": "checkout", "branch": "feature", "create": true})
+	assert.Equal(t, []string{"checkout", "-b", "feature"}, prov.cmd[3:])
+	assert.Equal(t, []string{"GIT_TERMINAL_PROMPT=0"}, prov.env, "without a token git runs unauthenticated")
+	run(map[string]interface{}{"workspace_id": "ws-1", "operation": "pull", "remote": "upstream", "branch": "main"})
+	assert.Equal(t, []string{"pull", "--ff-only", "--", "upstream", "main"}, prov.cmd[3:])
+	run(map[string]interface{}{"workspace_id": "ws-1", "operation": "push"})
+	assert.Equal(t, []string{"push", "--set-upstream", "--", "origin", "HEAD"}, prov.cmd[3:])
+
+	prov.err = errors.New("exit status 128")
+	result = run(map[string]interface{}{"workspace_id": "ws-1", "operation": "fetch"})
+	assert.Equal(t, "failed", result.Status)
+	assert.Contains(t, result.Error, "exit status 128")
+
+	result = run(map[string]interface{}{"workspace_id": "ws-1", "operation": "rebase"})
+	assert.Equal(t, "failed", result.Status)
+	result = run(map[string]interface{}{"workspace_id": "ws-2", "operation": "status"})
+	assert.Equal(t, "failed", result.Status)
+	assert.Contains(t, result.Error, "workspace ws-2 not found")
+}
@@ -2,11 +2,12 @@ package agent
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
 )
 
 // HeartbeatService handles periodic heartbeat to coordination server
@@ -65,24 +66,11 @@ func (h *HeartbeatService) sendHeartbeat() error {
 		"uptime":    time.Since(nodeCopy.CreatedAt).String(),
 	}
 
-	url := fmt.Sprintf("%s/api/v1/nodes/%s/heartbeat", h.agent.config.CoordinationURL, h.agent.node.ID)
-	req, err := http.NewRequest("POST", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	if h.agent.config.AuthToken != "" {
-		req.Header.Set("Authorization", "Bearer "+h.agent.config.AuthToken)
-	}
-
 	// For now, we'll simulate the heartbeat call
 	log.Printf("Heartbeat: %+v", heartbeatData)
 
 	// In a real implementation:
-	// req.Body = io.NopCloser(bytes.NewReader(data))
-	// resp, err := h.client.Do(req)
-	// ... handle response
+	// err := h.agent.coord.SendHeartbeat(ctx, h.agent.node.ID, &coordination.NodeHeartbeat{...})
 
 	return nil
 }
@@ -114,22 +102,13 @@ func (s *StatusReporter) ReportStatus(status string, message string) error {
 	s.agent.node.LastSeen = time.Now()
 	s.agent.mu.Unlock()
 
-	statusData := map[string]interface{}{
-		"status":    status,
-		"message":   message,
-		"timestamp": time.Now(),
-	}
-
-	data, err := json.Marshal(statusData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal status data: %w", err)
-	}
-
 	log.Printf("Status update: %s - %s", status, message)
 
-	// In a real implementation, send to coordination server
-	_ = data
-
+	// Status updates wait in the outbox while the coordination server is unreachable
+	entry := outboxEntry{Kind: outboxStatus, Heartbeat: &coordination.NodeHeartbeat{Status: status}}
+	if err := s.agent.deliver(entry); err != nil {
+		return fmt.Errorf("failed to report status: %w", err)
+	}
 	return nil
 }
 
@@ -0,0 +1,381 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+// metricsContentType is the Prometheus text exposition format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// durationBuckets are the histogram bucket bounds, in seconds. They reach to ten minutes
+// because creating a workspace can pull an image.
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// histogram counts observations into durationBuckets
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets))
+	}
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// agentMetrics holds what the agent counts as it runs. Gauges are read from the agent's
+// state when scraped instead. A nil *agentMetrics records nothing.
+type agentMetrics struct {
+	mu                sync.Mutex
+	commands          map[[2]string]uint64 // type, status
+	commandDurations  map[string]*histogram
+	heartbeats        uint64
+	heartbeatFailures uint64
+	providerOps       map[[2]string]*histogram // provider, operation
+	providerErrors    map[[2]string]uint64
+}
+
+func newAgentMetrics() *agentMetrics {
+	return &agentMetrics{
+		commands:         make(map[[2]string]uint64),
+		commandDurations: make(map[string]*histogram),
+		providerOps:      make(map[[2]string]*histogram),
+		providerErrors:   make(map[[2]string]uint64),
+	}
+}
+
+// observeCommand records a command executed for the coordination server
+func (m *agentMetrics) observeCommand(cmdType, status string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands[[2]string{cmdType, status}]++
+	h, ok := m.commandDurations[cmdType]
+	if !ok {
+		h = &histogram{}
+		m.commandDurations[cmdType] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// observeHeartbeat records a heartbeat and whether it failed
+func (m *agentMetrics) observeHeartbeat(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.heartbeats++
+	if err != nil {
+		m.heartbeatFailures++
+	}
+}
+
+// observeProvider records a provider operation
+func (m *agentMetrics) observeProvider(name, operation string, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	key := [2]string{name, operation}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.providerOps[key]
+	if !ok {
+		h = &histogram{}
+		m.providerOps[key] = h
+	}
+	h.observe(d.Seconds())
+	if err != nil {
+		m.providerErrors[key]++
+	}
+}
+
+// write writes the counters and histograms in the text exposition format
+func (m *agentMetrics) write(w *metricsWriter) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.header("nexus_agent_commands_total", "Commands executed for the coordination server, by type and result.", "counter")
+	for _, key := range sortedPairs(m.commands) {
+		w.sample("nexus_agent_commands_total", float64(m.commands[key]), "type", key[0], "status", key[1])
+	}
+	w.header("nexus_agent_command_duration_seconds", "Time taken to execute commands, by type.", "histogram")
+	for _, cmdType := range sortedKeys(m.commandDurations) {
+		w.histogram("nexus_agent_command_duration_seconds", m.commandDurations[cmdType], "type", cmdType)
+	}
+
+	w.header("nexus_agent_heartbeats_total", "Heartbeats sent to the coordination server.", "counter")
+	w.sample("nexus_agent_heartbeats_total", float64(m.heartbeats))
+	w.header("nexus_agent_heartbeat_failures_total", "Heartbeats that could neither be delivered nor queued.", "counter")
+	w.sample("nexus_agent_heartbeat_failures_total", float64(m.heartbeatFailures))
+
+	w.header("nexus_agent_provider_operation_duration_seconds", "Time taken by provider operations, by provider and operation.", "histogram")
+	for _, key := range sortedPairs(m.providerOps) {
+		w.histogram("nexus_agent_provider_operation_duration_seconds", m.providerOps[key], "provider", key[0], "operation", key[1])
+	}
+	w.header("nexus_agent_provider_operation_errors_total", "Provider operations that failed, by provider and operation.", "counter")
+	for _, key := range sortedPairs(m.providerErrors) {
+		w.sample("nexus_agent_provider_operation_errors_total", float64(m.providerErrors[key]), "provider", key[0], "operation", key[1])
+	}
+}
+
+// metricsWriter writes metrics in the Prometheus text exposition format
+type metricsWriter struct {
+	w io.Writer
+}
+
+func (w *metricsWriter) header(name, help, metricType string) {
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sample writes one series; labels alternate names and values
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	fmt.Fprintf(w.w, "%s%s %s\n", name, formatLabels(labels), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (w *metricsWriter) histogram(name string, h *histogram, labels ...string) {
+	var cumulative uint64
+	for i, bound := range durationBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		w.sample(name+"_bucket", float64(cumulative), append(labels, "le", strconv.FormatFloat(bound, 'g', -1, 64))...)
+	}
+	w.sample(name+"_bucket", float64(h.count), append(labels, "le", "+Inf")...)
+	w.sample(name+"_sum", h.sum, labels...)
+	w.sample(name+"_count", float64(h.count), labels...)
+}
+
+// labelEscaper escapes label values as the exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPairs[V any](m map[[2]string]V) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// writeMetrics writes the gauges read from the workspace manager's state
+func (wm *WorkspaceManager) writeMetrics(w *metricsWriter) {
+	workspaces := map[string]int{}
+	for _, status := range []WorkspaceStatus{
+		WorkspaceStatusCreating, WorkspaceStatusInitializing, WorkspaceStatusRunning,
+		WorkspaceStatusStopped, WorkspaceStatusError, WorkspaceStatusDeleting,
+	} {
+		workspaces[string(status)] = 0
+	}
+	services := map[string]int{}
+
+	wm.mu.RLock()
+	for _, workspace := range wm.workspaces {
+		workspace.mu.RLock()
+		workspaces[string(workspace.Status)]++
+		for _, svc := range workspace.Services {
+			services[string(svc.Status)]++
+		}
+		workspace.mu.RUnlock()
+	}
+	wm.mu.RUnlock()
+
+	w.header("nexus_agent_workspaces", "Workspaces managed by the agent, by status.", "gauge")
+	for _, status := range sortedKeys(workspaces) {
+		w.sample("nexus_agent_workspaces", float64(workspaces[status]), "status", status)
+	}
+	w.header("nexus_agent_workspace_services", "Services of managed workspaces, by status.", "gauge")
+	for _, status := range sortedKeys(services) {
+		w.sample("nexus_agent_workspace_services", float64(services[status]), "status", status)
+	}
+
+	if wm.pool != nil {
+		w.header("nexus_agent_pooled_workspaces", "Pooled workspaces warming or waiting to be claimed, by pool.", "gauge")
+		for i := range wm.pool.configs {
+			w.sample("nexus_agent_pooled_workspaces", float64(wm.pool.size(i)), "pool", strconv.Itoa(i))
+		}
+	}
+
+	wm.portAllocationLock.Lock()
+	pr := wm.portRange
+	freeSSH := pr.free(pr.SSHStart, pr.SSHEnd)
+	freeService := pr.free(pr.ServiceStart, pr.ServiceEnd)
+	wm.portAllocationLock.Unlock()
+	totalSSH := max(pr.SSHEnd-pr.SSHStart+1, 0)
+	totalService := max(pr.ServiceEnd-pr.ServiceStart+1, 0)
+
+	w.header("nexus_agent_ports", "Ports the agent allocates to workspaces, by range.", "gauge")
+	w.sample("nexus_agent_ports", float64(totalService), "range", "service")
+	w.sample("nexus_agent_ports", float64(totalSSH), "range", "ssh")
+	w.header("nexus_agent_ports_allocated", "Ports allocated to workspaces, by range.", "gauge")
+	w.sample("nexus_agent_ports_allocated", float64(totalService-freeService), "range", "service")
+	w.sample("nexus_agent_ports_allocated", float64(totalSSH-freeSSH), "range", "ssh")
+}
+
+// writeMetrics writes the agent's gauges and everything it has counted
+func (a *Agent) writeMetrics(w *metricsWriter) {
+	a.mu.RLock()
+	health := make(map[string]string, len(a.services))
+	for name, service := range a.services {
+		health[name] = service.Health
+	}
+	a.mu.RUnlock()
+
+	w.header("nexus_agent_service_healthy", "Whether each node service is healthy.", "gauge")
+	for _, name := range sortedKeys(health) {
+		healthy := 0.0
+		if health[name] == "healthy" {
+			healthy = 1
+		}
+		w.sample("nexus_agent_service_healthy", healthy, "service", name)
+	}
+	w.header("nexus_agent_outbox_backlog", "Messages queued for the coordination server.", "gauge")
+	w.sample("nexus_agent_outbox_backlog", float64(a.OutboxBacklog()))
+
+	a.metrics.write(w)
+}
+
+// handleMetrics serves the agent's metrics for Prometheus to scrape
+func (h *WorkspaceHTTPHandler) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	mw := &metricsWriter{w: w}
+	h.manager.writeMetrics(mw)
+	if h.manager.agent != nil {
+		h.manager.agent.writeMetrics(mw)
+	}
+}
+
+// instrumentedProvider times the operations of a provider
+type instrumentedProvider struct {
+	provider.Provider
+	name    string
+	metrics *agentMetrics
+}
+
+// instrumentProvider wraps prov so its operations are recorded in m, keeping the optional
+// interfaces it implements
+func instrumentProvider(name string, prov provider.Provider, m *agentMetrics) provider.Provider {
+	p := &instrumentedProvider{Provider: prov, name: name, metrics: m}
+	if lister, ok := prov.(diskLister); ok {
+		return &instrumentedDiskProvider{instrumentedProvider: p, lister: lister}
+	}
+	return p
+}
+
+func (p *instrumentedProvider) observe(operation string, start time.Time, err error) {
+	p.metrics.observeProvider(p.name, operation, time.Since(start), err)
+}
+
+func (p *instrumentedProvider) Create(ctx context.Context, sessionID string, workspacePath string, config interface{}) (*provider.Session, error) {
+	start := time.Now()
+	session, err := p.Provider.Create(ctx, sessionID, workspacePath, config)
+	p.observe("create", start, err)
+	return session, err
+}
+
+func (p *instrumentedProvider) Start(ctx context.Context, sessionID string) error {
+	start := time.Now()
+	err := p.Provider.Start(ctx, sessionID)
+	p.observe("start", start, err)
+	return err
+}
+
+func (p *instrumentedProvider) Stop(ctx context.Context, sessionID string) error {
+	start := time.Now()
+	err := p.Provider.Stop(ctx, sessionID)
+	p.observe("stop", start, err)
+	return err
+}
+
+func (p *instrumentedProvider) Destroy(ctx context.Context, sessionID string) error {
+	start := time.Now()
+	err := p.Provider.Destroy(ctx, sessionID)
+	p.observe("destroy", start, err)
+	return err
+}
+
+func (p *instrumentedProvider) Exec(ctx context.Context, sessionID string, opts provider.ExecOptions) error {
+	start := time.Now()
+	err := p.Provider.Exec(ctx, sessionID, opts)
+	p.observe("exec", start, err)
+	return err
+}
+
+func (p *instrumentedProvider) List(ctx context.Context) ([]provider.Session, error) {
+	start := time.Now()
+	sessions, err := p.Provider.List(ctx)
+	p.observe("list", start, err)
+	return sessions, err
+}
+
+// instrumentedDiskProvider is an instrumentedProvider for a provider that lists disks
+type instrumentedDiskProvider struct {
+	*instrumentedProvider
+	lister diskLister
+}
+
+func (p *instrumentedDiskProvider) Disks(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	disks, err := p.lister.Disks(ctx)
+	p.observe("disks", start, err)
+	return disks, err
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingDestroyProvider fails to destroy sessions
+type failingDestroyProvider struct {
+	poolProvider
+}
+
+func (p *failingDestroyProvider) Destroy(ctx context.Context, sessionID string) error {
+	return errors.New("busy")
+}
+
+func TestAgentMetrics(t *testing.T) {
+	wm := createTestWorkspaceManager()
+	agent := wm.agent
+	agent.metrics = newAgentMetrics()
+	agent.services["registry"] = Service{Name: "registry", Health: "healthy"}
+	agent.services["cache"] = Service{Name: "cache", Health: "unhealthy"}
+
+	prov := instrumentProvider("docker", &failingDestroyProvider{}, agent.metrics)
+	_, isLister := prov.(diskLister)
+	assert.False(t, isLister, "wrapping does not add optional interfaces")
+	_, err := prov.Create(context.Background(), "ws-1", "/tmp/ws-1", nil)
+	require.NoError(t, err)
+	assert.Error(t, prov.Destroy(context.Background(), "ws-1"))
+
+	wm.workspaces["ws-1"] = &ManagedWorkspace{
+		Command:  &CreateWorkspaceCommand{WorkspaceID: "ws-1"},
+		Status:   WorkspaceStatusRunning,
+		Services: map[string]*ManagedService{"web": {Status: ServiceStatusRunning}},
+	}
+	_, err = wm.portRange.AllocateSSHPort()
+	require.NoError(t, err)
+
+	agent.metrics.observeCommand("workspace", "success", 300*time.Millisecond)
+	agent.metrics.observeCommand("workspace", "failed", 20*time.Second)
+	agent.metrics.observeHeartbeat(nil)
+	agent.metrics.observeHeartbeat(errors.New("connection refused"))
+
+	handler := NewWorkspaceHTTPHandler(wm, 0, WithAPIConfig(APIConfig{Tokens: []string{"secret"}}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "metrics are behind the API's authentication")
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, metricsContentType, w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE nexus_agent_workspaces gauge",
+		`nexus_agent_workspaces{status="running"} 1`,
+		`nexus_agent_workspaces{status="stopped"} 0`,
+		`nexus_agent_workspace_services{status="running"} 1`,
+		`nexus_agent_service_healthy{service="cache"} 0`,
+		`nexus_agent_service_healthy{service="registry"} 1`,
+		`nexus_agent_ports{range="ssh"} 78`,
+		`nexus_agent_ports_allocated{range="ssh"} 1`,
+		`nexus_agent_ports_allocated{range="service"} 0`,
+		`nexus_agent_commands_total{type="workspace",status="failed"} 1`,
+		`nexus_agent_commands_total{type="workspace",status="success"} 1`,
+		`nexus_agent_command_duration_seconds_bucket{type="workspace",le="0.5"} 1`,
+		`nexus_agent_command_duration_seconds_bucket{type="workspace",le="30"} 2`,
+		`nexus_agent_command_duration_seconds_bucket{type="workspace",le="+Inf"} 2`,
+		`nexus_agent_command_duration_seconds_sum{type="workspace"} 20.3`,
+		`nexus_agent_command_duration_seconds_count{type="workspace"} 2`,
+		"nexus_agent_heartbeats_total 2",
+		"nexus_agent_heartbeat_failures_total 1",
+		`nexus_agent_provider_operation_duration_seconds_count{provider="docker",operation="create"} 1`,
+		`nexus_agent_provider_operation_duration_seconds_count{provider="docker",operation="destroy"} 1`,
+		`nexus_agent_provider_operation_errors_total{provider="docker",operation="destroy"} 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.NotContains(t, body, `operation_errors_total{provider="docker",operation="create"}`)
+}
+
+func TestFormatLabelsEscapes(t *testing.T) {
+	assert.Equal(t, `{service="a\"b\\c\nd"}`, formatLabels([]string{"service", "a\"b\\c\nd"}))
+	assert.Empty(t, formatLabels(nil))
+}
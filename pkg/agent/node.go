@@ -1,19 +1,21 @@
 package agent
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
 	"github.com/nexus/nexus/pkg/provider"
 	"github.com/nexus/nexus/pkg/provider/docker"
 	"github.com/nexus/nexus/pkg/provider/lxc"
@@ -50,15 +52,21 @@ type Service struct {
 
 // NodeConfig represents the agent's configuration
 type NodeConfig struct {
-	CoordinationURL string          `yaml:"coordination_url" json:"coordination_url"`
-	AuthToken       string          `yaml:"auth_token" json:"auth_token"`
-	Provider        string          `yaml:"provider" json:"provider"`
-	Heartbeat       HeartbeatConfig `yaml:"heartbeat" json:"heartbeat"`
-	CommandTimeout  time.Duration   `yaml:"command_timeout" json:"command_timeout"`
-	RetryPolicy     RetryConfig     `yaml:"retry_policy" json:"retry_policy"`
-	OfflineMode     bool            `yaml:"offline_mode" json:"offline_mode"`
-	CacheDir        string          `yaml:"cache_dir" json:"cache_dir"`
-	LogLevel        string          `yaml:"log_level" json:"log_level"`
+	CoordinationURL string            `yaml:"coordination_url" json:"coordination_url"`
+	AuthToken       string            `yaml:"auth_token" json:"auth_token"`
+	EnrollmentToken string            `yaml:"enrollment_token,omitempty" json:"-"`
+	Provider        string            `yaml:"provider" json:"provider"`
+	Heartbeat       HeartbeatConfig   `yaml:"heartbeat" json:"heartbeat"`
+	CommandTimeout  time.Duration     `yaml:"command_timeout" json:"command_timeout"`
+	RetryPolicy     RetryConfig       `yaml:"retry_policy" json:"retry_policy"`
+	Reservations    ReservationConfig `yaml:"reservations" json:"reservations"`
+	Update          UpdateConfig      `yaml:"update" json:"update"`
+	GC              GCConfig          `yaml:"gc" json:"gc"`
+	API             APIConfig         `yaml:"api" json:"api"`
+	Pools           []PoolConfig      `yaml:"pools" json:"pools"`
+	OfflineMode     bool              `yaml:"offline_mode" json:"offline_mode"`
+	CacheDir        string            `yaml:"cache_dir" json:"cache_dir"`
+	LogLevel        string            `yaml:"log_level" json:"log_level"`
 }
 
 type HeartbeatConfig struct {
@@ -73,6 +81,14 @@ type RetryConfig struct {
 	MaxBackoff time.Duration `yaml:"max_backoff" json:"max_backoff"`
 }
 
+// ReservationConfig holds host resources back from workspaces and caps how many may run
+type ReservationConfig struct {
+	CPU           int   `yaml:"cpu" json:"cpu"`
+	MemoryMB      int64 `yaml:"memory_mb" json:"memory_mb"`
+	DiskGB        int64 `yaml:"disk_gb" json:"disk_gb"`
+	MaxWorkspaces int   `yaml:"max_workspaces" json:"max_workspaces"`
+}
+
 // Command represents a command sent to the node
 type Command struct {
 	ID        string                 `json:"id"`
@@ -102,12 +118,36 @@ type Agent struct {
 	node      *Node
 	config    NodeConfig
 	providers map[string]provider.Provider
-	client    *http.Client
+	coord     *client.Client
+
+	// credential is the node's own secret, issued when it enrolled
+	credential *nodeCredential
+	registered bool
+
+	// updating is set while a new agent binary is downloaded and installed
+	updating atomic.Bool
+
+	// outbox holds messages the coordination server has not accepted yet; nil without CacheDir
+	outbox *outbox
+
+	// metrics counts commands, heartbeats and provider operations for /metrics
+	metrics *agentMetrics
 
 	// Runtime state
 	running  bool
 	sessions map[string]*provider.Session
 	services map[string]Service
+	activity map[string]time.Time // workspace ID -> last use not yet reported
+
+	// workspaces tracks the workspaces created through the workspace API; drift is what
+	// reconciling them after a restart found, and orphans what garbage collection last
+	// found, until a heartbeat reports them
+	workspaces *WorkspaceManager
+	drift      *coordination.WorkspaceDrift
+	orphans    []coordination.OrphanedResource
+
+	// api serves the workspace API to local tools when it is enabled
+	api *WorkspaceHTTPHandler
 
 	// Communication
 	commandCh chan Command
@@ -123,7 +163,7 @@ func NewAgent(config NodeConfig) (*Agent, error) {
 		ID:           generateNodeID(),
 		Name:         getHostname(),
 		Host:         getLocalIP(),
-		Version:      "1.0.0",
+		Version:      AgentVersion,
 		Status:       "initializing",
 		CreatedAt:    time.Now(),
 		LastSeen:     time.Now(),
@@ -143,22 +183,41 @@ func NewAgent(config NodeConfig) (*Agent, error) {
 		node.Provider = config.Provider
 	}
 
-	agent := &Agent{
-		node:      node,
-		config:    config,
-		providers: make(map[string]provider.Provider),
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		sessions:  make(map[string]*provider.Session),
-		services:  make(map[string]Service),
-		commandCh: make(chan Command, 100),
+	// An enrolled node keeps the ID its credential is bound to
+	credential, err := loadCredential(config.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	if credential != nil {
+		node.ID = credential.NodeID
 	}
 
+	agent := &Agent{
+		node:       node,
+		credential: credential,
+		config:     config,
+		providers:  make(map[string]provider.Provider),
+		sessions:   make(map[string]*provider.Session),
+		services:   make(map[string]Service),
+		commandCh:  make(chan Command, 100),
+		metrics:    newAgentMetrics(),
+	}
+	agent.coord = client.New(config.CoordinationURL,
+		client.WithHTTPClient(&http.Client{Timeout: 30 * time.Second}),
+		client.WithTokenSource(func() (string, error) { return agent.bearerToken(), nil }),
+	)
+
 	// Initialize providers
 	if err := agent.initProviders(); err != nil {
 		return nil, fmt.Errorf("failed to initialize providers: %w", err)
 	}
+	agent.workspaces = NewWorkspaceManager(agent)
+
+	if config.CacheDir != "" {
+		if agent.outbox, err = openOutbox(config.CacheDir); err != nil {
+			return nil, err
+		}
+	}
 
 	return agent, nil
 }
@@ -167,21 +226,21 @@ func NewAgent(config NodeConfig) (*Agent, error) {
 func (a *Agent) initProviders() error {
 	// Initialize Docker provider
 	if dockerProv, err := docker.NewDockerProvider(); err == nil {
-		a.providers["docker"] = dockerProv
+		a.providers["docker"] = instrumentProvider("docker", dockerProv, a.metrics)
 	} else {
 		log.Printf("Docker provider not available: %v", err)
 	}
 
 	// Initialize LXC provider
 	if lxcProv, err := lxc.NewLXCProvider(); err == nil {
-		a.providers["lxc"] = lxcProv
+		a.providers["lxc"] = instrumentProvider("lxc", lxcProv, a.metrics)
 	} else {
 		log.Printf("LXC provider not available: %v", err)
 	}
 
 	// Initialize QEMU provider
 	if qemuProv, err := qemu.NewQEMUProvider(); err == nil {
-		a.providers["qemu"] = qemuProv
+		a.providers["qemu"] = instrumentProvider("qemu", qemuProv, a.metrics)
 	} else {
 		log.Printf("QEMU provider not available: %v", err)
 	}
@@ -221,9 +280,26 @@ func (a *Agent) Start(ctx context.Context) error {
 
 	log.Printf("Starting node agent %s on %s:%d", a.node.ID, a.node.Host, a.node.Port)
 
+	// Pick up the workspaces that were running before a restart
+	a.reconcileWorkspaces(ctx)
+
+	// Serve the workspace API to tools on this node
+	if a.config.API.Port > 0 {
+		a.api = NewWorkspaceHTTPHandler(a.workspaces, a.config.API.Port, WithAPIConfig(a.config.API))
+		if err := a.api.Start(ctx); err != nil {
+			a.mu.Lock()
+			a.running = false
+			a.mu.Unlock()
+			return fmt.Errorf("failed to start workspace API: %w", err)
+		}
+	}
+
 	// Register with coordination server
 	if err := a.registerWithServer(); err != nil {
 		log.Printf("Failed to register with coordination server: %v", err)
+		if upgradeRequired(err) {
+			a.handleUpgradeRequired(ctx)
+		}
 		if !a.config.OfflineMode {
 			return fmt.Errorf("registration failed: %w", err)
 		}
@@ -233,7 +309,22 @@ func (a *Agent) Start(ctx context.Context) error {
 	// Start background processes
 	go a.heartbeatLoop(ctx)
 	go a.commandProcessor(ctx)
+	if a.config.CoordinationURL != "" {
+		go a.commandPollLoop(ctx)
+	}
 	go a.serviceMonitor(ctx)
+	if a.outbox != nil {
+		go a.replayOutbox(ctx)
+	}
+	if a.config.Update.Enabled {
+		go a.updateLoop(ctx)
+	}
+	if !a.config.GC.Disabled {
+		go a.gcLoop(ctx)
+	}
+	if len(a.config.Pools) > 0 {
+		go a.poolLoop(ctx)
+	}
 
 	log.Printf("Node agent started successfully")
 	return nil
@@ -259,6 +350,12 @@ func (a *Agent) Stop(ctx context.Context) error {
 		}
 	}
 
+	if a.api != nil {
+		if err := a.api.Stop(ctx); err != nil {
+			log.Printf("Error stopping workspace API: %v", err)
+		}
+	}
+
 	// Unregister from coordination server
 	if err := a.unregisterFromServer(); err != nil {
 		log.Printf("Failed to unregister from server: %v", err)
@@ -274,62 +371,93 @@ func (a *Agent) registerWithServer() error {
 		return fmt.Errorf("coordination URL not configured")
 	}
 
-	data, err := json.Marshal(a.node)
-	if err != nil {
-		return fmt.Errorf("failed to marshal node data: %w", err)
-	}
-
-	url := fmt.Sprintf("%s/api/v1/nodes", a.config.CoordinationURL)
-	req, err := http.NewRequest("POST", url, io.NopCloser(bytes.NewReader(data)))
+	registration, err := a.coord.RegisterNode(context.Background(), a.coordinationNode())
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("registration failed: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	if a.config.AuthToken != "" {
-		req.Header.Set("Authorization", "Bearer "+a.config.AuthToken)
+	// Registering with an enrollment token returns the node's own credential
+	if issued := registration.Credential; issued != nil {
+		credential := &nodeCredential{NodeID: issued.NodeID, Secret: issued.Secret, ExpiresAt: issued.ExpiresAt}
+		if err := a.storeCredential(credential); err != nil {
+			return fmt.Errorf("failed to store node credential: %w", err)
+		}
+		log.Printf("Enrolled node %s with its own credential", credential.NodeID)
 	}
 
-	resp, err := a.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send registration: %w", err)
+	// The server wants this node on another agent version
+	if release := registration.Update; release != nil {
+		go func() {
+			if err := a.applyUpdate(context.Background(), release); err != nil {
+				log.Printf("Agent update failed: %v", err)
+			}
+		}()
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("registration failed with status %d: %s", resp.StatusCode, string(body))
-	}
+	a.mu.Lock()
+	a.registered = true
+	a.mu.Unlock()
 
 	log.Printf("Successfully registered with coordination server")
 	return nil
 }
 
-// unregisterFromServer unregisters the node from the coordination server
-func (a *Agent) unregisterFromServer() error {
-	if a.config.CoordinationURL == "" {
-		return nil
+// coordinationNode describes the node the way the coordination server registers it
+func (a *Agent) coordinationNode() *coordination.Node {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	capabilities := make(map[string]interface{}, len(a.node.Capabilities))
+	for _, capability := range a.node.Capabilities {
+		capabilities[capability] = true
+	}
+
+	services := make(map[string]coordination.NodeService, len(a.node.Services))
+	for name, service := range a.node.Services {
+		nodeService := coordination.NodeService{
+			ID:     name,
+			Name:   service.Name,
+			Type:   service.Type,
+			Status: service.Status,
+			Port:   service.Port,
+			Labels: service.Labels,
+		}
+		if service.Health != "" {
+			nodeService.Health = &coordination.HealthStatus{Status: service.Health}
+		}
+		services[name] = nodeService
 	}
 
-	url := fmt.Sprintf("%s/api/v1/nodes/%s", a.config.CoordinationURL, a.node.ID)
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	metadata := make(map[string]interface{}, len(a.node.Metadata)+2)
+	for k, v := range a.node.Metadata {
+		metadata[k] = v
 	}
+	metadata["version"] = a.node.Version
+	metadata["protocol_version"] = coordination.AgentProtocolVersion
 
-	if a.config.AuthToken != "" {
-		req.Header.Set("Authorization", "Bearer "+a.config.AuthToken)
+	return &coordination.Node{
+		ID:           a.node.ID,
+		Name:         a.node.Name,
+		Provider:     a.node.Provider,
+		Status:       a.node.Status,
+		Address:      a.node.Host,
+		Port:         a.node.Port,
+		LastSeen:     time.Now(),
+		Capabilities: capabilities,
+		Services:     services,
+		Metadata:     metadata,
+		CreatedAt:    a.node.CreatedAt,
 	}
+}
 
-	resp, err := a.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send unregistration: %w", err)
+// unregisterFromServer unregisters the node from the coordination server
+func (a *Agent) unregisterFromServer() error {
+	if a.config.CoordinationURL == "" {
+		return nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unregistration failed with status %d: %s", resp.StatusCode, string(body))
+	if err := a.coord.UnregisterNode(context.Background(), a.node.ID); err != nil {
+		return fmt.Errorf("unregistration failed: %w", err)
 	}
 
 	log.Printf("Successfully unregistered from coordination server")
@@ -346,8 +474,16 @@ func (a *Agent) heartbeatLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if err := a.sendHeartbeat(); err != nil {
+			err := a.sendHeartbeat()
+			a.metrics.observeHeartbeat(err)
+			if err != nil {
 				log.Printf("Failed to send heartbeat: %v", err)
+				if upgradeRequired(err) {
+					a.handleUpgradeRequired(ctx)
+				}
+			}
+			if err := a.rotateCredential(); err != nil {
+				log.Printf("Failed to rotate node credential: %v", err)
 			}
 		}
 	}
@@ -360,41 +496,37 @@ func (a *Agent) sendHeartbeat() error {
 	}
 
 	a.mu.RLock()
-	nodeCopy := *a.node
-	nodeCopy.LastSeen = time.Now()
+	status := a.node.Status
+	drift := a.drift
+	orphans := a.orphans
 	a.mu.RUnlock()
+	a.workspaces.observeConnections(context.Background())
+	activity := a.pendingActivity()
 
-	data, err := json.Marshal(map[string]interface{}{
-		"last_seen": nodeCopy.LastSeen,
-		"status":    nodeCopy.Status,
-		"services":  a.services,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to marshal heartbeat data: %w", err)
-	}
+	heartbeat := &coordination.NodeHeartbeat{
+		Status:   status,
+		Activity: activity,
+		Drift:    drift,
+		Orphans:  orphans,
+		Capacity: a.workspaces.Capacity(),
 
-	url := fmt.Sprintf("%s/api/v1/nodes/%s/heartbeat", a.config.CoordinationURL, a.node.ID)
-	req, err := http.NewRequest("POST", url, io.NopCloser(bytes.NewReader(data)))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		ProtocolVersion: coordination.AgentProtocolVersion,
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	if a.config.AuthToken != "" {
-		req.Header.Set("Authorization", "Bearer "+a.config.AuthToken)
+	if err := a.deliver(outboxEntry{Kind: outboxHeartbeat, Heartbeat: heartbeat}); err != nil {
+		return fmt.Errorf("heartbeat failed: %w", err)
 	}
 
-	resp, err := a.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send heartbeat: %w", err)
+	a.mu.Lock()
+	if drift != nil && a.drift == drift {
+		a.drift = nil
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("heartbeat failed with status %d: %s", resp.StatusCode, string(body))
+	// Keep orphans a garbage collection found while this heartbeat was in flight
+	if len(orphans) > 0 && len(a.orphans) > 0 && &a.orphans[0] == &orphans[0] {
+		a.orphans = nil
 	}
+	a.mu.Unlock()
 
+	a.activityReported(activity)
 	return nil
 }
 
@@ -405,7 +537,9 @@ func (a *Agent) commandProcessor(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case cmd := <-a.commandCh:
+			start := time.Now()
 			result := a.executeCommand(cmd)
+			a.metrics.observeCommand(cmd.Type, result.Status, time.Since(start))
 			if err := a.sendCommandResult(result); err != nil {
 				log.Printf("Failed to send command result: %v", err)
 			}
@@ -413,6 +547,75 @@ func (a *Agent) commandProcessor(ctx context.Context) {
 	}
 }
 
+// commandPollWait is how long the server holds each command poll open
+const commandPollWait = 20 * time.Second
+
+// credentialWaitInterval is how often an agent without a node credential checks for one
+// before polling for commands
+var credentialWaitInterval = time.Minute
+
+// commandPollLoop waits on the coordination server for commands sent to this node and
+// queues them for commandProcessor. Each poll acknowledges the commands of the previous
+// one; until a poll gets through, the server delivers them again and they are skipped.
+// The server hands a node's commands only to the node's own credential, so the loop does
+// not poll until the agent has enrolled.
+func (a *Agent) commandPollLoop(ctx context.Context) {
+	if !a.enrolled() {
+		log.Printf("Not polling for commands: node %s has no node credential, and the server refuses the shared auth token. Register the node with an enrollment token to receive commands.", a.node.ID)
+	}
+	for !a.enrolled() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(credentialWaitInterval):
+		}
+	}
+
+	backoff := time.Second
+	var acked []string
+	for ctx.Err() == nil {
+		commands, err := a.coord.PollNodeCommands(ctx, a.node.ID, acked, commandPollWait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to poll for commands: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+		backoff = time.Second
+
+		received := acked
+		acked = make([]string, 0, len(commands))
+		for _, c := range commands {
+			acked = append(acked, c.ID)
+			if slices.Contains(received, c.ID) {
+				continue
+			}
+			workspace, _ := c.Params["workspace_id"].(string)
+			cmd := Command{
+				ID:        c.ID,
+				Type:      c.Type,
+				Action:    c.Action,
+				Params:    c.Params,
+				Workspace: workspace,
+				Timeout:   c.Timeout,
+				Created:   c.Created,
+			}
+			select {
+			case a.commandCh <- cmd:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
 // executeCommand executes a command and returns the result
 func (a *Agent) executeCommand(cmd Command) CommandResult {
 	executor := NewExecutor(a)
@@ -424,6 +627,8 @@ func (a *Agent) executeCommand(cmd Command) CommandResult {
 		return executor.ExecuteServiceCommand(cmd)
 	case "system":
 		return executor.ExecuteSystemCommand(cmd)
+	case "workspace":
+		return executor.ExecuteWorkspaceCommand(cmd)
 	default:
 		return CommandResult{
 			ID:       cmd.ID,
@@ -442,31 +647,25 @@ func (a *Agent) sendCommandResult(result CommandResult) error {
 		return nil
 	}
 
-	data, err := json.Marshal(result)
-	if err != nil {
-		return fmt.Errorf("failed to marshal result: %w", err)
-	}
-
-	url := fmt.Sprintf("%s/api/v1/commands/%s/result", a.config.CoordinationURL, result.ID)
-	req, err := http.NewRequest("POST", url, io.NopCloser(bytes.NewReader(data)))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	if a.config.AuthToken != "" {
-		req.Header.Set("Authorization", "Bearer "+a.config.AuthToken)
-	}
-
-	resp, err := a.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send result: %w", err)
+	report := &coordination.CommandResult{
+		ID:     result.ID,
+		NodeID: result.NodeID,
+		Command: coordination.Command{
+			ID:      result.Command.ID,
+			Type:    result.Command.Type,
+			Action:  result.Command.Action,
+			Params:  result.Command.Params,
+			Timeout: result.Command.Timeout,
+			Created: result.Command.Created,
+		},
+		Status:   result.Status,
+		Output:   result.Output,
+		Error:    result.Error,
+		Duration: result.Duration,
+		Finished: result.Finished,
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("result submission failed with status %d: %s", resp.StatusCode, string(body))
+	if err := a.deliver(outboxEntry{Kind: outboxCommandResult, Result: report}); err != nil {
+		return fmt.Errorf("result submission failed: %w", err)
 	}
 
 	return nil
@@ -787,7 +986,15 @@ func (a *Agent) listSessions(cmd Command, result CommandResult) CommandResult {
 
 func (a *Agent) execInSession(cmd Command, result CommandResult) CommandResult {
 	executor := NewExecutor(a)
-	return executor.execInSessionFunc(cmd, result)
+	result = executor.execInSessionFunc(cmd, result)
+	if result.Status == "success" {
+		workspaceID := cmd.Workspace
+		if workspaceID == "" {
+			workspaceID, _ = cmd.Params["session_id"].(string)
+		}
+		a.RecordActivity(workspaceID)
+	}
+	return result
 }
 
 func (a *Agent) listServices(cmd Command, result CommandResult) CommandResult {
@@ -0,0 +1,320 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
+)
+
+// outboxFile is the name of the undelivered message queue inside CacheDir
+const outboxFile = "outbox.json"
+
+// maxOutboxEntries bounds the backlog; the oldest entries are dropped beyond it
+const maxOutboxEntries = 10000
+
+// Kinds of messages the agent sends to the coordination server
+const (
+	outboxHeartbeat     = "heartbeat"
+	outboxStatus        = "status"
+	outboxCommandResult = "command_result"
+)
+
+// outboxEntry is a message for the coordination server waiting to be delivered
+type outboxEntry struct {
+	Kind      string                      `json:"kind"`
+	NodeID    string                      `json:"node_id"`
+	Heartbeat *coordination.NodeHeartbeat `json:"heartbeat,omitempty"` // heartbeat and status
+	Result    *coordination.CommandResult `json:"result,omitempty"`
+	QueuedAt  time.Time                   `json:"queued_at"`
+	Attempts  int                         `json:"attempts"`
+}
+
+// outbox is the durable, ordered queue of messages the coordination server has not accepted yet
+type outbox struct {
+	path    string
+	mu      sync.Mutex
+	entries []outboxEntry
+	notify  chan struct{}
+}
+
+// openOutbox loads the outbox saved in cacheDir, or starts an empty one
+func openOutbox(cacheDir string) (*outbox, error) {
+	o := &outbox{
+		path:   filepath.Join(cacheDir, outboxFile),
+		notify: make(chan struct{}, 1),
+	}
+
+	data, err := os.ReadFile(o.path)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	if err := json.Unmarshal(data, &o.entries); err != nil {
+		return nil, fmt.Errorf("failed to parse outbox: %w", err)
+	}
+	if len(o.entries) > 0 {
+		o.wake()
+	}
+	return o, nil
+}
+
+// Len returns the number of undelivered messages
+func (o *outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// push queues entry behind the backlog. Consecutive heartbeats are merged so an agent
+// that stays offline does not queue one per interval.
+func (o *outbox) push(entry outboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if n := len(o.entries); n > 0 && entry.Kind == outboxHeartbeat && o.entries[n-1].Kind == outboxHeartbeat {
+		mergeHeartbeat(o.entries[n-1].Heartbeat, entry.Heartbeat)
+	} else {
+		o.entries = append(o.entries, entry)
+	}
+	if dropped := len(o.entries) - maxOutboxEntries; dropped > 0 {
+		log.Printf("Outbox full, dropping %d oldest messages", dropped)
+		o.entries = o.entries[dropped:]
+	}
+
+	if err := o.save(); err != nil {
+		return err
+	}
+	o.wake()
+	return nil
+}
+
+// peek returns the oldest undelivered message
+func (o *outbox) peek() (outboxEntry, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.entries) == 0 {
+		return outboxEntry{}, false
+	}
+	return o.entries[0], true
+}
+
+// pop removes the oldest message once it was delivered or given up on
+func (o *outbox) pop() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.entries) > 0 {
+		o.entries = o.entries[1:]
+	}
+	return o.save()
+}
+
+// failed records a failed delivery of the oldest message and returns its attempt count
+func (o *outbox) failed() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.entries) == 0 {
+		return 0
+	}
+	o.entries[0].Attempts++
+	return o.entries[0].Attempts
+}
+
+// wake signals the replay loop that messages are waiting
+func (o *outbox) wake() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// save atomically replaces the outbox file. The caller holds o.mu.
+func (o *outbox) save() error {
+	data, err := json.Marshal(o.entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(o.path), 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	return nil
+}
+
+// mergeHeartbeat folds a newer heartbeat into a queued one
+func mergeHeartbeat(queued, newer *coordination.NodeHeartbeat) {
+	if newer.Status != "" {
+		queued.Status = newer.Status
+	}
+	if newer.Capacity != nil {
+		queued.Capacity = newer.Capacity
+	}
+	if newer.Orphans != nil {
+		queued.Orphans = newer.Orphans
+	}
+	for id, at := range newer.Activity {
+		if queued.Activity == nil {
+			queued.Activity = make(map[string]time.Time)
+		}
+		if at.After(queued.Activity[id]) {
+			queued.Activity[id] = at
+		}
+	}
+	if newer.Drift != nil {
+		if queued.Drift == nil {
+			queued.Drift = &coordination.WorkspaceDrift{}
+		}
+		queued.Drift.Adopted = append(queued.Drift.Adopted, newer.Drift.Adopted...)
+		queued.Drift.Vanished = append(queued.Drift.Vanished, newer.Drift.Vanished...)
+	}
+}
+
+// retryable reports whether a failed delivery may succeed later: the server was unreachable,
+// overloaded or failed internally
+func retryable(err error) bool {
+	code := client.StatusCode(err)
+	return code == 0 || code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// delay returns how long to wait before retry attempt n, doubling from Backoff up to MaxBackoff
+func (r RetryConfig) delay(attempt int) time.Duration {
+	d := r.Backoff
+	if d <= 0 {
+		d = time.Second
+	}
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if r.MaxBackoff > 0 && d >= r.MaxBackoff {
+			return r.MaxBackoff
+		}
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		return r.MaxBackoff
+	}
+	return d
+}
+
+// OutboxBacklog returns the number of messages waiting for the coordination server
+func (a *Agent) OutboxBacklog() int {
+	if a.outbox == nil {
+		return 0
+	}
+	return a.outbox.Len()
+}
+
+// deliver sends a message to the coordination server. With an outbox, a message the server
+// could not take right now is queued and nil is returned; messages are never sent ahead
+// of the backlog.
+func (a *Agent) deliver(entry outboxEntry) error {
+	if entry.NodeID == "" {
+		entry.NodeID = a.node.ID
+	}
+	if a.outbox == nil {
+		return a.post(context.Background(), entry)
+	}
+
+	if a.outbox.Len() == 0 {
+		err := a.post(context.Background(), entry)
+		if err == nil || !retryable(err) {
+			return err
+		}
+		log.Printf("Coordination server unavailable, queueing %s: %v", entry.Kind, err)
+	}
+
+	entry.QueuedAt = time.Now()
+	return a.outbox.push(entry)
+}
+
+// post sends one message to the coordination server
+func (a *Agent) post(ctx context.Context, entry outboxEntry) error {
+	switch entry.Kind {
+	case outboxHeartbeat, outboxStatus:
+		return a.coord.SendHeartbeat(ctx, entry.NodeID, entry.Heartbeat)
+	case outboxCommandResult:
+		return a.coord.ReportCommandResult(ctx, entry.Result)
+	default:
+		return fmt.Errorf("unknown outbox message kind: %s", entry.Kind)
+	}
+}
+
+// replayOutbox delivers queued messages in order whenever there are any, backing off while
+// the coordination server is unreachable
+func (a *Agent) replayOutbox(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.outbox.notify:
+		}
+
+		for {
+			entry, ok := a.outbox.peek()
+			if !ok {
+				break
+			}
+
+			err := a.ensureRegistered()
+			if err == nil {
+				err = a.post(ctx, entry)
+			}
+
+			switch {
+			case err == nil:
+				if err := a.outbox.pop(); err != nil {
+					log.Printf("Failed to update outbox: %v", err)
+				}
+				continue
+			case !retryable(err):
+				log.Printf("Dropping queued %s rejected by the coordination server: %v", entry.Kind, err)
+				if err := a.outbox.pop(); err != nil {
+					log.Printf("Failed to update outbox: %v", err)
+				}
+				continue
+			}
+
+			// The server answered but failed; give up on the message after MaxRetries
+			attempts := a.outbox.failed()
+			if client.StatusCode(err) != 0 && a.config.RetryPolicy.MaxRetries > 0 && attempts >= a.config.RetryPolicy.MaxRetries {
+				log.Printf("Dropping queued %s after %d attempts: %v", entry.Kind, attempts, err)
+				if err := a.outbox.pop(); err != nil {
+					log.Printf("Failed to update outbox: %v", err)
+				}
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(a.config.RetryPolicy.delay(attempts)):
+			}
+		}
+	}
+}
+
+// ensureRegistered registers the node if it started without reaching the coordination server
+func (a *Agent) ensureRegistered() error {
+	a.mu.RLock()
+	registered := a.registered
+	a.mu.RUnlock()
+	if registered {
+		return nil
+	}
+	return a.registerWithServer()
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxReplaysInOrderAfterOutage(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		down     = true
+		received []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, r.URL.Path)
+		if r.URL.Path == "/api/v1/nodes" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": "node-1"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	config := NodeConfig{
+		CoordinationURL: server.URL,
+		CacheDir:        cacheDir,
+		RetryPolicy:     RetryConfig{MaxRetries: 3, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+	}
+	agent, err := NewAgent(config)
+	require.NoError(t, err)
+	agent.node.ID = "node-1"
+
+	agent.RecordActivity("ws-1")
+	require.NoError(t, agent.sendHeartbeat(), "heartbeats are queued while the server is down")
+	require.NoError(t, agent.sendHeartbeat())
+	require.NoError(t, agent.sendCommandResult(CommandResult{ID: "cmd-1", NodeID: "node-1"}))
+	assert.Equal(t, 2, agent.OutboxBacklog(), "consecutive heartbeats are merged")
+	assert.Empty(t, agent.pendingActivity(), "queued activity counts as reported")
+
+	queued := agent.outbox.entries[0].Heartbeat
+	assert.Contains(t, queued.Activity, "ws-1")
+
+	handler := NewWorkspaceHTTPHandler(agent.workspaces, 0)
+	w := httptest.NewRecorder()
+	handler.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/health", nil))
+	var health map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, float64(2), health["outbox"])
+
+	// The backlog survives a restart
+	restarted, err := NewAgent(config)
+	require.NoError(t, err)
+	require.Equal(t, 2, restarted.OutboxBacklog())
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go restarted.replayOutbox(ctx)
+
+	require.Eventually(t, func() bool { return restarted.OutboxBacklog() == 0 }, 5*time.Second, 5*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"/api/v1/nodes",
+		"/api/v1/nodes/node-1/heartbeat",
+		"/api/v1/commands/cmd-1/result",
+	}, received, "the node registers before its backlog is replayed in order")
+}
+
+func TestOutboxDropsRejectedMessages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(NodeConfig{CoordinationURL: server.URL, CacheDir: t.TempDir()})
+	require.NoError(t, err)
+
+	require.Error(t, agent.sendHeartbeat(), "a rejected message is not queued")
+	assert.Zero(t, agent.OutboxBacklog())
+}
+
+func TestRetryDelay(t *testing.T) {
+	retry := RetryConfig{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, retry.delay(1))
+	assert.Equal(t, 2*time.Second, retry.delay(2))
+	assert.Equal(t, 4*time.Second, retry.delay(3))
+	assert.Equal(t, 5*time.Second, retry.delay(4))
+	assert.Equal(t, time.Second, RetryConfig{}.delay(1))
+}
@@ -0,0 +1,355 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+// poolSessionPrefix starts the session IDs of pooled workspaces
+const poolSessionPrefix = "pool-"
+
+const (
+	// defaultPoolRefillInterval is how often pools are topped up when nothing claims from them
+	defaultPoolRefillInterval = time.Minute
+	// poolWarmTimeout bounds creating a pooled workspace, image pull and clone included
+	poolWarmTimeout = 10 * time.Minute
+)
+
+// PoolConfig keeps Size idle workspaces warm for workspaces of one provider, image and
+// repository. Pooled workspaces have the SSH server installed and the repository cloned,
+// so claiming one only adds the user and checks out their branch. Repositories that need
+// credentials cannot be cloned before a workspace owner is known and are cloned on claim
+// instead. Pooled workspaces are not counted against the node's capacity until claimed.
+type PoolConfig struct {
+	Provider   string `yaml:"provider" json:"provider"`
+	Image      string `yaml:"image" json:"image"`
+	Repository string `yaml:"repository" json:"repository"` // clone URL, matched against repository.url
+	Size       int    `yaml:"size" json:"size"`
+}
+
+// matches reports whether a workspace created by cmd can be claimed from the pool
+func (c PoolConfig) matches(cmd *CreateWorkspaceCommand) bool {
+	return c.Provider == cmd.Provider && c.Image == cmd.Image && c.Repository == cmd.Repository.URL
+}
+
+// pooledWorkspace is a workspace being warmed or waiting to be claimed
+type pooledWorkspace struct {
+	sessionID   string
+	containerID string
+	pool        int // index into the pool configuration
+	ready       bool
+	cloned      bool // the repository was cloned without credentials while warming
+}
+
+// workspacePool tracks the pooled workspaces of a WorkspaceManager
+type workspacePool struct {
+	mu      sync.Mutex
+	configs []PoolConfig
+	members map[string]*pooledWorkspace // session ID -> workspace
+	refill  chan struct{}
+}
+
+func newWorkspacePool(configs []PoolConfig) *workspacePool {
+	return &workspacePool{
+		configs: configs,
+		members: make(map[string]*pooledWorkspace),
+		refill:  make(chan struct{}, 1),
+	}
+}
+
+// claim takes a ready workspace for cmd out of the pool, or returns nil when there is none
+func (p *workspacePool) claim(cmd *CreateWorkspaceCommand) *pooledWorkspace {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, member := range p.members {
+		if member.ready && p.configs[member.pool].matches(cmd) {
+			delete(p.members, id)
+			p.requestRefill()
+			return member
+		}
+	}
+	return nil
+}
+
+// requestRefill wakes the pool loop without waiting for it
+func (p *workspacePool) requestRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// holds reports whether sessionID is being warmed or waiting to be claimed
+func (p *workspacePool) holds(sessionID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.members[sessionID]
+	return ok
+}
+
+// sessions returns the session IDs of the pooled workspaces
+func (p *workspacePool) sessions() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]string, 0, len(p.members))
+	for id := range p.members {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// size counts the workspaces of a pool, ready or not
+func (p *workspacePool) size(pool int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, member := range p.members {
+		if member.pool == pool {
+			n++
+		}
+	}
+	return n
+}
+
+// newPoolSessionID returns a session ID for a pooled workspace
+func newPoolSessionID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return poolSessionPrefix + hex.EncodeToString(b)
+}
+
+// claimPooled sets a workspace up in a pooled one, adding the user and checking out the
+// branch. It returns nil when no pooled workspace matches or setting it up fails, leaving
+// the workspace to be created from scratch.
+func (wm *WorkspaceManager) claimPooled(ctx context.Context, prov provider.Provider, workspace *ManagedWorkspace) *WorkspaceCreateResult {
+	cmd := workspace.Command
+	member := wm.pool.claim(cmd)
+	if member == nil {
+		return nil
+	}
+
+	workspace.mu.Lock()
+	workspace.SessionID = member.sessionID
+	workspace.mu.Unlock()
+
+	err := wm.addSSHUser(ctx, prov, member.sessionID, cmd.SSH)
+	if repository := wm.pool.configs[member.pool].Repository; err == nil && repository != "" {
+		err = wm.setUpRepository(ctx, prov, member, repository, cmd)
+	}
+	if err != nil {
+		log.Printf("Failed to set up pooled workspace %s for %s, creating it from scratch: %v", member.sessionID, cmd.WorkspaceID, err)
+		workspace.mu.Lock()
+		workspace.SessionID = ""
+		workspace.mu.Unlock()
+		if err := prov.Destroy(ctx, member.sessionID); err != nil {
+			log.Printf("Failed to destroy pooled workspace %s: %v", member.sessionID, err)
+		}
+		return nil
+	}
+
+	workspace.mu.Lock()
+	workspace.ContainerID = member.containerID
+	workspace.Status = WorkspaceStatusRunning
+	workspace.mu.Unlock()
+	log.Printf("Workspace %s claimed pooled workspace %s", cmd.WorkspaceID, member.sessionID)
+
+	return &WorkspaceCreateResult{
+		WorkspaceID: cmd.WorkspaceID,
+		ContainerID: member.containerID,
+		Status:      WorkspaceStatusRunning,
+		SSHPort:     workspace.SSHPort,
+		Services:    make(map[string]int),
+		Timestamp:   time.Now(),
+	}
+}
+
+// setUpRepository brings the repository of a claimed workspace up to date with the
+// owner's GitHub token, cloning it when that could not be done while warming, and checks
+// out the workspace's branch when it names one
+func (wm *WorkspaceManager) setUpRepository(ctx context.Context, prov provider.Provider, member *pooledWorkspace, repository string, cmd *CreateWorkspaceCommand) error {
+	token, err := wm.agent.gitHubToken(cmd.ID)
+	if err != nil {
+		return err
+	}
+	branch := cmd.Repository.Branch
+
+	if !member.cloned {
+		clone := []string{"clone", "--quiet"}
+		if branch != "" {
+			clone = append(clone, "--branch", branch)
+		}
+		return runPoolGit(ctx, prov, member.sessionID, token, append(clone, "--", repository, workspaceDir)...)
+	}
+
+	inCheckout := []string{"-C", workspaceDir}
+	if err := runPoolGit(ctx, prov, member.sessionID, token, append(inCheckout, "fetch", "--quiet", "origin")...); err != nil {
+		return err
+	}
+	if branch == "" {
+		// The default branch the repository was cloned at is what the workspace wants
+		return nil
+	}
+	return runPoolGit(ctx, prov, member.sessionID, token, append(inCheckout, "checkout", "--quiet", branch, "--")...)
+}
+
+// runPoolGit runs git in a pooled workspace, authenticated with token when it is set
+func runPoolGit(ctx context.Context, prov provider.Provider, sessionID, token string, args ...string) error {
+	var stderr strings.Builder
+	err := prov.Exec(ctx, sessionID, provider.ExecOptions{
+		Cmd:          append([]string{"git"}, args...),
+		Env:          gitEnv(token),
+		Stderr:       true,
+		StderrWriter: &stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("git %s failed: %w %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// warmPooled creates one workspace for a pool and adds it once it is ready to be claimed
+func (wm *WorkspaceManager) warmPooled(ctx context.Context, pool int) error {
+	cfg := wm.pool.configs[pool]
+	prov, ok := wm.providers[cfg.Provider]
+	if !ok || prov == nil {
+		return fmt.Errorf("provider %s not available", cfg.Provider)
+	}
+
+	// Held from the start so garbage collection leaves it alone while it warms
+	member := &pooledWorkspace{sessionID: newPoolSessionID(), pool: pool}
+	wm.pool.mu.Lock()
+	wm.pool.members[member.sessionID] = member
+	wm.pool.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, poolWarmTimeout)
+	defer cancel()
+
+	containerID, cloned, err := wm.warm(ctx, prov, cfg, member.sessionID)
+	wm.pool.mu.Lock()
+	if err != nil {
+		delete(wm.pool.members, member.sessionID)
+	} else {
+		member.containerID, member.cloned, member.ready = containerID, cloned, true
+	}
+	wm.pool.mu.Unlock()
+
+	if err != nil {
+		if err := prov.Destroy(context.Background(), member.sessionID); err != nil {
+			log.Printf("Failed to destroy pooled workspace %s: %v", member.sessionID, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// warm creates and starts a pooled workspace, installs the SSH server and clones the
+// repository. No owner, and so no GitHub token, is known yet: a repository that cannot
+// be cloned without credentials is left to be cloned on claim. It returns the container
+// ID and whether the repository was cloned.
+func (wm *WorkspaceManager) warm(ctx context.Context, prov provider.Provider, cfg PoolConfig, sessionID string) (string, bool, error) {
+	session, err := prov.Create(ctx, sessionID, filepath.Join(workspaceRoot, sessionID), nil)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create container: %w", err)
+	}
+	if err := prov.Start(ctx, sessionID); err != nil {
+		return "", false, fmt.Errorf("failed to start container: %w", err)
+	}
+	if err := wm.installSSH(ctx, prov, sessionID); err != nil {
+		return "", false, err
+	}
+	if cfg.Repository == "" {
+		return session.ID, false, nil
+	}
+
+	if err := runPoolGit(ctx, prov, sessionID, "", "clone", "--quiet", "--", cfg.Repository, workspaceDir); err != nil {
+		log.Printf("Pooled workspace %s will clone %s when claimed: %v", sessionID, cfg.Repository, err)
+		return session.ID, false, nil
+	}
+	return session.ID, true, nil
+}
+
+// fillPools warms workspaces until every pool has its configured size. A pool that fails
+// to warm is retried on the next fill.
+func (wm *WorkspaceManager) fillPools(ctx context.Context) {
+	for i, cfg := range wm.pool.configs {
+		for wm.pool.size(i) < cfg.Size && ctx.Err() == nil {
+			if err := wm.warmPooled(ctx, i); err != nil {
+				log.Printf("Failed to warm a %s workspace for pool %d: %v", cfg.Provider, i, err)
+				break
+			}
+		}
+	}
+}
+
+// discardStalePooled destroys pooled workspaces left over from before a restart. They were
+// never claimed, so nothing of value is lost.
+func (wm *WorkspaceManager) discardStalePooled(ctx context.Context) {
+	for name, prov := range wm.providers {
+		if prov == nil {
+			continue
+		}
+		sessions, err := prov.List(ctx)
+		if err != nil {
+			log.Printf("Failed to list %s sessions: %v", name, err)
+			continue
+		}
+		for _, session := range sessions {
+			sessionID := session.Labels[sessionLabel]
+			if !strings.HasPrefix(sessionID, poolSessionPrefix) || wm.sessionInUse(sessionID) {
+				continue
+			}
+			if err := prov.Destroy(ctx, sessionID); err != nil {
+				log.Printf("Failed to destroy stale pooled workspace %s: %v", sessionID, err)
+				continue
+			}
+			log.Printf("Destroyed stale pooled workspace %s", sessionID)
+		}
+	}
+}
+
+// sessionInUse reports whether a managed or pooled workspace runs in sessionID
+func (wm *WorkspaceManager) sessionInUse(sessionID string) bool {
+	if wm.pool.holds(sessionID) {
+		return true
+	}
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	for id, workspace := range wm.workspaces {
+		workspace.mu.RLock()
+		session := workspace.session()
+		workspace.mu.RUnlock()
+		if id == sessionID || session == sessionID {
+			return true
+		}
+	}
+	return false
+}
+
+// poolLoop keeps the configured pools full, refilling them as workspaces are claimed
+func (a *Agent) poolLoop(ctx context.Context) {
+	wm := a.workspaces
+	wm.discardStalePooled(ctx)
+	wm.fillPools(ctx)
+
+	ticker := time.NewTicker(defaultPoolRefillInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wm.pool.refill:
+		}
+		wm.fillPools(ctx)
+	}
+}
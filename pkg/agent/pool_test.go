@@ -0,0 +1,242 @@
+package agent
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+// poolProvider records the sessions it creates and the commands run in each
+type poolProvider struct {
+	provider.Provider
+	mu        sync.Mutex
+	created   []string
+	destroyed []string
+	stopped   []string
+	execs     map[string][]string // session ID -> first word of each script or argv
+	sessions  []provider.Session
+	private   bool              // git clone fails without a GitHub token
+	tokens    map[string]string // git command -> the token it was authenticated with
+	output    map[string]string // command -> what it prints
+}
+
+func (p *poolProvider) Create(ctx context.Context, sessionID, workspacePath string, config interface{}) (*provider.Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.created = append(p.created, sessionID)
+	return &provider.Session{ID: "container-" + sessionID}, nil
+}
+
+func (p *poolProvider) Start(ctx context.Context, sessionID string) error {
+	return nil
+}
+
+func (p *poolProvider) Stop(ctx context.Context, sessionID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = append(p.stopped, sessionID)
+	return nil
+}
+
+func (p *poolProvider) Exec(ctx context.Context, sessionID string, opts provider.ExecOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.execs == nil {
+		p.execs = make(map[string][]string)
+	}
+	cmd := strings.Join(opts.Cmd, " ")
+	if opts.Cmd[0] == "/bin/bash" {
+		// Name scripts after what they do
+		switch {
+		case strings.Contains(cmd, "apt-get install"):
+			cmd = "install-ssh"
+		case strings.Contains(cmd, "useradd"):
+			cmd = "add-user"
+		}
+	}
+	p.execs[sessionID] = append(p.execs[sessionID], cmd)
+	if out, ok := p.output[cmd]; ok && opts.StdoutWriter != nil {
+		opts.StdoutWriter.Write([]byte(out))
+	}
+	if opts.Cmd[0] == "git" {
+		if p.tokens == nil {
+			p.tokens = make(map[string]string)
+		}
+		p.tokens[cmd] = gitEnvToken(opts.Env)
+		if p.private && opts.Cmd[1] == "clone" && p.tokens[cmd] == "" {
+			return errors.New("authentication required")
+		}
+	}
+	return nil
+}
+
+// gitEnvToken returns the token gitEnv authenticated git with, if any
+func gitEnvToken(env []string) string {
+	for _, v := range env {
+		if header, ok := strings.CutPrefix(v, "GIT_CONFIG_VALUE_0=AUTHORIZATION: basic "); ok {
+			credentials, _ := base64.StdEncoding.DecodeString(header)
+			return strings.TrimPrefix(string(credentials), "x-access-token:")
+		}
+	}
+	return ""
+}
+
+func (p *poolProvider) Destroy(ctx context.Context, sessionID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.destroyed = append(p.destroyed, sessionID)
+	return nil
+}
+
+func (p *poolProvider) List(ctx context.Context) ([]provider.Session, error) {
+	return p.sessions, nil
+}
+
+func poolTestManager(prov provider.Provider, pools ...PoolConfig) *WorkspaceManager {
+	agent := &Agent{
+		node:      &Node{ID: "test-node"},
+		config:    NodeConfig{Pools: pools},
+		providers: map[string]provider.Provider{"docker": prov},
+	}
+	agent.workspaces = NewWorkspaceManager(agent)
+	return agent.workspaces
+}
+
+func TestWorkspacePoolClaim(t *testing.T) {
+	prov := &poolProvider{}
+	cmd := capacityTestCommand("ws-1", 1, "1GB")
+	cmd.Provider = "docker"
+	cmd.Repository.Branch = "feature"
+	wm := poolTestManager(prov, PoolConfig{Provider: "docker", Image: cmd.Image, Repository: cmd.Repository.URL, Size: 1})
+
+	wm.fillPools(context.Background())
+	require.Len(t, prov.created, 1)
+	pooled := prov.created[0]
+	assert.True(t, strings.HasPrefix(pooled, poolSessionPrefix))
+	assert.Equal(t, []string{
+		"install-ssh",
+		"netstat -tlnp",
+		"git clone --quiet -- " + cmd.Repository.URL + " " + workspaceDir,
+	}, prov.execs[pooled], "pooled workspaces are ready but for the user")
+	wm.fillPools(context.Background())
+	assert.Len(t, prov.created, 1, "a full pool is left alone")
+
+	result, err := wm.CreateWorkspace(context.Background(), cmd)
+	require.NoError(t, err)
+	assert.Equal(t, WorkspaceStatusRunning, result.Status)
+	assert.Equal(t, "container-"+pooled, result.ContainerID)
+	assert.Len(t, prov.created, 1, "the workspace is not created from scratch")
+	assert.Equal(t, []string{
+		"add-user",
+		"git -C " + workspaceDir + " fetch --quiet origin",
+		"git -C " + workspaceDir + " checkout --quiet feature --",
+	}, prov.execs[pooled][3:])
+	assert.Equal(t, pooled, wm.workspaces["ws-1"].session())
+	assert.Zero(t, wm.pool.size(0))
+	select {
+	case <-wm.pool.refill:
+	default:
+		t.Fatal("claiming does not ask for the pool to be refilled")
+	}
+
+	require.NoError(t, wm.DeleteWorkspace(context.Background(), "ws-1"))
+	assert.Equal(t, []string{pooled}, prov.destroyed, "the claimed workspace is addressed by its pooled session")
+}
+
+func TestWorkspacePoolPrivateRepository(t *testing.T) {
+	// The coordination server hands out the owner's token for the create command
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/commands/create-1/github-token" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(coordination.CommandGitHubToken{Token: "ghs_owner"})
+	}))
+	defer server.Close()
+
+	prov := &poolProvider{private: true}
+	cmd := capacityTestCommand("ws-1", 1, "1GB")
+	cmd.ID = "create-1"
+	cmd.Provider = "docker"
+	wm := poolTestManager(prov, PoolConfig{Provider: "docker", Image: cmd.Image, Repository: cmd.Repository.URL, Size: 1})
+	wm.agent.config.CoordinationURL = server.URL
+	wm.agent.coord = client.New(server.URL)
+
+	wm.fillPools(context.Background())
+	require.Len(t, prov.created, 1)
+	pooled := prov.created[0]
+	assert.Equal(t, 1, wm.pool.size(0), "a repository that needs credentials does not keep the pool from warming")
+
+	result, err := wm.CreateWorkspace(context.Background(), cmd)
+	require.NoError(t, err)
+	assert.Equal(t, "container-"+pooled, result.ContainerID)
+	clone := "git clone --quiet --branch main -- " + cmd.Repository.URL + " " + workspaceDir
+	assert.Equal(t, []string{"add-user", clone}, prov.execs[pooled][3:], "the repository is cloned on claim")
+	assert.Equal(t, "ghs_owner", prov.tokens[clone], "the clone is authenticated with the owner's token")
+}
+
+func TestWorkspacePoolRepositoryWithoutBranch(t *testing.T) {
+	prov := &poolProvider{}
+	cmd := capacityTestCommand("ws-1", 1, "1GB")
+	cmd.Repository.Branch = ""
+	wm := poolTestManager(prov)
+	member := &pooledWorkspace{sessionID: "pool-1", cloned: true}
+
+	require.NoError(t, wm.setUpRepository(context.Background(), prov, member, cmd.Repository.URL, cmd))
+	assert.Equal(t, []string{"git -C " + workspaceDir + " fetch --quiet origin"}, prov.execs["pool-1"], "the default branch stays checked out")
+
+	member = &pooledWorkspace{sessionID: "pool-2"}
+	require.NoError(t, wm.setUpRepository(context.Background(), prov, member, cmd.Repository.URL, cmd))
+	assert.Equal(t, []string{"git clone --quiet -- " + cmd.Repository.URL + " " + workspaceDir}, prov.execs["pool-2"])
+}
+
+func TestWorkspacePoolOnlyMatchingClaims(t *testing.T) {
+	cmd := capacityTestCommand("ws-1", 1, "1GB")
+	cmd.Provider = "docker"
+	pool := newWorkspacePool([]PoolConfig{{Provider: "docker", Image: cmd.Image, Repository: cmd.Repository.URL, Size: 1}})
+	pool.members["pool-warming"] = &pooledWorkspace{sessionID: "pool-warming"}
+	assert.Nil(t, pool.claim(cmd), "workspaces still warming cannot be claimed")
+
+	pool.members["pool-ready"] = &pooledWorkspace{sessionID: "pool-ready", ready: true}
+	other := *cmd
+	other.Image = "debian:12"
+	assert.Nil(t, pool.claim(&other))
+	assert.Equal(t, "pool-ready", pool.claim(cmd).sessionID)
+}
+
+func TestStalePooledWorkspacesAreDiscarded(t *testing.T) {
+	prov := &poolProvider{sessions: []provider.Session{
+		{ID: "c1", Labels: map[string]string{sessionLabel: "pool-stale"}},
+		{ID: "c2", Labels: map[string]string{sessionLabel: "pool-claimed"}},
+		{ID: "c3", Labels: map[string]string{sessionLabel: "ws-2"}},
+	}}
+	wm := poolTestManager(prov)
+	wm.workspaces["ws-1"] = &ManagedWorkspace{
+		Command:   &CreateWorkspaceCommand{WorkspaceID: "ws-1", Provider: "docker"},
+		Status:    WorkspaceStatusRunning,
+		SessionID: "pool-claimed",
+		Services:  map[string]*ManagedService{},
+	}
+
+	drift, err := wm.Reconcile(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, drift)
+	assert.Equal(t, []string{"ws-2"}, drift.Adopted, "pooled sessions are not adopted as workspaces")
+	assert.Empty(t, drift.Vanished, "claimed workspaces are found by their pooled session")
+
+	wm.discardStalePooled(context.Background())
+	assert.Equal(t, []string{"pool-stale"}, prov.destroyed)
+}
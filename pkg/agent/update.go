@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/coordination/client"
+)
+
+// AgentVersion is the version of this agent build, set at link time with
+// -ldflags "-X github.com/nexus/nexus/pkg/agent.AgentVersion=<version>"
+var AgentVersion = "1.0.0"
+
+// defaultUpdateCheckInterval is how often an agent asks for the desired release
+const defaultUpdateCheckInterval = time.Hour
+
+// UpdateConfig controls agent self-updates to the release the coordination server advertises
+type UpdateConfig struct {
+	Enabled       bool          `yaml:"enabled" json:"enabled"`
+	CheckInterval time.Duration `yaml:"check_interval" json:"check_interval"`
+	URL           string        `yaml:"url" json:"url"`               // download from here instead; {version}, {os} and {arch} are replaced
+	PublicKey     string        `yaml:"public_key" json:"public_key"` // base64 ed25519 key releases must be signed with
+}
+
+// Replaced in tests
+var (
+	executablePath = os.Executable
+	restartAgent   = restartProcess
+)
+
+// updateLoop applies the advertised release whenever the agent runs another version
+func (a *Agent) updateLoop(ctx context.Context) {
+	interval := a.config.Update.CheckInterval
+	if interval <= 0 {
+		interval = defaultUpdateCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.checkForUpdate(ctx); err != nil {
+				log.Printf("Agent update failed: %v", err)
+			}
+		}
+	}
+}
+
+// checkForUpdate asks the coordination server for the desired release and applies it
+func (a *Agent) checkForUpdate(ctx context.Context) error {
+	release, err := a.coord.GetAgentRelease(ctx, runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return fmt.Errorf("failed to get agent release: %w", err)
+	}
+	return a.applyUpdate(ctx, release)
+}
+
+// upgradeRequired reports whether err is the server refusing this agent's protocol version
+func upgradeRequired(err error) bool {
+	return client.StatusCode(err) == http.StatusUpgradeRequired
+}
+
+// handleUpgradeRequired updates the agent after the server refused its protocol version,
+// when updates are enabled
+func (a *Agent) handleUpgradeRequired(ctx context.Context) {
+	if !a.config.Update.Enabled {
+		log.Printf("The coordination server requires another agent version and updates are disabled")
+		return
+	}
+	if err := a.checkForUpdate(ctx); err != nil {
+		log.Printf("Agent update failed: %v", err)
+	}
+}
+
+// applyUpdate replaces the agent binary with release and restarts the agent. Nothing
+// happens when the release is the running version or updates are disabled.
+func (a *Agent) applyUpdate(ctx context.Context, release *coordination.AgentRelease) error {
+	if !a.config.Update.Enabled || release == nil || release.Version == "" || release.Version == AgentVersion {
+		return nil
+	}
+	if !a.updating.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer a.updating.Store(false)
+
+	if release.SHA256 == "" {
+		return fmt.Errorf("release %s has no checksum", release.Version)
+	}
+
+	exe, err := executablePath()
+	if err != nil {
+		return fmt.Errorf("failed to locate agent binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	// Running the release's binary under another version means the binary was built as
+	// that version; installing it again would only restart into the same version forever
+	if installed, err := fileSHA256(exe); err == nil && strings.EqualFold(installed, release.SHA256) {
+		return fmt.Errorf("agent %s is already installed but reports version %s; the release binary was built with the wrong version and is not installed again", release.Version, AgentVersion)
+	}
+
+	log.Printf("Updating agent from %s to %s", AgentVersion, release.Version)
+
+	// Download next to the binary so the swap is a rename on the same filesystem
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".nexus-agent-update-*")
+	if err != nil {
+		return fmt.Errorf("failed to create update file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	body, err := a.downloadRelease(ctx, release)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	digest := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, digest), body)
+	body.Close()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download agent %s: %w", release.Version, err)
+	}
+
+	if err := verifyRelease(release, digest.Sum(nil), a.config.Update.PublicKey); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return fmt.Errorf("failed to make agent binary executable: %w", err)
+	}
+	if err := installBinary(tmp.Name(), exe); err != nil {
+		return err
+	}
+
+	log.Printf("Installed agent %s, restarting", release.Version)
+	return restartAgent(exe)
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// downloadRelease opens the binary of release: from the configured URL, the URL the server
+// advertised, or the server itself
+func (a *Agent) downloadRelease(ctx context.Context, release *coordination.AgentRelease) (io.ReadCloser, error) {
+	source := release.URL
+	if a.config.Update.URL != "" {
+		source = strings.NewReplacer("{version}", release.Version, "{os}", runtime.GOOS, "{arch}", runtime.GOARCH).Replace(a.config.Update.URL)
+	}
+	if source == "" {
+		body, err := a.coord.DownloadAgentBinary(ctx, runtime.GOOS, runtime.GOARCH)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download agent %s: %w", release.Version, err)
+		}
+		return body, nil
+	}
+
+	// Third-party download locations never see the node credential
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent download URL: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download agent %s: %w", release.Version, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download agent %s: %s", release.Version, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// verifyRelease checks the downloaded binary's SHA-256 against the release and, when the
+// agent has a public key, the release's signature of that digest
+func verifyRelease(release *coordination.AgentRelease, digest []byte, publicKey string) error {
+	if got := hex.EncodeToString(digest); !strings.EqualFold(got, release.SHA256) {
+		return fmt.Errorf("checksum mismatch for agent %s: got %s, want %s", release.Version, got, release.SHA256)
+	}
+	if publicKey == "" {
+		return nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid update public key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(release.Signature)
+	if err != nil || release.Signature == "" {
+		return fmt.Errorf("agent %s is not signed", release.Version)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), digest, signature) {
+		return fmt.Errorf("invalid signature for agent %s", release.Version)
+	}
+	return nil
+}
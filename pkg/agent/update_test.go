@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nexus/nexus/pkg/coordination"
+)
+
+// updateTestServer serves a release of binary, signed with key when it is set
+func updateTestServer(t *testing.T, version string, binary []byte, key ed25519.PrivateKey) *httptest.Server {
+	sum := sha256.Sum256(binary)
+	release := coordination.AgentRelease{Version: version, OS: runtime.GOOS, Arch: runtime.GOARCH, SHA256: hex.EncodeToString(sum[:])}
+	if key != nil {
+		release.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, sum[:]))
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/agent/release":
+			assert.Equal(t, runtime.GOOS, r.URL.Query().Get("os"))
+			json.NewEncoder(w).Encode(release)
+		case "/api/v1/agent/binary/" + runtime.GOOS + "/" + runtime.GOARCH:
+			w.Write(binary)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// fakeExecutable points the updater at a stand-in agent binary and records restarts
+func fakeExecutable(t *testing.T) (exe string, restarted *string) {
+	exe = filepath.Join(t.TempDir(), "nexus")
+	require.NoError(t, os.WriteFile(exe, []byte("old agent"), 0755))
+
+	restarted = new(string)
+	originalPath, originalRestart := executablePath, restartAgent
+	executablePath = func() (string, error) { return exe, nil }
+	restartAgent = func(path string) error { *restarted = path; return nil }
+	t.Cleanup(func() { executablePath, restartAgent = originalPath, originalRestart })
+	return exe, restarted
+}
+
+func TestAgentSelfUpdate(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	server := updateTestServer(t, "2.0.0", []byte("new agent"), private)
+	exe, restarted := fakeExecutable(t)
+
+	agent, err := NewAgent(NodeConfig{CoordinationURL: server.URL, Update: UpdateConfig{
+		Enabled:   true,
+		PublicKey: base64.StdEncoding.EncodeToString(public),
+	}})
+	require.NoError(t, err)
+
+	require.NoError(t, agent.checkForUpdate(context.Background()))
+	data, err := os.ReadFile(exe)
+	require.NoError(t, err)
+	assert.Equal(t, "new agent", string(data))
+	assert.Equal(t, exe, *restarted)
+
+	info, err := os.Stat(exe)
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&0100, "the new binary is executable")
+	entries, err := os.ReadDir(filepath.Dir(exe))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no download is left behind")
+}
+
+func TestAgentUpdateStopsWhenTheReleaseReportsAnotherVersion(t *testing.T) {
+	downloads := 0
+	binary := []byte("agent built as 1.0.0")
+	sum := sha256.Sum256(binary)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/agent/release":
+			json.NewEncoder(w).Encode(coordination.AgentRelease{Version: "2.0.0", SHA256: hex.EncodeToString(sum[:])})
+		default:
+			downloads++
+			w.Write(binary)
+		}
+	}))
+	defer server.Close()
+	exe, restarted := fakeExecutable(t)
+	require.NoError(t, os.WriteFile(exe, binary, 0755))
+
+	agent, err := NewAgent(NodeConfig{CoordinationURL: server.URL, Update: UpdateConfig{Enabled: true}})
+	require.NoError(t, err)
+
+	err = agent.checkForUpdate(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reports version "+AgentVersion)
+	assert.Zero(t, downloads, "the installed release is not downloaded again")
+	assert.Empty(t, *restarted, "nor restarted into")
+}
+
+func TestAgentUpdateRejectsUnverifiedBinaries(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		server    *httptest.Server
+		publicKey string
+		want      string
+	}{
+		"unsigned":      {updateTestServer(t, "2.0.0", []byte("new agent"), nil), base64.StdEncoding.EncodeToString(public), "not signed"},
+		"wrong signer":  {updateTestServer(t, "2.0.0", []byte("new agent"), otherKey), base64.StdEncoding.EncodeToString(public), "invalid signature"},
+		"bad checksum":  {badChecksumServer(t), "", "checksum mismatch"},
+		"invalid key":   {updateTestServer(t, "2.0.0", []byte("new agent"), otherKey), "not-a-key", "invalid update public key"},
+		"current agent": {updateTestServer(t, AgentVersion, []byte("new agent"), nil), "", ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			exe, restarted := fakeExecutable(t)
+			agent, err := NewAgent(NodeConfig{CoordinationURL: tt.server.URL, Update: UpdateConfig{Enabled: true, PublicKey: tt.publicKey}})
+			require.NoError(t, err)
+
+			err = agent.checkForUpdate(context.Background())
+			if tt.want == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.want)
+			}
+
+			data, err := os.ReadFile(exe)
+			require.NoError(t, err)
+			assert.Equal(t, "old agent", string(data), "the running binary is kept")
+			assert.Empty(t, *restarted)
+			entries, err := os.ReadDir(filepath.Dir(exe))
+			require.NoError(t, err)
+			assert.Len(t, entries, 1)
+		})
+	}
+}
+
+// badChecksumServer advertises a checksum that does not match the binary it serves
+func badChecksumServer(t *testing.T) *httptest.Server {
+	release := coordination.AgentRelease{Version: "2.0.0", SHA256: hex.EncodeToString(make([]byte, sha256.Size))}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/agent/release" {
+			json.NewEncoder(w).Encode(release)
+			return
+		}
+		w.Write([]byte("tampered agent"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHeartbeatReportsProtocolVersion(t *testing.T) {
+	var heartbeat coordination.NodeHeartbeat
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&heartbeat))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(NodeConfig{CoordinationURL: server.URL})
+	require.NoError(t, err)
+	require.NoError(t, agent.sendHeartbeat())
+	assert.Equal(t, coordination.AgentProtocolVersion, heartbeat.ProtocolVersion)
+
+	node := agent.coordinationNode()
+	assert.Equal(t, AgentVersion, node.Metadata["version"])
+	assert.Equal(t, coordination.AgentProtocolVersion, node.Metadata["protocol_version"])
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// installBinary atomically replaces the binary at exe with the one at path
+func installBinary(path, exe string) error {
+	if err := os.Rename(path, exe); err != nil {
+		return fmt.Errorf("failed to install agent binary: %w", err)
+	}
+	return nil
+}
+
+// restartProcess replaces the running agent with the binary at exe, keeping its arguments
+// and environment
+func restartProcess(exe string) error {
+	if err := syscall.Exec(exe, os.Args, os.Environ()); err != nil {
+		return fmt.Errorf("failed to restart agent: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// installBinary replaces the binary at exe with the one at path. A running executable
+// cannot be overwritten on Windows, but it can be renamed out of the way.
+func installBinary(path, exe string) error {
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return fmt.Errorf("failed to install agent binary: %w", err)
+	}
+	if err := os.Rename(path, exe); err != nil {
+		os.Rename(old, exe)
+		return fmt.Errorf("failed to install agent binary: %w", err)
+	}
+	return nil
+}
+
+// restartProcess starts the binary at exe with the agent's arguments and exits
+func restartProcess(exe string) error {
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to restart agent: %w", err)
+	}
+	os.Exit(0)
+	return nil
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// APIConfig exposes the agent's workspace API to tools on the node's network. Requests
+// must carry one of Tokens as a bearer token or, when ClientCAFile is set, a client
+// certificate signed by that CA. The API refuses to start when neither is configured,
+// and bearer tokens are only accepted over plain HTTP on a loopback address.
+type APIConfig struct {
+	Port         int      `yaml:"port" json:"port"`       // 0 keeps the API closed
+	Address      string   `yaml:"address" json:"address"` // empty listens on every interface
+	Tokens       []string `yaml:"tokens" json:"-"`
+	CertFile     string   `yaml:"cert_file" json:"cert_file"`
+	KeyFile      string   `yaml:"key_file" json:"key_file"`
+	ClientCAFile string   `yaml:"client_ca_file" json:"client_ca_file"`
+}
+
+// WorkspaceHandlerOption configures a WorkspaceHTTPHandler
+type WorkspaceHandlerOption func(*WorkspaceHTTPHandler)
+
+// WithAPIConfig authenticates requests and serves TLS as cfg describes
+func WithAPIConfig(cfg APIConfig) WorkspaceHandlerOption {
+	return func(h *WorkspaceHTTPHandler) {
+		h.config = cfg
+	}
+}
+
+// authRequired reports whether the handler checks credentials at all
+func (c APIConfig) authRequired() bool {
+	return len(c.Tokens) > 0 || c.ClientCAFile != ""
+}
+
+// loopback reports whether the API only listens on the loopback interface
+func (c APIConfig) loopback() bool {
+	if c.Address == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(c.Address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkExposure refuses to serve the API without credentials, or with bearer tokens
+// sent in the clear to the network
+func (c APIConfig) checkExposure(tlsConfig *tls.Config) error {
+	if !c.authRequired() {
+		return fmt.Errorf("the workspace API requires tokens or client_ca_file")
+	}
+	if tlsConfig == nil && !c.loopback() {
+		return fmt.Errorf("bearer tokens over plain HTTP require a loopback address; set cert_file and key_file or address: 127.0.0.1")
+	}
+	return nil
+}
+
+// tlsConfig loads the server certificate and, for mutual TLS, the client CA. It returns
+// nil when the API is served over plain HTTP.
+func (c APIConfig) tlsConfig() (*tls.Config, error) {
+	if c.CertFile == "" && c.KeyFile == "" {
+		if c.ClientCAFile != "" {
+			return nil, fmt.Errorf("client_ca_file requires cert_file and key_file")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.ClientCAFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", c.ClientCAFile)
+	}
+	config.ClientCAs = pool
+	// Token holders may connect without a certificate; everyone else needs one
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(c.Tokens) > 0 {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// authenticated reports whether r carries a verified client certificate or a known token
+func (h *WorkspaceHTTPHandler) authenticated(r *http.Request) bool {
+	if !h.config.authRequired() {
+		return true
+	}
+	if h.config.ClientCAFile != "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	for _, known := range h.config.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAuth rejects unauthenticated requests to everything but the health check
+func (h *WorkspaceHTTPHandler) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/health" && !h.authenticated(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="nexus-agent"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
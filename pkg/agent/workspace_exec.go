@@ -0,0 +1,417 @@
+package agent
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+const (
+	// serviceLogDir is where workspace services write their output inside the workspace
+	serviceLogDir = "/tmp/nexus/logs"
+	// workspaceDir is the working directory of commands run inside a workspace
+	workspaceDir = "/workspace"
+	// maxUploadSize bounds files uploaded through the workspace API
+	maxUploadSize = 64 << 20
+	// uploadChunkSize is how much of an upload is written per exec; its base64 form has
+	// to fit on a command line
+	uploadChunkSize = 48 << 10
+	// defaultLogLines is how many lines of a service log are returned without ?lines
+	defaultLogLines = 100
+)
+
+// ExecRequest runs a command in a workspace through the workspace API
+type ExecRequest struct {
+	Command []string      `json:"command"`
+	Env     []string      `json:"env,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// ExecResult is how a command run through the workspace API ended
+type ExecResult struct {
+	Status   string        `json:"status"`           // success, failed or timeout
+	Output   string        `json:"output,omitempty"` // stdout and stderr, unless they were streamed
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// ExecEvent is one server-sent event of a streamed exec: a chunk of output or, last, the result
+type ExecEvent struct {
+	Stream string      `json:"stream,omitempty"`
+	Data   string      `json:"data,omitempty"`
+	Result *ExecResult `json:"result,omitempty"`
+}
+
+// serviceLogPath returns the log file of a workspace service
+func serviceLogPath(service string) string {
+	return path.Join(serviceLogDir, service+".log")
+}
+
+// workspacePath resolves p inside a workspace; relative paths start at /workspace
+func workspacePath(p string) string {
+	if !path.IsAbs(p) {
+		p = path.Join(workspaceDir, p)
+	}
+	return path.Clean(p)
+}
+
+// workspaceProvider returns the provider running a managed workspace
+func (wm *WorkspaceManager) workspaceProvider(workspaceID string) (provider.Provider, *ManagedWorkspace, error) {
+	wm.mu.RLock()
+	workspace, exists := wm.workspaces[workspaceID]
+	wm.mu.RUnlock()
+	if !exists {
+		return nil, nil, fmt.Errorf("workspace %s not found", workspaceID)
+	}
+
+	prov, ok := wm.providers[workspace.Command.Provider]
+	if !ok || prov == nil {
+		return nil, workspace, fmt.Errorf("provider %s not available", workspace.Command.Provider)
+	}
+	return prov, workspace, nil
+}
+
+// lookupWorkspace writes an error and returns nil unless workspaceID is managed and its
+// provider is available. Every use of the workspace API counts as activity.
+func (h *WorkspaceHTTPHandler) lookupWorkspace(w http.ResponseWriter, workspaceID string) (provider.Provider, *ManagedWorkspace) {
+	prov, workspace, err := h.manager.workspaceProvider(workspaceID)
+	if err != nil {
+		status := http.StatusNotFound
+		if workspace != nil {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return nil, nil
+	}
+	h.manager.agent.RecordActivity(workspaceID)
+	return prov, workspace
+}
+
+// syncWriter serializes writes of concurrently running output streams
+type syncWriter struct {
+	mu    *sync.Mutex
+	write func(p []byte) error
+}
+
+func (w syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// handleExec runs a command in a workspace. With ?stream=true output is sent as
+// server-sent ExecEvents while the command runs; otherwise it is returned in the result.
+func (h *WorkspaceHTTPHandler) handleExec(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	var req ExecRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Command) == 0 {
+		http.Error(w, "command is required", http.StatusBadRequest)
+		return
+	}
+
+	prov, workspace := h.lookupWorkspace(w, workspaceID)
+	if prov == nil {
+		return
+	}
+
+	ctx := r.Context()
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		defer cancel()
+	}
+
+	if r.URL.Query().Get("stream") != "true" {
+		output := &outputStream{}
+		result := runExec(ctx, prov, workspace.session(), req, output.writer("stdout"), output.writer("stderr"))
+		result.Output = output.Close()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Printf("Failed to encode response: %v", err)
+		}
+		return
+	}
+
+	// Commands may run longer than the server's write timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var mu sync.Mutex
+	send := func(event ExecEvent) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	stream := func(name string) io.Writer {
+		return syncWriter{mu: &mu, write: func(p []byte) error {
+			return send(ExecEvent{Stream: name, Data: string(p)})
+		}}
+	}
+
+	result := runExec(ctx, prov, workspace.session(), req, stream("stdout"), stream("stderr"))
+	mu.Lock()
+	defer mu.Unlock()
+	if err := send(ExecEvent{Result: &result}); err != nil {
+		log.Printf("Failed to send exec result: %v", err)
+	}
+}
+
+// runExec runs req in a workspace, writing its output to stdout and stderr
+func runExec(ctx context.Context, prov provider.Provider, sessionID string, req ExecRequest, stdout, stderr io.Writer) ExecResult {
+	start := time.Now()
+	err := prov.Exec(ctx, sessionID, provider.ExecOptions{
+		Cmd:          req.Command,
+		Env:          req.Env,
+		Stdout:       true,
+		Stderr:       true,
+		StdoutWriter: stdout,
+		StderrWriter: stderr,
+	})
+
+	result := ExecResult{Status: "success", Duration: time.Since(start)}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.Status = "timeout"
+		result.Error = "command timed out"
+	case err != nil:
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// trackingWriter remembers whether anything was written through it
+type trackingWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.written = t.written || len(p) > 0
+	return t.w.Write(p)
+}
+
+// handleDownloadFile streams the file at ?path out of a workspace
+func (h *WorkspaceHTTPHandler) handleDownloadFile(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	filePath := r.URL.Query().Get("path")
+	if filePath == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	filePath = workspacePath(filePath)
+
+	prov, workspace := h.lookupWorkspace(w, workspaceID)
+	if prov == nil {
+		return
+	}
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(filePath)))
+
+	var stderr strings.Builder
+	body := &trackingWriter{w: w}
+	err := prov.Exec(r.Context(), workspace.session(), provider.ExecOptions{
+		Cmd:          []string{"cat", "--", filePath},
+		Stdout:       true,
+		Stderr:       true,
+		StdoutWriter: body,
+		StderrWriter: &stderr,
+	})
+	if err == nil || body.written {
+		// A failure part way through can only be signalled by cutting the response short
+		if err != nil {
+			log.Printf("Failed to download %s from workspace %s: %v", filePath, workspaceID, err)
+		}
+		return
+	}
+
+	w.Header().Del("Content-Disposition")
+	if strings.Contains(stderr.String(), "No such file") {
+		http.Error(w, fmt.Sprintf("file %s not found", filePath), http.StatusNotFound)
+		return
+	}
+	http.Error(w, fmt.Sprintf("failed to read %s: %v %s", filePath, err, strings.TrimSpace(stderr.String())), http.StatusInternalServerError)
+}
+
+// handleUploadFile writes the request body to ?path in a workspace. Providers have no way
+// to pass stdin to a command, so the file is written base64 encoded, chunk by chunk, next
+// to its destination and moved into place once complete.
+func (h *WorkspaceHTTPHandler) handleUploadFile(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	filePath := r.URL.Query().Get("path")
+	if filePath == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	filePath = workspacePath(filePath)
+
+	prov, workspace := h.lookupWorkspace(w, workspaceID)
+	if prov == nil {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	ctx := r.Context()
+	run := func(script string, args ...string) error {
+		var stderr strings.Builder
+		cmd := append([]string{"sh", "-c", script, "sh"}, args...)
+		if err := prov.Exec(ctx, workspace.session(), provider.ExecOptions{Cmd: cmd, Stderr: true, StderrWriter: &stderr}); err != nil {
+			return fmt.Errorf("%w %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+
+	tmp := filePath + ".nexus-upload"
+	if err := run(`mkdir -p "$(dirname "$1")" && : > "$1"`, tmp); err != nil {
+		http.Error(w, fmt.Sprintf("failed to create %s: %v", filePath, err), http.StatusInternalServerError)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxUploadSize)
+	buf := make([]byte, uploadChunkSize)
+	var size int64
+	for {
+		n, readErr := io.ReadFull(body, buf)
+		if n > 0 {
+			chunk := base64.StdEncoding.EncodeToString(buf[:n])
+			if err := run(`printf '%s' "$1" | base64 -d >> "$2"`, chunk, tmp); err != nil {
+				_ = run(`rm -f "$1"`, tmp)
+				http.Error(w, fmt.Sprintf("failed to write %s: %v", filePath, err), http.StatusInternalServerError)
+				return
+			}
+			size += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			_ = run(`rm -f "$1"`, tmp)
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(readErr, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, fmt.Sprintf("failed to read upload: %v", readErr), status)
+			return
+		}
+	}
+
+	if err := run(`mv -f -- "$1" "$2"`, tmp, filePath); err != nil {
+		_ = run(`rm -f "$1"`, tmp)
+		http.Error(w, fmt.Sprintf("failed to write %s: %v", filePath, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"path": filePath, "size": size}); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// flushWriter flushes every write so tailed lines reach the client as they arrive
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = f.rc.Flush()
+	}
+	return n, err
+}
+
+// handleTailLogs returns the last ?lines of a service's log and, with ?follow=true, keeps
+// streaming new lines until the client disconnects
+func (h *WorkspaceHTTPHandler) handleTailLogs(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	query := r.URL.Query()
+	service := query.Get("service")
+	if service == "" {
+		http.Error(w, "service is required", http.StatusBadRequest)
+		return
+	}
+	lines := defaultLogLines
+	if raw := query.Get("lines"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			http.Error(w, "lines must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		lines = n
+	}
+	follow := query.Get("follow") == "true"
+
+	prov, workspace := h.lookupWorkspace(w, workspaceID)
+	if prov == nil {
+		return
+	}
+	workspace.mu.RLock()
+	_, exists := workspace.Services[service]
+	workspace.mu.RUnlock()
+	if !exists {
+		http.Error(w, fmt.Sprintf("service %s not found", service), http.StatusNotFound)
+		return
+	}
+
+	cmd := []string{"tail", "-n", strconv.Itoa(lines)}
+	if follow {
+		cmd = append(cmd, "-F")
+	}
+	cmd = append(cmd, serviceLogPath(service))
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	var mu sync.Mutex
+	out := syncWriter{mu: &mu, write: func(p []byte) error {
+		_, err := flushWriter{w: w, rc: rc}.Write(p)
+		return err
+	}}
+	err := prov.Exec(r.Context(), workspace.session(), provider.ExecOptions{
+		Cmd:          cmd,
+		Stdout:       true,
+		Stderr:       true,
+		StdoutWriter: out,
+		StderrWriter: out,
+	})
+	if err != nil && r.Context().Err() == nil {
+		log.Printf("Failed to tail %s logs of workspace %s: %v", service, workspaceID, err)
+	}
+}
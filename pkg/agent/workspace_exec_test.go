@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+// hostProvider runs commands on the test host as if it were the workspace
+type hostProvider struct {
+	provider.Provider
+}
+
+func (p *hostProvider) Exec(ctx context.Context, sessionID string, opts provider.ExecOptions) error {
+	cmd := exec.CommandContext(ctx, opts.Cmd[0], opts.Cmd[1:]...)
+	cmd.Env = append(os.Environ(), opts.Env...)
+	cmd.Stdout, cmd.Stderr = opts.StdoutWriter, opts.StderrWriter
+	return cmd.Run()
+}
+
+// workspaceAPITestHandler serves a running workspace ws-1 whose commands run on prov
+func workspaceAPITestHandler(prov provider.Provider, opts ...WorkspaceHandlerOption) *WorkspaceHTTPHandler {
+	wm := createTestWorkspaceManager()
+	wm.providers["docker"] = prov
+	wm.workspaces["ws-1"] = &ManagedWorkspace{
+		Command:  &CreateWorkspaceCommand{WorkspaceID: "ws-1", Provider: "docker"},
+		Status:   WorkspaceStatusRunning,
+		Services: map[string]*ManagedService{"web": {}},
+	}
+	return NewWorkspaceHTTPHandler(wm, 0, opts...)
+}
+
+func TestWorkspaceAPIRequiresToken(t *testing.T) {
+	handler := workspaceAPITestHandler(&hostProvider{}, WithAPIConfig(APIConfig{Tokens: []string{"secret"}}))
+
+	request := func(token, path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("", "/api/v1/workspaces"))
+	assert.Equal(t, http.StatusUnauthorized, request("wrong", "/api/v1/workspaces"))
+	assert.Equal(t, http.StatusOK, request("secret", "/api/v1/workspaces"))
+	assert.Equal(t, http.StatusOK, request("", "/api/v1/health"), "health checks stay open")
+
+	open := workspaceAPITestHandler(&hostProvider{})
+	w := httptest.NewRecorder()
+	open.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/workspaces", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "Start refuses this configuration, but the handler itself checks nothing")
+}
+
+func TestWorkspaceAPIRejectsMissingTLSFiles(t *testing.T) {
+	handler := workspaceAPITestHandler(&hostProvider{}, WithAPIConfig(APIConfig{ClientCAFile: "ca.pem"}))
+	assert.ErrorContains(t, handler.Start(context.Background()), "client_ca_file requires cert_file and key_file")
+}
+
+func TestWorkspaceAPIRefusesExposure(t *testing.T) {
+	open := workspaceAPITestHandler(&hostProvider{})
+	assert.ErrorContains(t, open.Start(context.Background()), "requires tokens or client_ca_file")
+
+	public := workspaceAPITestHandler(&hostProvider{}, WithAPIConfig(APIConfig{Tokens: []string{"secret"}}))
+	assert.ErrorContains(t, public.Start(context.Background()), "require a loopback address")
+
+	local := workspaceAPITestHandler(&hostProvider{}, WithAPIConfig(APIConfig{Address: "127.0.0.1", Tokens: []string{"secret"}}))
+	require.NoError(t, local.Start(context.Background()))
+	defer local.Stop(context.Background())
+	assert.True(t, local.listener.Addr().(*net.TCPAddr).IP.IsLoopback())
+}
+
+func TestAgentStartRefusesOpenAPI(t *testing.T) {
+	agent, err := NewAgent(NodeConfig{OfflineMode: true, API: APIConfig{Port: 1}})
+	require.NoError(t, err)
+	assert.ErrorContains(t, agent.Start(context.Background()), "requires tokens or client_ca_file")
+}
+
+func TestWorkspaceExec(t *testing.T) {
+	handler := workspaceAPITestHandler(&hostProvider{})
+
+	exec := func(query string, req ExecRequest) *httptest.ResponseRecorder {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/workspaces/ws-1/exec"+query, bytes.NewReader(body)))
+		return w
+	}
+
+	w := exec("", ExecRequest{Command: []string{"sh", "-c", `echo "hello $NAME"; exit 3`}, Env: []string{"NAME=nexus"}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var result ExecResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, "failed", result.Status)
+	assert.Equal(t, "hello nexus\n", result.Output)
+	assert.Contains(t, result.Error, "exit status 3")
+
+	w = exec("", ExecRequest{Command: []string{"sleep", "5"}, Timeout: 50 * time.Millisecond})
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, "timeout", result.Status)
+
+	w = exec("?stream=true", ExecRequest{Command: []string{"sh", "-c", "echo out; echo err >&2"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	var events []ExecEvent
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			var event ExecEvent
+			require.NoError(t, json.Unmarshal([]byte(data), &event))
+			events = append(events, event)
+		}
+	}
+	require.NotEmpty(t, events)
+	streams := map[string]string{}
+	for _, event := range events[:len(events)-1] {
+		streams[event.Stream] += event.Data
+	}
+	assert.Equal(t, map[string]string{"stdout": "out\n", "stderr": "err\n"}, streams)
+	last := events[len(events)-1].Result
+	require.NotNil(t, last, "the result is the last event")
+	assert.Equal(t, "success", last.Status)
+	assert.Empty(t, last.Output, "streamed output is not repeated in the result")
+
+	w = exec("", ExecRequest{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/workspaces/ws-unknown/exec", strings.NewReader(`{"command":["true"]}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestWorkspaceFileTransfer(t *testing.T) {
+	handler := workspaceAPITestHandler(&hostProvider{})
+	dir := t.TempDir()
+	target := filepath.Join(dir, "nested", "data.bin")
+
+	// Larger than one upload chunk, and not valid text
+	content := bytes.Repeat([]byte{0, 1, 2, 'x', 0xff}, uploadChunkSize/2)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/v1/workspaces/ws-1/files?path="+target, bytes.NewReader(content)))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	written, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, content, written)
+	entries, err := os.ReadDir(filepath.Dir(target))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no partial upload is left behind")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/workspaces/ws-1/files?path="+target, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, content, w.Body.Bytes())
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "data.bin")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/workspaces/ws-1/files?path="+filepath.Join(dir, "missing"), nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/workspaces/ws-1/files", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWorkspaceTailLogs(t *testing.T) {
+	prov := &execProvider{stdout: "listening on :8080\n"}
+	handler := workspaceAPITestHandler(prov)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/workspaces/ws-1/logs?service=web&lines=20&follow=true", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "listening on :8080\n", w.Body.String())
+	assert.Equal(t, []string{"tail", "-n", "20", "-F", serviceLogPath("web")}, prov.cmd)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/workspaces/ws-1/logs?service=db", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/workspaces/ws-1/logs?service=web&lines=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -2,35 +2,45 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 type WorkspaceHTTPHandler struct {
 	manager  *WorkspaceManager
+	config   APIConfig
 	mux      *http.ServeMux
+	handler  http.Handler
 	server   *http.Server
 	listener net.Listener
 	mu       sync.RWMutex
 }
 
-func NewWorkspaceHTTPHandler(manager *WorkspaceManager, port int) *WorkspaceHTTPHandler {
+func NewWorkspaceHTTPHandler(manager *WorkspaceManager, port int, opts ...WorkspaceHandlerOption) *WorkspaceHTTPHandler {
 	h := &WorkspaceHTTPHandler{
 		manager: manager,
 		mux:     http.NewServeMux(),
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
 
 	h.registerRoutes()
+	h.handler = h.requireAuth(h.mux)
 
 	h.server = &http.Server{
-		Addr:         fmt.Sprintf(":%d", port),
-		Handler:      h.mux,
+		Addr:         net.JoinHostPort(h.config.Address, strconv.Itoa(port)),
+		Handler:      h.handler,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -45,13 +55,30 @@ func (h *WorkspaceHTTPHandler) registerRoutes() {
 	h.mux.HandleFunc("/api/v1/workspaces/", h.handleWorkspaceAction)
 	h.mux.HandleFunc("/api/v1/workspaces/status/", h.handleGetWorkspaceStatus)
 	h.mux.HandleFunc("/api/v1/health", h.handleHealth)
+	h.mux.HandleFunc("/metrics", h.handleMetrics)
+}
+
+// ServeHTTP serves the workspace API, authenticating requests
+func (h *WorkspaceHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
 }
 
 func (h *WorkspaceHTTPHandler) Start(ctx context.Context) error {
+	tlsConfig, err := h.config.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if err := h.config.checkExposure(tlsConfig); err != nil {
+		return err
+	}
+
 	listener, err := net.Listen("tcp", h.server.Addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %w", h.server.Addr, err)
 	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
 
 	h.mu.Lock()
 	h.listener = listener
@@ -99,6 +126,15 @@ func (h *WorkspaceHTTPHandler) handleCreateWorkspace(w http.ResponseWriter, r *h
 	defer cancel()
 
 	result, err := h.manager.CreateWorkspace(ctx, &cmd)
+	var admissionErr *AdmissionError
+	if errors.As(err, &admissionErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"error": admissionErr}); err != nil {
+			log.Printf("Failed to encode response: %v", err)
+		}
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to create workspace: %v", err), http.StatusInternalServerError)
 		return
@@ -144,9 +180,19 @@ func (h *WorkspaceHTTPHandler) handleListWorkspaces(w http.ResponseWriter, r *ht
 }
 
 func (h *WorkspaceHTTPHandler) handleWorkspaceAction(w http.ResponseWriter, r *http.Request) {
-	parts := r.URL.Path[len("/api/v1/workspaces/"):]
+	parts, resource, _ := strings.Cut(r.URL.Path[len("/api/v1/workspaces/"):], "/")
 
 	switch {
+	case resource == "exec" && r.Method == http.MethodPost:
+		h.handleExec(w, r, parts)
+	case resource == "files" && r.Method == http.MethodGet:
+		h.handleDownloadFile(w, r, parts)
+	case resource == "files" && r.Method == http.MethodPut:
+		h.handleUploadFile(w, r, parts)
+	case resource == "logs" && r.Method == http.MethodGet:
+		h.handleTailLogs(w, r, parts)
+	case resource != "":
+		http.Error(w, "not found", http.StatusNotFound)
 	case r.Method == http.MethodDelete:
 		h.handleDeleteWorkspace(w, r, parts)
 	case r.Method == http.MethodPost && r.URL.Query().Get("action") == "start-services":
@@ -242,6 +288,11 @@ func (h *WorkspaceHTTPHandler) handleHealth(w http.ResponseWriter, r *http.Reque
 	workspaceCount := len(h.manager.workspaces)
 	h.manager.mu.RUnlock()
 
+	outboxBacklog := 0
+	if h.manager.agent != nil {
+		outboxBacklog = h.manager.agent.OutboxBacklog()
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
@@ -249,6 +300,7 @@ func (h *WorkspaceHTTPHandler) handleHealth(w http.ResponseWriter, r *http.Reque
 		"status":     "ok",
 		"timestamp":  time.Now().Format(time.RFC3339),
 		"workspaces": workspaceCount,
+		"outbox":     outboxBacklog,
 	}); err != nil {
 		log.Printf("Failed to encode response: %v", err)
 	}
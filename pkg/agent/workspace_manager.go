@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -16,6 +17,7 @@ type WorkspaceManager struct {
 	workspaces         map[string]*ManagedWorkspace
 	portAllocationLock sync.Mutex
 	portRange          PortAllocationRange
+	pool               *workspacePool
 	mu                 sync.RWMutex
 }
 
@@ -28,27 +30,37 @@ type PortAllocationRange struct {
 }
 
 type ManagedWorkspace struct {
-	Command          *CreateWorkspaceCommand
-	ContainerID      string
-	Status           WorkspaceStatus
-	StartedAt        time.Time
-	Services         map[string]*ManagedService
-	SSHPort          int
-	ContainerIP      string
-	LastStatusUpdate time.Time
-	ErrorMessage     string
+	Command          *CreateWorkspaceCommand    `json:"command"`
+	ContainerID      string                     `json:"container_id"`
+	Status           WorkspaceStatus            `json:"status"`
+	StartedAt        time.Time                  `json:"started_at"`
+	Services         map[string]*ManagedService `json:"services"`
+	SSHPort          int                        `json:"ssh_port"`
+	ContainerIP      string                     `json:"container_ip,omitempty"`
+	LastStatusUpdate time.Time                  `json:"last_status_update"`
+	ErrorMessage     string                     `json:"error_message,omitempty"`
+	SessionID        string                     `json:"session_id,omitempty"` // set when claimed from a pool
 	mu               sync.RWMutex
 }
 
+// session returns the provider session the workspace runs in. Workspaces claimed from a
+// pool keep the session they were warmed in; all others run in one named after them.
+func (w *ManagedWorkspace) session() string {
+	if w.SessionID != "" {
+		return w.SessionID
+	}
+	return w.Command.WorkspaceID
+}
+
 type ManagedService struct {
-	Definition   ServiceDefinition
-	Status       ServiceStatus
-	Port         int
-	MappedPort   int
-	StartedAt    time.Time
-	HealthStatus string
-	LastCheck    time.Time
-	ErrorMessage string
+	Definition   ServiceDefinition `json:"definition"`
+	Status       ServiceStatus     `json:"status"`
+	Port         int               `json:"port"`
+	MappedPort   int               `json:"mapped_port"`
+	StartedAt    time.Time         `json:"started_at"`
+	HealthStatus string            `json:"health_status,omitempty"`
+	LastCheck    time.Time         `json:"last_check"`
+	ErrorMessage string            `json:"error_message,omitempty"`
 }
 
 func NewWorkspaceManager(agent *Agent) *WorkspaceManager {
@@ -63,6 +75,7 @@ func NewWorkspaceManager(agent *Agent) *WorkspaceManager {
 			ServiceEnd:     30000,
 			allocatedPorts: make(map[int]bool),
 		},
+		pool: newWorkspacePool(agent.config.Pools),
 	}
 }
 
@@ -95,11 +108,19 @@ func (wm *WorkspaceManager) CreateWorkspace(ctx context.Context, cmd *CreateWork
 		return nil, fmt.Errorf("invalid workspace command: %w", err)
 	}
 
-	// Allocate SSH port
+	// Admit the workspace and claim its SSH port in one step so concurrent creates cannot
+	// overcommit the node
+	wm.mu.Lock()
+	if err := wm.admit(cmd); err != nil {
+		wm.mu.Unlock()
+		return nil, err
+	}
+
 	wm.portAllocationLock.Lock()
 	sshPort, err := wm.portRange.AllocateSSHPort()
 	wm.portAllocationLock.Unlock()
 	if err != nil {
+		wm.mu.Unlock()
 		return nil, fmt.Errorf("failed to allocate SSH port: %w", err)
 	}
 
@@ -110,10 +131,9 @@ func (wm *WorkspaceManager) CreateWorkspace(ctx context.Context, cmd *CreateWork
 		Services:  make(map[string]*ManagedService),
 		SSHPort:   sshPort,
 	}
-
-	wm.mu.Lock()
 	wm.workspaces[cmd.WorkspaceID] = workspace
 	wm.mu.Unlock()
+	defer wm.saveState()
 
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
@@ -131,8 +151,13 @@ func (wm *WorkspaceManager) CreateWorkspace(ctx context.Context, cmd *CreateWork
 		return result, nil
 	}
 
+	// A warm workspace from a pool only needs the user and branch set up
+	if result := wm.claimPooled(ctx, providerImpl, workspace); result != nil {
+		return result, nil
+	}
+
 	// Create workspace path
-	workspacePath := fmt.Sprintf("/var/lib/nexus/workspaces/%s", cmd.WorkspaceID)
+	workspacePath := filepath.Join(workspaceRoot, cmd.WorkspaceID)
 
 	session, err := providerImpl.Create(ctx, cmd.WorkspaceID, workspacePath, nil)
 	if err != nil {
@@ -192,8 +217,17 @@ func (wm *WorkspaceManager) CreateWorkspace(ctx context.Context, cmd *CreateWork
 	return result, nil
 }
 
-func (wm *WorkspaceManager) configureSSH(ctx context.Context, prov provider.Provider, workspaceID string, sshCfg SSHConfig) error {
-	setupSSHScript := fmt.Sprintf(`#!/bin/bash
+func (wm *WorkspaceManager) configureSSH(ctx context.Context, prov provider.Provider, sessionID string, sshCfg SSHConfig) error {
+	if err := wm.installSSH(ctx, prov, sessionID); err != nil {
+		return err
+	}
+	return wm.addSSHUser(ctx, prov, sessionID, sshCfg)
+}
+
+// installSSH installs and starts the SSH server. It is the slow part of configuring SSH
+// and the same for every user, so pooled workspaces run it ahead of time.
+func (wm *WorkspaceManager) installSSH(ctx context.Context, prov provider.Provider, sessionID string) error {
+	installSSHScript := `#!/bin/bash
 set -e
 
 # Update package manager
@@ -202,18 +236,6 @@ apt-get update -qq
 # Install SSH server
 apt-get install -y openssh-server > /dev/null 2>&1
 
-# Create user
-useradd -m -s /bin/bash %s || true
-
-# Setup SSH directory
-mkdir -p /home/%s/.ssh
-chmod 700 /home/%s/.ssh
-
-# Add public key
-echo "%s" > /home/%s/.ssh/authorized_keys
-chmod 600 /home/%s/.ssh/authorized_keys
-chown -R %s:%s /home/%s/.ssh
-
 # Configure SSH daemon
 mkdir -p /run/sshd
 sed -i 's/#PermitRootLogin.*/PermitRootLogin no/' /etc/ssh/sshd_config
@@ -225,13 +247,13 @@ service ssh start || /usr/sbin/sshd -D &
 sleep 2
 
 echo "SSH configured successfully"
-`, sshCfg.User, sshCfg.User, sshCfg.User, sshCfg.PubKey, sshCfg.User, sshCfg.User, sshCfg.User, sshCfg.User, sshCfg.User)
+`
 
 	cmd := provider.ExecOptions{
-		Cmd: []string{"/bin/bash", "-c", setupSSHScript},
+		Cmd: []string{"/bin/bash", "-c", installSSHScript},
 	}
 
-	if err := prov.Exec(ctx, workspaceID, cmd); err != nil {
+	if err := prov.Exec(ctx, sessionID, cmd); err != nil {
 		return fmt.Errorf("failed to configure SSH in container: %w", err)
 	}
 
@@ -240,13 +262,41 @@ echo "SSH configured successfully"
 	verifyCmd := provider.ExecOptions{
 		Cmd: []string{"netstat", "-tlnp"},
 	}
-	if err := prov.Exec(ctx, workspaceID, verifyCmd); err != nil {
+	if err := prov.Exec(ctx, sessionID, verifyCmd); err != nil {
 		log.Printf("Warning: Could not verify SSH is listening, but continuing: %v", err)
 	}
 
 	return nil
 }
 
+// addSSHUser creates the workspace user and authorizes their public key
+func (wm *WorkspaceManager) addSSHUser(ctx context.Context, prov provider.Provider, sessionID string, sshCfg SSHConfig) error {
+	addUserScript := fmt.Sprintf(`#!/bin/bash
+set -e
+
+# Create user
+useradd -m -s /bin/bash %s || true
+
+# Setup SSH directory
+mkdir -p /home/%s/.ssh
+chmod 700 /home/%s/.ssh
+
+# Add public key
+echo "%s" > /home/%s/.ssh/authorized_keys
+chmod 600 /home/%s/.ssh/authorized_keys
+chown -R %s:%s /home/%s/.ssh
+`, sshCfg.User, sshCfg.User, sshCfg.User, sshCfg.PubKey, sshCfg.User, sshCfg.User, sshCfg.User, sshCfg.User, sshCfg.User)
+
+	cmd := provider.ExecOptions{
+		Cmd: []string{"/bin/bash", "-c", addUserScript},
+	}
+
+	if err := prov.Exec(ctx, sessionID, cmd); err != nil {
+		return fmt.Errorf("failed to add SSH user: %w", err)
+	}
+	return nil
+}
+
 func (wm *WorkspaceManager) StartServices(ctx context.Context, workspaceID string) (*WorkspaceStatusUpdate, error) {
 	wm.mu.RLock()
 	workspace, exists := wm.workspaces[workspaceID]
@@ -293,7 +343,7 @@ func (wm *WorkspaceManager) StartServices(ctx context.Context, workspaceID strin
 		workspace.mu.Unlock()
 
 		// Start service with retries
-		err = wm.startServiceWithRetry(ctx, prov, workspaceID, svc, 3, 1*time.Second)
+		err = wm.startServiceWithRetry(ctx, prov, workspace.session(), svc, 3, 1*time.Second)
 		if err != nil {
 			serviceStatus[svc.Name] = "error"
 			managedSvc.Status = ServiceStatusError
@@ -319,6 +369,7 @@ func (wm *WorkspaceManager) StartServices(ctx context.Context, workspaceID strin
 	workspace.mu.Lock()
 	workspace.LastStatusUpdate = time.Now()
 	workspace.mu.Unlock()
+	wm.saveState()
 
 	return &WorkspaceStatusUpdate{
 		WorkspaceID: workspaceID,
@@ -328,7 +379,7 @@ func (wm *WorkspaceManager) StartServices(ctx context.Context, workspaceID strin
 	}, nil
 }
 
-func (wm *WorkspaceManager) startServiceWithRetry(ctx context.Context, prov provider.Provider, workspaceID string, svc ServiceDefinition, maxRetries int, backoff time.Duration) error {
+func (wm *WorkspaceManager) startServiceWithRetry(ctx context.Context, prov provider.Provider, sessionID string, svc ServiceDefinition, maxRetries int, backoff time.Duration) error {
 	var lastErr error
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
@@ -341,10 +392,10 @@ func (wm *WorkspaceManager) startServiceWithRetry(ctx context.Context, prov prov
 		}
 
 		cmd := provider.ExecOptions{
-			Cmd: []string{"/bin/bash", "-c", fmt.Sprintf("cd /workspace && %s", svc.Command)},
+			Cmd: []string{"/bin/bash", "-c", fmt.Sprintf("mkdir -p %s && cd %s && { %s; } >> %q 2>&1", serviceLogDir, workspaceDir, svc.Command, serviceLogPath(svc.Name))},
 		}
 
-		err := prov.Exec(ctx, workspaceID, cmd)
+		err := prov.Exec(ctx, sessionID, cmd)
 		if err == nil {
 			return nil
 		}
@@ -388,11 +439,11 @@ func (wm *WorkspaceManager) runHealthCheck(ctx context.Context, prov provider.Pr
 
 	switch hc.Type {
 	case HealthCheckHTTP:
-		wm.checkHTTPHealth(ctx, prov, workspaceID, svc, managedSvc)
+		wm.checkHTTPHealth(ctx, prov, workspace.session(), svc, managedSvc)
 	case HealthCheckTCP:
-		wm.checkTCPHealth(ctx, prov, workspaceID, svc, managedSvc)
+		wm.checkTCPHealth(ctx, prov, workspace.session(), svc, managedSvc)
 	case HealthCheckExec:
-		wm.checkExecHealth(ctx, prov, workspaceID, svc, managedSvc)
+		wm.checkExecHealth(ctx, prov, workspace.session(), svc, managedSvc)
 	}
 
 	workspace.mu.Lock()
@@ -400,7 +451,7 @@ func (wm *WorkspaceManager) runHealthCheck(ctx context.Context, prov provider.Pr
 	workspace.mu.Unlock()
 }
 
-func (wm *WorkspaceManager) checkHTTPHealth(ctx context.Context, prov provider.Provider, workspaceID string, svc ServiceDefinition, managedSvc *ManagedService) {
+func (wm *WorkspaceManager) checkHTTPHealth(ctx context.Context, prov provider.Provider, sessionID string, svc ServiceDefinition, managedSvc *ManagedService) {
 	path := "/health"
 	if svc.HealthCheck.Path != "" {
 		path = svc.HealthCheck.Path
@@ -410,7 +461,7 @@ func (wm *WorkspaceManager) checkHTTPHealth(ctx context.Context, prov provider.P
 		Cmd: []string{"/bin/bash", "-c", fmt.Sprintf("curl -sf http://localhost:%d%s", svc.Port, path)},
 	}
 
-	if err := prov.Exec(ctx, workspaceID, cmd); err == nil {
+	if err := prov.Exec(ctx, sessionID, cmd); err == nil {
 		managedSvc.HealthStatus = "healthy"
 		managedSvc.Status = ServiceStatusRunning
 	} else {
@@ -420,12 +471,12 @@ func (wm *WorkspaceManager) checkHTTPHealth(ctx context.Context, prov provider.P
 	}
 }
 
-func (wm *WorkspaceManager) checkTCPHealth(ctx context.Context, prov provider.Provider, workspaceID string, svc ServiceDefinition, managedSvc *ManagedService) {
+func (wm *WorkspaceManager) checkTCPHealth(ctx context.Context, prov provider.Provider, sessionID string, svc ServiceDefinition, managedSvc *ManagedService) {
 	cmd := provider.ExecOptions{
 		Cmd: []string{"/bin/bash", "-c", fmt.Sprintf("nc -z localhost %d", svc.Port)},
 	}
 
-	if err := prov.Exec(ctx, workspaceID, cmd); err == nil {
+	if err := prov.Exec(ctx, sessionID, cmd); err == nil {
 		managedSvc.HealthStatus = "healthy"
 		managedSvc.Status = ServiceStatusRunning
 	} else {
@@ -434,12 +485,12 @@ func (wm *WorkspaceManager) checkTCPHealth(ctx context.Context, prov provider.Pr
 	}
 }
 
-func (wm *WorkspaceManager) checkExecHealth(ctx context.Context, prov provider.Provider, workspaceID string, svc ServiceDefinition, managedSvc *ManagedService) {
+func (wm *WorkspaceManager) checkExecHealth(ctx context.Context, prov provider.Provider, sessionID string, svc ServiceDefinition, managedSvc *ManagedService) {
 	cmd := provider.ExecOptions{
 		Cmd: []string{"/bin/bash", "-c", svc.HealthCheck.Command},
 	}
 
-	if err := prov.Exec(ctx, workspaceID, cmd); err == nil {
+	if err := prov.Exec(ctx, sessionID, cmd); err == nil {
 		managedSvc.HealthStatus = "healthy"
 		managedSvc.Status = ServiceStatusRunning
 	} else {
@@ -511,6 +562,7 @@ func (wm *WorkspaceManager) StopWorkspace(ctx context.Context, workspaceID strin
 	workspace.mu.Lock()
 	workspace.Status = WorkspaceStatusStopped
 	workspace.mu.Unlock()
+	defer wm.saveState()
 
 	prov, ok := wm.providers[workspace.Command.Provider]
 	if !ok {
@@ -520,13 +572,43 @@ func (wm *WorkspaceManager) StopWorkspace(ctx context.Context, workspaceID strin
 	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
-	if err := prov.Stop(ctx, workspaceID); err != nil {
+	if err := prov.Stop(ctx, workspace.session()); err != nil {
 		return fmt.Errorf("failed to stop container: %w", err)
 	}
 
 	return nil
 }
 
+// StartWorkspace starts the container of a stopped workspace again
+func (wm *WorkspaceManager) StartWorkspace(ctx context.Context, workspaceID string) error {
+	wm.mu.RLock()
+	workspace, exists := wm.workspaces[workspaceID]
+	wm.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("workspace %s not found", workspaceID)
+	}
+
+	prov, ok := wm.providers[workspace.Command.Provider]
+	if !ok {
+		return fmt.Errorf("provider %s not available", workspace.Command.Provider)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if err := prov.Start(ctx, workspace.session()); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+
+	workspace.mu.Lock()
+	workspace.Status = WorkspaceStatusRunning
+	workspace.mu.Unlock()
+	wm.saveState()
+
+	return nil
+}
+
 func (wm *WorkspaceManager) DeleteWorkspace(ctx context.Context, workspaceID string) error {
 	wm.mu.RLock()
 	workspace, exists := wm.workspaces[workspaceID]
@@ -544,7 +626,7 @@ func (wm *WorkspaceManager) DeleteWorkspace(ctx context.Context, workspaceID str
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	if err := prov.Destroy(ctx, workspaceID); err != nil {
+	if err := prov.Destroy(ctx, workspace.session()); err != nil {
 		return fmt.Errorf("failed to destroy container: %w", err)
 	}
 
@@ -559,6 +641,7 @@ func (wm *WorkspaceManager) DeleteWorkspace(ctx context.Context, workspaceID str
 	wm.mu.Lock()
 	delete(wm.workspaces, workspaceID)
 	wm.mu.Unlock()
+	wm.saveState()
 
 	return nil
 }
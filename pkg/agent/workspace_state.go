@@ -0,0 +1,242 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+// workspaceStateFile is the name of the managed workspace state inside CacheDir
+const workspaceStateFile = "workspaces.json"
+
+// sessionLabel is the label providers put on the containers they create for a workspace
+const sessionLabel = "nexus.session.id"
+
+// loadWorkspaceState reads the workspaces saved in cacheDir. It returns nil when none were saved.
+func loadWorkspaceState(cacheDir string) (map[string]*ManagedWorkspace, error) {
+	if cacheDir == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(cacheDir, workspaceStateFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace state: %w", err)
+	}
+
+	var workspaces map[string]*ManagedWorkspace
+	if err := json.Unmarshal(data, &workspaces); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace state: %w", err)
+	}
+	return workspaces, nil
+}
+
+// writeWorkspaceState atomically replaces the workspace state in cacheDir
+func writeWorkspaceState(cacheDir string, data []byte) error {
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	path := filepath.Join(cacheDir, workspaceStateFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write workspace state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write workspace state: %w", err)
+	}
+	return nil
+}
+
+// cacheDir returns the directory the manager saves its state in, or "" when state is not kept
+func (wm *WorkspaceManager) cacheDir() string {
+	if wm.agent == nil {
+		return ""
+	}
+	return wm.agent.config.CacheDir
+}
+
+// saveState writes the managed workspaces to CacheDir so a restarted agent can find them
+func (wm *WorkspaceManager) saveState() {
+	cacheDir := wm.cacheDir()
+	if cacheDir == "" {
+		return
+	}
+
+	wm.mu.RLock()
+	workspaces := make(map[string]json.RawMessage, len(wm.workspaces))
+	var err error
+	for id, workspace := range wm.workspaces {
+		workspace.mu.RLock()
+		workspaces[id], err = json.Marshal(workspace)
+		workspace.mu.RUnlock()
+		if err != nil {
+			break
+		}
+	}
+	wm.mu.RUnlock()
+	if err != nil {
+		log.Printf("Failed to save workspace state: %v", err)
+		return
+	}
+
+	data, err := json.MarshalIndent(workspaces, "", "  ")
+	if err == nil {
+		err = writeWorkspaceState(cacheDir, data)
+	}
+	if err != nil {
+		log.Printf("Failed to save workspace state: %v", err)
+	}
+}
+
+// Reconcile restores the workspaces saved before a restart and compares them with what the
+// providers actually run. Labelled sessions missing from the saved state are adopted; saved
+// workspaces whose sessions are gone are forgotten and their ports released. It returns the
+// difference, or nil when the saved state matched.
+func (wm *WorkspaceManager) Reconcile(ctx context.Context) (*coordination.WorkspaceDrift, error) {
+	if err := wm.restoreState(); err != nil {
+		return nil, err
+	}
+
+	drift := &coordination.WorkspaceDrift{}
+	for name, prov := range wm.providers {
+		if prov == nil {
+			continue
+		}
+		sessions, err := prov.List(ctx)
+		if err != nil {
+			// Without a listing we cannot tell which workspaces are gone, so keep them
+			log.Printf("Failed to list %s sessions: %v", name, err)
+			continue
+		}
+		wm.reconcileProvider(name, sessions, drift)
+	}
+	wm.saveState()
+
+	if len(drift.Adopted) == 0 && len(drift.Vanished) == 0 {
+		return nil, nil
+	}
+	return drift, nil
+}
+
+// restoreState loads the workspaces saved in CacheDir and reserves their ports
+func (wm *WorkspaceManager) restoreState() error {
+	saved, err := loadWorkspaceState(wm.cacheDir())
+	if err != nil {
+		return err
+	}
+
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.portAllocationLock.Lock()
+	defer wm.portAllocationLock.Unlock()
+	for id, workspace := range saved {
+		if workspace.Command == nil {
+			continue
+		}
+		if workspace.Services == nil {
+			workspace.Services = make(map[string]*ManagedService)
+		}
+		wm.workspaces[id] = workspace
+		wm.reservePorts(workspace)
+	}
+	return nil
+}
+
+// reconcileProvider applies one provider's sessions to the managed workspaces
+func (wm *WorkspaceManager) reconcileProvider(providerName string, sessions []provider.Session, drift *coordination.WorkspaceDrift) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.portAllocationLock.Lock()
+	defer wm.portAllocationLock.Unlock()
+
+	live := make(map[string]bool)
+	for _, session := range sessions {
+		workspaceID := session.Labels[sessionLabel]
+		if workspaceID == "" {
+			continue
+		}
+		live[workspaceID] = true
+
+		// Pooled workspaces are claimed by a workspace that is already saved, or discarded
+		if strings.HasPrefix(workspaceID, poolSessionPrefix) {
+			continue
+		}
+		if _, exists := wm.workspaces[workspaceID]; exists {
+			continue
+		}
+		workspace := &ManagedWorkspace{
+			Command:     &CreateWorkspaceCommand{WorkspaceID: workspaceID, Provider: providerName},
+			ContainerID: session.ID,
+			Status:      sessionStatus(session.Status),
+			Services:    make(map[string]*ManagedService),
+			SSHPort:     session.SSHPort,
+		}
+		wm.workspaces[workspaceID] = workspace
+		wm.reservePorts(workspace)
+		drift.Adopted = append(drift.Adopted, workspaceID)
+		log.Printf("Adopted workspace %s running on %s", workspaceID, providerName)
+	}
+
+	for id, workspace := range wm.workspaces {
+		if workspace.Command.Provider != providerName || live[workspace.session()] {
+			continue
+		}
+		wm.portRange.ReleasePort(workspace.SSHPort)
+		for _, svc := range workspace.Services {
+			wm.portRange.ReleasePort(svc.MappedPort)
+		}
+		delete(wm.workspaces, id)
+		drift.Vanished = append(drift.Vanished, id)
+		log.Printf("Workspace %s is no longer running on %s", id, providerName)
+	}
+}
+
+// reservePorts marks the ports of a restored workspace as allocated. The caller holds
+// portAllocationLock.
+func (wm *WorkspaceManager) reservePorts(workspace *ManagedWorkspace) {
+	if wm.portRange.allocatedPorts == nil {
+		wm.portRange.allocatedPorts = make(map[int]bool)
+	}
+	if workspace.SSHPort != 0 {
+		wm.portRange.allocatedPorts[workspace.SSHPort] = true
+	}
+	for _, svc := range workspace.Services {
+		if svc.MappedPort != 0 {
+			wm.portRange.allocatedPorts[svc.MappedPort] = true
+		}
+	}
+}
+
+// sessionStatus maps a provider's session status to a workspace status
+func sessionStatus(status string) WorkspaceStatus {
+	status = strings.ToLower(status)
+	if status == "running" || strings.HasPrefix(status, "up") {
+		return WorkspaceStatusRunning
+	}
+	return WorkspaceStatusStopped
+}
+
+// reconcileWorkspaces restores the managed workspaces after a restart. Drift is reported
+// with the next heartbeat.
+func (a *Agent) reconcileWorkspaces(ctx context.Context) {
+	drift, err := a.workspaces.Reconcile(ctx)
+	if err != nil {
+		log.Printf("Failed to reconcile workspaces: %v", err)
+		return
+	}
+	if drift != nil {
+		a.mu.Lock()
+		a.drift = drift
+		a.mu.Unlock()
+	}
+}
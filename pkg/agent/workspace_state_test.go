@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nexus/nexus/pkg/coordination"
+	"github.com/nexus/nexus/pkg/provider"
+)
+
+// listingProvider is a provider whose only working call is List
+type listingProvider struct {
+	provider.Provider
+	sessions []provider.Session
+}
+
+func (p *listingProvider) List(ctx context.Context) ([]provider.Session, error) {
+	return p.sessions, nil
+}
+
+func TestWorkspaceStateSurvivesRestart(t *testing.T) {
+	cacheDir := t.TempDir()
+	newManager := func(sessions ...provider.Session) *WorkspaceManager {
+		agent := &Agent{
+			node:      &Node{ID: "test-node"},
+			config:    NodeConfig{CacheDir: cacheDir},
+			providers: map[string]provider.Provider{"docker": &listingProvider{sessions: sessions}},
+		}
+		return NewWorkspaceManager(agent)
+	}
+
+	before := newManager()
+	before.workspaces["ws-1"] = &ManagedWorkspace{
+		Command:  &CreateWorkspaceCommand{WorkspaceID: "ws-1", Provider: "docker"},
+		Status:   WorkspaceStatusRunning,
+		SSHPort:  2222,
+		Services: map[string]*ManagedService{"web": {MappedPort: 23000}},
+	}
+	before.workspaces["ws-2"] = &ManagedWorkspace{
+		Command:  &CreateWorkspaceCommand{WorkspaceID: "ws-2", Provider: "docker"},
+		Status:   WorkspaceStatusRunning,
+		SSHPort:  2223,
+		Services: map[string]*ManagedService{},
+	}
+	before.saveState()
+
+	info, err := os.Stat(filepath.Join(cacheDir, workspaceStateFile))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	after := newManager(
+		provider.Session{ID: "c1", Status: "Up 3 minutes", Labels: map[string]string{sessionLabel: "ws-1"}},
+		provider.Session{ID: "c3", Status: "Exited (0)", SSHPort: 2224, Labels: map[string]string{sessionLabel: "ws-3"}},
+		provider.Session{ID: "unmanaged", Status: "Up"},
+	)
+	drift, err := after.Reconcile(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, drift)
+	assert.Equal(t, []string{"ws-3"}, drift.Adopted)
+	assert.Equal(t, []string{"ws-2"}, drift.Vanished)
+
+	require.Contains(t, after.workspaces, "ws-1")
+	assert.Equal(t, 23000, after.workspaces["ws-1"].Services["web"].MappedPort)
+	assert.Equal(t, WorkspaceStatusStopped, after.workspaces["ws-3"].Status)
+	assert.NotContains(t, after.workspaces, "ws-2")
+
+	port, err := after.portRange.AllocateSSHPort()
+	require.NoError(t, err)
+	assert.Equal(t, 2223, port, "ports of vanished workspaces are released, restored ones stay allocated")
+	port, err = after.portRange.AllocateServicePort()
+	require.NoError(t, err)
+	assert.Equal(t, 23001, port)
+
+	saved, err := loadWorkspaceState(cacheDir)
+	require.NoError(t, err)
+	assert.Len(t, saved, 2, "the reconciled state is saved")
+
+	drift, err = after.Reconcile(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, drift, "nothing drifts when the saved state matches the providers")
+}
+
+func TestHeartbeatReportsWorkspaceDrift(t *testing.T) {
+	var reported []*coordination.WorkspaceDrift
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var heartbeat coordination.NodeHeartbeat
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&heartbeat))
+		reported = append(reported, heartbeat.Drift)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	agent, err := NewAgent(NodeConfig{CoordinationURL: server.URL, CacheDir: t.TempDir()})
+	require.NoError(t, err)
+	agent.workspaces.providers = map[string]provider.Provider{
+		"docker": &listingProvider{sessions: []provider.Session{{ID: "c1", Labels: map[string]string{sessionLabel: "ws-1"}}}},
+	}
+
+	agent.reconcileWorkspaces(context.Background())
+	require.NoError(t, agent.sendHeartbeat())
+	require.NoError(t, agent.sendHeartbeat())
+
+	require.Len(t, reported, 2)
+	require.NotNil(t, reported[0])
+	assert.Equal(t, []string{"ws-1"}, reported[0].Adopted)
+	assert.Nil(t, reported[1], "drift is reported once")
+}
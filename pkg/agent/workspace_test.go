@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 	"time"
@@ -340,3 +341,65 @@ func TestServiceDependencyResolution(t *testing.T) {
 		})
 	}
 }
+
+func TestWorkspaceCreateCommand(t *testing.T) {
+	wm := createTestWorkspaceManager()
+	agent := wm.agent
+	agent.workspaces = wm
+
+	create := capacityTestCommand("ws-1", 1, "1GB")
+	data, err := json.Marshal(create)
+	require.NoError(t, err)
+	var params map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &params))
+
+	result := agent.executeCommand(Command{ID: "create-1", Type: "workspace", Action: "create", Params: params})
+	assert.Equal(t, "failed", result.Status)
+	assert.Contains(t, result.Error, "provider missing not available")
+	var created WorkspaceCreateResult
+	require.NoError(t, json.Unmarshal([]byte(result.Output), &created), "the create result is reported")
+	assert.Equal(t, "ws-1", created.WorkspaceID)
+	require.Contains(t, wm.workspaces, "ws-1")
+	assert.Equal(t, "ubuntu:22.04", wm.workspaces["ws-1"].Command.Image)
+
+	delete(params, "image")
+	params["workspace_id"] = "ws-2"
+	result = agent.executeCommand(Command{ID: "create-2", Type: "workspace", Action: "create", Params: params})
+	assert.Equal(t, "failed", result.Status)
+	assert.Contains(t, result.Error, "image is required")
+}
+
+func TestWorkspaceLifecycleCommands(t *testing.T) {
+	prov := &poolProvider{}
+	wm := poolTestManager(prov)
+	agent := wm.agent
+	cmd := capacityTestCommand("ws-1", 1, "1GB")
+	cmd.Provider = "docker"
+	_, err := wm.CreateWorkspace(context.Background(), cmd)
+	require.NoError(t, err)
+	session := wm.workspaces["ws-1"].session()
+
+	run := func(action, workspaceID string) CommandResult {
+		return agent.executeCommand(Command{ID: action + "-1", Type: "workspace", Action: action, Params: map[string]interface{}{"workspace_id": workspaceID}})
+	}
+
+	result := run("stop", "ws-1")
+	assert.Equal(t, "success", result.Status, result.Error)
+	assert.Equal(t, []string{session}, prov.stopped)
+	assert.Equal(t, WorkspaceStatusStopped, wm.GetWorkspaceStatus("ws-1").Status)
+
+	result = run("start", "ws-1")
+	assert.Equal(t, "success", result.Status, result.Error)
+	assert.Equal(t, WorkspaceStatusRunning, wm.GetWorkspaceStatus("ws-1").Status)
+
+	result = run("start", "ws-2")
+	assert.Equal(t, "failed", result.Status, "unknown workspaces cannot be started")
+
+	result = run("delete", "ws-1")
+	assert.Equal(t, "success", result.Status, result.Error)
+	assert.Equal(t, []string{session}, prov.destroyed)
+	assert.Nil(t, wm.GetWorkspaceStatus("ws-1"))
+
+	result = run("delete", "ws-1")
+	assert.Equal(t, "success", result.Status, "deleting a workspace that is already gone succeeds")
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// ErrNotLoggedIn is returned when no credentials have been stored
+var ErrNotLoggedIn = errors.New("not logged in")
+
+// expirySkew refreshes tokens slightly before they expire so requests in flight stay valid
+const expirySkew = 30 * time.Second
+
+// Credentials are the tokens obtained by a CLI login
+type Credentials struct {
+	Issuer       string    `json:"issuer"`
+	ClientID     string    `json:"client_id"`
+	Username     string    `json:"username,omitempty"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	IDToken      string    `json:"id_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// NewCredentials builds credentials from a token returned by the issuer
+func NewCredentials(config *Config, token *oauth2.Token) *Credentials {
+	creds := &Credentials{
+		Issuer:   config.Issuer,
+		ClientID: config.ClientID,
+	}
+	creds.apply(token)
+	return creds
+}
+
+// apply copies a fresh token into the credentials, keeping the refresh token
+// when the issuer did not rotate it
+func (c *Credentials) apply(token *oauth2.Token) {
+	c.AccessToken = token.AccessToken
+	c.Expiry = token.Expiry
+	if token.RefreshToken != "" {
+		c.RefreshToken = token.RefreshToken
+	}
+	if idToken, ok := token.Extra("id_token").(string); ok && idToken != "" {
+		c.IDToken = idToken
+	} else if !c.idTokenValid(time.Now()) {
+		// The issuer did not replace the ID token and the old one would be rejected
+		c.IDToken = ""
+	}
+}
+
+// Expired reports whether the tokens need refreshing at now: the access token or
+// the ID token presented in its place has expired
+func (c *Credentials) Expired(now time.Time) bool {
+	if c.IDToken != "" && !c.idTokenValid(now) {
+		return true
+	}
+	return c.accessTokenExpired(now)
+}
+
+func (c *Credentials) accessTokenExpired(now time.Time) bool {
+	if c.Expiry.IsZero() {
+		return false
+	}
+	return !now.Add(expirySkew).Before(c.Expiry)
+}
+
+// idTokenExpiry returns the expiry of the ID token, or the zero time when there is
+// no ID token or it carries no readable exp claim
+func (c *Credentials) idTokenExpiry() time.Time {
+	if c.IDToken == "" {
+		return time.Time{}
+	}
+	// The server verifies the signature; the CLI only needs to know when to stop sending it
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(c.IDToken, &claims); err != nil || claims.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return claims.ExpiresAt.Time
+}
+
+func (c *Credentials) idTokenValid(now time.Time) bool {
+	expiry := c.idTokenExpiry()
+	return !expiry.IsZero() && now.Add(expirySkew).Before(expiry)
+}
+
+// BearerToken returns the token to present to the coordination server. A valid ID token
+// is preferred because the server can verify it without a round trip to the issuer.
+func (c *Credentials) BearerToken() string {
+	if c.idTokenValid(time.Now()) {
+		return c.IDToken
+	}
+	return c.AccessToken
+}
+
+// CredentialStore keeps credentials in a file only readable by the current user
+type CredentialStore struct {
+	path string
+}
+
+// NewCredentialStore creates a store backed by path
+func NewCredentialStore(path string) *CredentialStore {
+	return &CredentialStore{path: path}
+}
+
+// DefaultCredentialsPath returns the credentials file in the nexus user config directory
+func DefaultCredentialsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "nexus", "credentials.json")
+}
+
+// Path returns the file backing the store
+func (s *CredentialStore) Path() string {
+	return s.path
+}
+
+// Load reads the stored credentials, returning ErrNotLoggedIn when there are none
+func (s *CredentialStore) Load() (*Credentials, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotLoggedIn
+		}
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+
+	return &creds, nil
+}
+
+// Save writes the credentials atomically with 0600 permissions
+func (s *CredentialStore) Save(creds *Credentials) error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create credentials directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".credentials-*")
+	if err != nil {
+		return fmt.Errorf("failed to create credentials file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to restrict credentials file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes the stored credentials
+func (s *CredentialStore) Delete() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove credentials: %w", err)
+	}
+	return nil
+}
+
+// RefreshFunc exchanges the refresh token of creds for a new token
+type RefreshFunc func(ctx context.Context, creds *Credentials) (*oauth2.Token, error)
+
+// Token returns a bearer token from the stored credentials, refreshing and saving
+// them first when they have expired
+func (s *CredentialStore) Token(ctx context.Context, refresh RefreshFunc) (string, error) {
+	creds, err := s.Load()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if !creds.Expired(now) {
+		return creds.BearerToken(), nil
+	}
+
+	if creds.RefreshToken == "" {
+		// A stale ID token can't be refreshed, but the access token may still be good
+		if !creds.accessTokenExpired(now) {
+			return creds.AccessToken, nil
+		}
+		return "", fmt.Errorf("session expired, run 'nexus auth login' again")
+	}
+
+	token, err := refresh(ctx, creds)
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh session, run 'nexus auth login' again: %w", err)
+	}
+
+	creds.apply(token)
+	if err := s.Save(creds); err != nil {
+		return "", err
+	}
+
+	return creds.BearerToken(), nil
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// testIDToken returns an unverifiable ID token that expires at expiry
+func testIDToken(t *testing.T, expiry time.Time) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "sub-alice",
+		ExpiresAt: jwt.NewNumericDate(expiry),
+	}).SignedString([]byte("test"))
+	require.NoError(t, err)
+	return token
+}
+
+func TestCredentialStore_SaveLoadDelete(t *testing.T) {
+	store := NewCredentialStore(filepath.Join(t.TempDir(), "nexus", "credentials.json"))
+
+	_, err := store.Load()
+	assert.ErrorIs(t, err, ErrNotLoggedIn)
+
+	idToken := testIDToken(t, time.Now().Add(time.Hour))
+	token := (&oauth2.Token{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(time.Hour),
+	}).WithExtra(map[string]interface{}{"id_token": idToken})
+
+	creds := NewCredentials(&Config{Issuer: "https://issuer.example", ClientID: "cli"}, token)
+	creds.Username = "alice"
+	require.NoError(t, store.Save(creds))
+
+	info, err := os.Stat(store.Path())
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "credentials must only be readable by the owner")
+
+	loaded, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "https://issuer.example", loaded.Issuer)
+	assert.Equal(t, "alice", loaded.Username)
+	assert.Equal(t, "refresh", loaded.RefreshToken)
+	assert.Equal(t, idToken, loaded.BearerToken(), "the ID token is presented when available")
+
+	require.NoError(t, store.Delete())
+	require.NoError(t, store.Delete(), "deleting twice is not an error")
+	_, err = store.Load()
+	assert.ErrorIs(t, err, ErrNotLoggedIn)
+}
+
+func TestCredentialStore_TokenRefreshesExpiredCredentials(t *testing.T) {
+	store := NewCredentialStore(filepath.Join(t.TempDir(), "credentials.json"))
+	require.NoError(t, store.Save(&Credentials{
+		Issuer:       "https://issuer.example",
+		AccessToken:  "old-access",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(10 * time.Second),
+	}))
+
+	refreshed := 0
+	refresh := func(ctx context.Context, creds *Credentials) (*oauth2.Token, error) {
+		refreshed++
+		assert.Equal(t, "refresh", creds.RefreshToken)
+		return &oauth2.Token{AccessToken: "new-access", Expiry: time.Now().Add(time.Hour)}, nil
+	}
+
+	// Within the expiry skew the token is refreshed before use
+	token, err := store.Token(context.Background(), refresh)
+	require.NoError(t, err)
+	assert.Equal(t, "new-access", token)
+	assert.Equal(t, 1, refreshed)
+
+	loaded, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "new-access", loaded.AccessToken)
+	assert.Equal(t, "refresh", loaded.RefreshToken, "the refresh token is kept when the issuer does not rotate it")
+
+	token, err = store.Token(context.Background(), refresh)
+	require.NoError(t, err)
+	assert.Equal(t, "new-access", token)
+	assert.Equal(t, 1, refreshed, "valid tokens are not refreshed")
+}
+
+func TestCredentialStore_TokenRefreshesStaleIDToken(t *testing.T) {
+	store := NewCredentialStore(filepath.Join(t.TempDir(), "credentials.json"))
+	require.NoError(t, store.Save(&Credentials{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		IDToken:      testIDToken(t, time.Now().Add(-time.Minute)),
+		Expiry:       time.Now().Add(time.Hour),
+	}))
+
+	loaded, err := store.Load()
+	require.NoError(t, err)
+	assert.True(t, loaded.Expired(time.Now()), "an expired ID token needs refreshing even while the access token is valid")
+	assert.Equal(t, "access", loaded.BearerToken(), "an expired ID token is never presented")
+
+	// The refreshed ID token replaces the stale one
+	newIDToken := testIDToken(t, time.Now().Add(time.Hour))
+	token, err := store.Token(context.Background(), func(ctx context.Context, creds *Credentials) (*oauth2.Token, error) {
+		return (&oauth2.Token{AccessToken: "new-access", Expiry: time.Now().Add(time.Hour)}).
+			WithExtra(map[string]interface{}{"id_token": newIDToken}), nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, newIDToken, token)
+
+	// A refresh without a new ID token drops the stale one
+	require.NoError(t, store.Save(&Credentials{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		IDToken:      testIDToken(t, time.Now().Add(-time.Minute)),
+		Expiry:       time.Now().Add(time.Hour),
+	}))
+	token, err = store.Token(context.Background(), func(ctx context.Context, creds *Credentials) (*oauth2.Token, error) {
+		return &oauth2.Token{AccessToken: "new-access", Expiry: time.Now().Add(time.Hour)}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "new-access", token)
+	loaded, err = store.Load()
+	require.NoError(t, err)
+	assert.Empty(t, loaded.IDToken)
+
+	// Without a refresh token the access token is used until it expires too
+	require.NoError(t, store.Save(&Credentials{
+		AccessToken: "access",
+		IDToken:     testIDToken(t, time.Now().Add(-time.Minute)),
+		Expiry:      time.Now().Add(time.Hour),
+	}))
+	token, err = store.Token(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "access", token)
+}
+
+func TestDefaultCredentialsPath(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	if dir, err := os.UserConfigDir(); err != nil || dir != configDir {
+		t.Skip("the user config directory does not follow XDG_CONFIG_HOME on this platform")
+	}
+	assert.Equal(t, filepath.Join(configDir, "nexus", "credentials.json"), DefaultCredentialsPath())
+}
+
+func TestCredentialStore_TokenRefreshFailure(t *testing.T) {
+	store := NewCredentialStore(filepath.Join(t.TempDir(), "credentials.json"))
+	require.NoError(t, store.Save(&Credentials{AccessToken: "old", Expiry: time.Now().Add(-time.Minute)}))
+
+	_, err := store.Token(context.Background(), nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nexus auth login")
+
+	require.NoError(t, store.Save(&Credentials{AccessToken: "old", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Minute)}))
+	_, err = store.Token(context.Background(), func(ctx context.Context, creds *Credentials) (*oauth2.Token, error) {
+		return nil, fmt.Errorf("invalid_grant")
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid_grant")
+}
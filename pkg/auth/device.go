@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+// DeviceScopes are requested by CLI logins; offline_access asks the issuer for a refresh token
+const DeviceScopes = "openid profile email offline_access"
+
+// SupportsDeviceFlow reports whether the issuer advertises a device authorization endpoint
+func (p *OIDCProvider) SupportsDeviceFlow() bool {
+	return p.oauth2Config.Endpoint.DeviceAuthURL != ""
+}
+
+// DeviceAuth starts the OAuth 2.0 device authorization grant (RFC 8628) and returns
+// the code the user has to enter at the verification URI
+func (p *OIDCProvider) DeviceAuth(ctx context.Context) (*oauth2.DeviceAuthResponse, error) {
+	if !p.SupportsDeviceFlow() {
+		return nil, fmt.Errorf("issuer %s does not support the device authorization grant", p.config.Issuer)
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.httpClient)
+	response, err := p.oauth2Config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device authorization: %w", err)
+	}
+
+	return response, nil
+}
+
+// DeviceAccessToken polls the issuer until the user approves the device code, the code
+// expires or ctx is cancelled
+func (p *OIDCProvider) DeviceAccessToken(ctx context.Context, response *oauth2.DeviceAuthResponse) (*oauth2.Token, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.httpClient)
+	token, err := p.oauth2Config.DeviceAccessToken(ctx, response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete device authorization: %w", err)
+	}
+
+	return token, nil
+}
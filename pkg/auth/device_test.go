@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDeviceFlowServer(t *testing.T, withDeviceEndpoint bool) *httptest.Server {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer := fmt.Sprintf("http://%s", r.Host)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			discovery := map[string]interface{}{
+				"issuer":         issuer,
+				"token_endpoint": issuer + "/oauth2/token",
+				"jwks_uri":       issuer + "/oauth2/jwks",
+			}
+			if withDeviceEndpoint {
+				discovery["device_authorization_endpoint"] = issuer + "/oauth2/device_authorization"
+			}
+			json.NewEncoder(w).Encode(discovery)
+		case "/oauth2/device_authorization":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "cli-client", r.PostForm.Get("client_id"))
+			assert.Contains(t, r.PostForm.Get("scope"), "offline_access")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"device_code":      "device-123",
+				"user_code":        "ABCD-EFGH",
+				"verification_uri": issuer + "/activate",
+				"expires_in":       60,
+				"interval":         1,
+			})
+		case "/oauth2/token":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "urn:ietf:params:oauth:grant-type:device_code", r.PostForm.Get("grant_type"))
+			assert.Equal(t, "device-123", r.PostForm.Get("device_code"))
+			polls++
+			if polls == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "authorization_pending"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  "access",
+				"refresh_token": "refresh",
+				"id_token":      "id",
+				"token_type":    "Bearer",
+				"expires_in":    3600,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOIDCProvider_DeviceFlow(t *testing.T) {
+	if testing.Short() {
+		t.Skip("device flow polls at one second intervals")
+	}
+	ctx := context.Background()
+	server := newDeviceFlowServer(t, true)
+
+	provider, err := NewOIDCProvider(ctx, &Config{Issuer: server.URL, ClientID: "cli-client", Scopes: DeviceScopes})
+	require.NoError(t, err)
+	require.True(t, provider.SupportsDeviceFlow())
+
+	response, err := provider.DeviceAuth(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ABCD-EFGH", response.UserCode)
+	assert.Equal(t, server.URL+"/activate", response.VerificationURI)
+
+	token, err := provider.DeviceAccessToken(ctx, response)
+	require.NoError(t, err)
+
+	creds := NewCredentials(provider.GetConfig(), token)
+	assert.Equal(t, server.URL, creds.Issuer)
+	assert.Equal(t, "refresh", creds.RefreshToken)
+	assert.Equal(t, "id", creds.IDToken)
+}
+
+func TestOIDCProvider_DeviceFlowUnsupported(t *testing.T) {
+	ctx := context.Background()
+	server := newDeviceFlowServer(t, false)
+
+	provider, err := NewOIDCProvider(ctx, &Config{Issuer: server.URL, ClientID: "cli-client"})
+	require.NoError(t, err)
+	assert.False(t, provider.SupportsDeviceFlow())
+
+	_, err = provider.DeviceAuth(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not support the device authorization grant")
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -66,6 +67,46 @@ type TokenClaims struct {
 	Name          string `json:"name"`
 	Picture       string `json:"picture"`
 	Profile       string `json:"profile"`
+	// AuthorizedParty and ClientID name the client the token was issued to
+	AuthorizedParty string `json:"azp"`
+	ClientID        string `json:"client_id"`
+}
+
+// PreferredName returns the name to identify the user by: the preferred username,
+// then the verified email address, then the subject
+func (c *TokenClaims) PreferredName() string {
+	switch {
+	case c.Username != "":
+		return c.Username
+	case c.Email != "" && c.EmailVerified:
+		return c.Email
+	default:
+		return c.Subject
+	}
+}
+
+// IssuedTo reports whether the token was issued to clientID. An authorized party or
+// client_id claim must name the client, and the audience must include the client or
+// one of the allowed audiences unless one of those claims already names it.
+func (c *TokenClaims) IssuedTo(clientID string, allowedAudiences []string) bool {
+	if clientID == "" {
+		return false
+	}
+	if c.AuthorizedParty != "" && c.AuthorizedParty != clientID {
+		return false
+	}
+	if c.ClientID != "" && c.ClientID != clientID {
+		return false
+	}
+	if c.AuthorizedParty == clientID || c.ClientID == clientID {
+		return true
+	}
+	for _, audience := range c.Audience {
+		if audience == clientID || slices.Contains(allowedAudiences, audience) {
+			return true
+		}
+	}
+	return false
 }
 
 // NewOIDCProvider creates a new OIDC provider with Authgear
@@ -99,14 +140,13 @@ func NewOIDCProvider(ctx context.Context, config *Config) (*OIDCProvider, error)
 
 	jwksCache := &JWKSCache{}
 
+	// The verifier checks signatures, issuer and expiry; the audience is checked by
+	// TokenClaims.IssuedTo so allowed audiences and access tokens are accepted too
 	verifierConfig := &oidc.Config{
 		ClientID:             config.ClientID,
 		SupportedSigningAlgs: []string{"RS256"},
 		SkipIssuerCheck:      config.SkipIssuerCheck,
-	}
-	// Audience is validated via ClientID field - Authgear uses client_id as audience
-	if len(config.AllowedAudiences) > 0 {
-		verifierConfig.SkipClientIDCheck = false // Use ClientID for audience check
+		SkipClientIDCheck:    true,
 	}
 
 	oauth2Config := &oauth2.Config{
@@ -151,15 +191,27 @@ func (p *OIDCProvider) Exchange(ctx context.Context, code string, opts ...oauth2
 
 // VerifyIDToken validates an ID token and returns the claims
 func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawToken string) (*TokenClaims, error) {
-	idToken, err := p.verifier.Verify(ctx, rawToken)
+	claims, err := p.verifyToken(ctx, rawToken)
 	if err != nil {
 		return nil, fmt.Errorf("failed to verify ID token: %w", err)
 	}
+	return claims, nil
+}
+
+// verifyToken checks a JWT issued by the provider and that it was issued to this client
+func (p *OIDCProvider) verifyToken(ctx context.Context, rawToken string) (*TokenClaims, error) {
+	token, err := p.verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
 
 	var claims TokenClaims
-	if err := idToken.Claims(&claims); err != nil {
+	if err := token.Claims(&claims); err != nil {
 		return nil, fmt.Errorf("failed to parse claims: %w", err)
 	}
+	if !claims.IssuedTo(p.config.ClientID, p.config.AllowedAudiences) {
+		return nil, fmt.Errorf("token was not issued to client %s", p.config.ClientID)
+	}
 
 	return &claims, nil
 }
@@ -172,8 +224,15 @@ func (p *OIDCProvider) RefreshToken(ctx context.Context, refreshToken string) (*
 	return p.oauth2Config.TokenSource(ctx, token).Token()
 }
 
-// UserInfo retrieves user information from the UserInfo endpoint
+// UserInfo retrieves user information from the UserInfo endpoint. The access token
+// must itself be a JWT issued to this client: the userinfo endpoint accepts tokens
+// issued to any client, so it can't tell us who the token was meant for.
 func (p *OIDCProvider) UserInfo(ctx context.Context, accessToken string) (*TokenClaims, error) {
+	tokenClaims, err := p.verifyToken(ctx, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify access token: %w", err)
+	}
+
 	userInfo, err := p.provider.UserInfo(ctx, oauth2.StaticTokenSource(&oauth2.Token{
 		AccessToken: accessToken,
 	}))
@@ -185,6 +244,16 @@ func (p *OIDCProvider) UserInfo(ctx context.Context, accessToken string) (*Token
 	if err := userInfo.Claims(claims); err != nil {
 		return nil, fmt.Errorf("failed to parse user info: %w", err)
 	}
+	if claims.Subject != tokenClaims.Subject {
+		return nil, fmt.Errorf("user info subject %q does not match the access token", claims.Subject)
+	}
+
+	// The userinfo response carries no issuer, audience or expiry of its own
+	claims.Issuer = tokenClaims.Issuer
+	claims.Audience = tokenClaims.Audience
+	claims.ExpiresAt = tokenClaims.ExpiresAt
+	claims.AuthorizedParty = tokenClaims.AuthorizedParty
+	claims.ClientID = tokenClaims.ClientID
 
 	return claims, nil
 }
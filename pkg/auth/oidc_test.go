@@ -273,6 +273,40 @@ func TestTokenClaims(t *testing.T) {
 	assert.Equal(t, "https://example.com/avatar.png", claims.Picture)
 }
 
+func TestTokenClaimsPreferredName(t *testing.T) {
+	claims := &TokenClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "user-123"}, Email: "test@example.com"}
+	assert.Equal(t, "user-123", claims.PreferredName(), "an unverified email is not an identity")
+
+	claims.EmailVerified = true
+	assert.Equal(t, "test@example.com", claims.PreferredName())
+
+	claims.Username = "testuser"
+	assert.Equal(t, "testuser", claims.PreferredName())
+}
+
+func TestTokenClaimsIssuedTo(t *testing.T) {
+	allowed := []string{"https://api.example.com"}
+	for _, tc := range []struct {
+		name     string
+		claims   TokenClaims
+		issuedTo bool
+	}{
+		{"audience", TokenClaims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"client-1"}}}, true},
+		{"allowed audience", TokenClaims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"https://api.example.com"}}}, true},
+		{"other audience", TokenClaims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"client-2"}}}, false},
+		{"no audience", TokenClaims{}, false},
+		{"authorized party", TokenClaims{AuthorizedParty: "client-1", RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"https://other.example.com"}}}, true},
+		{"other authorized party", TokenClaims{AuthorizedParty: "client-2", RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"client-1"}}}, false},
+		{"client_id", TokenClaims{ClientID: "client-1"}, true},
+		{"other client_id", TokenClaims{ClientID: "client-2", RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"https://api.example.com"}}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.issuedTo, tc.claims.IssuedTo("client-1", allowed))
+		})
+	}
+	assert.False(t, (&TokenClaims{}).IssuedTo("", nil))
+}
+
 func TestConfigDefaults(t *testing.T) {
 	ctx := context.Background()
 
@@ -0,0 +1,288 @@
+package coordination
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AgentProtocolVersion is the version of the agent protocol this server speaks. Agents that
+// do not report one speak version 1.
+const AgentProtocolVersion = 1
+
+// AgentUpdateConfig selects the agent release nodes should run and the protocol versions
+// the server accepts
+type AgentUpdateConfig struct {
+	Version            string            `yaml:"version,omitempty"`              // desired agent version; empty disables updates
+	BinaryDir          string            `yaml:"binary_dir,omitempty"`           // holds nexus-agent-<os>-<arch> binaries the server hands out
+	URL                string            `yaml:"url,omitempty"`                  // download URL instead of BinaryDir; {version}, {os} and {arch} are replaced
+	Checksums          map[string]string `yaml:"checksums,omitempty"`            // "<os>/<arch>" -> SHA-256 of the binary at URL
+	Signatures         map[string]string `yaml:"signatures,omitempty"`           // "<os>/<arch>" -> base64 ed25519 signature of the SHA-256
+	MinProtocolVersion int               `yaml:"min_protocol_version,omitempty"` // oldest agent protocol accepted, default 1
+}
+
+// AgentRelease is the agent build a node should run. URL is empty when the binary is
+// downloaded from the server itself.
+type AgentRelease struct {
+	Version            string `json:"version,omitempty"`
+	OS                 string `json:"os"`
+	Arch               string `json:"arch"`
+	URL                string `json:"url,omitempty"`
+	SHA256             string `json:"sha256,omitempty"`
+	Signature          string `json:"signature,omitempty"`
+	ProtocolVersion    int    `json:"protocol_version"`
+	MinProtocolVersion int    `json:"min_protocol_version"`
+}
+
+// agentBinaryName returns the file name of the agent binary for a platform in BinaryDir
+func agentBinaryName(goos, goarch string) string {
+	name := fmt.Sprintf("nexus-agent-%s-%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// validPlatform reports whether s can be an OS or architecture name
+func validPlatform(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// minProtocolVersion returns the oldest agent protocol the server accepts
+func (s *Server) minProtocolVersion() int {
+	if v := s.config.Agents.MinProtocolVersion; v > 0 {
+		return v
+	}
+	return 1
+}
+
+// checkAgentProtocol writes a 426 response and returns false when an agent speaking
+// protocol cannot work with this server. The response carries the release to update to.
+func (s *Server) checkAgentProtocol(w http.ResponseWriter, protocol int, goos, goarch string) bool {
+	if protocol == 0 {
+		protocol = 1
+	}
+	if protocol >= s.minProtocolVersion() && protocol <= AgentProtocolVersion {
+		return true
+	}
+
+	details := map[string]interface{}{
+		"protocol_version":     protocol,
+		"min_protocol_version": s.minProtocolVersion(),
+		"max_protocol_version": AgentProtocolVersion,
+	}
+	if release, err := s.agentRelease(goos, goarch); err == nil && release.Version != "" {
+		details["release"] = release
+	}
+	sendM4JSONError(w, http.StatusUpgradeRequired, "agent_protocol_unsupported",
+		fmt.Sprintf("Agent protocol %d is not supported; this server accepts %d to %d", protocol, s.minProtocolVersion(), AgentProtocolVersion), details)
+	return false
+}
+
+// nodeProtocolVersion reads the protocol version a node reported in its metadata
+func nodeProtocolVersion(node *Node) int {
+	switch v := node.Metadata["protocol_version"].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
+
+// nodeMetadataString returns a string from a node's metadata
+func nodeMetadataString(node *Node, key string) string {
+	s, _ := node.Metadata[key].(string)
+	return s
+}
+
+// agentRelease returns the release nodes on goos/goarch should run. Version is empty when
+// no release is configured.
+func (s *Server) agentRelease(goos, goarch string) (*AgentRelease, error) {
+	cfg := s.config.Agents
+	release := &AgentRelease{
+		OS:                 goos,
+		Arch:               goarch,
+		ProtocolVersion:    AgentProtocolVersion,
+		MinProtocolVersion: s.minProtocolVersion(),
+	}
+	if cfg.Version == "" || !validPlatform(goos) || !validPlatform(goarch) {
+		return release, nil
+	}
+
+	platform := goos + "/" + goarch
+	release.Version = cfg.Version
+	release.Signature = cfg.Signatures[platform]
+
+	if cfg.URL != "" {
+		release.SHA256 = cfg.Checksums[platform]
+		if release.SHA256 == "" {
+			return nil, fmt.Errorf("no checksum configured for %s", platform)
+		}
+		release.URL = strings.NewReplacer("{version}", cfg.Version, "{os}", goos, "{arch}", goarch).Replace(cfg.URL)
+		return release, nil
+	}
+
+	if cfg.BinaryDir == "" {
+		return nil, fmt.Errorf("agent updates need binary_dir or url")
+	}
+	sum, err := s.agentChecksums.sum(filepath.Join(cfg.BinaryDir, agentBinaryName(goos, goarch)))
+	if err != nil {
+		return nil, err
+	}
+	release.SHA256 = sum
+	return release, nil
+}
+
+// pendingAgentUpdate returns the release a node running version should update to, or nil
+// when it is current or no release is available for its platform
+func (s *Server) pendingAgentUpdate(version, goos, goarch string) *AgentRelease {
+	if s.config.Agents.Version == "" || version == s.config.Agents.Version {
+		return nil
+	}
+	release, err := s.agentRelease(goos, goarch)
+	if err != nil || release.Version == "" {
+		return nil
+	}
+	return release
+}
+
+// checksumCache remembers the SHA-256 of files until they change
+type checksumCache struct {
+	mu   sync.Mutex
+	sums map[string]cachedChecksum
+}
+
+type cachedChecksum struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// sum returns the hex SHA-256 of the file at path
+func (c *checksumCache) sum(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("agent binary not available: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.sums[path]; ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("agent binary not available: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash agent binary: %w", err)
+	}
+
+	if c.sums == nil {
+		c.sums = make(map[string]cachedChecksum)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	c.sums[path] = cachedChecksum{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	return sum, nil
+}
+
+// authorizeAgent lets node agents through and requires nodes:read from everyone else
+func (s *Server) authorizeAgent(w http.ResponseWriter, r *http.Request) bool {
+	if p := s.caller(r); p != nil && p.Kind == PrincipalNode {
+		return true
+	}
+	return s.authorize(w, r, ActionNodesRead)
+}
+
+// handleAgentRelease returns the agent release a node should run
+// GET /api/v1/agent/release?os=linux&arch=amd64
+func (s *Server) handleAgentRelease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAgent(w, r) {
+		return
+	}
+
+	goos, goarch := r.URL.Query().Get("os"), r.URL.Query().Get("arch")
+	if !validPlatform(goos) || !validPlatform(goarch) {
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_platform", "os and arch are required", nil)
+		return
+	}
+
+	release, err := s.agentRelease(goos, goarch)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "release_unavailable", err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(release)
+}
+
+// handleAgentBinary serves an agent binary from BinaryDir
+// GET /api/v1/agent/binary/{os}/{arch}
+func (s *Server) handleAgentBinary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeAgent(w, r) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/api/v1/agent/binary/"), "/")
+	if len(parts) != 2 {
+		http.Error(w, "Invalid endpoint", http.StatusNotFound)
+		return
+	}
+	if !validPlatform(parts[0]) || !validPlatform(parts[1]) {
+		http.Error(w, "Expected /api/v1/agent/binary/{os}/{arch}", http.StatusBadRequest)
+		return
+	}
+	if s.config.Agents.BinaryDir == "" {
+		sendM4JSONError(w, http.StatusNotFound, "release_unavailable", "The server does not host agent binaries", nil)
+		return
+	}
+
+	path := filepath.Join(s.config.Agents.BinaryDir, agentBinaryName(parts[0], parts[1]))
+	f, err := os.Open(path)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "release_unavailable", fmt.Sprintf("No agent binary for %s/%s", parts[0], parts[1]), nil)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read agent binary: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,119 @@
+package coordination
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentReleaseFromBinaryDir(t *testing.T) {
+	dir := t.TempDir()
+	binary := []byte("agent 2.0.0")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nexus-agent-linux-amd64"), binary, 0755))
+	sum := sha256.Sum256(binary)
+
+	srv := newTestServer(t, &Config{})
+	srv.config.Agents = AgentUpdateConfig{
+		Version:    "2.0.0",
+		BinaryDir:  dir,
+		Signatures: map[string]string{"linux/amd64": "c2lnbmF0dXJl"},
+	}
+
+	w := rbacRequest(t, srv, "", http.MethodGet, "/api/v1/agent/release?os=linux&arch=amd64", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var release AgentRelease
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&release))
+	assert.Equal(t, AgentRelease{
+		Version:            "2.0.0",
+		OS:                 "linux",
+		Arch:               "amd64",
+		SHA256:             hex.EncodeToString(sum[:]),
+		Signature:          "c2lnbmF0dXJl",
+		ProtocolVersion:    AgentProtocolVersion,
+		MinProtocolVersion: 1,
+	}, release, "binaries hosted by the server have no URL")
+
+	w = rbacRequest(t, srv, "", http.MethodGet, "/api/v1/agent/binary/linux/amd64", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, binary, w.Body.Bytes())
+
+	w = rbacRequest(t, srv, "", http.MethodGet, "/api/v1/agent/release?os=darwin&arch=arm64", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "release_unavailable", decodeM4Error(t, w.Body.Bytes()).Error)
+
+	w = rbacRequest(t, srv, "", http.MethodGet, "/api/v1/agent/binary/..%2f..%2fetc/passwd", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = rbacRequest(t, srv, "", http.MethodGet, "/api/v1/agent/release", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAgentReleaseFromURL(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+	srv.config.Agents = AgentUpdateConfig{
+		Version:   "2.0.0",
+		URL:       "https://releases.example.com/{version}/nexus-{os}-{arch}",
+		Checksums: map[string]string{"linux/arm64": "abc123"},
+	}
+
+	release, err := srv.agentRelease("linux", "arm64")
+	require.NoError(t, err)
+	assert.Equal(t, "https://releases.example.com/2.0.0/nexus-linux-arm64", release.URL)
+	assert.Equal(t, "abc123", release.SHA256)
+
+	_, err = srv.agentRelease("linux", "amd64")
+	assert.Error(t, err, "releases without a checksum are never offered")
+
+	srv.config.Agents.Version = ""
+	release, err = srv.agentRelease("linux", "amd64")
+	require.NoError(t, err)
+	assert.Empty(t, release.Version, "no release is offered without a desired version")
+}
+
+func TestAgentVersionSkew(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nexus-agent-linux-amd64"), []byte("agent"), 0755))
+	srv := newTestServer(t, &Config{})
+	srv.config.Agents = AgentUpdateConfig{Version: "2.0.0", BinaryDir: dir}
+
+	register := func(version string, protocol int) (int, NodeRegistration, []byte) {
+		w := rbacRequest(t, srv, "", http.MethodPost, "/api/v1/nodes", map[string]interface{}{
+			"id":       "node-1",
+			"metadata": map[string]interface{}{"version": version, "protocol_version": protocol, "os": "linux", "arch": "amd64"},
+		})
+		var registration NodeRegistration
+		body := w.Body.Bytes()
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(body, &registration))
+		}
+		return w.Code, registration, body
+	}
+
+	code, registration, _ := register("1.0.0", 1)
+	require.Equal(t, http.StatusOK, code)
+	require.NotNil(t, registration.Update, "outdated agents are told what to run")
+	assert.Equal(t, "2.0.0", registration.Update.Version)
+
+	code, registration, _ = register("2.0.0", 1)
+	require.Equal(t, http.StatusOK, code)
+	assert.Nil(t, registration.Update)
+
+	code, _, body := register("3.0.0", AgentProtocolVersion+1)
+	assert.Equal(t, http.StatusUpgradeRequired, code, "agents newer than the server are refused")
+	resp := decodeM4Error(t, body)
+	assert.Equal(t, "agent_protocol_unsupported", resp.Error)
+	assert.Contains(t, resp.Details, "release", "refused agents learn which release to install")
+
+	w := rbacRequest(t, srv, "", http.MethodPost, "/api/v1/nodes/node-1/heartbeat", NodeHeartbeat{ProtocolVersion: 1})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	srv.config.Agents.MinProtocolVersion = 2
+	w = rbacRequest(t, srv, "", http.MethodPost, "/api/v1/nodes/node-1/heartbeat", NodeHeartbeat{})
+	assert.Equal(t, http.StatusUpgradeRequired, w.Code, "agents that do not report a protocol speak version 1")
+}
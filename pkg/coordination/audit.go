@@ -0,0 +1,240 @@
+package coordination
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit results
+const (
+	AuditResultSuccess = "success"
+	AuditResultDenied  = "denied"
+	AuditResultFailure = "failure"
+)
+
+const (
+	// defaultAuditLimit and maxAuditLimit bound the entries returned by GET /api/v1/audit
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+	// maxRequestIDLength caps client supplied X-Request-ID values
+	maxRequestIDLength = 128
+)
+
+// auditMethodVerbs names the action a method performs on a resource
+var auditMethodVerbs = map[string]string{
+	http.MethodPost:   "create",
+	http.MethodPut:    "update",
+	http.MethodPatch:  "update",
+	http.MethodDelete: "delete",
+}
+
+// auditCollectionActions are path segments that name an action on a collection rather than an ID
+var auditCollectionActions = map[string]string{
+	"create-from-repo": "create",
+	"register-github":  "register_github",
+	"import":           "import",
+	"export":           "export",
+}
+
+// isMutating reports whether a request method can change server state
+func isMutating(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+// shouldAudit reports whether a request belongs in the audit trail. Heartbeats and activity
+// reports are signals sent continuously by nodes and tools, not changes anyone makes.
+func shouldAudit(r *http.Request) bool {
+	return isMutating(r.Method) && !strings.HasSuffix(r.URL.Path, "/heartbeat") && !strings.HasSuffix(r.URL.Path, "/activity")
+}
+
+// auditAction derives the action and resource of a request from its method and path,
+// e.g. DELETE /api/v1/workspaces/ws-1 is workspaces.delete on workspaces/ws-1 and
+// POST /api/v1/workspaces/ws-1/stop is workspaces.stop on workspaces/ws-1
+func auditAction(method, path string) (action, resource string) {
+	verb := auditMethodVerbs[method]
+	if verb == "" {
+		verb = strings.ToLower(method)
+	}
+
+	rest, ok := strings.CutPrefix(path, "/api/v1/")
+	if !ok {
+		return verb, path
+	}
+	segments := strings.Split(strings.Trim(rest, "/"), "/")
+	kind := segments[0]
+
+	switch {
+	case len(segments) == 1:
+		return kind + "." + verb, kind
+	case len(segments) == 2 && auditCollectionActions[segments[1]] != "":
+		return kind + "." + auditCollectionActions[segments[1]], kind
+	case len(segments) == 2:
+		return kind + "." + verb, kind + "/" + segments[1]
+	}
+
+	resource = kind + "/" + segments[1]
+	if method == http.MethodPost {
+		return kind + "." + segments[2], resource
+	}
+	return kind + "." + segments[2] + "." + verb, resource
+}
+
+// auditResult classifies a response status
+func auditResult(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return AuditResultDenied
+	case status >= 400:
+		return AuditResultFailure
+	default:
+		return AuditResultSuccess
+	}
+}
+
+// requestIDFor returns the request ID supplied by the client or a new one
+func requestIDFor(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= maxRequestIDLength {
+		return id
+	}
+	return generateRequestID()
+}
+
+// sourceIP returns the address of the peer that sent r
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestActor carries the principal the auth middleware authenticated back out to the
+// logging middleware, which wraps auth so that rejected requests are audited too
+type requestActor struct {
+	principal *Principal
+}
+
+type requestActorKey struct{}
+
+// setRequestActor records the authenticated principal of r for the audit trail
+func setRequestActor(r *http.Request, principal *Principal) {
+	if actor, ok := r.Context().Value(requestActorKey{}).(*requestActor); ok {
+		actor.principal = principal
+	}
+}
+
+// recordAudit appends a mutating request to the audit trail; principal is nil for requests
+// rejected before authenticating. Failures are logged rather than surfaced, since the
+// request has already been served.
+func (s *Server) recordAudit(r *http.Request, principal *Principal, requestID string, status int, duration time.Duration) {
+	store, ok := s.registry.(AuditStore)
+	if !ok {
+		return
+	}
+
+	action, resource := auditAction(r.Method, r.URL.Path)
+	entry := &AuditEntry{
+		Timestamp:  time.Now(),
+		Actor:      "anonymous",
+		ActorKind:  "anonymous",
+		Action:     action,
+		Resource:   resource,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RequestID:  requestID,
+		SourceIP:   sourceIP(r),
+		Status:     status,
+		Result:     auditResult(status),
+		DurationMs: duration.Milliseconds(),
+	}
+	if principal != nil {
+		entry.Actor = principal.Username
+		entry.ActorKind = principal.Kind
+	}
+
+	if err := store.AppendAudit(entry); err != nil {
+		log.Printf("Failed to record audit entry for %s %s: %v", r.Method, r.URL.Path, err)
+	}
+}
+
+// parseAuditTime accepts an RFC 3339 timestamp or a duration counted back from now
+func parseAuditTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or a duration such as 24h", value)
+}
+
+// AuditListResponse is the body of GET /api/v1/audit
+type AuditListResponse struct {
+	Entries []*AuditEntry `json:"entries"`
+	Count   int           `json:"count"`
+}
+
+// handleListAudit serves GET /api/v1/audit, filtered by actor, action, resource, result,
+// since, until and limit query parameters
+func (s *Server) handleListAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorize(w, r, ActionAdmin) {
+		return
+	}
+
+	store, ok := s.registry.(AuditStore)
+	if !ok {
+		sendM4JSONError(w, http.StatusNotImplemented, "not_supported", "Audit log is not supported by this registry", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Actor:    query.Get("actor"),
+		Action:   query.Get("action"),
+		Resource: query.Get("resource"),
+		Result:   query.Get("result"),
+		Limit:    defaultAuditLimit,
+	}
+
+	now := time.Now()
+	for param, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := parseAuditTime(value, now)
+		if err != nil {
+			sendM4JSONError(w, http.StatusBadRequest, "invalid_"+param, err.Error(), nil)
+			return
+		}
+		*target = t
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			sendM4JSONError(w, http.StatusBadRequest, "invalid_limit", fmt.Sprintf("Invalid limit: %s", value), nil)
+			return
+		}
+		filter.Limit = min(limit, maxAuditLimit)
+	}
+
+	entries, err := store.ListAudit(filter)
+	if err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "list_failed", fmt.Sprintf("Failed to list audit entries: %v", err), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuditListResponse{Entries: entries, Count: len(entries)})
+}
@@ -0,0 +1,141 @@
+package coordination
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditAction(t *testing.T) {
+	for _, tc := range []struct {
+		method, path, action, resource string
+	}{
+		{http.MethodPost, "/api/v1/nodes", "nodes.create", "nodes"},
+		{http.MethodPut, "/api/v1/nodes/node-1", "nodes.update", "nodes/node-1"},
+		{http.MethodDelete, "/api/v1/workspaces/ws-1", "workspaces.delete", "workspaces/ws-1"},
+		{http.MethodPost, "/api/v1/workspaces/ws-1/stop", "workspaces.stop", "workspaces/ws-1"},
+		{http.MethodDelete, "/api/v1/workspaces/ws-1/grants/bob", "workspaces.grants.delete", "workspaces/ws-1"},
+		{http.MethodPost, "/api/v1/workspaces/create-from-repo", "workspaces.create", "workspaces"},
+		{http.MethodPost, "/api/v1/nodes/node-1/commands/run", "nodes.commands", "nodes/node-1"},
+		{http.MethodPut, "/api/v1/users/bob/role", "users.role.update", "users/bob"},
+		{http.MethodPost, "/api/v1/admin/import", "admin.import", "admin"},
+		{http.MethodPost, "/auth/github/callback", "create", "/auth/github/callback"},
+	} {
+		action, resource := auditAction(tc.method, tc.path)
+		assert.Equal(t, tc.action, action, tc.path)
+		assert.Equal(t, tc.resource, resource, tc.path)
+	}
+}
+
+// auditRequest sends a request through the middleware of the running server
+func auditRequest(t *testing.T, srv *Server, token, method, path string, body interface{}) *httptest.ResponseRecorder {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Request-ID", "req-"+method+path)
+	req.RemoteAddr = "192.0.2.10:51234"
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, req)
+	return w
+}
+
+func TestAuditLog(t *testing.T) {
+	srv := newRBACTestServer(t)
+
+	w := auditRequest(t, srv, "alice-token", http.MethodDelete, "/api/v1/workspaces/ws-2", nil)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	var errResp M4ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+	assert.Equal(t, "req-DELETE/api/v1/workspaces/ws-2", errResp.RequestID, "errors carry the audited request ID")
+
+	w = auditRequest(t, srv, rbacAdminToken, http.MethodPost, "/api/v1/nodes", map[string]interface{}{"id": "node-1"})
+	require.Equal(t, http.StatusOK, w.Code)
+	w = auditRequest(t, srv, rbacAdminToken, http.MethodPost, "/api/v1/nodes/node-1/heartbeat", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = auditRequest(t, srv, "alice-token", http.MethodGet, "/api/v1/workspaces", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	entries, err := srv.registry.(AuditStore).ListAudit(AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "reads and heartbeats are not audited")
+
+	assert.Equal(t, "admin", entries[0].Actor)
+	assert.Equal(t, PrincipalService, entries[0].ActorKind)
+	assert.Equal(t, "nodes.create", entries[0].Action)
+	assert.Equal(t, AuditResultSuccess, entries[0].Result)
+
+	denied := entries[1]
+	assert.Equal(t, "alice", denied.Actor)
+	assert.Equal(t, "workspaces.delete", denied.Action)
+	assert.Equal(t, "workspaces/ws-2", denied.Resource)
+	assert.Equal(t, "req-DELETE/api/v1/workspaces/ws-2", denied.RequestID)
+	assert.Equal(t, "192.0.2.10", denied.SourceIP)
+	assert.Equal(t, http.StatusForbidden, denied.Status)
+	assert.Equal(t, AuditResultDenied, denied.Result)
+}
+
+func TestAuditLogRecordsRejections(t *testing.T) {
+	srv := newRBACTestServer(t)
+	srv.limits = newRequestLimits(LimitsConfig{PerIP: RateLimitConfig{RequestsPerSecond: 0.001, Burst: 2}})
+
+	w := auditRequest(t, srv, "forged-token", http.MethodDelete, "/api/v1/workspaces/ws-1", nil)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	w = auditRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-1/stop", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = auditRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-1/start", nil)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	entries, err := srv.registry.(AuditStore).ListAudit(AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	byAction := make(map[string]*AuditEntry)
+	for _, entry := range entries {
+		byAction[entry.Action] = entry
+	}
+
+	unauthenticated := byAction["workspaces.delete"]
+	require.NotNil(t, unauthenticated, "requests rejected by the auth middleware are audited")
+	assert.Equal(t, "anonymous", unauthenticated.Actor)
+	assert.Equal(t, http.StatusUnauthorized, unauthenticated.Status)
+	assert.Equal(t, AuditResultDenied, unauthenticated.Result)
+	assert.Equal(t, "req-DELETE/api/v1/workspaces/ws-1", unauthenticated.RequestID)
+
+	assert.Equal(t, "alice", byAction["workspaces.stop"].Actor, "the authenticated principal reaches the audit trail")
+
+	limited := byAction["workspaces.start"]
+	require.NotNil(t, limited, "requests rejected by the rate limiter are audited")
+	assert.Equal(t, http.StatusTooManyRequests, limited.Status)
+	assert.Equal(t, AuditResultFailure, limited.Result)
+}
+
+func TestListAuditEndpoint(t *testing.T) {
+	srv := newRBACTestServer(t)
+	auditRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-1/stop", nil)
+	auditRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/ws-2/stop", nil)
+
+	w := rbacRequest(t, srv, "alice-token", http.MethodGet, "/api/v1/audit", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only admins read the audit log")
+
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodGet, "/api/v1/audit?actor=bob&since=1h", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Entries []*AuditEntry `json:"entries"`
+		Count   int           `json:"count"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(t, 1, resp.Count)
+	assert.Equal(t, "workspaces/ws-2", resp.Entries[0].Resource)
+
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodGet, "/api/v1/audit?since=yesterday", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodGet, "/api/v1/audit?limit=0", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
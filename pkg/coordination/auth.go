@@ -0,0 +1,290 @@
+package coordination
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nexus/nexus/pkg/auth"
+)
+
+// Principal kinds
+const (
+	// PrincipalUser is a person authenticated with an OIDC ID or access token, or a personal API token
+	PrincipalUser = "user"
+	// PrincipalService is the holder of the static server token: administrators and node agents
+	PrincipalService = "service"
+	// PrincipalNode is a node agent authenticated with its own node credential
+	PrincipalNode = "node"
+	// PrincipalEnrollment is a new agent holding a one-time enrollment token
+	PrincipalEnrollment = "enrollment"
+)
+
+const (
+	// principalCacheTTL bounds how long a verified token is trusted without asking the issuer again
+	principalCacheTTL = 5 * time.Minute
+	// rejectedTokenTTL is how long a rejected token is refused without asking the issuer again
+	rejectedTokenTTL = time.Minute
+	// maxRejectedTokens bounds the memory spent remembering rejected tokens
+	maxRejectedTokens = 10000
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Kind     string `json:"kind"`
+	Subject  string `json:"subject"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	UserID   string `json:"user_id,omitempty"`
+	// Scopes limit a principal authenticated with an API token; nil means unrestricted
+	Scopes  []string `json:"scopes,omitempty"`
+	TokenID string   `json:"token_id,omitempty"`
+	// NodeID binds node and enrollment principals to a single node
+	NodeID string `json:"node_id,omitempty"`
+}
+
+// IsService reports whether the principal authenticated with the static server token
+func (p *Principal) IsService() bool {
+	return p != nil && p.Kind == PrincipalService
+}
+
+type principalContextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored by the auth middleware, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// tokenVerifier is the subset of auth.OIDCProvider the server needs to authenticate users
+type tokenVerifier interface {
+	VerifyIDToken(ctx context.Context, rawToken string) (*auth.TokenClaims, error)
+	UserInfo(ctx context.Context, accessToken string) (*auth.TokenClaims, error)
+}
+
+type cachedPrincipal struct {
+	principal *Principal
+	expires   time.Time
+}
+
+// principalCache remembers verified and rejected tokens by their SHA-256 digest,
+// so neither a signed-in user nor a client retrying a bad token hits the issuer on every request
+type principalCache struct {
+	entries  map[string]cachedPrincipal
+	rejected map[string]time.Time
+	mu       sync.Mutex
+}
+
+func newPrincipalCache() *principalCache {
+	return &principalCache{
+		entries:  make(map[string]cachedPrincipal),
+		rejected: make(map[string]time.Time),
+	}
+}
+
+func tokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *principalCache) get(token string, now time.Time) (*Principal, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := tokenDigest(token)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.principal, true
+}
+
+func (c *principalCache) put(token string, principal *Principal, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[tokenDigest(token)] = cachedPrincipal{principal: principal, expires: expires}
+}
+
+// isRejected reports whether the token was rejected within the last rejectedTokenTTL
+func (c *principalCache) isRejected(token string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := tokenDigest(token)
+	expires, ok := c.rejected[key]
+	if !ok {
+		return false
+	}
+	if now.After(expires) {
+		delete(c.rejected, key)
+		return false
+	}
+	return true
+}
+
+// reject remembers a token the issuer refused
+func (c *principalCache) reject(token string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.rejected) >= maxRejectedTokens {
+		for key, expires := range c.rejected {
+			if now.After(expires) {
+				delete(c.rejected, key)
+			}
+		}
+	}
+	// Still full: evict arbitrary entries rather than grow without bound
+	for key := range c.rejected {
+		if len(c.rejected) < maxRejectedTokens {
+			break
+		}
+		delete(c.rejected, key)
+	}
+	c.rejected[tokenDigest(token)] = now.Add(rejectedTokenTTL)
+}
+
+// initializeOIDC connects to the configured OIDC issuer
+func (s *Server) initializeOIDC() error {
+	if !s.config.Auth.Enabled || s.config.Auth.OIDC == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	provider, err := auth.NewOIDCProvider(ctx, s.config.Auth.OIDC)
+	if err != nil {
+		return fmt.Errorf("failed to initialize OIDC provider: %w", err)
+	}
+
+	s.oidc = provider
+	return nil
+}
+
+// authenticate resolves a bearer token to a principal. The static server token
+// identifies a service caller; anything else must be a token issued by the OIDC provider.
+func (s *Server) authenticate(ctx context.Context, token string) (*Principal, error) {
+	if staticToken := s.config.Server.AuthToken; staticToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(staticToken)) == 1 {
+		return &Principal{Kind: PrincipalService, Subject: "server-token", Username: "admin"}, nil
+	}
+
+	switch {
+	case strings.HasPrefix(token, APITokenPrefix):
+		return s.authenticateAPIToken(token)
+	case strings.HasPrefix(token, NodeCredentialPrefix):
+		return s.authenticateNodeCredential(token)
+	case strings.HasPrefix(token, EnrollmentTokenPrefix):
+		return s.authenticateEnrollmentToken(token)
+	}
+
+	if s.oidc == nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	now := time.Now()
+	if principal, ok := s.principals.get(token, now); ok {
+		return principal, nil
+	}
+	if s.principals.isRejected(token, now) {
+		return nil, fmt.Errorf("token rejected by identity provider")
+	}
+
+	// ID tokens are verified locally against the issuer's keys; access tokens are
+	// also checked with the issuer through the userinfo endpoint.
+	claims, err := s.oidc.VerifyIDToken(ctx, token)
+	if err != nil {
+		claims, err = s.oidc.UserInfo(ctx, token)
+		if err != nil {
+			s.principals.reject(token, now)
+			return nil, fmt.Errorf("token rejected by identity provider: %w", err)
+		}
+	}
+
+	principal, err := s.principalForClaims(claims)
+	if err != nil {
+		s.principals.reject(token, now)
+		return nil, err
+	}
+
+	expires := now.Add(principalCacheTTL)
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(expires) {
+		expires = claims.ExpiresAt.Time
+	}
+
+	s.principals.put(token, principal, expires)
+	return principal, nil
+}
+
+// principalForClaims maps verified token claims to a principal backed by a registered user.
+// Users are identified by issuer and subject; the username is only a display name.
+func (s *Server) principalForClaims(claims *auth.TokenClaims) (*Principal, error) {
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("token carries no subject")
+	}
+	if oidcConfig := s.config.Auth.OIDC; oidcConfig != nil && !claims.IssuedTo(oidcConfig.ClientID, oidcConfig.AllowedAudiences) {
+		return nil, fmt.Errorf("token was not issued to client %s", oidcConfig.ClientID)
+	}
+
+	user, err := s.registry.GetUserRegistry().GetBySubject(claims.Issuer, claims.Subject)
+	if err != nil {
+		if user, err = s.registerSubject(claims); err != nil {
+			return nil, err
+		}
+	}
+
+	principal := &Principal{
+		Kind:     PrincipalUser,
+		Subject:  claims.Subject,
+		Username: user.Username,
+		UserID:   user.ID,
+	}
+	if claims.EmailVerified {
+		principal.Email = claims.Email
+	}
+	return principal, nil
+}
+
+// registerSubject registers the user behind a subject signing in for the first time
+func (s *Server) registerSubject(claims *auth.TokenClaims) (*User, error) {
+	userRegistry := s.registry.GetUserRegistry()
+	username := claims.PreferredName()
+	user := &User{ID: claims.Subject, Issuer: claims.Issuer, Username: username}
+
+	var registerErr error
+	if _, err := userRegistry.GetByUsername(username); err == nil {
+		registerErr = fmt.Errorf("username %s belongs to another user", username)
+	} else if registerErr = userRegistry.Register(user); registerErr == nil {
+		log.Printf("Registered user %s from OIDC subject %s", username, claims.Subject)
+		return user, nil
+	}
+
+	// Another request may have registered the subject concurrently
+	if existing, err := userRegistry.GetBySubject(claims.Issuer, claims.Subject); err == nil {
+		return existing, nil
+	}
+	return nil, fmt.Errorf("failed to register user %s: %w", username, registerErr)
+}
@@ -0,0 +1,257 @@
+package coordination
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nexus/nexus/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVerifier accepts fixed ID and access tokens and counts calls to the issuer
+type fakeVerifier struct {
+	idTokens     map[string]*auth.TokenClaims
+	accessTokens map[string]*auth.TokenClaims
+	calls        int
+}
+
+func (f *fakeVerifier) VerifyIDToken(ctx context.Context, rawToken string) (*auth.TokenClaims, error) {
+	f.calls++
+	if claims, ok := f.idTokens[rawToken]; ok {
+		return claims, nil
+	}
+	return nil, fmt.Errorf("failed to verify ID token: malformed jwt")
+}
+
+func (f *fakeVerifier) UserInfo(ctx context.Context, accessToken string) (*auth.TokenClaims, error) {
+	f.calls++
+	if claims, ok := f.accessTokens[accessToken]; ok {
+		return claims, nil
+	}
+	return nil, fmt.Errorf("failed to get user info: 401 Unauthorized")
+}
+
+const testIssuer = "https://issuer.example.com"
+
+// testClaims returns the claims of an ID token the test issuer issued to the server's client
+func testClaims(subject, username string) *auth.TokenClaims {
+	return &auth.TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{"nexus"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Username: username,
+	}
+}
+
+func newAuthTestServer(t *testing.T) (*Server, *fakeVerifier) {
+	srv := newTestServer(t, &Config{})
+	srv.config.Auth.Enabled = true
+	srv.config.Auth.JWTSecret = "jwt-secret-must-not-authenticate"
+	srv.config.Server.AuthToken = "static-agent-token-0123456789"
+	srv.config.Auth.OIDC = &auth.Config{Issuer: testIssuer, ClientID: "nexus"}
+
+	verifier := &fakeVerifier{
+		idTokens: map[string]*auth.TokenClaims{
+			"id-token": testClaims("sub-alice", "alice"),
+		},
+		accessTokens: map[string]*auth.TokenClaims{
+			"access-token": {
+				RegisteredClaims: jwt.RegisteredClaims{Issuer: testIssuer, Subject: "sub-bob"},
+				ClientID:         "nexus",
+				Email:            "bob@example.com",
+				EmailVerified:    true,
+			},
+		},
+	}
+	verifier.idTokens["id-token"].Email = "alice@example.com"
+	verifier.idTokens["id-token"].EmailVerified = true
+	srv.oidc = verifier
+	return srv, verifier
+}
+
+func authRequest(t *testing.T, srv *Server, token string) (*httptest.ResponseRecorder, *Principal) {
+	var principal *Principal
+	handler := srv.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/nodes", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w, principal
+}
+
+func TestAuthMiddlewareStaticToken(t *testing.T) {
+	srv, verifier := newAuthTestServer(t)
+
+	w, principal := authRequest(t, srv, "static-agent-token-0123456789")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, principal)
+	assert.True(t, principal.IsService())
+	assert.Zero(t, verifier.calls, "the static token must not be sent to the identity provider")
+
+	w, _ = authRequest(t, srv, "jwt-secret-must-not-authenticate")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "the JWT signing secret is not a credential")
+
+	w, _ = authRequest(t, srv, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthMiddlewareOIDCTokens(t *testing.T) {
+	srv, verifier := newAuthTestServer(t)
+
+	w, principal := authRequest(t, srv, "id-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotNil(t, principal)
+	assert.Equal(t, PrincipalUser, principal.Kind)
+	assert.Equal(t, "alice", principal.Username)
+	assert.Equal(t, "sub-alice", principal.Subject)
+
+	user, err := srv.registry.GetUserRegistry().GetByUsername("alice")
+	require.NoError(t, err, "first sign-in registers the user")
+	assert.Equal(t, "sub-alice", user.ID)
+	assert.Equal(t, testIssuer, user.Issuer)
+	assert.Equal(t, user.ID, principal.UserID)
+	assert.Equal(t, "alice@example.com", principal.Email)
+
+	// Access tokens are validated by the issuer and fall back to the email as username
+	w, principal = authRequest(t, srv, "access-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "bob@example.com", principal.Username)
+
+	w, _ = authRequest(t, srv, "forged-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "invalid_token")
+
+	// Verified tokens are served from the cache
+	calls := verifier.calls
+	w, principal = authRequest(t, srv, "id-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "alice", principal.Username)
+	assert.Equal(t, calls, verifier.calls)
+}
+
+func TestAuthMiddlewareKeysUsersOnSubject(t *testing.T) {
+	srv, verifier := newAuthTestServer(t)
+	verifier.idTokens["alice-renamed"] = testClaims("sub-alice", "alice-smith")
+	verifier.idTokens["impostor"] = testClaims("sub-mallory", "alice")
+	verifier.idTokens["unverified-email"] = testClaims("sub-eve", "")
+	verifier.idTokens["unverified-email"].Email = "alice@example.com"
+
+	w, principal := authRequest(t, srv, "id-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "sub-alice", principal.UserID)
+
+	// A changed preferred_username still signs in as the same user
+	w, principal = authRequest(t, srv, "alice-renamed")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "alice", principal.Username)
+	assert.Equal(t, "sub-alice", principal.UserID)
+
+	// Another subject claiming the same preferred_username is refused
+	w, _ = authRequest(t, srv, "impostor")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	_, err := srv.registry.GetUserRegistry().GetBySubject(testIssuer, "sub-mallory")
+	assert.Error(t, err, "the impostor is not registered")
+
+	// An unverified email is neither the username nor the principal's email
+	w, principal = authRequest(t, srv, "unverified-email")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "sub-eve", principal.Username)
+	assert.Empty(t, principal.Email)
+
+	// Users registered by an administrator are claimed by their subject
+	require.NoError(t, srv.registry.GetUserRegistry().Register(&User{ID: "sub-dave", Username: "dave", Role: RoleOperator}))
+	verifier.idTokens["dave-token"] = testClaims("sub-dave", "david")
+	w, principal = authRequest(t, srv, "dave-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "dave", principal.Username)
+}
+
+func TestAuthMiddlewareChecksAudience(t *testing.T) {
+	srv, verifier := newAuthTestServer(t)
+	verifier.idTokens["other-client"] = testClaims("sub-alice", "alice")
+	verifier.idTokens["other-client"].Audience = jwt.ClaimStrings{"other-app"}
+	verifier.idTokens["other-party"] = testClaims("sub-alice", "alice")
+	verifier.idTokens["other-party"].AuthorizedParty = "other-app"
+	verifier.accessTokens["other-access"] = &auth.TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Issuer: testIssuer, Subject: "sub-alice"},
+		ClientID:         "other-app",
+		Username:         "alice",
+	}
+
+	for _, token := range []string{"other-client", "other-party", "other-access"} {
+		w, _ := authRequest(t, srv, token)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, token)
+	}
+
+	srv.config.Auth.OIDC.AllowedAudiences = []string{"other-app"}
+	srv.principals = newPrincipalCache()
+	w, _ := authRequest(t, srv, "other-client")
+	assert.Equal(t, http.StatusOK, w.Code, "allowed audiences are accepted")
+}
+
+func TestAuthMiddlewareCachesRejectedTokens(t *testing.T) {
+	srv, verifier := newAuthTestServer(t)
+
+	w, _ := authRequest(t, srv, "forged-token")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	calls := verifier.calls
+
+	for i := 0; i < 5; i++ {
+		w, _ = authRequest(t, srv, "forged-token")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	assert.Equal(t, calls, verifier.calls, "rejected tokens are not sent to the issuer again")
+}
+
+func TestAuthMiddlewareDisabled(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+
+	w, principal := authRequest(t, srv, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, principal)
+}
+
+func TestPrincipalCacheExpiry(t *testing.T) {
+	cache := newPrincipalCache()
+	principal := &Principal{Kind: PrincipalUser, Username: "alice"}
+
+	now := time.Now()
+	cache.put("token", principal, now.Add(time.Minute))
+
+	cached, ok := cache.get("token", now)
+	require.True(t, ok)
+	assert.Equal(t, principal, cached)
+
+	_, ok = cache.get("token", now.Add(2*time.Minute))
+	assert.False(t, ok)
+
+	cache.reject("bad-token", now)
+	assert.True(t, cache.isRejected("bad-token", now))
+	assert.False(t, cache.isRejected("bad-token", now.Add(rejectedTokenTTL+time.Second)))
+	assert.False(t, cache.isRejected("token", now))
+}
+
+func TestPrincipalCacheBoundsRejectedTokens(t *testing.T) {
+	cache := newPrincipalCache()
+	now := time.Now()
+	for i := 0; i < maxRejectedTokens+10; i++ {
+		cache.reject(fmt.Sprintf("bad-token-%d", i), now)
+	}
+	assert.Len(t, cache.rejected, maxRejectedTokens)
+	assert.True(t, cache.isRejected(fmt.Sprintf("bad-token-%d", maxRejectedTokens+9), now))
+}
@@ -0,0 +1,298 @@
+package coordination
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// SnapshotVersion is the format version of exported snapshots
+const SnapshotVersion = "2.0"
+
+// Snapshot is a storage-independent export of the coordination server state
+type Snapshot struct {
+	Version             string                `json:"version"`
+	Timestamp           time.Time             `json:"timestamp"`
+	Nodes               []*Node               `json:"nodes"`
+	Users               []*User               `json:"users"`
+	Workspaces          []*DBWorkspace        `json:"workspaces"`
+	Services            []*DBService          `json:"services"`
+	WorkspaceGrants     []*WorkspaceGrant     `json:"workspace_grants"`
+	GitHubInstallations []*GitHubInstallation `json:"github_installations"`
+	GitHubForks         []*GitHubFork         `json:"github_forks"`
+
+	// Redacted is set when the GitHub installation tokens were left out
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// Redact leaves the GitHub installation tokens out of the snapshot. The installations
+// are copied, so the exported records themselves keep their tokens.
+func (s *Snapshot) Redact() {
+	installations := make([]*GitHubInstallation, len(s.GitHubInstallations))
+	for i, installation := range s.GitHubInstallations {
+		redacted := *installation
+		redacted.Token = ""
+		redacted.TokenExpiresAt = time.Time{}
+		installations[i] = &redacted
+	}
+	s.GitHubInstallations = installations
+	s.Redacted = true
+}
+
+// ImportResult counts the records written by an import
+type ImportResult struct {
+	Nodes               int `json:"nodes"`
+	Users               int `json:"users"`
+	Workspaces          int `json:"workspaces"`
+	Services            int `json:"services"`
+	WorkspaceGrants     int `json:"workspace_grants"`
+	GitHubInstallations int `json:"github_installations"`
+	GitHubForks         int `json:"github_forks"`
+	Skipped             int `json:"skipped"`
+}
+
+// ExportSnapshot collects the state held by the given registries.
+// GitHub data is included when the registry implements GitHubStore.
+func ExportSnapshot(registry Registry, workspaceRegistry WorkspaceRegistry) (*Snapshot, error) {
+	snapshot := &Snapshot{
+		Version:             SnapshotVersion,
+		Timestamp:           time.Now(),
+		Services:            make([]*DBService, 0),
+		WorkspaceGrants:     make([]*WorkspaceGrant, 0),
+		GitHubInstallations: make([]*GitHubInstallation, 0),
+		GitHubForks:         make([]*GitHubFork, 0),
+	}
+
+	nodes, err := registry.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export nodes: %w", err)
+	}
+	snapshot.Nodes = nodes
+
+	users, err := registry.GetUserRegistry().List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export users: %w", err)
+	}
+	if users == nil {
+		users = make([]*User, 0)
+	}
+	snapshot.Users = users
+
+	workspaces, err := workspaceRegistry.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export workspaces: %w", err)
+	}
+	snapshot.Workspaces = workspaces
+
+	for _, ws := range workspaces {
+		services, err := workspaceRegistry.ListServices(ws.WorkspaceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export services for workspace %s: %w", ws.WorkspaceID, err)
+		}
+		snapshot.Services = append(snapshot.Services, services...)
+
+		grants, err := workspaceRegistry.ListGrants(ws.WorkspaceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export grants for workspace %s: %w", ws.WorkspaceID, err)
+		}
+		snapshot.WorkspaceGrants = append(snapshot.WorkspaceGrants, grants...)
+	}
+
+	if store, ok := registry.(GitHubStore); ok {
+		installations, err := store.ListGitHubInstallations()
+		if err != nil {
+			return nil, fmt.Errorf("failed to export GitHub installations: %w", err)
+		}
+		snapshot.GitHubInstallations = installations
+
+		forks, err := store.ListGitHubForks()
+		if err != nil {
+			return nil, fmt.Errorf("failed to export GitHub forks: %w", err)
+		}
+		snapshot.GitHubForks = forks
+	}
+
+	return snapshot, nil
+}
+
+// ImportSnapshot writes a snapshot into the given registries. Records keep the timestamps
+// they were exported with. Existing users and workspaces are kept and counted as skipped;
+// nodes, services, grants and GitHub data are overwritten. Installations without a token,
+// as in redacted snapshots, are skipped and their users connect GitHub again.
+func ImportSnapshot(registry Registry, workspaceRegistry WorkspaceRegistry, snapshot *Snapshot) (*ImportResult, error) {
+	result := &ImportResult{}
+
+	for _, node := range snapshot.Nodes {
+		if err := registry.Register(node); err != nil {
+			return result, fmt.Errorf("failed to import node %s: %w", node.ID, err)
+		}
+		result.Nodes++
+	}
+
+	userRegistry := registry.GetUserRegistry()
+	for _, user := range snapshot.Users {
+		if _, err := userRegistry.GetByUsername(user.Username); err == nil {
+			result.Skipped++
+			continue
+		}
+		if err := userRegistry.Register(user); err != nil {
+			return result, fmt.Errorf("failed to import user %s: %w", user.Username, err)
+		}
+		result.Users++
+	}
+
+	for _, ws := range snapshot.Workspaces {
+		if _, err := workspaceRegistry.Get(ws.WorkspaceID); err == nil {
+			result.Skipped++
+			continue
+		}
+		if err := workspaceRegistry.Create(ws); err != nil {
+			return result, fmt.Errorf("failed to import workspace %s: %w", ws.WorkspaceID, err)
+		}
+		result.Workspaces++
+	}
+
+	for _, svc := range snapshot.Services {
+		if err := workspaceRegistry.SaveService(svc); err != nil {
+			return result, fmt.Errorf("failed to import service %s: %w", svc.ServiceID, err)
+		}
+		result.Services++
+	}
+
+	for _, grant := range snapshot.WorkspaceGrants {
+		if err := workspaceRegistry.SaveGrant(grant); err != nil {
+			return result, fmt.Errorf("failed to import grant for %s on %s: %w", grant.Username, grant.WorkspaceID, err)
+		}
+		result.WorkspaceGrants++
+	}
+
+	if len(snapshot.GitHubInstallations) == 0 && len(snapshot.GitHubForks) == 0 {
+		return result, nil
+	}
+
+	store, ok := registry.(GitHubStore)
+	if !ok {
+		return result, fmt.Errorf("registry does not support GitHub data")
+	}
+
+	for _, installation := range snapshot.GitHubInstallations {
+		if installation.Token == "" {
+			result.Skipped++
+			continue
+		}
+		if err := store.StoreGitHubInstallation(installation); err != nil {
+			return result, fmt.Errorf("failed to import GitHub installation for %s: %w", installation.UserID, err)
+		}
+		result.GitHubInstallations++
+	}
+
+	for _, fork := range snapshot.GitHubForks {
+		if err := store.StoreGitHubFork(fork); err != nil {
+			return result, fmt.Errorf("failed to import GitHub fork %s/%s: %w", fork.OriginalOwner, fork.OriginalRepo, err)
+		}
+		result.GitHubForks++
+	}
+
+	return result, nil
+}
+
+// BackupSQLite copies a live SQLite database to destPath using the SQLite online backup API,
+// so the server can keep serving requests while the backup runs
+func BackupSQLite(srcPath, destPath string) error {
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("backup file already exists: %s", destPath)
+	}
+
+	return copySQLite(srcPath, destPath)
+}
+
+// RestoreSQLite replaces the database at destPath with the contents of a backup file.
+// The coordination server must be stopped while restoring.
+func RestoreSQLite(backupPath, destPath string) error {
+	if _, err := os.Stat(backupPath); err != nil {
+		return fmt.Errorf("backup file not found: %s", backupPath)
+	}
+
+	db, err := OpenSQLiteDatabase("file:" + backupPath + "?mode=ro")
+	if err != nil {
+		return err
+	}
+	current, err := NewMigrator(db, migrations).CurrentVersion()
+	db.Close()
+	if err != nil {
+		return fmt.Errorf("invalid backup file: %w", err)
+	}
+	if current == 0 {
+		return fmt.Errorf("invalid backup file: no applied migrations in %s", backupPath)
+	}
+
+	return copySQLite(backupPath, destPath)
+}
+
+func copySQLite(srcPath, destPath string) error {
+	src, err := OpenSQLiteDatabase(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dest, err := OpenSQLiteDatabase(destPath)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	ctx := context.Background()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire source connection: %w", err)
+	}
+	defer srcConn.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire destination connection: %w", err)
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+			return runSQLiteBackup(destDriverConn, srcDriverConn)
+		})
+	})
+}
+
+func runSQLiteBackup(destDriverConn, srcDriverConn interface{}) error {
+	destSQLite, ok := destDriverConn.(*sqlite3.SQLiteConn)
+	if !ok {
+		return fmt.Errorf("destination is not a SQLite connection")
+	}
+	srcSQLite, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+	if !ok {
+		return fmt.Errorf("source is not a SQLite connection")
+	}
+
+	backup, err := destSQLite.Backup("main", srcSQLite, "main")
+	if err != nil {
+		return fmt.Errorf("failed to start backup: %w", err)
+	}
+
+	done, err := backup.Step(-1)
+	if err != nil {
+		backup.Finish()
+		return fmt.Errorf("failed to copy database: %w", err)
+	}
+	if !done {
+		backup.Finish()
+		return fmt.Errorf("backup did not complete")
+	}
+
+	if err := backup.Finish(); err != nil {
+		return fmt.Errorf("failed to finish backup: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package coordination
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// snapshotCreatedAt is when the seeded workspace was created, long before the test runs
+var snapshotCreatedAt = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func seedSnapshotRegistries(t *testing.T, registry Registry, workspaceRegistry WorkspaceRegistry) {
+	require.NoError(t, registry.Register(&Node{ID: "node-1", Name: "node-1", Status: "active"}))
+	require.NoError(t, registry.GetUserRegistry().Register(&User{ID: "alice", Username: "alice", PublicKey: "ssh-ed25519 AAAA"}))
+	require.NoError(t, workspaceRegistry.Create(&DBWorkspace{
+		WorkspaceID: "ws-1", UserID: "alice", WorkspaceName: "one", Status: "running",
+		CreatedAt: snapshotCreatedAt,
+	}))
+	require.NoError(t, workspaceRegistry.SaveService(&DBService{
+		ServiceID: "ws-1-web", WorkspaceID: "ws-1", ServiceName: "web",
+		Command: "npm start", Port: 3000, Status: "running",
+	}))
+	require.NoError(t, registry.GetUserRegistry().Register(&User{ID: "bob", Username: "bob"}))
+	require.NoError(t, workspaceRegistry.SaveGrant(&WorkspaceGrant{WorkspaceID: "ws-1", Username: "bob", Role: GrantViewer}))
+
+	store := registry.(GitHubStore)
+	require.NoError(t, store.StoreGitHubInstallation(&GitHubInstallation{
+		UserID: "alice", GitHubUserID: 1, GitHubUsername: "alice",
+		Token: "gho_token", TokenExpiresAt: time.Now().Add(time.Hour),
+	}))
+	require.NoError(t, store.StoreGitHubFork(&GitHubFork{
+		UserID: "alice", OriginalOwner: "oursky", OriginalRepo: "epson-eshop",
+		ForkOwner: "alice", ForkURL: "https://github.com/alice/epson-eshop.git",
+	}))
+}
+
+func TestExportImportSnapshot_MemoryToSQLite(t *testing.T) {
+	memRegistry := NewInMemoryRegistry()
+	memWorkspaces := NewInMemoryWorkspaceRegistry()
+	seedSnapshotRegistries(t, memRegistry, memWorkspaces)
+
+	snapshot, err := ExportSnapshot(memRegistry, memWorkspaces)
+	require.NoError(t, err)
+	assert.Equal(t, SnapshotVersion, snapshot.Version)
+	assert.Len(t, snapshot.Nodes, 1)
+	assert.Len(t, snapshot.Users, 2)
+	assert.Len(t, snapshot.Workspaces, 1)
+	assert.Len(t, snapshot.Services, 1)
+	assert.Len(t, snapshot.WorkspaceGrants, 1)
+	assert.Len(t, snapshot.GitHubInstallations, 1)
+	assert.Len(t, snapshot.GitHubForks, 1)
+
+	data, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+	var decoded Snapshot
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	sqliteRegistry, err := NewSQLiteRegistry(t.TempDir() + "/nexus.db")
+	require.NoError(t, err)
+	defer sqliteRegistry.Close()
+
+	result, err := ImportSnapshot(sqliteRegistry, sqliteRegistry.GetWorkspaceRegistry(), &decoded)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Users)
+	assert.Equal(t, 1, result.Workspaces)
+	assert.Equal(t, 1, result.Services)
+	assert.Equal(t, 1, result.WorkspaceGrants)
+	assert.Equal(t, 1, result.GitHubInstallations)
+	assert.Equal(t, 1, result.GitHubForks)
+
+	ws, err := sqliteRegistry.GetWorkspaceRegistry().Get("ws-1")
+	require.NoError(t, err)
+	assert.Equal(t, "running", ws.Status)
+	assert.True(t, snapshotCreatedAt.Equal(ws.CreatedAt), "imported workspaces keep their creation time, got %s", ws.CreatedAt)
+	assert.True(t, snapshotCreatedAt.Equal(ws.UpdatedAt))
+
+	fork, err := sqliteRegistry.GetGitHubFork("alice", "oursky", "epson-eshop")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", fork.ForkOwner)
+
+	result, err = ImportSnapshot(sqliteRegistry, sqliteRegistry.GetWorkspaceRegistry(), &decoded)
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Skipped)
+}
+
+func TestBackupAndRestoreSQLite(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := dir + "/nexus.db"
+	backupPath := dir + "/backup.db"
+
+	registry, err := NewSQLiteRegistry(dbPath)
+	require.NoError(t, err)
+	seedSnapshotRegistries(t, registry, registry.GetWorkspaceRegistry())
+
+	require.NoError(t, BackupSQLite(dbPath, backupPath))
+	assert.Error(t, BackupSQLite(dbPath, backupPath), "existing backup files must not be overwritten")
+
+	require.NoError(t, registry.GetWorkspaceRegistry().Delete("ws-1"))
+	require.NoError(t, registry.Close())
+
+	require.NoError(t, RestoreSQLite(backupPath, dbPath))
+
+	restored, err := NewSQLiteRegistry(dbPath)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	ws, err := restored.GetWorkspaceRegistry().Get("ws-1")
+	require.NoError(t, err)
+	assert.Equal(t, "one", ws.WorkspaceName)
+}
+
+func TestRestoreSQLite_InvalidBackup(t *testing.T) {
+	dir := t.TempDir()
+
+	assert.Error(t, RestoreSQLite(dir+"/missing.db", dir+"/nexus.db"))
+
+	notABackup := dir + "/empty.db"
+	require.NoError(t, os.WriteFile(notABackup, nil, 0644))
+	assert.Error(t, RestoreSQLite(notABackup, dir+"/nexus.db"))
+}
+
+func TestHandleAdminExportImport(t *testing.T) {
+	source := newTestServer(t, &Config{})
+	seedSnapshotRegistries(t, source.registry, source.workspaceRegistry)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/export", nil)
+	w := httptest.NewRecorder()
+	source.handleAdminExport(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "gho_token", "tokens are only exported when asked for")
+	var redacted Snapshot
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &redacted))
+	assert.True(t, redacted.Redacted)
+	require.Len(t, redacted.GitHubInstallations, 1)
+
+	target := newTestServer(t, &Config{})
+	result, err := target.ImportSnapshot(&redacted)
+	require.NoError(t, err)
+	assert.Zero(t, result.GitHubInstallations, "installations without a token are skipped")
+	target.gitHubInstallationsMu.RLock()
+	assert.Empty(t, target.gitHubInstallations)
+	target.gitHubInstallationsMu.RUnlock()
+
+	installation, err := source.registry.(GitHubStore).GetGitHubInstallation("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "gho_token", installation.Token, "redacting leaves the stored installation alone")
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/admin/export?secrets=true", nil)
+	w = httptest.NewRecorder()
+	source.handleAdminExport(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "gho_token")
+
+	target = newTestServer(t, &Config{})
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/admin/import", bytes.NewReader(w.Body.Bytes()))
+	w = httptest.NewRecorder()
+	target.handleAdminImport(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	assert.Equal(t, 1, result.Workspaces)
+
+	_, err = target.workspaceRegistry.Get("ws-1")
+	assert.NoError(t, err)
+
+	target.gitHubInstallationsMu.RLock()
+	_, hasInstallation := target.gitHubInstallations["alice"]
+	target.gitHubInstallationsMu.RUnlock()
+	assert.True(t, hasInstallation)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/admin/import", bytes.NewReader([]byte("{")))
+	w = httptest.NewRecorder()
+	target.handleAdminImport(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,44 @@
+package coordination
+
+// NodeResources is an amount of CPU, memory and disk. A zero total means the node could
+// not determine it.
+type NodeResources struct {
+	CPU      int   `json:"cpu"`
+	MemoryMB int64 `json:"memory_mb"`
+	DiskGB   int64 `json:"disk_gb"`
+}
+
+// NodeCapacity is what a node reports about its room for workspaces in each heartbeat
+type NodeCapacity struct {
+	Total            NodeResources `json:"total"`
+	Allocatable      NodeResources `json:"allocatable"` // total minus reservations and workspace requests
+	Workspaces       int           `json:"workspaces"`  // running workspaces
+	MaxWorkspaces    int           `json:"max_workspaces,omitempty"`
+	FreeSSHPorts     int           `json:"free_ssh_ports"`
+	FreeServicePorts int           `json:"free_service_ports"`
+}
+
+// Fits reports whether a workspace of the given size fits on the node. A node that has not
+// reported its capacity is assumed to have room, and dimensions it could not determine are
+// not checked.
+func (c *NodeCapacity) Fits(cpu int, memoryMB, diskGB int64) bool {
+	if c == nil {
+		return true
+	}
+	if c.FreeSSHPorts <= 0 {
+		return false
+	}
+	if c.MaxWorkspaces > 0 && c.Workspaces >= c.MaxWorkspaces {
+		return false
+	}
+	if c.Total.CPU > 0 && cpu > c.Allocatable.CPU {
+		return false
+	}
+	if c.Total.MemoryMB > 0 && memoryMB > c.Allocatable.MemoryMB {
+		return false
+	}
+	if c.Total.DiskGB > 0 && diskGB > c.Allocatable.DiskGB {
+		return false
+	}
+	return true
+}
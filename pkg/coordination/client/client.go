@@ -0,0 +1,759 @@
+// Package client is a typed Go client for the coordination server API.
+//
+// The API is described by the OpenAPI document the server publishes at
+// /api/v1/openapi.json. Each method of Client corresponds to the operation of the same
+// name in that document and exchanges the request and response types of the
+// coordination package.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nexus/nexus/pkg/coordination"
+)
+
+// Client calls the coordination server API
+type Client struct {
+	baseURL    string
+	token      func() (string, error)
+	httpClient *http.Client
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithToken authenticates every request with a fixed bearer token
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = func() (string, error) { return token, nil }
+	}
+}
+
+// WithTokenSource authenticates each request with the bearer token returned by source,
+// for callers whose token changes over time. An empty token sends no Authorization header.
+func WithTokenSource(source func() (string, error)) Option {
+	return func(c *Client) {
+		c.token = source
+	}
+}
+
+// WithHTTPClient sends requests through httpClient instead of http.DefaultClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// New returns a client for the coordination server at baseURL, e.g. http://localhost:3001
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is returned when the server answers with a status outside 2xx. Code, Message,
+// Details and RequestID are set when the body is an M4ErrorResponse.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    map[string]interface{}
+	RequestID  string
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// StatusCode returns the HTTP status of an *Error in err's chain, or 0 when there is none
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// newRequest builds an authenticated request with in encoded as its JSON body
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, in interface{}) (*http.Request, error) {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.token != nil {
+		token, err := c.token()
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	return req, nil
+}
+
+// send performs req with httpClient and returns the response when its status is 2xx
+func send(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	apiErr := &Error{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	var m4 coordination.M4ErrorResponse
+	if json.Unmarshal(body, &m4) == nil {
+		apiErr.Code, apiErr.Message, apiErr.Details, apiErr.RequestID = m4.Error, m4.Message, m4.Details, m4.RequestID
+	}
+	return nil, apiErr
+}
+
+// do sends in as the JSON body of a request and decodes the response into out. Either may be nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	return c.doWith(c.httpClient, ctx, method, path, query, in, out)
+}
+
+// doHeld is do for requests the server holds open, which are bounded by ctx alone rather
+// than the client timeout
+func (c *Client) doHeld(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+	return c.doWith(&httpClient, ctx, method, path, query, in, out)
+}
+
+func (c *Client) doWith(httpClient *http.Client, ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	req, err := c.newRequest(ctx, method, path, query, in)
+	if err != nil {
+		return err
+	}
+	resp, err := send(httpClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	// An empty body leaves out unchanged
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+func nodePath(nodeID string) string {
+	return "/api/v1/nodes/" + url.PathEscape(nodeID)
+}
+
+func userPath(username string) string {
+	return "/api/v1/users/" + url.PathEscape(username)
+}
+
+func workspacePath(workspaceID string) string {
+	return "/api/v1/workspaces/" + url.PathEscape(workspaceID)
+}
+
+// RegisterNode registers a node. When the client authenticates with an enrollment token
+// the registration carries the node's own credential.
+func (c *Client) RegisterNode(ctx context.Context, node *coordination.Node) (*coordination.NodeRegistration, error) {
+	var registration coordination.NodeRegistration
+	if err := c.do(ctx, http.MethodPost, "/api/v1/nodes", nil, node, &registration); err != nil {
+		return nil, err
+	}
+	return &registration, nil
+}
+
+// ListNodes lists the registered nodes
+func (c *Client) ListNodes(ctx context.Context) ([]*coordination.Node, error) {
+	var resp coordination.NodeListResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v1/nodes", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Nodes, nil
+}
+
+// GetNode returns a node
+func (c *Client) GetNode(ctx context.Context, nodeID string) (*coordination.Node, error) {
+	var node coordination.Node
+	if err := c.do(ctx, http.MethodGet, nodePath(nodeID), nil, nil, &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// UpdateNode changes fields of a node and returns the updated node
+func (c *Client) UpdateNode(ctx context.Context, nodeID string, updates map[string]interface{}) (*coordination.Node, error) {
+	var node coordination.Node
+	if err := c.do(ctx, http.MethodPut, nodePath(nodeID), nil, updates, &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// UnregisterNode removes a node
+func (c *Client) UnregisterNode(ctx context.Context, nodeID string) error {
+	return c.do(ctx, http.MethodDelete, nodePath(nodeID), nil, nil, nil)
+}
+
+// GetNodeStatus returns the status of a node
+func (c *Client) GetNodeStatus(ctx context.Context, nodeID string) (*coordination.NodeStatusResponse, error) {
+	var status coordination.NodeStatusResponse
+	if err := c.do(ctx, http.MethodGet, nodePath(nodeID)+"/status", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// SendHeartbeat reports that a node is alive
+func (c *Client) SendHeartbeat(ctx context.Context, nodeID string, heartbeat *coordination.NodeHeartbeat) error {
+	return c.do(ctx, http.MethodPost, nodePath(nodeID)+"/heartbeat", nil, heartbeat, nil)
+}
+
+// RotateNodeCredential exchanges the credential the client authenticates with for a new one
+func (c *Client) RotateNodeCredential(ctx context.Context, nodeID string) (*coordination.IssuedNodeCredential, error) {
+	var issued coordination.IssuedNodeCredential
+	if err := c.do(ctx, http.MethodPost, nodePath(nodeID)+"/credential", nil, nil, &issued); err != nil {
+		return nil, err
+	}
+	return &issued, nil
+}
+
+// GetNodeCredential returns the credential metadata of a node
+func (c *Client) GetNodeCredential(ctx context.Context, nodeID string) (*coordination.NodeCredential, error) {
+	var credential coordination.NodeCredential
+	if err := c.do(ctx, http.MethodGet, nodePath(nodeID)+"/credential", nil, nil, &credential); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// RevokeNodeCredential revokes the credential of a node
+func (c *Client) RevokeNodeCredential(ctx context.Context, nodeID string) error {
+	return c.do(ctx, http.MethodDelete, nodePath(nodeID)+"/credential", nil, nil, nil)
+}
+
+// ListNodeWorkspaces returns the IDs of the workspaces registered on a node
+func (c *Client) ListNodeWorkspaces(ctx context.Context, nodeID string) ([]string, error) {
+	var resp coordination.NodeWorkspaces
+	if err := c.do(ctx, http.MethodGet, nodePath(nodeID)+"/workspaces", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.WorkspaceIDs, nil
+}
+
+// SendCommand queues a command for a node and returns it as queued. FollowCommandOutput
+// waits for its result.
+func (c *Client) SendCommand(ctx context.Context, nodeID string, command *coordination.Command) (*coordination.Command, error) {
+	var queued coordination.Command
+	if err := c.do(ctx, http.MethodPost, nodePath(nodeID)+"/commands", nil, command, &queued); err != nil {
+		return nil, err
+	}
+	return &queued, nil
+}
+
+// PollNodeCommands acknowledges the commands received from the previous poll and returns
+// the commands sent to a node, waiting up to wait for one when there are none. Commands
+// that were not acknowledged are returned again.
+func (c *Client) PollNodeCommands(ctx context.Context, nodeID string, acked []string, wait time.Duration) ([]coordination.Command, error) {
+	var resp coordination.NodeCommands
+	query := url.Values{"wait": {wait.String()}}
+	if len(acked) > 0 {
+		query.Set("ack", strings.Join(acked, ","))
+	}
+	if err := c.doHeld(ctx, http.MethodGet, nodePath(nodeID)+"/commands", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Commands, nil
+}
+
+// ReportCommandOutput reports a chunk of the output of a command a node is running
+func (c *Client) ReportCommandOutput(ctx context.Context, chunk *coordination.CommandOutput) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/commands/"+url.PathEscape(chunk.CommandID)+"/output", nil, chunk, nil)
+}
+
+// FollowCommandOutput streams the output of a command, starting with the output the server
+// has buffered. The channel closes after the event carrying the result, or when ctx is
+// cancelled or the connection closes.
+func (c *Client) FollowCommandOutput(ctx context.Context, commandID string) (<-chan coordination.CommandStreamEvent, error) {
+	return stream[coordination.CommandStreamEvent](ctx, c, "/api/v1/commands/"+url.PathEscape(commandID)+"/output")
+}
+
+// GetCommandGitHubToken returns the GitHub token of the owner of the workspace a command
+// runs in. Only the node the command was sent to may fetch it.
+func (c *Client) GetCommandGitHubToken(ctx context.Context, commandID string) (*coordination.CommandGitHubToken, error) {
+	var token coordination.CommandGitHubToken
+	if err := c.do(ctx, http.MethodGet, "/api/v1/commands/"+url.PathEscape(commandID)+"/github-token", nil, nil, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ReportCommandResult reports the result of a command a node executed
+func (c *Client) ReportCommandResult(ctx context.Context, result *coordination.CommandResult) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/commands/"+url.PathEscape(result.ID)+"/result", nil, result, nil)
+}
+
+// GetAgentRelease returns the agent release nodes on goos/goarch should run. Its Version is
+// empty when the server does not manage agent versions.
+func (c *Client) GetAgentRelease(ctx context.Context, goos, goarch string) (*coordination.AgentRelease, error) {
+	var release coordination.AgentRelease
+	query := url.Values{"os": {goos}, "arch": {goarch}}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/agent/release", query, nil, &release); err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+// DownloadAgentBinary streams the agent binary the server hosts for goos/goarch. The
+// caller closes the returned reader.
+func (c *Client) DownloadAgentBinary(ctx context.Context, goos, goarch string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/v1/agent/binary/"+url.PathEscape(goos)+"/"+url.PathEscape(goarch), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Binaries can take longer than any client timeout, so the download is bounded by ctx alone
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+	resp, err := send(&httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// ListServices returns the services of every node, by node ID
+func (c *Client) ListServices(ctx context.Context) (map[string][]coordination.NodeService, error) {
+	var resp coordination.ServiceListResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v1/services", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Services, nil
+}
+
+// RegisterUser registers a user
+func (c *Client) RegisterUser(ctx context.Context, user *coordination.User) (*coordination.User, error) {
+	var registered coordination.User
+	if err := c.do(ctx, http.MethodPost, "/api/v1/users", nil, user, &registered); err != nil {
+		return nil, err
+	}
+	return &registered, nil
+}
+
+// ListUsers lists the registered users
+func (c *Client) ListUsers(ctx context.Context) ([]*coordination.User, error) {
+	var resp coordination.UserListResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v1/users", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Users, nil
+}
+
+// GetUser returns a user
+func (c *Client) GetUser(ctx context.Context, username string) (*coordination.User, error) {
+	var user coordination.User
+	if err := c.do(ctx, http.MethodGet, userPath(username), nil, nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteUser deletes a user and revokes their API tokens
+func (c *Client) DeleteUser(ctx context.Context, username string) error {
+	return c.do(ctx, http.MethodDelete, userPath(username), nil, nil, nil)
+}
+
+// SetUserRole changes the role of a user
+func (c *Client) SetUserRole(ctx context.Context, username, role string) (*coordination.User, error) {
+	var user coordination.User
+	if err := c.do(ctx, http.MethodPut, userPath(username)+"/role", nil, coordination.SetRoleRequest{Role: role}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUserUsage returns a user's workspace usage against every quota that applies to them
+func (c *Client) GetUserUsage(ctx context.Context, username string) (*coordination.QuotaUsageResponse, error) {
+	var usage coordination.QuotaUsageResponse
+	if err := c.do(ctx, http.MethodGet, userPath(username)+"/usage", nil, nil, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// RegisterGitHubUser registers a user by GitHub identity and SSH key
+func (c *Client) RegisterGitHubUser(ctx context.Context, req *coordination.M4RegisterGitHubUserRequest) (*coordination.M4RegisterGitHubUserResponse, error) {
+	var resp coordination.M4RegisterGitHubUserResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/users/register-github", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateWorkspace creates a workspace from a repository. Creation continues in the
+// background; poll GetWorkspaceStatus until the workspace is running.
+func (c *Client) CreateWorkspace(ctx context.Context, req *coordination.M4CreateWorkspaceRequest) (*coordination.M4CreateWorkspaceResponse, error) {
+	var resp coordination.M4CreateWorkspaceResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/workspaces/create-from-repo", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListWorkspaces lists the workspaces visible to the caller. Zero limit and offset take
+// the server defaults.
+func (c *Client) ListWorkspaces(ctx context.Context, limit, offset int) (*coordination.M4ListWorkspacesResponse, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+
+	var resp coordination.M4ListWorkspacesResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v1/workspaces", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetWorkspaceStatus returns the status of a workspace
+func (c *Client) GetWorkspaceStatus(ctx context.Context, workspaceID string) (*coordination.M4WorkspaceStatusResponse, error) {
+	var resp coordination.M4WorkspaceStatusResponse
+	if err := c.do(ctx, http.MethodGet, workspacePath(workspaceID)+"/status", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// StopWorkspace stops a workspace
+func (c *Client) StopWorkspace(ctx context.Context, workspaceID string) (*coordination.M4StopWorkspaceResponse, error) {
+	var resp coordination.M4StopWorkspaceResponse
+	if err := c.do(ctx, http.MethodPost, workspacePath(workspaceID)+"/stop", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// StartWorkspace starts a stopped workspace
+func (c *Client) StartWorkspace(ctx context.Context, workspaceID string) (*coordination.M4StartWorkspaceResponse, error) {
+	var resp coordination.M4StartWorkspaceResponse
+	if err := c.do(ctx, http.MethodPost, workspacePath(workspaceID)+"/start", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteWorkspace deletes a workspace
+func (c *Client) DeleteWorkspace(ctx context.Context, workspaceID string) (*coordination.M4DeleteWorkspaceResponse, error) {
+	var resp coordination.M4DeleteWorkspaceResponse
+	if err := c.do(ctx, http.MethodDelete, workspacePath(workspaceID), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RecordWorkspaceActivity records SSH, exec or service activity in a workspace
+func (c *Client) RecordWorkspaceActivity(ctx context.Context, workspaceID string, req *coordination.WorkspaceActivityRequest) error {
+	return c.do(ctx, http.MethodPost, workspacePath(workspaceID)+"/activity", nil, req, nil)
+}
+
+// ExtendWorkspace keeps a workspace alive for the given Go duration, e.g. "8h"
+func (c *Client) ExtendWorkspace(ctx context.Context, workspaceID, duration string) (*coordination.ExtendWorkspaceResponse, error) {
+	var resp coordination.ExtendWorkspaceResponse
+	req := coordination.ExtendWorkspaceRequest{Duration: duration}
+	if err := c.do(ctx, http.MethodPost, workspacePath(workspaceID)+"/extend", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// WorkspaceGit runs a git operation in a workspace's checkout on its node and waits for the result
+func (c *Client) WorkspaceGit(ctx context.Context, workspaceID string, req *coordination.WorkspaceGitRequest) (*coordination.WorkspaceGitResult, error) {
+	var result coordination.WorkspaceGitResult
+	if err := c.doHeld(ctx, http.MethodPost, workspacePath(workspaceID)+"/git", nil, req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListGrants lists the users granted access to a workspace
+func (c *Client) ListGrants(ctx context.Context, workspaceID string) ([]*coordination.WorkspaceGrant, error) {
+	var resp coordination.GrantListResponse
+	if err := c.do(ctx, http.MethodGet, workspacePath(workspaceID)+"/grants", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Grants, nil
+}
+
+// AddGrant grants a user collaborator or viewer access to a workspace
+func (c *Client) AddGrant(ctx context.Context, workspaceID, username, role string) (*coordination.WorkspaceGrant, error) {
+	var grant coordination.WorkspaceGrant
+	req := coordination.GrantRequest{Username: username, Role: role}
+	if err := c.do(ctx, http.MethodPost, workspacePath(workspaceID)+"/grants", nil, req, &grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// RemoveGrant revokes a user's access to a workspace
+func (c *Client) RemoveGrant(ctx context.Context, workspaceID, username string) error {
+	return c.do(ctx, http.MethodDelete, workspacePath(workspaceID)+"/grants/"+url.PathEscape(username), nil, nil, nil)
+}
+
+// CreateAPIToken creates an API token. The secret is only returned here.
+func (c *Client) CreateAPIToken(ctx context.Context, req *coordination.CreateAPITokenRequest) (*coordination.CreateAPITokenResponse, error) {
+	var resp coordination.CreateAPITokenResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/tokens", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListAPITokens lists the caller's API tokens, or those of username for admins
+func (c *Client) ListAPITokens(ctx context.Context, username string) ([]*coordination.APIToken, error) {
+	query := url.Values{}
+	if username != "" {
+		query.Set("username", username)
+	}
+
+	var resp coordination.APITokenListResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v1/tokens", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tokens, nil
+}
+
+// RevokeAPIToken revokes an API token
+func (c *Client) RevokeAPIToken(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/tokens/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// CreateEnrollmentToken creates a one-time node enrollment token. The secret is only returned here.
+func (c *Client) CreateEnrollmentToken(ctx context.Context, req *coordination.CreateEnrollmentTokenRequest) (*coordination.CreateEnrollmentTokenResponse, error) {
+	var resp coordination.CreateEnrollmentTokenResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/enrollment-tokens", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListEnrollmentTokens lists the enrollment tokens
+func (c *Client) ListEnrollmentTokens(ctx context.Context) ([]*coordination.EnrollmentToken, error) {
+	var resp coordination.EnrollmentTokenListResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v1/enrollment-tokens", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tokens, nil
+}
+
+// DeleteEnrollmentToken deletes an enrollment token
+func (c *Client) DeleteEnrollmentToken(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/enrollment-tokens/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// ExportState exports the server state, with GitHub installation tokens when includeSecrets is set
+func (c *Client) ExportState(ctx context.Context, includeSecrets bool) (*coordination.Snapshot, error) {
+	var query url.Values
+	if includeSecrets {
+		query = url.Values{"secrets": {"true"}}
+	}
+	var snapshot coordination.Snapshot
+	if err := c.do(ctx, http.MethodGet, "/api/v1/admin/export", query, nil, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// ImportState imports an exported snapshot, skipping records that already exist
+func (c *Client) ImportState(ctx context.Context, snapshot *coordination.Snapshot) (*coordination.ImportResult, error) {
+	var result coordination.ImportResult
+	if err := c.do(ctx, http.MethodPost, "/api/v1/admin/import", nil, snapshot, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// AuditQuery filters audit entries. Since and Until take an RFC 3339 time or a duration
+// counted back from now; empty fields match everything.
+type AuditQuery struct {
+	Actor    string
+	Action   string
+	Resource string
+	Result   string
+	Since    string
+	Until    string
+	Limit    int
+}
+
+// ListAudit lists audit entries, newest first
+func (c *Client) ListAudit(ctx context.Context, q AuditQuery) ([]*coordination.AuditEntry, error) {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"actor":    q.Actor,
+		"action":   q.Action,
+		"resource": q.Resource,
+		"result":   q.Result,
+		"since":    q.Since,
+		"until":    q.Until,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	var resp coordination.AuditListResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v1/audit", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Entries, nil
+}
+
+// Health checks the health of the server
+func (c *Client) Health(ctx context.Context) (*coordination.HealthResponse, error) {
+	var health coordination.HealthResponse
+	if err := c.do(ctx, http.MethodGet, "/health", nil, nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Metrics returns node and connection counts
+func (c *Client) Metrics(ctx context.Context) (map[string]interface{}, error) {
+	var metrics map[string]interface{}
+	if err := c.do(ctx, http.MethodGet, "/metrics", nil, nil, &metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// OpenAPI returns the OpenAPI document published by the server
+func (c *Client) OpenAPI(ctx context.Context) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, coordination.OpenAPIPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := send(c.httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return data, nil
+}
+
+// Events streams server events until ctx is cancelled or the connection closes
+func (c *Client) Events(ctx context.Context) (<-chan coordination.Event, error) {
+	return stream[coordination.Event](ctx, c, "/ws")
+}
+
+// stream reads the server-sent events at path, decoding each into a T, until ctx is
+// cancelled or the connection closes
+func stream[T any](ctx context.Context, c *Client, path string) (<-chan T, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream outlives any client timeout, so it is bounded by ctx alone
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+	resp, err := send(&httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan T)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var event T
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// GetGitHubToken returns the caller's GitHub installation token
+func (c *Client) GetGitHubToken(ctx context.Context) (*coordination.GitHubTokenResponse, error) {
+	var resp coordination.GitHubTokenResponse
+	if err := c.do(ctx, http.MethodGet, "/api/github/token", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetGitHubOAuthURL returns the URL that starts GitHub OAuth for a repository
+func (c *Client) GetGitHubOAuthURL(ctx context.Context, repoFullName string) (*coordination.GitHubOAuthURLResponse, error) {
+	var resp coordination.GitHubOAuthURLResponse
+	req := coordination.GitHubOAuthURLRequest{RepoFullName: repoFullName}
+	if err := c.do(ctx, http.MethodPost, "/api/github/oauth-url", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
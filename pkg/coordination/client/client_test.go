@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nexus/nexus/pkg/coordination"
+)
+
+const adminToken = "static-admin-token-0123456789"
+
+func newTestServer(t *testing.T) *httptest.Server {
+	cfg := &coordination.Config{}
+	cfg.Auth.Enabled = true
+	cfg.Server.AuthToken = adminToken
+
+	srv, err := coordination.NewServer(cfg)
+	require.NoError(t, err)
+	server := httptest.NewServer(srv.Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientCoversEveryOperation(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(coordination.OpenAPISpec(), &spec))
+
+	clientType := reflect.TypeOf(&Client{})
+	for path, item := range spec.Paths {
+		for method, op := range item {
+			if op.OperationID == "gitHubOAuthCallback" {
+				continue // completed by the browser
+			}
+			name := strings.ToUpper(op.OperationID[:1]) + op.OperationID[1:]
+			_, ok := clientType.MethodByName(name)
+			assert.True(t, ok, "no client method %s for %s %s", name, strings.ToUpper(method), path)
+		}
+	}
+}
+
+func TestClientNodes(t *testing.T) {
+	server := newTestServer(t)
+	c := New(server.URL, WithToken(adminToken))
+	ctx := context.Background()
+
+	registration, err := c.RegisterNode(ctx, &coordination.Node{ID: "node-1", Name: "node one", Provider: "lxc", Status: "active"})
+	require.NoError(t, err)
+	assert.Equal(t, "node-1", registration.ID)
+	assert.Nil(t, registration.Credential)
+
+	nodes, err := c.ListNodes(ctx)
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	assert.Equal(t, "node one", nodes[0].Name)
+
+	require.NoError(t, c.SendHeartbeat(ctx, "node-1", &coordination.NodeHeartbeat{Status: "active"}))
+
+	status, err := c.GetNodeStatus(ctx, "node-1")
+	require.NoError(t, err)
+	assert.Equal(t, "node-1", status.NodeID)
+
+	queued, err := c.SendCommand(ctx, "node-1", &coordination.Command{Type: "exec", Action: "ls"})
+	require.NoError(t, err)
+	assert.Equal(t, "node-1", queued.Target)
+	require.NoError(t, c.ReportCommandResult(ctx, &coordination.CommandResult{ID: queued.ID, NodeID: "node-1", Status: "success"}))
+
+	require.NoError(t, c.UnregisterNode(ctx, "node-1"))
+	_, err = c.GetNode(ctx, "node-1")
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, StatusCode(err))
+}
+
+func TestClientUsersAndTokens(t *testing.T) {
+	server := newTestServer(t)
+	c := New(server.URL, WithToken(adminToken))
+	ctx := context.Background()
+
+	_, err := c.RegisterUser(ctx, &coordination.User{ID: "sub-alice", Username: "alice"})
+	require.NoError(t, err)
+	user, err := c.SetUserRole(ctx, "alice", coordination.RoleOperator)
+	require.NoError(t, err)
+	assert.Equal(t, coordination.RoleOperator, user.Role)
+
+	users, err := c.ListUsers(ctx)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	usage, err := c.GetUserUsage(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", usage.Username)
+
+	created, err := c.CreateAPIToken(ctx, &coordination.CreateAPITokenRequest{Name: "ci", Scopes: []string{coordination.ActionWorkspacesRead}, Username: "alice"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.Token)
+
+	tokens, err := c.ListAPITokens(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	require.NoError(t, c.RevokeAPIToken(ctx, tokens[0].ID))
+
+	enrollment, err := c.CreateEnrollmentToken(ctx, &coordination.CreateEnrollmentTokenRequest{NodeID: "node-2"})
+	require.NoError(t, err)
+	enrollments, err := c.ListEnrollmentTokens(ctx)
+	require.NoError(t, err)
+	require.Len(t, enrollments, 1)
+	require.NoError(t, c.DeleteEnrollmentToken(ctx, enrollment.EnrollmentToken.ID))
+}
+
+func TestClientErrors(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := New(server.URL, WithToken("wrong")).ListNodes(ctx)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, StatusCode(err))
+
+	_, err = New(server.URL, WithToken(adminToken)).GetUserUsage(ctx, "nobody")
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "user_not_found", apiErr.Code)
+	assert.NotEmpty(t, apiErr.RequestID)
+
+	tokens := []string{"first", ""}
+	c := New(server.URL, WithTokenSource(func() (string, error) {
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token, nil
+	}))
+	_, err = c.Health(ctx)
+	assert.Equal(t, http.StatusUnauthorized, StatusCode(err))
+	_, err = c.Health(ctx)
+	assert.Equal(t, http.StatusUnauthorized, StatusCode(err), "an empty token sends no Authorization header")
+
+	spec, err := New(server.URL, WithToken(adminToken)).OpenAPI(ctx)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(coordination.OpenAPISpec()), string(spec))
+}
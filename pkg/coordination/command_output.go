@@ -0,0 +1,268 @@
+package coordination
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limits on the output the server keeps for commands that are still being followed
+const (
+	maxBufferedChunks      = 1000             // per command; the oldest chunks are dropped beyond it
+	commandStreamRetention = 5 * time.Minute  // after the result arrived
+	commandStreamIdleTTL   = 30 * time.Minute // without output or result
+	followerBuffer         = 256
+)
+
+// CommandOutput is a chunk of the output of a running command. Seq increases by one per
+// chunk across both streams, so a gap means chunks were lost.
+type CommandOutput struct {
+	CommandID string    `json:"command_id"`
+	NodeID    string    `json:"node_id"`
+	Stream    string    `json:"stream"` // stdout or stderr
+	Seq       int64     `json:"seq"`
+	Data      string    `json:"data"`
+	Time      time.Time `json:"time"`
+}
+
+// CommandStreamEvent is one event of GET /api/v1/commands/{id}/output: an output chunk,
+// or the result that ends the stream
+type CommandStreamEvent struct {
+	Output *CommandOutput `json:"output,omitempty"`
+	Result *CommandResult `json:"result,omitempty"`
+}
+
+// commandStream is the output of one command kept for followers that connect late
+type commandStream struct {
+	chunks    []CommandOutput
+	result    *CommandResult
+	updated   time.Time
+	followers map[chan CommandStreamEvent]struct{}
+}
+
+// commandStreams buffers command output and fans it out to followers
+type commandStreams struct {
+	mu      sync.Mutex
+	streams map[string]*commandStream
+}
+
+func newCommandStreams() *commandStreams {
+	return &commandStreams{streams: make(map[string]*commandStream)}
+}
+
+// get returns the stream of commandID, creating it. The caller holds c.mu.
+func (c *commandStreams) get(commandID string) *commandStream {
+	stream, ok := c.streams[commandID]
+	if !ok {
+		stream = &commandStream{followers: make(map[chan CommandStreamEvent]struct{})}
+		c.streams[commandID] = stream
+	}
+	stream.updated = time.Now()
+	return stream
+}
+
+// publish sends event to the followers of stream, dropping followers that fall behind.
+// The caller holds c.mu.
+func (c *commandStreams) publish(stream *commandStream, event CommandStreamEvent) {
+	for ch := range stream.followers {
+		select {
+		case ch <- event:
+		default:
+			close(ch)
+			delete(stream.followers, ch)
+		}
+	}
+}
+
+// append records an output chunk
+func (c *commandStreams) append(chunk CommandOutput) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune()
+
+	stream := c.get(chunk.CommandID)
+	if stream.result != nil {
+		return
+	}
+	stream.chunks = append(stream.chunks, chunk)
+	if dropped := len(stream.chunks) - maxBufferedChunks; dropped > 0 {
+		stream.chunks = stream.chunks[dropped:]
+	}
+	c.publish(stream, CommandStreamEvent{Output: &chunk})
+}
+
+// finish records the result of a command and ends its followers' streams
+func (c *commandStreams) finish(result CommandResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune()
+
+	stream := c.get(result.ID)
+	stream.result = &result
+	c.publish(stream, CommandStreamEvent{Result: &result})
+	for ch := range stream.followers {
+		close(ch)
+		delete(stream.followers, ch)
+	}
+}
+
+// follow returns the buffered events of a command and, unless it has finished, a channel
+// of the events that follow. The channel is closed when the command finishes or the
+// follower falls behind; stop unsubscribes.
+func (c *commandStreams) follow(commandID string) (replay []CommandStreamEvent, events <-chan CommandStreamEvent, stop func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stream := c.get(commandID)
+	for i := range stream.chunks {
+		replay = append(replay, CommandStreamEvent{Output: &stream.chunks[i]})
+	}
+	if stream.result != nil {
+		return append(replay, CommandStreamEvent{Result: stream.result}), nil, func() {}
+	}
+
+	ch := make(chan CommandStreamEvent, followerBuffer)
+	stream.followers[ch] = struct{}{}
+	return replay, ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := stream.followers[ch]; ok {
+			close(ch)
+			delete(stream.followers, ch)
+		}
+	}
+}
+
+// finished reports whether the result of commandID is still kept
+func (c *commandStreams) finished(commandID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stream, ok := c.streams[commandID]
+	return ok && stream.result != nil
+}
+
+// prune forgets finished commands after commandStreamRetention and abandoned ones after
+// commandStreamIdleTTL. The caller holds c.mu.
+func (c *commandStreams) prune() {
+	now := time.Now()
+	for id, stream := range c.streams {
+		ttl := commandStreamIdleTTL
+		if stream.result != nil {
+			ttl = commandStreamRetention
+		}
+		if len(stream.followers) == 0 && now.Sub(stream.updated) > ttl {
+			delete(c.streams, id)
+		}
+	}
+}
+
+// CommandResultEvent is the data of command_result events. Event subscribers only need
+// nodes:read, so the output, error and parameters stay behind the output endpoint.
+type CommandResultEvent struct {
+	CommandID string        `json:"command_id"`
+	NodeID    string        `json:"node_id"`
+	Type      string        `json:"type"`
+	Action    string        `json:"action"`
+	Status    string        `json:"status"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// recordCommandResult keeps a result for followers and queues it for event subscribers
+func (s *Server) recordCommandResult(result CommandResult) {
+	s.commandStreams.finish(result)
+
+	select {
+	case s.commandCh <- result:
+	default:
+		log.Printf("Command result channel full, dropping result for command %s", result.ID)
+	}
+}
+
+// handleCommandOutput receives a chunk of output from the node running a command
+// POST /api/v1/commands/{id}/output
+func (s *Server) handleCommandOutput(w http.ResponseWriter, r *http.Request, commandID string) {
+	// Nodes may only report output of commands sent to them
+	if !s.authorizeCommandReport(w, r, commandID) {
+		return
+	}
+
+	var chunk CommandOutput
+	if err := json.NewDecoder(r.Body).Decode(&chunk); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if chunk.CommandID != commandID {
+		http.Error(w, "Command ID mismatch", http.StatusBadRequest)
+		return
+	}
+	if target, ok := s.commandQueue.target(commandID); ok {
+		chunk.NodeID = target
+	}
+	if chunk.Stream != "stdout" && chunk.Stream != "stderr" {
+		http.Error(w, "Stream must be stdout or stderr", http.StatusBadRequest)
+		return
+	}
+	if chunk.Time.IsZero() {
+		chunk.Time = time.Now()
+	}
+
+	// Output is only for those allowed to follow it, so it is not broadcast as an event
+	s.commandStreams.append(chunk)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleFollowCommandOutput streams the output of a command as server-sent events, starting
+// with the output buffered so far and ending with its result
+// GET /api/v1/commands/{id}/output
+func (s *Server) handleFollowCommandOutput(w http.ResponseWriter, r *http.Request, commandID string) {
+	if !s.authorize(w, r, ActionNodesAdmin) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	replay, events, stop := s.commandStreams.follow(commandID)
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	send := func(event CommandStreamEvent) {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	for _, event := range replay {
+		send(event)
+	}
+	flusher.Flush()
+	if events == nil {
+		return
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			send(event)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package coordination
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// followCommand reads the events of GET /api/v1/commands/{id}/output until the stream ends
+func followCommand(t *testing.T, url, token string) <-chan CommandStreamEvent {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	events := make(chan CommandStreamEvent, 16)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var event CommandStreamEvent
+			if json.Unmarshal([]byte(data), &event) == nil {
+				events <- event
+			}
+		}
+	}()
+	return events
+}
+
+func TestCommandOutputStreaming(t *testing.T) {
+	srv := newRBACTestServer(t)
+	enrollment := createTestEnrollmentToken(t, srv, CreateEnrollmentTokenRequest{})
+	node1 := enrollTestNode(t, srv, enrollment, "node-1").Secret
+	enrollment = createTestEnrollmentToken(t, srv, CreateEnrollmentTokenRequest{})
+	node2 := enrollTestNode(t, srv, enrollment, "node-2").Secret
+	events := subscribeEvents(srv)
+	srv.commandQueue.enqueue("node-1", Command{ID: "cmd-1", Type: "system", Action: "build"})
+	srv.commandQueue.enqueue("node-1", Command{ID: "cmd-2", Type: "system", Action: "build"})
+
+	chunk := func(seq int64, stream, data string) CommandOutput {
+		return CommandOutput{CommandID: "cmd-1", NodeID: "node-1", Stream: stream, Seq: seq, Data: data}
+	}
+
+	w := rbacRequest(t, srv, node1, http.MethodPost, "/api/v1/commands/cmd-1/output", chunk(1, "stdout", "building\n"))
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	w = rbacRequest(t, srv, node2, http.MethodPost, "/api/v1/commands/cmd-1/output", chunk(2, "stdout", "forged\n"))
+	assert.Equal(t, http.StatusForbidden, w.Code, "nodes only report output of their own commands")
+	forged := chunk(2, "stdout", "forged\n")
+	forged.NodeID = "node-2"
+	w = rbacRequest(t, srv, node2, http.MethodPost, "/api/v1/commands/cmd-1/output", forged)
+	assert.Equal(t, http.StatusForbidden, w.Code, "claiming the target node in the body does not help")
+	w = rbacRequest(t, srv, node1, http.MethodPost, "/api/v1/commands/cmd-2/output", chunk(2, "stdout", "x"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = rbacRequest(t, srv, node1, http.MethodPost, "/api/v1/commands/cmd-1/output", chunk(2, "stdin", "x"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, drainEventTypes(events), "output is not broadcast to event subscribers, who may not follow it")
+
+	server := httptest.NewServer(srv.authMiddleware(srv.router))
+	defer server.Close()
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodGet, "/api/v1/commands/cmd-1/output", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "following output needs nodes.admin")
+
+	follower := followCommand(t, server.URL+"/api/v1/commands/cmd-1/output", rbacAdminToken)
+	first := <-follower
+	require.NotNil(t, first.Output, "buffered output is replayed")
+	assert.Equal(t, "building\n", first.Output.Data)
+
+	w = rbacRequest(t, srv, node1, http.MethodPost, "/api/v1/commands/cmd-1/output", chunk(2, "stderr", "warning\n"))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	second := <-follower
+	require.NotNil(t, second.Output)
+	assert.Equal(t, int64(2), second.Output.Seq)
+	assert.Equal(t, "stderr", second.Output.Stream)
+
+	w = rbacRequest(t, srv, node1, http.MethodPost, "/api/v1/commands/cmd-1/result", CommandResult{ID: "cmd-1", NodeID: "node-1", Status: "success"})
+	require.Equal(t, http.StatusAccepted, w.Code)
+	last := <-follower
+	require.NotNil(t, last.Result)
+	assert.Equal(t, "success", last.Result.Status)
+	_, open := <-follower
+	assert.False(t, open, "the stream ends with the result")
+
+	// A follower that connects after the command finished gets everything at once
+	var replayed []CommandStreamEvent
+	for event := range followCommand(t, server.URL+"/api/v1/commands/cmd-1/output", rbacAdminToken) {
+		replayed = append(replayed, event)
+	}
+	require.Len(t, replayed, 3)
+	assert.NotNil(t, replayed[2].Result)
+}
+
+func TestCommandStreamsBoundBufferedOutput(t *testing.T) {
+	streams := newCommandStreams()
+	for i := 1; i <= maxBufferedChunks+10; i++ {
+		streams.append(CommandOutput{CommandID: "cmd-1", Seq: int64(i)})
+	}
+
+	replay, events, stop := streams.follow("cmd-1")
+	defer stop()
+	require.Len(t, replay, maxBufferedChunks)
+	assert.Equal(t, int64(11), replay[0].Output.Seq, "the oldest chunks are dropped")
+
+	streams.finish(CommandResult{ID: "cmd-1"})
+	event := <-events
+	assert.NotNil(t, event.Result)
+	_, open := <-events
+	assert.False(t, open)
+
+	streams.append(CommandOutput{CommandID: "cmd-1", Seq: 2000})
+	replay, _, _ = streams.follow("cmd-1")
+	assert.Len(t, replay, maxBufferedChunks+1, "output after the result is ignored")
+}
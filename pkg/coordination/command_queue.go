@@ -0,0 +1,266 @@
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCommandPollWait is how long a node's command poll is held open without ?wait
+	defaultCommandPollWait = 20 * time.Second
+	// maxCommandPollWait bounds ?wait
+	maxCommandPollWait = time.Minute
+)
+
+// NodeCommands is the body of GET /api/v1/nodes/{id}/commands
+type NodeCommands struct {
+	Commands []Command `json:"commands"`
+}
+
+// commandQueue holds the commands each node has not acknowledged yet, and remembers each
+// command until its result arrives. Commands handed to a node stay queued until a later
+// poll acknowledges them, so a poll response lost on the way is delivered again.
+type commandQueue struct {
+	mu        sync.Mutex
+	pending   map[string][]Command     // node ID -> commands in the order they were sent
+	delivered map[string][]Command     // node ID -> commands handed out but not acknowledged
+	waiters   map[string]chan struct{} // closed when a node's queue grows
+	sent      map[string]Command       // command ID -> command, Target set to its node
+}
+
+func newCommandQueue() *commandQueue {
+	return &commandQueue{
+		pending:   make(map[string][]Command),
+		delivered: make(map[string][]Command),
+		waiters:   make(map[string]chan struct{}),
+		sent:      make(map[string]Command),
+	}
+}
+
+// enqueue queues cmd for nodeID and wakes the node's poll
+func (q *commandQueue) enqueue(nodeID string, cmd Command) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cmd.Target = nodeID
+	q.pending[nodeID] = append(q.pending[nodeID], cmd)
+	q.sent[cmd.ID] = cmd
+	if ch, ok := q.waiters[nodeID]; ok {
+		close(ch)
+		delete(q.waiters, nodeID)
+	}
+}
+
+// cancel withdraws a command. It reports whether the node had not picked it up yet.
+func (q *commandQueue) cancel(nodeID, commandID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.sent, commandID)
+	q.delivered[nodeID] = removeCommands(q.delivered[nodeID], commandID)
+	n := len(q.pending[nodeID])
+	q.pending[nodeID] = removeCommands(q.pending[nodeID], commandID)
+	return len(q.pending[nodeID]) < n
+}
+
+// removeCommands returns commands without the ones with the given IDs
+func removeCommands(commands []Command, ids ...string) []Command {
+	kept := commands[:0]
+	for _, cmd := range commands {
+		if !slices.Contains(ids, cmd.ID) {
+			kept = append(kept, cmd)
+		}
+	}
+	return kept
+}
+
+// target returns the node a command was sent to, while its result is outstanding
+func (q *commandQueue) target(commandID string) (string, bool) {
+	cmd, ok := q.command(commandID)
+	return cmd.Target, ok
+}
+
+// command returns a command whose result is outstanding
+func (q *commandQueue) command(commandID string) (Command, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cmd, ok := q.sent[commandID]
+	return cmd, ok
+}
+
+// finish forgets a command once its result has arrived
+func (q *commandQueue) finish(commandID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.sent, commandID)
+}
+
+// take first drops the commands the node acknowledged, then returns the commands it has
+// not acknowledged, waiting up to wait for one to arrive when there are none. Returned
+// commands stay queued until a later take acknowledges them.
+func (q *commandQueue) take(ctx context.Context, nodeID string, acked []string, wait time.Duration) []Command {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	q.mu.Lock()
+	q.delivered[nodeID] = removeCommands(q.delivered[nodeID], acked...)
+	q.mu.Unlock()
+
+	for {
+		q.mu.Lock()
+		if len(q.pending[nodeID]) > 0 || len(q.delivered[nodeID]) > 0 {
+			commands := append(slices.Clone(q.delivered[nodeID]), q.pending[nodeID]...)
+			q.delivered[nodeID] = commands
+			delete(q.pending, nodeID)
+			q.mu.Unlock()
+			return slices.Clone(commands)
+		}
+		ch, ok := q.waiters[nodeID]
+		if !ok {
+			ch = make(chan struct{})
+			q.waiters[nodeID] = ch
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// dispatchCommand sends cmd to a node and waits for its result. Commands the node did
+// not pick up when ctx ends are withdrawn.
+func (s *Server) dispatchCommand(ctx context.Context, nodeID string, cmd Command) (*CommandResult, error) {
+	cmd.Target = nodeID
+	cmd.Created = time.Now()
+	s.commandQueue.enqueue(nodeID, cmd)
+
+	result, err := s.waitForCommandResult(ctx, cmd.ID)
+	if err != nil {
+		s.commandQueue.cancel(nodeID, cmd.ID)
+		return nil, err
+	}
+	return result, nil
+}
+
+// workspaceCommandTimeout bounds how long a node may take to start, stop or delete a workspace
+const workspaceCommandTimeout = 2 * time.Minute
+
+// runWorkspaceAction has the node hosting ws start, stop or delete it and waits for the
+// node to confirm. The registry is left to the caller, which only changes it on success.
+func (s *Server) runWorkspaceAction(ctx context.Context, ws *DBWorkspace, action string) error {
+	if ws.NodeID == nil || *ws.NodeID == "" {
+		return fmt.Errorf("workspace %s is not placed on a node", ws.WorkspaceID)
+	}
+	command := Command{
+		ID:      fmt.Sprintf("%s_%d_%s", action, time.Now().UnixNano(), ws.WorkspaceID),
+		Type:    "workspace",
+		Action:  action,
+		Params:  map[string]interface{}{"workspace_id": ws.WorkspaceID},
+		Timeout: workspaceCommandTimeout,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, workspaceCommandTimeout+30*time.Second)
+	defer cancel()
+	result, err := s.dispatchCommand(ctx, *ws.NodeID, command)
+	if err != nil {
+		return err
+	}
+	if result.Status != "success" {
+		return fmt.Errorf("%s on node %s %s: %s", action, *ws.NodeID, result.Status, result.Error)
+	}
+	return nil
+}
+
+// waitForCommandResult follows a command until the node reports its result
+func (s *Server) waitForCommandResult(ctx context.Context, commandID string) (*CommandResult, error) {
+	for {
+		replay, events, stop := s.commandStreams.follow(commandID)
+		for _, event := range replay {
+			if event.Result != nil {
+				stop()
+				return event.Result, nil
+			}
+		}
+
+	follow:
+		for {
+			select {
+			case <-ctx.Done():
+				stop()
+				return nil, ctx.Err()
+			case event, ok := <-events:
+				if !ok {
+					// Dropped for falling behind on output; follow again
+					break follow
+				}
+				if event.Result != nil {
+					stop()
+					return event.Result, nil
+				}
+			}
+		}
+		stop()
+	}
+}
+
+// authorizeCommandReport lets the node a command was sent to, or an admin, report its
+// output and result. The node is checked against where the server sent the command, not
+// against anything in the report.
+func (s *Server) authorizeCommandReport(w http.ResponseWriter, r *http.Request, commandID string) bool {
+	p := s.caller(r)
+	if p == nil || p.Kind != PrincipalNode {
+		return s.authorize(w, r, ActionNodesAdmin)
+	}
+	if target, ok := s.commandQueue.target(commandID); !ok || target != p.NodeID {
+		sendM4JSONError(w, http.StatusForbidden, "forbidden", fmt.Sprintf("Command %s was not sent to node %s", commandID, p.NodeID), nil)
+		return false
+	}
+	return true
+}
+
+// handlePollCommands hands a node the commands sent to it, holding the request open
+// until one arrives or ?wait passes. ?ack lists the IDs of the commands the node received
+// from its previous poll; the others are handed out again. Only the node's own credential
+// may poll.
+// GET /api/v1/nodes/{id}/commands
+func (s *Server) handlePollCommands(w http.ResponseWriter, r *http.Request, nodeID string) {
+	// Commands may carry workspace details meant for the node alone, so not even admins
+	// take them off its queue
+	if p := s.caller(r); p != nil && (p.Kind != PrincipalNode || p.NodeID != nodeID) {
+		sendM4JSONError(w, http.StatusForbidden, "forbidden", fmt.Sprintf("Only node %s can take its commands", nodeID), nil)
+		return
+	}
+
+	wait := defaultCommandPollWait
+	if raw := r.URL.Query().Get("wait"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			sendM4JSONError(w, http.StatusBadRequest, "invalid_wait", "wait must be a duration", nil)
+			return
+		}
+		wait = min(d, maxCommandPollWait)
+	}
+
+	var acked []string
+	if raw := r.URL.Query().Get("ack"); raw != "" {
+		acked = strings.Split(raw, ",")
+	}
+
+	// The poll may outlast the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	commands := s.commandQueue.take(r.Context(), nodeID, acked, wait)
+	if commands == nil {
+		commands = []Command{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NodeCommands{Commands: commands})
+}
@@ -5,43 +5,16 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/nexus/nexus/pkg/auth"
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds the coordination server configuration
 type Config struct {
-	Server struct {
-		Host         string `yaml:"host,omitempty"`
-		Port         int    `yaml:"port,omitempty"`
-		AuthToken    string `yaml:"auth_token,omitempty"`
-		JWTSecret    string `yaml:"jwt_secret,omitempty"`
-		ReadTimeout  string `yaml:"read_timeout,omitempty"`
-		WriteTimeout string `yaml:"write_timeout,omitempty"`
-		IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-	} `yaml:"server,omitempty"`
-
-	Registry struct {
-		Provider            string        `yaml:"provider,omitempty"`
-		SyncInterval        string        `yaml:"sync_interval,omitempty"`
-		HealthCheckInterval string        `yaml:"health_check_interval,omitempty"`
-		NodeTimeout         string        `yaml:"node_timeout,omitempty"`
-		MaxRetries          int           `yaml:"max_retries,omitempty"`
-		Storage             StorageConfig `yaml:"storage,omitempty"`
-	} `yaml:"registry,omitempty"`
-
-	WebSocket struct {
-		Enabled    bool     `yaml:"enabled,omitempty"`
-		Path       string   `yaml:"path,omitempty"`
-		Origins    []string `yaml:"origins,omitempty"`
-		PingPeriod string   `yaml:"ping_period,omitempty"`
-	} `yaml:"websocket,omitempty"`
-
-	Auth struct {
-		Enabled     bool     `yaml:"enabled,omitempty"`
-		JWTSecret   string   `yaml:"jwt_secret,omitempty"`
-		TokenExpiry string   `yaml:"token_expiry,omitempty"`
-		AllowedIPs  []string `yaml:"allowed_ips,omitempty"`
-	} `yaml:"auth,omitempty"`
+	Server    ServerConfig    `yaml:"server,omitempty"`
+	Registry  RegistryConfig  `yaml:"registry,omitempty"`
+	WebSocket WebSocketConfig `yaml:"websocket,omitempty"`
+	Auth      AuthConfig      `yaml:"auth,omitempty"`
 
 	Logging struct {
 		Level      string `yaml:"level,omitempty"`
@@ -62,14 +35,111 @@ type Config struct {
 			} `yaml:"remote,omitempty"`
 		} `yaml:"lxc,omitempty"`
 	} `yaml:"provider,omitempty"`
+
+	Quotas    QuotaConfig       `yaml:"quotas,omitempty"`
+	Lifecycle LifecycleConfig   `yaml:"lifecycle,omitempty"`
+	Agents    AgentUpdateConfig `yaml:"agents,omitempty"`
+}
+
+// ServerConfig is the HTTP listener and API protection of the coordination server
+type ServerConfig struct {
+	Host         string       `yaml:"host,omitempty"`
+	Port         int          `yaml:"port,omitempty"`
+	AuthToken    string       `yaml:"auth_token,omitempty"`
+	JWTSecret    string       `yaml:"jwt_secret,omitempty"`
+	ReadTimeout  string       `yaml:"read_timeout,omitempty"`
+	WriteTimeout string       `yaml:"write_timeout,omitempty"`
+	IdleTimeout  string       `yaml:"idle_timeout,omitempty"`
+	Limits       LimitsConfig `yaml:"limits,omitempty"`
+}
+
+// RegistryConfig selects where nodes and workspaces are stored and how nodes are watched
+type RegistryConfig struct {
+	Provider            string        `yaml:"provider,omitempty"` // no longer used; rejected in favour of storage.type
+	SyncInterval        string        `yaml:"sync_interval,omitempty"`
+	HealthCheckInterval string        `yaml:"health_check_interval,omitempty"`
+	NodeTimeout         string        `yaml:"node_timeout,omitempty"`
+	MaxRetries          int           `yaml:"max_retries,omitempty"`
+	Storage             StorageConfig `yaml:"storage,omitempty"`
+	RescheduleStateless bool          `yaml:"reschedule_stateless,omitempty"` // move stateless workspaces off offline nodes
+}
+
+// WebSocketConfig controls the event stream
+type WebSocketConfig struct {
+	Enabled    bool     `yaml:"enabled,omitempty"`
+	Path       string   `yaml:"path,omitempty"`
+	Origins    []string `yaml:"origins,omitempty"`
+	PingPeriod string   `yaml:"ping_period,omitempty"`
+}
+
+// AuthConfig authenticates API callers
+type AuthConfig struct {
+	Enabled     bool         `yaml:"enabled,omitempty"`
+	JWTSecret   string       `yaml:"jwt_secret,omitempty"`
+	TokenExpiry string       `yaml:"token_expiry,omitempty"`
+	AllowedIPs  []string     `yaml:"allowed_ips,omitempty"`
+	OIDC        *auth.Config `yaml:"oidc,omitempty"` // identity provider for user tokens
 }
 
+// defaultStorageType is where the server keeps nodes and workspaces when the
+// configuration does not say
+const defaultStorageType = "sqlite"
+
 type StorageConfig struct {
-	Type   string                 `yaml:"type,omitempty"`
-	Path   string                 `yaml:"path,omitempty"`
+	Type   string                 `yaml:"type,omitempty"` // memory, sqlite or postgres; the server defaults to sqlite
+	Path   string                 `yaml:"path,omitempty"` // SQLite database file
+	DSN    string                 `yaml:"dsn,omitempty"`  // PostgreSQL connection string
 	Config map[string]interface{} `yaml:"config,omitempty"`
 }
 
+// LimitsConfig protects the API from runaway clients. Zero values disable a limit.
+type LimitsConfig struct {
+	PerToken      RateLimitConfig `yaml:"per_token,omitempty"` // requests carrying the same bearer token
+	PerIP         RateLimitConfig `yaml:"per_ip,omitempty"`    // requests from the same address
+	Expensive     RateLimitConfig `yaml:"expensive,omitempty"` // workspace creation and OAuth, per token or address
+	MaxBodyBytes  int64           `yaml:"max_body_bytes,omitempty"`
+	MaxConcurrent int             `yaml:"max_concurrent,omitempty"` // requests served at once
+}
+
+// RateLimitConfig is a token bucket refilled at RequestsPerSecond holding up to Burst requests
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second,omitempty"`
+	Burst             int     `yaml:"burst,omitempty"`
+}
+
+// QuotaConfig limits the workspaces users and teams may hold. Users without an entry of
+// their own get Default; team limits apply to the combined usage of all members.
+type QuotaConfig struct {
+	Default QuotaLimits            `yaml:"default,omitempty"`
+	Users   map[string]QuotaLimits `yaml:"users,omitempty"` // keyed by username
+	Teams   map[string]TeamQuota   `yaml:"teams,omitempty"` // keyed by team name
+}
+
+// QuotaLimits caps workspace usage. A zero limit is unlimited.
+type QuotaLimits struct {
+	MaxWorkspaces int   `yaml:"max_workspaces,omitempty" json:"max_workspaces,omitempty"`
+	MaxRunning    int   `yaml:"max_running,omitempty" json:"max_running,omitempty"`
+	MaxCPU        int   `yaml:"max_cpu,omitempty" json:"max_cpu,omitempty"`             // vCPUs across running workspaces
+	MaxMemoryMB   int64 `yaml:"max_memory_mb,omitempty" json:"max_memory_mb,omitempty"` // memory across running workspaces
+	MaxDiskGB     int64 `yaml:"max_disk_gb,omitempty" json:"max_disk_gb,omitempty"`     // disk across all workspaces
+}
+
+// TeamQuota is a named group of users sharing one set of limits
+type TeamQuota struct {
+	Members     []string `yaml:"members,omitempty"`
+	QuotaLimits `yaml:",inline"`
+}
+
+// LifecycleConfig stops idle workspaces and deletes expired ones. Empty durations disable
+// the corresponding check.
+type LifecycleConfig struct {
+	IdleTimeout   string `yaml:"idle_timeout,omitempty"`   // stop running workspaces without activity for this long
+	TTL           string `yaml:"ttl,omitempty"`            // delete workspaces this long after creation
+	WarningPeriod string `yaml:"warning_period,omitempty"` // warn this long before a stop or deletion
+	CheckInterval string `yaml:"check_interval,omitempty"`
+	MaxExtension  string `yaml:"max_extension,omitempty"` // longest keep-alive a single extend may grant
+}
+
 // LoadConfig loads coordination configuration from file
 func LoadConfig(path string) (*Config, error) {
 	// Set defaults
@@ -79,8 +149,11 @@ func LoadConfig(path string) (*Config, error) {
 	cfg.Server.ReadTimeout = "30s"
 	cfg.Server.WriteTimeout = "30s"
 	cfg.Server.IdleTimeout = "120s"
+	cfg.Server.Limits.PerIP = RateLimitConfig{RequestsPerSecond: 50, Burst: 100}
+	cfg.Server.Limits.Expensive = RateLimitConfig{RequestsPerSecond: 0.5, Burst: 5}
+	cfg.Server.Limits.MaxBodyBytes = 10 << 20
+	cfg.Server.Limits.MaxConcurrent = 512
 
-	cfg.Registry.Provider = "memory"
 	cfg.Registry.SyncInterval = "30s"
 	cfg.Registry.HealthCheckInterval = "10s"
 	cfg.Registry.NodeTimeout = "60s"
@@ -115,6 +188,9 @@ func LoadConfig(path string) (*Config, error) {
 			return nil, fmt.Errorf("failed to parse config file: %w", err)
 		}
 	}
+	if cfg.Registry.Provider != "" {
+		return nil, fmt.Errorf("registry.provider is no longer supported; set registry.storage.type (%s by default) instead", defaultStorageType)
+	}
 
 	// Environment variable overrides
 	if host := os.Getenv("VENDETTA_COORD_HOST"); host != "" {
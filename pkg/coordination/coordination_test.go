@@ -107,24 +107,24 @@ func TestInMemoryRegistry(t *testing.T) {
 	})
 }
 
+// newTestServer creates a server for a test, failing it when the server cannot be created
+func newTestServer(t testing.TB, cfg *Config) *Server {
+	t.Helper()
+	srv, err := NewServer(cfg)
+	require.NoError(t, err)
+	return srv
+}
+
 func TestServerCreation(t *testing.T) {
 	config := &Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+		Server: ServerConfig{
 			Host:      "localhost",
 			Port:      3001,
 			AuthToken: "test-token",
 		},
 	}
 
-	server := NewServer(config)
+	server := newTestServer(t, config)
 	assert.NotNil(t, server)
 	assert.Equal(t, config, server.config)
 	assert.NotNil(t, server.registry)
@@ -132,24 +132,27 @@ func TestServerCreation(t *testing.T) {
 	assert.NotNil(t, server.commandCh)
 }
 
+func TestServerCreationFailsWithoutConfiguredStorage(t *testing.T) {
+	cfg := &Config{}
+	cfg.Registry.Storage = StorageConfig{Type: "sqlite", Path: t.TempDir()} // a directory, not a database
+	_, err := NewServer(cfg)
+	assert.Error(t, err, "configured storage does not silently fall back to memory")
+
+	cfg.Registry.Storage = StorageConfig{Type: "etcd"}
+	_, err = NewServer(cfg)
+	assert.Error(t, err)
+}
+
 func TestServerHandlers(t *testing.T) {
 	config := &Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+		Server: ServerConfig{
 			Host:      "localhost",
 			Port:      0, // Use random port for testing
 			AuthToken: "test-token",
 		},
 	}
 
-	server := NewServer(config)
+	server := newTestServer(t, config)
 	require.NotNil(t, server)
 
 	t.Run("Register Node", func(t *testing.T) {
@@ -239,22 +242,14 @@ func TestServerHandlers(t *testing.T) {
 
 func TestCommandHandling(t *testing.T) {
 	config := &Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+		Server: ServerConfig{
 			Host:      "localhost",
 			Port:      0,
 			AuthToken: "test-token",
 		},
 	}
 
-	server := NewServer(config)
+	server := newTestServer(t, config)
 	require.NotNil(t, server)
 
 	// Register a test node first
@@ -284,17 +279,23 @@ func TestCommandHandling(t *testing.T) {
 
 		server.handleSendCommand(w, req, "test-node")
 
-		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, http.StatusAccepted, w.Code)
 
-		var result CommandResult
-		err := json.NewDecoder(w.Body).Decode(&result)
+		var queued Command
+		err := json.NewDecoder(w.Body).Decode(&queued)
 		require.NoError(t, err)
-		assert.NotEmpty(t, result.ID)
-		assert.Equal(t, "test-node", result.NodeID)
-		assert.Equal(t, command.Type, result.Command.Type)
-		assert.Equal(t, command.Action, result.Command.Action)
-		assert.Equal(t, "success", result.Status)
-		assert.NotEmpty(t, result.Output)
+		assert.NotEmpty(t, queued.ID)
+		assert.Equal(t, "test-node", queued.Target)
+		assert.Equal(t, command.Action, queued.Action)
+		pending, ok := server.commandQueue.command(queued.ID)
+		require.True(t, ok, "the command is queued for the node")
+		assert.Equal(t, command.Type, pending.Type)
+		assert.False(t, server.commandStreams.finished(queued.ID), "nothing is reported until the node runs it")
+
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/nodes/test-node/commands", strings.NewReader(`{"id":"`+queued.ID+`"}`))
+		w = httptest.NewRecorder()
+		server.handleSendCommand(w, req, "test-node")
+		assert.Equal(t, http.StatusConflict, w.Code, "command IDs are not reused")
 	})
 
 	t.Run("Command Result", func(t *testing.T) {
@@ -320,26 +321,11 @@ func TestCommandHandling(t *testing.T) {
 func TestConfig(t *testing.T) {
 	t.Run("ValidateConfig", func(t *testing.T) {
 		validConfig := &Config{
-			Server: struct {
-				Host         string `yaml:"host,omitempty"`
-				Port         int    `yaml:"port,omitempty"`
-				AuthToken    string `yaml:"auth_token,omitempty"`
-				JWTSecret    string `yaml:"jwt_secret,omitempty"`
-				ReadTimeout  string `yaml:"read_timeout,omitempty"`
-				WriteTimeout string `yaml:"write_timeout,omitempty"`
-				IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-			}{
+			Server: ServerConfig{
 				Host: "localhost",
 				Port: 3001,
 			},
-			Registry: struct {
-				Provider            string        `yaml:"provider,omitempty"`
-				SyncInterval        string        `yaml:"sync_interval,omitempty"`
-				HealthCheckInterval string        `yaml:"health_check_interval,omitempty"`
-				NodeTimeout         string        `yaml:"node_timeout,omitempty"`
-				MaxRetries          int           `yaml:"max_retries,omitempty"`
-				Storage             StorageConfig `yaml:"storage,omitempty"`
-			}{
+			Registry: RegistryConfig{
 				MaxRetries: 3,
 			},
 		}
@@ -350,15 +336,7 @@ func TestConfig(t *testing.T) {
 
 	t.Run("ValidateConfig - Invalid Port", func(t *testing.T) {
 		invalidConfig := &Config{
-			Server: struct {
-				Host         string `yaml:"host,omitempty"`
-				Port         int    `yaml:"port,omitempty"`
-				AuthToken    string `yaml:"auth_token,omitempty"`
-				JWTSecret    string `yaml:"jwt_secret,omitempty"`
-				ReadTimeout  string `yaml:"read_timeout,omitempty"`
-				WriteTimeout string `yaml:"write_timeout,omitempty"`
-				IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-			}{
+			Server: ServerConfig{
 				Host: "localhost",
 				Port: 0, // Invalid port
 			},
@@ -369,54 +347,33 @@ func TestConfig(t *testing.T) {
 		assert.Contains(t, err.Error(), "port must be between 1 and 65535")
 	})
 
-	t.Run("ValidateConfig - Auth Enabled without Secret", func(t *testing.T) {
+	t.Run("ValidateConfig - Auth Enabled without Credentials", func(t *testing.T) {
 		invalidConfig := &Config{
-			Server: struct {
-				Host         string `yaml:"host,omitempty"`
-				Port         int    `yaml:"port,omitempty"`
-				AuthToken    string `yaml:"auth_token,omitempty"`
-				JWTSecret    string `yaml:"jwt_secret,omitempty"`
-				ReadTimeout  string `yaml:"read_timeout,omitempty"`
-				WriteTimeout string `yaml:"write_timeout,omitempty"`
-				IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-			}{
+			Server: ServerConfig{
 				Host: "localhost",
 				Port: 3001,
 			},
-			Auth: struct {
-				Enabled     bool     `yaml:"enabled,omitempty"`
-				JWTSecret   string   `yaml:"jwt_secret,omitempty"`
-				TokenExpiry string   `yaml:"token_expiry,omitempty"`
-				AllowedIPs  []string `yaml:"allowed_ips,omitempty"`
-			}{
+			Auth: AuthConfig{
 				Enabled: true,
-				// JWTSecret is empty
+				// Neither OIDC nor a server token is configured
 			},
 		}
 
 		err := ValidateConfig(invalidConfig)
 		assert.Error(t, err)
-		assert.Contains(t, err.Error(), "JWT secret is required")
+		assert.Contains(t, err.Error(), "OIDC issuer or server auth token is required")
 	})
 }
 
 func TestTimeoutParsing(t *testing.T) {
 	config := &Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
 	}
 
-	server := NewServer(config)
+	server := newTestServer(t, config)
 
 	t.Run("Valid Timeout", func(t *testing.T) {
 		duration := server.parseTimeout("30s")
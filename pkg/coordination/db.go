@@ -1,20 +1,42 @@
 package coordination
 
+import (
+	"os"
+
+	"github.com/nexus/nexus/pkg/paths"
+)
+
 const (
-	DBVersion = 1
+	DBVersion = 12
 )
 
+// Migration is a versioned schema change with its rollback.
+// PostgresUp and PostgresDown replace Up and Down on PostgreSQL when the SQLite SQL isn't portable.
 type Migration struct {
-	Version int
-	Name    string
-	SQL     string
+	Version      int
+	Name         string
+	Up           string
+	Down         string
+	PostgresUp   string
+	PostgresDown string
+}
+
+// forDialect returns the migration with the SQL to run on the given dialect
+func (m Migration) forDialect(d dialect) Migration {
+	if d == dialectPostgres && m.PostgresUp != "" {
+		m.Up = m.PostgresUp
+		m.Down = m.PostgresDown
+	}
+	m.PostgresUp = ""
+	m.PostgresDown = ""
+	return m
 }
 
 var migrations = []Migration{
 	{
 		Version: 1,
 		Name:    "initial_schema",
-		SQL: `
+		Up: `
 CREATE TABLE IF NOT EXISTS github_installations (
 	id INTEGER PRIMARY KEY,
 	installation_id INTEGER,
@@ -93,6 +115,441 @@ CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
 CREATE INDEX IF NOT EXISTS idx_workspaces_user_id ON workspaces(user_id);
 CREATE INDEX IF NOT EXISTS idx_workspaces_status ON workspaces(status);
 CREATE INDEX IF NOT EXISTS idx_services_workspace_id ON services(workspace_id);
+`,
+		Down: `
+DROP TABLE IF EXISTS services;
+DROP TABLE IF EXISTS workspaces;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS github_forks;
+DROP TABLE IF EXISTS github_installations;
+`,
+		// PostgreSQL leaves the foreign keys out: SQLite never enforced them and
+		// callers rely on creating workspaces for users known only to the auth layer
+		PostgresUp: `
+CREATE TABLE IF NOT EXISTS github_installations (
+	id BIGSERIAL PRIMARY KEY,
+	installation_id BIGINT,
+	user_id TEXT NOT NULL UNIQUE,
+	github_user_id BIGINT NOT NULL,
+	github_username TEXT NOT NULL,
+	repo_full_name TEXT,
+	token TEXT NOT NULL,
+	token_expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS github_forks (
+	id BIGSERIAL PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	original_owner TEXT NOT NULL,
+	original_repo TEXT NOT NULL,
+	fork_owner TEXT NOT NULL,
+	fork_url TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(user_id, original_owner, original_repo)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	public_key TEXT,
+	workspace_id TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS workspaces (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	workspace_name TEXT NOT NULL,
+	status TEXT,
+	provider TEXT,
+	image TEXT,
+	repo_owner TEXT,
+	repo_name TEXT,
+	repo_url TEXT,
+	repo_branch TEXT,
+	repo_commit TEXT,
+	fork_created BOOLEAN DEFAULT FALSE,
+	fork_url TEXT,
+	ssh_port INTEGER,
+	ssh_host TEXT,
+	node_id TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS services (
+	id TEXT PRIMARY KEY,
+	workspace_id TEXT NOT NULL,
+	service_name TEXT NOT NULL,
+	command TEXT NOT NULL,
+	port INTEGER NOT NULL,
+	local_port INTEGER,
+	status TEXT,
+	health_status TEXT,
+	last_health_check TIMESTAMPTZ,
+	depends_on TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_github_installations_user_id ON github_installations(user_id);
+CREATE INDEX IF NOT EXISTS idx_github_forks_user_id ON github_forks(user_id);
+CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
+CREATE INDEX IF NOT EXISTS idx_workspaces_user_id ON workspaces(user_id);
+CREATE INDEX IF NOT EXISTS idx_workspaces_status ON workspaces(status);
+CREATE INDEX IF NOT EXISTS idx_services_workspace_id ON services(workspace_id);
+`,
+		PostgresDown: `
+DROP TABLE IF EXISTS services;
+DROP TABLE IF EXISTS workspaces;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS github_forks;
+DROP TABLE IF EXISTS github_installations;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "workspace_registry",
+		Up: `
+CREATE INDEX IF NOT EXISTS idx_workspaces_user_name ON workspaces(user_id, workspace_name);
+CREATE INDEX IF NOT EXISTS idx_workspaces_node_id ON workspaces(node_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_services_workspace_service ON services(workspace_id, service_name);
+`,
+		Down: `
+DROP INDEX IF EXISTS idx_services_workspace_service;
+DROP INDEX IF EXISTS idx_workspaces_node_id;
+DROP INDEX IF EXISTS idx_workspaces_user_name;
+`,
+	},
+	{
+		Version: 3,
+		Name:    "nodes",
+		Up: `
+CREATE TABLE IF NOT EXISTS nodes (
+	id TEXT PRIMARY KEY,
+	name TEXT,
+	provider TEXT,
+	status TEXT,
+	address TEXT,
+	port INTEGER,
+	labels TEXT,
+	capabilities TEXT,
+	services TEXT,
+	metadata TEXT,
+	last_seen DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_nodes_status ON nodes(status);
+`,
+		Down: `
+DROP INDEX IF EXISTS idx_nodes_status;
+DROP TABLE IF EXISTS nodes;
+`,
+		PostgresUp: `
+CREATE TABLE IF NOT EXISTS nodes (
+	id TEXT PRIMARY KEY,
+	name TEXT,
+	provider TEXT,
+	status TEXT,
+	address TEXT,
+	port INTEGER,
+	labels TEXT,
+	capabilities TEXT,
+	services TEXT,
+	metadata TEXT,
+	last_seen TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_nodes_status ON nodes(status);
+`,
+		PostgresDown: `
+DROP INDEX IF EXISTS idx_nodes_status;
+DROP TABLE IF EXISTS nodes;
+`,
+	},
+	{
+		Version: 4,
+		Name:    "workspace_stateless",
+		Up: `
+ALTER TABLE workspaces ADD COLUMN stateless BOOLEAN NOT NULL DEFAULT FALSE;
+`,
+		Down: `
+ALTER TABLE workspaces DROP COLUMN stateless;
+`,
+	},
+	{
+		Version: 5,
+		Name:    "access_control",
+		Up: `
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'developer';
+
+CREATE TABLE IF NOT EXISTS workspace_grants (
+	workspace_id TEXT NOT NULL,
+	username TEXT NOT NULL,
+	role TEXT NOT NULL,
+	granted_by TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (workspace_id, username)
+);
+
+CREATE INDEX IF NOT EXISTS idx_workspace_grants_username ON workspace_grants(username);
+`,
+		Down: `
+DROP INDEX IF EXISTS idx_workspace_grants_username;
+DROP TABLE IF EXISTS workspace_grants;
+ALTER TABLE users DROP COLUMN role;
+`,
+		PostgresUp: `
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'developer';
+
+CREATE TABLE IF NOT EXISTS workspace_grants (
+	workspace_id TEXT NOT NULL,
+	username TEXT NOT NULL,
+	role TEXT NOT NULL,
+	granted_by TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (workspace_id, username)
+);
+
+CREATE INDEX IF NOT EXISTS idx_workspace_grants_username ON workspace_grants(username);
+`,
+		PostgresDown: `
+DROP INDEX IF EXISTS idx_workspace_grants_username;
+DROP TABLE IF EXISTS workspace_grants;
+ALTER TABLE users DROP COLUMN role;
+`,
+	},
+	{
+		Version: 6,
+		Name:    "api_tokens",
+		Up: `
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id TEXT PRIMARY KEY,
+	username TEXT NOT NULL,
+	name TEXT NOT NULL,
+	prefix TEXT,
+	token_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	expires_at DATETIME,
+	last_used_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (username, name)
+);
+`,
+		Down: `
+DROP TABLE IF EXISTS api_tokens;
+`,
+		PostgresUp: `
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id TEXT PRIMARY KEY,
+	username TEXT NOT NULL,
+	name TEXT NOT NULL,
+	prefix TEXT,
+	token_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	expires_at TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (username, name)
+);
+`,
+		PostgresDown: `
+DROP TABLE IF EXISTS api_tokens;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "node_credentials",
+		Up: `
+CREATE TABLE IF NOT EXISTS enrollment_tokens (
+	id TEXT PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	node_id TEXT,
+	created_by TEXT,
+	expires_at DATETIME NOT NULL,
+	used_at DATETIME,
+	used_by TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS node_credentials (
+	node_id TEXT PRIMARY KEY,
+	secret_hash TEXT NOT NULL UNIQUE,
+	previous_hash TEXT UNIQUE,
+	previous_expires_at DATETIME,
+	issued_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
+`,
+		Down: `
+DROP TABLE IF EXISTS node_credentials;
+DROP TABLE IF EXISTS enrollment_tokens;
+`,
+		PostgresUp: `
+CREATE TABLE IF NOT EXISTS enrollment_tokens (
+	id TEXT PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	node_id TEXT,
+	created_by TEXT,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	used_by TEXT,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS node_credentials (
+	node_id TEXT PRIMARY KEY,
+	secret_hash TEXT NOT NULL UNIQUE,
+	previous_hash TEXT UNIQUE,
+	previous_expires_at TIMESTAMPTZ,
+	issued_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+`,
+		PostgresDown: `
+DROP TABLE IF EXISTS node_credentials;
+DROP TABLE IF EXISTS enrollment_tokens;
+`,
+	},
+	{
+		Version: 8,
+		Name:    "audit_log",
+		Up: `
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp DATETIME NOT NULL,
+	actor TEXT NOT NULL,
+	actor_kind TEXT NOT NULL,
+	action TEXT NOT NULL,
+	resource TEXT,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	request_id TEXT NOT NULL,
+	source_ip TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	result TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp ON audit_log(timestamp);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor);
+CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(resource);
+`,
+		Down: `
+DROP TABLE IF EXISTS audit_log;
+`,
+		PostgresUp: `
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	timestamp TIMESTAMPTZ NOT NULL,
+	actor TEXT NOT NULL,
+	actor_kind TEXT NOT NULL,
+	action TEXT NOT NULL,
+	resource TEXT,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	request_id TEXT NOT NULL,
+	source_ip TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	result TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_timestamp ON audit_log(timestamp);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor);
+CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(resource);
+`,
+		PostgresDown: `
+DROP TABLE IF EXISTS audit_log;
+`,
+	},
+	{
+		Version: 9,
+		Name:    "workspace_resources",
+		Up: `
+ALTER TABLE workspaces ADD COLUMN cpu INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE workspaces ADD COLUMN memory_mb INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE workspaces ADD COLUMN disk_gb INTEGER NOT NULL DEFAULT 0;
+`,
+		Down: `
+ALTER TABLE workspaces DROP COLUMN disk_gb;
+ALTER TABLE workspaces DROP COLUMN memory_mb;
+ALTER TABLE workspaces DROP COLUMN cpu;
+`,
+	},
+	{
+		Version: 10,
+		Name:    "workspace_activity",
+		Up: `
+ALTER TABLE workspaces ADD COLUMN last_activity_at DATETIME;
+ALTER TABLE workspaces ADD COLUMN keep_alive_until DATETIME;
+`,
+		Down: `
+ALTER TABLE workspaces DROP COLUMN keep_alive_until;
+ALTER TABLE workspaces DROP COLUMN last_activity_at;
+`,
+		PostgresUp: `
+ALTER TABLE workspaces ADD COLUMN last_activity_at TIMESTAMPTZ;
+ALTER TABLE workspaces ADD COLUMN keep_alive_until TIMESTAMPTZ;
+`,
+		PostgresDown: `
+ALTER TABLE workspaces DROP COLUMN keep_alive_until;
+ALTER TABLE workspaces DROP COLUMN last_activity_at;
+`,
+	},
+	{
+		Version: 11,
+		Name:    "node_capacity",
+		Up: `
+ALTER TABLE nodes ADD COLUMN capacity TEXT;
+`,
+		Down: `
+ALTER TABLE nodes DROP COLUMN capacity;
 `,
 	},
+	{
+		Version: 12,
+		Name:    "user_issuer",
+		Up: `
+ALTER TABLE users ADD COLUMN issuer TEXT NOT NULL DEFAULT '';
+`,
+		Down: `
+ALTER TABLE users DROP COLUMN issuer;
+`,
+	},
+}
+
+// Migrations returns the registry schema migrations
+func Migrations() []Migration {
+	result := make([]Migration, len(migrations))
+	copy(result, migrations)
+	return result
+}
+
+// DatabasePath resolves the SQLite database location from the storage configuration,
+// the DB_PATH environment variable and finally the project data directory
+func DatabasePath(storage StorageConfig) string {
+	if storage.Path != "" {
+		return storage.Path
+	}
+	if dbPath := os.Getenv("DB_PATH"); dbPath != "" {
+		return dbPath
+	}
+	return paths.GetDatabasePath(paths.GetProjectRoot())
+}
+
+// PostgresDSN resolves the PostgreSQL connection string from the storage configuration
+// and the DATABASE_URL environment variable
+func PostgresDSN(storage StorageConfig) string {
+	if storage.DSN != "" {
+		return storage.DSN
+	}
+	return os.Getenv("DATABASE_URL")
 }
@@ -3,6 +3,7 @@ package coordination
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,6 +15,7 @@ import (
 )
 
 // handleRegisterNode handles node registration
+// A caller holding an enrollment token receives the node's own credential in the response.
 func (s *Server) handleRegisterNode(w http.ResponseWriter, r *http.Request) {
 	// First decode into a generic map to handle both array and map formats
 	var rawData map[string]interface{}
@@ -40,17 +42,46 @@ func (s *Server) handleRegisterNode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var enrollment NodeCredentialStore
+	if p := s.caller(r); p != nil && p.Kind == PrincipalEnrollment {
+		store, ok := s.enrollNode(w, p, node.ID)
+		if !ok {
+			return
+		}
+		enrollment = store
+	} else if !s.authorizeNode(w, r, node.ID, ActionNodesAdmin) {
+		return
+	}
+
+	goos, goarch := nodeMetadataString(&node, "os"), nodeMetadataString(&node, "arch")
+	if !s.checkAgentProtocol(w, nodeProtocolVersion(&node), goos, goarch) {
+		return
+	}
+
 	if err := s.registry.Register(&node); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to register node: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	registration := NodeRegistration{Node: node}
+	if version := nodeMetadataString(&node, "version"); version != "" {
+		registration.Update = s.pendingAgentUpdate(version, goos, goarch)
+	}
+	if enrollment != nil {
+		issued, err := s.issueNodeCredential(enrollment, node.ID, false)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to issue node credential: %v", err), http.StatusInternalServerError)
+			return
+		}
+		registration.Credential = issued
+	}
+
 	s.broadcastEvent("node_registered", map[string]interface{}{
 		"node": node,
 	})
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(node)
+	json.NewEncoder(w).Encode(registration)
 }
 
 // jsonEncode encodes a map to JSON bytes
@@ -59,8 +90,18 @@ func jsonEncode(data map[string]interface{}) []byte {
 	return b
 }
 
+// NodeListResponse is the body of GET /api/v1/nodes
+type NodeListResponse struct {
+	Nodes []*Node `json:"nodes"`
+	Count int     `json:"count"`
+}
+
 // handleListNodes handles listing all nodes
 func (s *Server) handleListNodes(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, ActionNodesRead) {
+		return
+	}
+
 	nodes, err := s.registry.List()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to list nodes: %v", err), http.StatusInternalServerError)
@@ -68,14 +109,15 @@ func (s *Server) handleListNodes(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"nodes": nodes,
-		"count": len(nodes),
-	})
+	json.NewEncoder(w).Encode(NodeListResponse{Nodes: nodes, Count: len(nodes)})
 }
 
 // handleGetNode handles getting a specific node
 func (s *Server) handleGetNode(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if !s.authorize(w, r, ActionNodesRead) {
+		return
+	}
+
 	node, err := s.registry.Get(nodeID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Node not found: %v", err), http.StatusNotFound)
@@ -86,19 +128,31 @@ func (s *Server) handleGetNode(w http.ResponseWriter, r *http.Request, nodeID st
 	json.NewEncoder(w).Encode(node)
 }
 
+// NodeStatusResponse is the body of GET /api/v1/nodes/{id}/status
+type NodeStatusResponse struct {
+	NodeID   string    `json:"node_id"`
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+	Uptime   string    `json:"uptime"`
+}
+
 // handleGetNodeStatus handles getting node status
 func (s *Server) handleGetNodeStatus(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if !s.authorize(w, r, ActionNodesRead) {
+		return
+	}
+
 	node, err := s.registry.Get(nodeID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Node not found: %v", err), http.StatusNotFound)
 		return
 	}
 
-	status := map[string]interface{}{
-		"node_id":   node.ID,
-		"status":    node.Status,
-		"last_seen": node.LastSeen,
-		"uptime":    time.Since(node.CreatedAt).String(),
+	status := NodeStatusResponse{
+		NodeID:   node.ID,
+		Status:   node.Status,
+		LastSeen: node.LastSeen,
+		Uptime:   time.Since(node.CreatedAt).String(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -107,6 +161,10 @@ func (s *Server) handleGetNodeStatus(w http.ResponseWriter, r *http.Request, nod
 
 // handleUpdateNode handles updating a node
 func (s *Server) handleUpdateNode(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if !s.authorizeNode(w, r, nodeID, ActionNodesAdmin) {
+		return
+	}
+
 	var updates map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
@@ -134,6 +192,10 @@ func (s *Server) handleUpdateNode(w http.ResponseWriter, r *http.Request, nodeID
 
 // handleUnregisterNode handles unregistering a node
 func (s *Server) handleUnregisterNode(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if !s.authorizeNode(w, r, nodeID, ActionNodesAdmin) {
+		return
+	}
+
 	if err := s.registry.Unregister(nodeID); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to unregister node: %v", err), http.StatusInternalServerError)
 		return
@@ -146,8 +208,14 @@ func (s *Server) handleUnregisterNode(w http.ResponseWriter, r *http.Request, no
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// handleSendCommand handles sending commands to nodes
+// handleSendCommand queues a command for a node and answers with the queued command.
+// The node reports the result when it has run it; GET /api/v1/commands/{id}/output
+// follows the output and ends with that result.
 func (s *Server) handleSendCommand(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if !s.authorize(w, r, ActionNodesAdmin) {
+		return
+	}
+
 	var command Command
 	if err := json.NewDecoder(r.Body).Decode(&command); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
@@ -156,9 +224,8 @@ func (s *Server) handleSendCommand(w http.ResponseWriter, r *http.Request, nodeI
 
 	// Generate command ID if not provided
 	if command.ID == "" {
-		command.ID = fmt.Sprintf("cmd_%d_%s", time.Now().Unix(), nodeID)
+		command.ID = fmt.Sprintf("cmd_%d_%s", time.Now().UnixNano(), nodeID)
 	}
-	command.Created = time.Now()
 
 	// Verify node exists
 	_, err := s.registry.Get(nodeID)
@@ -167,31 +234,28 @@ func (s *Server) handleSendCommand(w http.ResponseWriter, r *http.Request, nodeI
 		return
 	}
 
-	// In a real implementation, this would dispatch to the node
-	// For now, we'll simulate a response
-	result := CommandResult{
-		ID:       command.ID,
-		NodeID:   nodeID,
-		Command:  command,
-		Status:   "success",
-		Output:   "Command executed successfully",
-		Duration: time.Millisecond * 100,
-		Finished: time.Now(),
+	// An ID in use would hand the result of one command to the followers of another
+	if _, queued := s.commandQueue.command(command.ID); queued || s.commandStreams.finished(command.ID) {
+		sendM4JSONError(w, http.StatusConflict, "command_exists", fmt.Sprintf("Command %s was already sent", command.ID), nil)
+		return
 	}
 
-	// Send result through channel for broadcasting
-	select {
-	case s.commandCh <- result:
-	default:
-		log.Printf("Command result channel full, dropping result for command %s", command.ID)
-	}
+	command.Target = nodeID
+	command.Created = time.Now()
+	s.commandQueue.enqueue(nodeID, command)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(command)
 }
 
 // handleCommandResult handles receiving command results from nodes
 func (s *Server) handleCommandResult(w http.ResponseWriter, r *http.Request, commandID string) {
+	// Nodes may only report results of commands sent to them
+	if !s.authorizeCommandReport(w, r, commandID) {
+		return
+	}
+
 	var result CommandResult
 	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
@@ -202,19 +266,32 @@ func (s *Server) handleCommandResult(w http.ResponseWriter, r *http.Request, com
 		http.Error(w, "Command ID mismatch", http.StatusBadRequest)
 		return
 	}
-
-	// Send result through channel for broadcasting
-	select {
-	case s.commandCh <- result:
-	default:
-		log.Printf("Command result channel full, dropping result for command %s", commandID)
+	if target, ok := s.commandQueue.target(commandID); ok {
+		result.NodeID = target
 	}
 
+	s.commandQueue.finish(commandID)
+	s.recordCommandResult(result)
+
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// ServiceListResponse is the body of GET /api/v1/services, listing services by node ID
+type ServiceListResponse struct {
+	Services map[string][]NodeService `json:"services"`
+	Nodes    int                      `json:"nodes"`
+}
+
 // handleListServices handles listing all services across all nodes
 func (s *Server) handleListServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorize(w, r, ActionNodesRead) {
+		return
+	}
+
 	nodes, err := s.registry.List()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to list nodes: %v", err), http.StatusInternalServerError)
@@ -232,14 +309,25 @@ func (s *Server) handleListServices(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"services": services,
-		"nodes":    len(nodes),
-	})
+	json.NewEncoder(w).Encode(ServiceListResponse{Services: services, Nodes: len(nodes)})
+}
+
+// HealthResponse is the body of GET /health. An unhealthy server only reports status and error.
+type HealthResponse struct {
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	TotalNodes  int       `json:"total_nodes"`
+	ActiveNodes int       `json:"active_nodes"`
+	Version     string    `json:"version"`
 }
 
 // handleHealth handles health check requests
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 	nodes, err := s.registry.List()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -257,12 +345,12 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	health := map[string]interface{}{
-		"status":       "healthy",
-		"timestamp":    time.Now(),
-		"total_nodes":  len(nodes),
-		"active_nodes": activeNodes,
-		"version":      "1.0.0",
+	health := HealthResponse{
+		Status:      "healthy",
+		Timestamp:   time.Now(),
+		TotalNodes:  len(nodes),
+		ActiveNodes: activeNodes,
+		Version:     "1.0.0",
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -271,6 +359,10 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 // handleMetrics handles metrics requests
 func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 	nodes, err := s.registry.List()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to list nodes: %v", err), http.StatusInternalServerError)
@@ -313,6 +405,14 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 // handleWebSocket handles WebSocket connections for real-time updates
 // Simplified version using Server-Sent Events since WebSocket upgrade requires gorilla/websocket
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorize(w, r, ActionNodesRead) {
+		return
+	}
+
 	if r.Header.Get("Upgrade") == "websocket" {
 		// For WebSocket support, gorilla/websocket would be needed
 		// For now, we'll use Server-Sent Events as fallback
@@ -395,20 +495,21 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Simple Bearer token validation
 		parts := strings.SplitN(authHeader, " ", 2)
 		if len(parts) != 2 || parts[0] != "Bearer" {
 			http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
 			return
 		}
 
-		token := parts[1]
-		if token != s.config.Server.AuthToken && token != s.config.Auth.JWTSecret {
+		principal, err := s.authenticate(r.Context(), parts[1])
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		setRequestActor(r, principal)
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
 	})
 }
 
@@ -416,13 +517,21 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		requestID := requestIDFor(r)
+		w.Header().Set("X-Request-ID", requestID)
+
 		// Wrap response writer to capture status code
 		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		actor := &requestActor{}
 
-		next.ServeHTTP(wrapped, r)
+		next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), requestActorKey{}, actor)))
 
 		duration := time.Since(start)
 		log.Printf("%s %s %d %v", r.Method, r.URL.Path, wrapped.statusCode, duration)
+
+		if shouldAudit(r) {
+			s.recordAudit(r, actor.principal, requestID, wrapped.statusCode, duration)
+		}
 	})
 }
 
@@ -461,7 +570,23 @@ func (s *Server) handleUserRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	username := strings.Split(path, "/")[0]
+	parts := strings.Split(path, "/")
+	username := parts[0]
+
+	if len(parts) == 2 && parts[1] == "role" {
+		s.handleSetUserRole(w, r, username)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "usage" {
+		s.handleUserUsage(w, r, username)
+		return
+	}
+
+	if len(parts) != 1 {
+		http.Error(w, "Invalid endpoint", http.StatusNotFound)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
@@ -513,9 +638,26 @@ func (s *Server) handleRegisterUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	admin := s.can(r, ActionUsersAdmin)
+	if !s.isCaller(r, user.Username) && !admin {
+		sendForbidden(w, ActionUsersAdmin)
+		return
+	}
+	// Only admins choose IDs and roles; everyone else registers with a generated ID and
+	// the default role, so they can't take over another user's ID
+	if !admin {
+		user.ID = ""
+		user.Issuer = ""
+		user.Role = ""
+	}
+
 	userRegistry := s.registry.GetUserRegistry()
 	if err := userRegistry.Register(&user); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to register user: %v", err), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrUserExists) {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("Failed to register user: %v", err), status)
 		return
 	}
 
@@ -525,7 +667,17 @@ func (s *Server) handleRegisterUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
+// UserListResponse is the body of GET /api/v1/users
+type UserListResponse struct {
+	Users []*User `json:"users"`
+	Count int     `json:"count"`
+}
+
 func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, ActionUsersRead) {
+		return
+	}
+
 	userRegistry := s.registry.GetUserRegistry()
 	users, err := userRegistry.List()
 	if err != nil {
@@ -534,10 +686,14 @@ func (s *Server) handleListUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"users": users, "count": len(users)})
+	json.NewEncoder(w).Encode(UserListResponse{Users: users, Count: len(users)})
 }
 
 func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request, username string) {
+	if !s.isCaller(r, username) && !s.authorize(w, r, ActionUsersRead) {
+		return
+	}
+
 	userRegistry := s.registry.GetUserRegistry()
 	user, err := userRegistry.GetByUsername(username)
 	if err != nil {
@@ -550,18 +706,37 @@ func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request, username
 }
 
 func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request, username string) {
+	if !s.authorize(w, r, ActionUsersAdmin) {
+		return
+	}
+
 	userRegistry := s.registry.GetUserRegistry()
 	if err := userRegistry.Delete(username); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to delete user: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	if store, ok := s.apiTokenStore(); ok {
+		tokens, err := store.ListAPITokens(username)
+		if err == nil {
+			for _, token := range tokens {
+				if err := store.DeleteAPIToken(token.ID); err != nil {
+					log.Printf("Failed to revoke API token %s of deleted user %s: %v", token.ID, username, err)
+				}
+			}
+		}
+	}
+
 	s.broadcastEvent("user_deleted", map[string]interface{}{"username": username})
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (s *Server) handleGetWorkspaceUsers(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	if !s.authorize(w, r, ActionWorkspacesRead) {
+		return
+	}
+
 	userRegistry := s.registry.GetUserRegistry()
 	users, err := userRegistry.GetByWorkspace(workspaceID)
 	if err != nil {
@@ -572,7 +747,12 @@ func (s *Server) handleGetWorkspaceUsers(w http.ResponseWriter, r *http.Request,
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{"users": users, "count": len(users)})
 }
+
 func (s *Server) handleGetWorkspaceServices(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	if !s.authorize(w, r, ActionWorkspacesRead) {
+		return
+	}
+
 	// For now, return mock services. In a real implementation, this would query
 	// the workspace services from the local nexus instance or coordination registry
 	services := []map[string]interface{}{}
@@ -628,7 +808,7 @@ type GitHubOAuthCallbackResponse struct {
 // Query params: code (authorization code), state (CSRF token)
 // Returns: JSON response with status or redirects to success/error page
 func (s *Server) handleGitHubOAuthCallback(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -767,10 +947,17 @@ func (s *Server) handleGetGitHubToken(w http.ResponseWriter, r *http.Request) {
 	// Extract user ID from request context or header
 	// In a real implementation, this would come from authenticated user context
 	userID := r.Header.Get("X-User-ID")
+	if p := s.caller(r); userID == "" && p != nil && p.Kind == PrincipalUser {
+		userID = p.Username
+	}
 	if userID == "" {
 		http.Error(w, "User ID required", http.StatusUnauthorized)
 		return
 	}
+	// Installation tokens grant access to the user's repositories, so only hand them to their owner
+	if !s.isCaller(r, userID) && !s.authorize(w, r, ActionAdmin) {
+		return
+	}
 
 	s.gitHubInstallationsMu.RLock()
 	installation, exists := s.gitHubInstallations[userID]
@@ -786,11 +973,11 @@ func (s *Server) handleGetGitHubToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := map[string]interface{}{
-		"token":       installation.Token,
-		"expires_at":  installation.TokenExpiresAt,
-		"user_id":     installation.UserID,
-		"github_user": installation.GitHubUsername,
+	resp := GitHubTokenResponse{
+		Token:      installation.Token,
+		ExpiresAt:  installation.TokenExpiresAt,
+		UserID:     installation.UserID,
+		GitHubUser: installation.GitHubUsername,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -798,6 +985,14 @@ func (s *Server) handleGetGitHubToken(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// GitHubTokenResponse is the body of GET /api/github/token
+type GitHubTokenResponse struct {
+	Token      string    `json:"token"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserID     string    `json:"user_id"`
+	GitHubUser string    `json:"github_user"`
+}
+
 // GitHubOAuthURLRequest is the request to generate an OAuth authorization URL
 type GitHubOAuthURLRequest struct {
 	RepoFullName string `json:"repo_full_name"`
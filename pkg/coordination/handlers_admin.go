@@ -0,0 +1,62 @@
+package coordination
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// handleAdminExport exports the full server state as a JSON snapshot. GitHub installation
+// tokens are left out unless secrets=true is given.
+// GET /api/v1/admin/export
+func (s *Server) handleAdminExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.authorize(w, r, ActionAdmin) {
+		return
+	}
+
+	snapshot, err := s.ExportSnapshot(r.URL.Query().Get("secrets") == "true")
+	if err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "export_failed", fmt.Sprintf("Failed to export state: %v", err), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// handleAdminImport imports a JSON snapshot into the server state
+// POST /api/v1/admin/import
+func (s *Server) handleAdminImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.authorize(w, r, ActionAdmin) {
+		return
+	}
+
+	var snapshot Snapshot
+	if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_request", "Invalid snapshot", map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	result, err := s.ImportSnapshot(&snapshot)
+	if err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "import_failed", fmt.Sprintf("Failed to import state: %v", err), map[string]interface{}{
+			"imported": result,
+		})
+		return
+	}
+
+	s.broadcastEvent("state_imported", result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -57,6 +57,15 @@ type M4CreateWorkspaceRequest struct {
 	Provider       string                `json:"provider"`
 	Image          string                `json:"image"`
 	Services       []M4ServiceDefinition `json:"services"`
+	Stateless      bool                  `json:"stateless,omitempty"`
+	Resources      *M4Resources          `json:"resources,omitempty"`
+}
+
+// M4Resources sizes a workspace. Zero fields take the server defaults.
+type M4Resources struct {
+	CPU      int   `json:"cpu,omitempty"`
+	MemoryMB int64 `json:"memory_mb,omitempty"`
+	DiskGB   int64 `json:"disk_gb,omitempty"`
 }
 
 type M4CreateWorkspaceResponse struct {
@@ -146,13 +155,19 @@ func generateRequestID() string {
 }
 
 func sendM4JSONError(w http.ResponseWriter, statusCode int, errorCode string, message string, details map[string]interface{}) {
+	// Reuse the ID assigned by loggingMiddleware so errors can be matched to the audit log
+	requestID := w.Header().Get("X-Request-ID")
+	if requestID == "" {
+		requestID = generateRequestID()
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	resp := M4ErrorResponse{
 		Error:     errorCode,
 		Message:   message,
 		Details:   details,
-		RequestID: generateRequestID(),
+		RequestID: requestID,
 	}
 	json.NewEncoder(w).Encode(resp)
 }
@@ -181,6 +196,10 @@ func (s *Server) handleM4RegisterGitHub(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !s.isCaller(r, req.GitHubUsername) && !s.authorize(w, r, ActionUsersAdmin) {
+		return
+	}
+
 	userRegistry := s.registry.GetUserRegistry()
 	_, err := userRegistry.GetByUsername(req.GitHubUsername)
 	if err == nil {
@@ -237,6 +256,32 @@ func (s *Server) handleM4CreateWorkspace(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	resources := M4Resources{CPU: defaultWorkspaceCPU, MemoryMB: defaultWorkspaceMemoryMB, DiskGB: defaultWorkspaceDiskGB}
+	if req.Resources != nil {
+		if req.Resources.CPU < 0 || req.Resources.MemoryMB < 0 || req.Resources.DiskGB < 0 {
+			sendM4JSONError(w, http.StatusBadRequest, "invalid_resources", "Resources cannot be negative", nil)
+			return
+		}
+		if req.Resources.CPU > 0 {
+			resources.CPU = req.Resources.CPU
+		}
+		if req.Resources.MemoryMB > 0 {
+			resources.MemoryMB = req.Resources.MemoryMB
+		}
+		if req.Resources.DiskGB > 0 {
+			resources.DiskGB = req.Resources.DiskGB
+		}
+	}
+
+	if !s.authorize(w, r, ActionWorkspacesWrite) {
+		return
+	}
+	// Admins and operators may create workspaces on behalf of other users
+	if !s.isCaller(r, req.GitHubUsername) && !s.can(r, ActionNodesAdmin) {
+		sendM4JSONError(w, http.StatusForbidden, "forbidden", "Cannot create workspaces for another user", nil)
+		return
+	}
+
 	userRegistry := s.registry.GetUserRegistry()
 	user, err := userRegistry.GetByUsername(req.GitHubUsername)
 	if err != nil {
@@ -296,13 +341,50 @@ func (s *Server) handleM4CreateWorkspace(w http.ResponseWriter, r *http.Request)
 		RepoName:      repoName,
 		RepoURL:       repoURL,
 		RepoBranch:    req.Repository.Branch,
+		Stateless:     req.Stateless,
+		CPU:           resources.CPU,
+		MemoryMB:      resources.MemoryMB,
+		DiskGB:        resources.DiskGB,
+	}
+
+	s.quotaMu.Lock()
+	violation, err := s.checkQuota(user, QuotaUsage{
+		Workspaces: 1,
+		Running:    1,
+		CPU:        ws.CPU,
+		MemoryMB:   ws.MemoryMB,
+		DiskGB:     ws.DiskGB,
+	})
+	if err == nil && violation == nil {
+		err = s.workspaceRegistry.Create(ws)
+	}
+	s.quotaMu.Unlock()
+
+	if violation != nil {
+		sendQuotaError(w, violation)
+		return
 	}
-
-	if err := s.workspaceRegistry.Create(ws); err != nil {
+	if err != nil {
 		sendM4JSONError(w, http.StatusInternalServerError, "workspace_creation_failed", fmt.Sprintf("Failed to create workspace: %v", err), nil)
 		return
 	}
 
+	for _, def := range req.Services {
+		svc := &DBService{
+			ServiceID:    fmt.Sprintf("%s-%s", workspaceID, def.Name),
+			WorkspaceID:  workspaceID,
+			ServiceName:  def.Name,
+			Command:      def.Command,
+			Port:         def.Port,
+			Status:       "pending",
+			HealthStatus: "unknown",
+			DependsOn:    def.DependsOn,
+		}
+		if err := s.workspaceRegistry.SaveService(svc); err != nil {
+			fmt.Printf("Failed to save service %s for workspace %s: %v\n", def.Name, workspaceID, err)
+		}
+	}
+
 	go s.provisionWorkspace(context.Background(), workspaceID, user.ID, req, int(sshPort), installation.Token)
 
 	resp := M4CreateWorkspaceResponse{
@@ -363,6 +445,10 @@ func (s *Server) handleM4GetWorkspaceStatus(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if !s.authorizeWorkspace(w, r, ws, GrantViewer) {
+		return
+	}
+
 	sshPort := 2222
 	if ws.SSHPort != nil {
 		sshPort = *ws.SSHPort
@@ -385,17 +471,7 @@ func (s *Server) handleM4GetWorkspaceStatus(w http.ResponseWriter, r *http.Reque
 			User:        "dev",
 			KeyRequired: "~/.ssh/id_ed25519",
 		},
-		Services: map[string]M4ServiceStatus{
-			"web": {
-				Name:       "web",
-				Status:     "running",
-				Port:       3000,
-				MappedPort: 23000,
-				Health:     "healthy",
-				URL:        "http://localhost:23000",
-				LastCheck:  time.Now(),
-			},
-		},
+		Services: s.workspaceServiceStatuses(ws.WorkspaceID, sshHost),
 		Repository: M4Repository{
 			Owner:  ws.RepoOwner,
 			Name:   ws.RepoName,
@@ -412,6 +488,36 @@ func (s *Server) handleM4GetWorkspaceStatus(w http.ResponseWriter, r *http.Reque
 	json.NewEncoder(w).Encode(resp)
 }
 
+// workspaceServiceStatuses builds the service status map from the persisted service rows
+func (s *Server) workspaceServiceStatuses(workspaceID, host string) map[string]M4ServiceStatus {
+	statuses := make(map[string]M4ServiceStatus)
+
+	services, err := s.workspaceRegistry.ListServices(workspaceID)
+	if err != nil {
+		fmt.Printf("Failed to list services for workspace %s: %v\n", workspaceID, err)
+		return statuses
+	}
+
+	for _, svc := range services {
+		status := M4ServiceStatus{
+			Name:   svc.ServiceName,
+			Status: svc.Status,
+			Port:   svc.Port,
+			Health: svc.HealthStatus,
+		}
+		if svc.LocalPort != nil {
+			status.MappedPort = *svc.LocalPort
+			status.URL = fmt.Sprintf("http://%s:%d", host, *svc.LocalPort)
+		}
+		if svc.LastHealthCheck != nil {
+			status.LastCheck = *svc.LastHealthCheck
+		}
+		statuses[svc.ServiceName] = status
+	}
+
+	return statuses
+}
+
 func (s *Server) handleM4StopWorkspace(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -426,11 +532,16 @@ func (s *Server) handleM4StopWorkspace(w http.ResponseWriter, r *http.Request) {
 
 	workspaceID := parts[0]
 
-	if _, err := s.workspaceRegistry.Get(workspaceID); err != nil {
+	ws, err := s.workspaceRegistry.Get(workspaceID)
+	if err != nil {
 		sendM4JSONError(w, http.StatusNotFound, "workspace_not_found", fmt.Sprintf("Workspace not found: %s", workspaceID), nil)
 		return
 	}
 
+	if !s.authorizeWorkspace(w, r, ws, GrantCollaborator) {
+		return
+	}
+
 	if err := s.workspaceRegistry.UpdateStatus(workspaceID, "stopped"); err != nil {
 		sendM4JSONError(w, http.StatusInternalServerError, "stop_failed", fmt.Sprintf("Failed to stop workspace: %v", err), nil)
 		return
@@ -461,11 +572,16 @@ func (s *Server) handleM4DeleteWorkspace(w http.ResponseWriter, r *http.Request)
 
 	workspaceID := parts[0]
 
-	if _, err := s.workspaceRegistry.Get(workspaceID); err != nil {
+	ws, err := s.workspaceRegistry.Get(workspaceID)
+	if err != nil {
 		sendM4JSONError(w, http.StatusNotFound, "workspace_not_found", fmt.Sprintf("Workspace not found: %s", workspaceID), nil)
 		return
 	}
 
+	if !s.authorizeWorkspace(w, r, ws, grantOwner) {
+		return
+	}
+
 	if err := s.workspaceRegistry.Delete(workspaceID); err != nil {
 		sendM4JSONError(w, http.StatusInternalServerError, "delete_failed", fmt.Sprintf("Failed to delete workspace: %v", err), nil)
 		return
@@ -502,12 +618,24 @@ func (s *Server) handleM4ListWorkspacesRouter(w http.ResponseWriter, r *http.Req
 		}
 	}
 
-	allWorkspaces, err := s.workspaceRegistry.List()
+	if !s.authorize(w, r, ActionWorkspacesRead) {
+		return
+	}
+
+	listed, err := s.workspaceRegistry.List()
 	if err != nil {
 		sendM4JSONError(w, http.StatusInternalServerError, "list_failed", fmt.Sprintf("Failed to list workspaces: %v", err), nil)
 		return
 	}
 
+	// Only list the workspaces the caller can see
+	allWorkspaces := make([]*DBWorkspace, 0, len(listed))
+	for _, ws := range listed {
+		if s.canAccessWorkspace(r, ws, GrantViewer) {
+			allWorkspaces = append(allWorkspaces, ws)
+		}
+	}
+
 	workspaceItems := make([]M4WorkspaceListItem, 0)
 	for i, ws := range allWorkspaces {
 		if i < offset {
@@ -522,6 +650,11 @@ func (s *Server) handleM4ListWorkspacesRouter(w http.ResponseWriter, r *http.Req
 			sshPort = *ws.SSHPort
 		}
 
+		servicesCount := 0
+		if services, err := s.workspaceRegistry.ListServices(ws.WorkspaceID); err == nil {
+			servicesCount = len(services)
+		}
+
 		item := M4WorkspaceListItem{
 			WorkspaceID:   ws.WorkspaceID,
 			Name:          ws.WorkspaceName,
@@ -530,7 +663,7 @@ func (s *Server) handleM4ListWorkspacesRouter(w http.ResponseWriter, r *http.Req
 			Provider:      ws.Provider,
 			SSHPort:       sshPort,
 			CreatedAt:     ws.CreatedAt,
-			ServicesCount: 1,
+			ServicesCount: servicesCount,
 		}
 		workspaceItems = append(workspaceItems, item)
 	}
@@ -555,25 +688,64 @@ func (s *Server) handleM4WorkspacesRouter(w http.ResponseWriter, r *http.Request
 	}
 
 	parts := strings.Split(path, "/")
+	workspaceID := parts[0]
 
-	if len(parts) >= 2 && parts[1] == "status" {
-		if r.Method == http.MethodGet {
-			s.handleM4GetWorkspaceStatus(w, r)
+	switch {
+	case len(parts) == 1:
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-	}
+		s.handleM4DeleteWorkspace(w, r)
+		return
 
-	if len(parts) >= 2 && parts[1] == "stop" {
-		if r.Method == http.MethodPost {
-			s.handleM4StopWorkspace(w, r)
+	case len(parts) == 2 && parts[1] == "grants":
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-	}
+		s.handleWorkspaceGrants(w, r, workspaceID, "")
+		return
 
-	if r.Method == http.MethodDelete {
-		s.handleM4DeleteWorkspace(w, r)
+	case len(parts) == 3 && parts[1] == "grants":
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleWorkspaceGrants(w, r, workspaceID, parts[2])
+		return
+
+	case len(parts) != 2:
+		http.Error(w, "Invalid endpoint", http.StatusNotFound)
 		return
 	}
 
-	http.Error(w, "Invalid endpoint", http.StatusNotFound)
+	switch parts[1] {
+	case "status":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleM4GetWorkspaceStatus(w, r)
+	case "stop":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleM4StopWorkspace(w, r)
+	case "start":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleM4StartWorkspace(w, r)
+	case "activity":
+		s.handleWorkspaceActivity(w, r, workspaceID)
+	case "extend":
+		s.handleExtendWorkspace(w, r, workspaceID)
+	case "git":
+		s.handleWorkspaceGit(w, r, workspaceID)
+	default:
+		http.Error(w, "Invalid endpoint", http.StatusNotFound)
+	}
 }
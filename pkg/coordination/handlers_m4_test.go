@@ -14,16 +14,8 @@ import (
 )
 
 func TestM4RegisterGitHubUser(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -122,16 +114,8 @@ func TestM4RegisterGitHubUser(t *testing.T) {
 }
 
 func TestM4CreateWorkspace(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -249,16 +233,8 @@ func TestM4CreateWorkspace(t *testing.T) {
 }
 
 func TestM4GetWorkspaceStatus(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -331,16 +307,8 @@ func TestM4GetWorkspaceStatus(t *testing.T) {
 }
 
 func TestM4ListWorkspaces(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -397,16 +365,8 @@ func TestM4ListWorkspaces(t *testing.T) {
 }
 
 func TestM4StopWorkspace(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -477,16 +437,8 @@ func TestM4StopWorkspace(t *testing.T) {
 }
 
 func TestM4DeleteWorkspace(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -579,16 +531,8 @@ func TestM4ErrorResponses(t *testing.T) {
 }
 
 func TestM4HTTPMethods(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -635,16 +579,8 @@ func TestM4HTTPMethods(t *testing.T) {
 }
 
 func TestM4ValidationErrorDetails(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -669,16 +605,8 @@ func TestM4ValidationErrorDetails(t *testing.T) {
 }
 
 func TestM4InvalidJSONRequest(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
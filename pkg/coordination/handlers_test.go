@@ -12,16 +12,8 @@ import (
 )
 
 func TestHandleGetNodeStatus(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("GET", "/api/v1/nodes/test-node/status", nil)
@@ -32,16 +24,8 @@ func TestHandleGetNodeStatus(t *testing.T) {
 }
 
 func TestHandleUpdateNode(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	updates := map[string]interface{}{"status": "inactive"}
@@ -54,16 +38,8 @@ func TestHandleUpdateNode(t *testing.T) {
 }
 
 func TestHandleUnregisterNode(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("DELETE", "/api/v1/nodes/test-node", nil)
@@ -74,16 +50,8 @@ func TestHandleUnregisterNode(t *testing.T) {
 }
 
 func TestHandleSendCommand(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	cmd := map[string]interface{}{
@@ -99,16 +67,8 @@ func TestHandleSendCommand(t *testing.T) {
 }
 
 func TestHandleCommandResult(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	result := CommandResult{
@@ -124,16 +84,8 @@ func TestHandleCommandResult(t *testing.T) {
 }
 
 func TestHandleCommandResultMismatch(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	result := CommandResult{
@@ -149,16 +101,8 @@ func TestHandleCommandResultMismatch(t *testing.T) {
 }
 
 func TestHandleRegisterUser(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	user := User{
@@ -174,16 +118,8 @@ func TestHandleRegisterUser(t *testing.T) {
 }
 
 func TestHandleListUsers(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("GET", "/api/v1/users", nil)
@@ -199,16 +135,8 @@ func TestHandleListUsers(t *testing.T) {
 }
 
 func TestHandleGetUser(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("GET", "/api/v1/users/nonexistent", nil)
@@ -219,16 +147,8 @@ func TestHandleGetUser(t *testing.T) {
 }
 
 func TestHandleDeleteUser(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("DELETE", "/api/v1/users/test-user", nil)
@@ -239,16 +159,8 @@ func TestHandleDeleteUser(t *testing.T) {
 }
 
 func TestHandleGetWorkspaceServices(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("GET", "/api/v1/workspaces/ws-1/services", nil)
@@ -264,16 +176,8 @@ func TestHandleGetWorkspaceServices(t *testing.T) {
 }
 
 func TestHandleGetWorkspaceUsers(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("GET", "/api/v1/workspaces/ws-1/users", nil)
@@ -284,16 +188,8 @@ func TestHandleGetWorkspaceUsers(t *testing.T) {
 }
 
 func TestLoggingMiddleware(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	handler := srv.loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -14,16 +14,8 @@ import (
 
 // TestOAuthIntegration tests the complete GitHub OAuth flow
 func TestOAuthIntegration(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -57,16 +49,8 @@ func TestOAuthIntegration(t *testing.T) {
 
 // TestForkDetectionIntegration tests automatic fork detection during workspace creation
 func TestForkDetectionIntegration(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -170,16 +154,8 @@ func TestSQLitePersistenceAcrossRestarts(t *testing.T) {
 
 // TestWorkspaceCreationWithRegistration tests the complete workflow of registering user and creating workspace
 func TestWorkspaceCreationWithRegistration(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -248,16 +224,8 @@ func TestWorkspaceCreationWithRegistration(t *testing.T) {
 
 // TestMultipleWorkspacesForSameUser tests creating multiple workspaces for the same user
 func TestMultipleWorkspacesForSameUser(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -318,16 +286,8 @@ func TestMultipleWorkspacesForSameUser(t *testing.T) {
 
 // TestGitHubTokenRefresh tests that expired tokens trigger re-auth
 func TestGitHubTokenRefresh(t *testing.T) {
-	server := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{
+	server := newTestServer(t, &Config{
+		Server: ServerConfig{
 			Host: "localhost",
 			Port: 3001,
 		},
@@ -0,0 +1,401 @@
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLifecycleCheckInterval = time.Minute
+	defaultLifecycleWarningPeriod = 15 * time.Minute
+	defaultMaxExtension           = 7 * 24 * time.Hour
+)
+
+// Workspace activity sources
+const (
+	ActivitySSH     = "ssh"
+	ActivityExec    = "exec"
+	ActivityService = "service"
+)
+
+// lifecycleSettings holds the parsed LifecycleConfig. Zero durations disable a check.
+type lifecycleSettings struct {
+	idleTimeout   time.Duration
+	ttl           time.Duration
+	warningPeriod time.Duration
+	checkInterval time.Duration
+	maxExtension  time.Duration
+}
+
+// enabled reports whether any workspace lifecycle check is configured
+func (l lifecycleSettings) enabled() bool {
+	return l.idleTimeout > 0 || l.ttl > 0
+}
+
+// lifecycleSettings parses the lifecycle configuration, falling back to defaults for
+// missing or invalid values
+func (s *Server) lifecycleSettings() lifecycleSettings {
+	parse := func(value string, fallback time.Duration) time.Duration {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		return fallback
+	}
+
+	cfg := s.config.Lifecycle
+	return lifecycleSettings{
+		idleTimeout:   parse(cfg.IdleTimeout, 0),
+		ttl:           parse(cfg.TTL, 0),
+		warningPeriod: parse(cfg.WarningPeriod, defaultLifecycleWarningPeriod),
+		checkInterval: parse(cfg.CheckInterval, defaultLifecycleCheckInterval),
+		maxExtension:  parse(cfg.MaxExtension, defaultMaxExtension),
+	}
+}
+
+// lastActive returns when ws was last used, counting its creation as use
+func lastActive(ws *DBWorkspace) time.Time {
+	if ws.LastActivity != nil && ws.LastActivity.After(ws.CreatedAt) {
+		return *ws.LastActivity
+	}
+	return ws.CreatedAt
+}
+
+// deferred pushes deadline back to the workspace's keep-alive, if that is later
+func deferred(ws *DBWorkspace, deadline time.Time) time.Time {
+	if ws.KeepAliveUntil != nil && ws.KeepAliveUntil.After(deadline) {
+		return *ws.KeepAliveUntil
+	}
+	return deadline
+}
+
+// idleStopAt returns when ws will be stopped for inactivity, or nil if it will not be
+func (l lifecycleSettings) idleStopAt(ws *DBWorkspace) *time.Time {
+	if l.idleTimeout <= 0 || ws.Status != "running" {
+		return nil
+	}
+	at := deferred(ws, lastActive(ws).Add(l.idleTimeout))
+	return &at
+}
+
+// expiresAt returns when ws will be deleted, or nil if it will not be
+func (l lifecycleSettings) expiresAt(ws *DBWorkspace) *time.Time {
+	if l.ttl <= 0 {
+		return nil
+	}
+	at := deferred(ws, ws.CreatedAt.Add(l.ttl))
+	return &at
+}
+
+// runLifecycleReaper stops idle workspaces and deletes expired ones, until stop is closed
+func (s *Server) runLifecycleReaper(stop <-chan struct{}) {
+	settings := s.lifecycleSettings()
+	if !settings.enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(settings.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.sweepWorkspaces(now)
+		}
+	}
+}
+
+// sweepWorkspaces applies the idle timeout and TTL to every workspace, warning once per
+// deadline before acting on it. Nodes stop and delete workspaces concurrently, and the
+// sweep returns once all have answered or the reaper stops.
+func (s *Server) sweepWorkspaces(now time.Time) {
+	settings := s.lifecycleSettings()
+
+	workspaces, err := s.workspaceRegistry.List()
+	if err != nil {
+		log.Printf("Lifecycle reaper: failed to list workspaces: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.reaperStop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for _, ws := range workspaces {
+		if expiresAt := settings.expiresAt(ws); expiresAt != nil {
+			if !now.Before(*expiresAt) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.expireWorkspace(ctx, ws)
+				}()
+				continue
+			}
+			s.warnLifecycle(ws, "workspace_expiry_warning", "expires_at", *expiresAt, now, settings.warningPeriod)
+		}
+
+		if stopAt := settings.idleStopAt(ws); stopAt != nil {
+			if !now.Before(*stopAt) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.stopIdleWorkspace(ctx, ws, now)
+				}()
+				continue
+			}
+			s.warnLifecycle(ws, "workspace_idle_warning", "stops_at", *stopAt, now, settings.warningPeriod)
+		}
+	}
+}
+
+// warnLifecycle broadcasts a warning once deadline is within period. A workspace is warned
+// again only if its deadline moves, e.g. after activity or an extension.
+func (s *Server) warnLifecycle(ws *DBWorkspace, event, field string, deadline, now time.Time, period time.Duration) {
+	if now.Before(deadline.Add(-period)) {
+		return
+	}
+
+	key := event + ":" + ws.WorkspaceID
+	s.lifecycleMu.Lock()
+	warned, ok := s.lifecycleWarnings[key]
+	if ok && warned.Equal(deadline) {
+		s.lifecycleMu.Unlock()
+		return
+	}
+	s.lifecycleWarnings[key] = deadline
+	s.lifecycleMu.Unlock()
+
+	s.broadcastEvent(event, map[string]interface{}{
+		"workspace_id": ws.WorkspaceID,
+		"user_id":      ws.UserID,
+		field:          deadline,
+	})
+}
+
+// forgetLifecycleWarnings drops the warnings recorded for a workspace
+func (s *Server) forgetLifecycleWarnings(workspaceID string) {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+	for key := range s.lifecycleWarnings {
+		if strings.HasSuffix(key, ":"+workspaceID) {
+			delete(s.lifecycleWarnings, key)
+		}
+	}
+}
+
+// onHostingNode has the node hosting ws run a workspace action. Workspaces that were never
+// placed, or whose node is offline, have nothing on a node to act on; an offline node
+// collects what the registry no longer holds once it is back.
+func (s *Server) onHostingNode(ctx context.Context, ws *DBWorkspace, action string) error {
+	if ws.NodeID == nil || *ws.NodeID == "" || ws.Status == WorkspaceStatusUnreachable {
+		return nil
+	}
+	return s.runWorkspaceAction(ctx, ws, action)
+}
+
+// stopIdleWorkspace stops a running workspace that saw no activity for the idle timeout.
+// It is marked stopped once its node confirms, and tried again on the next sweep otherwise.
+func (s *Server) stopIdleWorkspace(ctx context.Context, ws *DBWorkspace, now time.Time) {
+	if err := s.onHostingNode(ctx, ws, "stop"); err != nil {
+		log.Printf("Lifecycle reaper: failed to stop idle workspace %s: %v", ws.WorkspaceID, err)
+		return
+	}
+	if err := s.workspaceRegistry.UpdateStatus(ws.WorkspaceID, "stopped"); err != nil {
+		log.Printf("Lifecycle reaper: failed to stop idle workspace %s: %v", ws.WorkspaceID, err)
+		return
+	}
+	s.forgetLifecycleWarnings(ws.WorkspaceID)
+
+	idle := now.Sub(lastActive(ws)).Round(time.Second)
+	log.Printf("Workspace %s idle for %s, stopped", ws.WorkspaceID, idle)
+	s.broadcastEvent("workspace_idle_stopped", map[string]interface{}{
+		"workspace_id":     ws.WorkspaceID,
+		"user_id":          ws.UserID,
+		"last_activity_at": lastActive(ws),
+	})
+}
+
+// expireWorkspace deletes a workspace that outlived the TTL. It leaves the registry once
+// its node confirms, and is tried again on the next sweep otherwise.
+func (s *Server) expireWorkspace(ctx context.Context, ws *DBWorkspace) {
+	if err := s.onHostingNode(ctx, ws, "delete"); err != nil {
+		log.Printf("Lifecycle reaper: failed to delete expired workspace %s: %v", ws.WorkspaceID, err)
+		return
+	}
+	if err := s.workspaceRegistry.Delete(ws.WorkspaceID); err != nil {
+		log.Printf("Lifecycle reaper: failed to delete expired workspace %s: %v", ws.WorkspaceID, err)
+		return
+	}
+	s.forgetLifecycleWarnings(ws.WorkspaceID)
+
+	log.Printf("Workspace %s reached its TTL, deleted", ws.WorkspaceID)
+	s.broadcastEvent("workspace_expired", map[string]interface{}{
+		"workspace_id": ws.WorkspaceID,
+		"user_id":      ws.UserID,
+	})
+}
+
+// recordActivity moves the last activity of a workspace forward to at. Times in the
+// future are clamped to now so a skewed clock cannot keep a workspace alive.
+func (s *Server) recordActivity(ws *DBWorkspace, at, now time.Time) error {
+	if at.IsZero() || at.After(now) {
+		at = now
+	}
+	if ws.LastActivity != nil && !at.After(*ws.LastActivity) {
+		return nil
+	}
+	return s.workspaceRegistry.Update(ws.WorkspaceID, map[string]interface{}{"last_activity_at": at})
+}
+
+// recordNodeActivity applies the activity a node reported in its heartbeat. Workspaces
+// the node does not host are ignored.
+func (s *Server) recordNodeActivity(nodeID string, activity map[string]time.Time) {
+	now := time.Now()
+	for workspaceID, at := range activity {
+		ws, err := s.workspaceRegistry.Get(workspaceID)
+		if err != nil || ws.NodeID == nil || *ws.NodeID != nodeID {
+			continue
+		}
+		if err := s.recordActivity(ws, at, now); err != nil {
+			log.Printf("Failed to record activity for workspace %s: %v", workspaceID, err)
+		}
+	}
+}
+
+// WorkspaceActivityRequest is the body of POST /api/v1/workspaces/{id}/activity
+type WorkspaceActivityRequest struct {
+	Source string    `json:"source"` // ssh, exec or service
+	At     time.Time `json:"at,omitempty"`
+}
+
+// handleWorkspaceActivity records activity in a workspace. The node hosting the workspace
+// and its collaborators may report activity.
+// POST /api/v1/workspaces/{id}/activity
+func (s *Server) handleWorkspaceActivity(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req WorkspaceActivityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	switch req.Source {
+	case ActivitySSH, ActivityExec, ActivityService:
+	default:
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_source", fmt.Sprintf("Invalid activity source: %s", req.Source), map[string]interface{}{
+			"allowed": []string{ActivitySSH, ActivityExec, ActivityService},
+		})
+		return
+	}
+
+	ws, err := s.workspaceRegistry.Get(workspaceID)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "workspace_not_found", fmt.Sprintf("Workspace not found: %s", workspaceID), nil)
+		return
+	}
+
+	if p := s.caller(r); p != nil && p.Kind == PrincipalNode {
+		if ws.NodeID == nil || *ws.NodeID != p.NodeID {
+			sendM4JSONError(w, http.StatusForbidden, "forbidden", fmt.Sprintf("Workspace %s is not hosted on node %s", workspaceID, p.NodeID), nil)
+			return
+		}
+	} else if !s.authorizeWorkspace(w, r, ws, GrantCollaborator) {
+		return
+	}
+
+	if err := s.recordActivity(ws, req.At, time.Now()); err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "activity_failed", fmt.Sprintf("Failed to record activity: %v", err), nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExtendWorkspaceRequest is the body of POST /api/v1/workspaces/{id}/extend
+type ExtendWorkspaceRequest struct {
+	Duration string `json:"duration"` // e.g. 8h
+}
+
+// ExtendWorkspaceResponse reports the deadlines of a workspace after an extension
+type ExtendWorkspaceResponse struct {
+	WorkspaceID    string     `json:"workspace_id"`
+	KeepAliveUntil time.Time  `json:"keep_alive_until"`
+	IdleStopAt     *time.Time `json:"idle_stop_at,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+}
+
+// handleExtendWorkspace keeps a workspace alive for a while regardless of idleness and TTL
+// POST /api/v1/workspaces/{id}/extend
+func (s *Server) handleExtendWorkspace(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ExtendWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body", map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	settings := s.lifecycleSettings()
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 {
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_duration", fmt.Sprintf("Invalid duration: %q", req.Duration), nil)
+		return
+	}
+	if duration > settings.maxExtension {
+		sendM4JSONError(w, http.StatusBadRequest, "extension_too_long", fmt.Sprintf("Extensions are limited to %s", settings.maxExtension), map[string]interface{}{
+			"max": settings.maxExtension.String(),
+		})
+		return
+	}
+
+	ws, err := s.workspaceRegistry.Get(workspaceID)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "workspace_not_found", fmt.Sprintf("Workspace not found: %s", workspaceID), nil)
+		return
+	}
+
+	if !s.authorizeWorkspace(w, r, ws, GrantCollaborator) {
+		return
+	}
+
+	until := time.Now().Add(duration)
+	if err := s.workspaceRegistry.Update(workspaceID, map[string]interface{}{"keep_alive_until": until}); err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "extend_failed", fmt.Sprintf("Failed to extend workspace: %v", err), nil)
+		return
+	}
+
+	ws, err = s.workspaceRegistry.Get(workspaceID)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "workspace_not_found", fmt.Sprintf("Workspace not found: %s", workspaceID), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ExtendWorkspaceResponse{
+		WorkspaceID:    workspaceID,
+		KeepAliveUntil: until,
+		IdleStopAt:     settings.idleStopAt(ws),
+		ExpiresAt:      settings.expiresAt(ws),
+	})
+}
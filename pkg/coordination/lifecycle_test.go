@@ -0,0 +1,158 @@
+package coordination
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSweepStopsIdleWorkspaces(t *testing.T) {
+	srv := newRBACTestServer(t)
+	srv.config.Lifecycle = LifecycleConfig{IdleTimeout: "1h", WarningPeriod: "10m"}
+	events := subscribeEvents(srv)
+
+	ws, err := srv.workspaceRegistry.Get("ws-1")
+	require.NoError(t, err)
+	now := ws.CreatedAt
+
+	srv.sweepWorkspaces(now.Add(30 * time.Minute))
+	assert.Empty(t, drainEventTypes(events))
+
+	srv.sweepWorkspaces(now.Add(55 * time.Minute))
+	srv.sweepWorkspaces(now.Add(56 * time.Minute))
+	assert.Equal(t, []string{"workspace_idle_warning", "workspace_idle_warning"}, drainEventTypes(events),
+		"ws-1 and ws-2 are each warned once")
+
+	require.NoError(t, srv.recordActivity(ws, now.Add(50*time.Minute), now.Add(time.Hour)))
+	srv.sweepWorkspaces(now.Add(61 * time.Minute))
+	assert.Equal(t, []string{"workspace_idle_stopped"}, drainEventTypes(events), "only ws-2 was idle")
+
+	ws1, err := srv.workspaceRegistry.Get("ws-1")
+	require.NoError(t, err)
+	assert.Equal(t, "running", ws1.Status)
+	ws2, err := srv.workspaceRegistry.Get("ws-2")
+	require.NoError(t, err)
+	assert.Equal(t, "stopped", ws2.Status)
+}
+
+func TestSweepDeletesExpiredWorkspaces(t *testing.T) {
+	srv := newRBACTestServer(t)
+	srv.config.Lifecycle = LifecycleConfig{TTL: "24h"}
+	events := subscribeEvents(srv)
+
+	ws, err := srv.workspaceRegistry.Get("ws-1")
+	require.NoError(t, err)
+	created := ws.CreatedAt
+	keepAlive := created.Add(48 * time.Hour)
+	ws.KeepAliveUntil = &keepAlive
+
+	srv.sweepWorkspaces(created.Add(23*time.Hour + 50*time.Minute))
+	assert.Equal(t, []string{"workspace_expiry_warning"}, drainEventTypes(events))
+
+	srv.sweepWorkspaces(created.Add(25 * time.Hour))
+	assert.Equal(t, []string{"workspace_expired"}, drainEventTypes(events))
+
+	_, err = srv.workspaceRegistry.Get("ws-2")
+	assert.Error(t, err)
+	_, err = srv.workspaceRegistry.Get("ws-1")
+	assert.NoError(t, err, "the keep-alive defers expiry")
+}
+
+func TestSweepActsThroughTheHostingNode(t *testing.T) {
+	srv := newRBACTestServer(t)
+	srv.config.Lifecycle = LifecycleConfig{IdleTimeout: "1h", TTL: "24h"}
+	require.NoError(t, srv.workspaceRegistry.Update("ws-1", map[string]interface{}{"node_id": "node-1"}))
+	require.NoError(t, srv.workspaceRegistry.Update("ws-2", map[string]interface{}{"node_id": "node-1"}))
+	events := subscribeEvents(srv)
+
+	ws, err := srv.workspaceRegistry.Get("ws-1")
+	require.NoError(t, err)
+	created := ws.CreatedAt
+	keepAlive := created.Add(48 * time.Hour)
+	ws2, err := srv.workspaceRegistry.Get("ws-2")
+	require.NoError(t, err)
+	ws2.KeepAliveUntil = &keepAlive
+
+	// ws-1 expired; ws-2 is kept alive past its idle timeout
+	go answerWorkspaceCommand(t, srv, "node-1", "delete", "failed")
+	srv.sweepWorkspaces(created.Add(25 * time.Hour))
+	assert.Empty(t, drainEventTypes(events))
+	_, err = srv.workspaceRegistry.Get("ws-1")
+	assert.NoError(t, err, "the workspace stays registered until its node deletes it")
+
+	go answerWorkspaceCommand(t, srv, "node-1", "delete", "success")
+	srv.sweepWorkspaces(created.Add(25 * time.Hour))
+	assert.Equal(t, []string{"workspace_expired"}, drainEventTypes(events))
+	_, err = srv.workspaceRegistry.Get("ws-1")
+	assert.Error(t, err)
+
+	ws2.KeepAliveUntil = nil
+	srv.config.Lifecycle.TTL = ""
+	go answerWorkspaceCommand(t, srv, "node-1", "stop", "success")
+	srv.sweepWorkspaces(created.Add(2 * time.Hour))
+	assert.Equal(t, []string{"workspace_idle_stopped"}, drainEventTypes(events))
+	ws2, err = srv.workspaceRegistry.Get("ws-2")
+	require.NoError(t, err)
+	assert.Equal(t, "stopped", ws2.Status, "the workspace is marked stopped once its node stopped it")
+}
+
+func TestWorkspaceActivityAndExtend(t *testing.T) {
+	srv := newRBACTestServer(t)
+	srv.config.Lifecycle = LifecycleConfig{IdleTimeout: "2h", TTL: "72h", MaxExtension: "24h"}
+
+	w := rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-1/activity", WorkspaceActivityRequest{Source: "browser"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-1/activity", WorkspaceActivityRequest{Source: ActivitySSH})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = rbacRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/ws-1/activity", WorkspaceActivityRequest{Source: ActivitySSH})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	ws, err := srv.workspaceRegistry.Get("ws-1")
+	require.NoError(t, err)
+	require.NotNil(t, ws.LastActivity)
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-1/extend", ExtendWorkspaceRequest{Duration: "48h"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = rbacRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/ws-1/extend", ExtendWorkspaceRequest{Duration: "8h"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-1/extend", ExtendWorkspaceRequest{Duration: "8h"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp ExtendWorkspaceResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.WithinDuration(t, time.Now().Add(8*time.Hour), resp.KeepAliveUntil, time.Minute)
+	require.NotNil(t, resp.IdleStopAt)
+	assert.True(t, resp.IdleStopAt.Equal(resp.KeepAliveUntil), "the keep-alive outlasts the idle timeout")
+	require.NotNil(t, resp.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(72*time.Hour), *resp.ExpiresAt, time.Minute)
+}
+
+func TestHeartbeatRecordsNodeActivity(t *testing.T) {
+	srv := newRBACTestServer(t)
+	credential := enrollTestNode(t, srv, createTestEnrollmentToken(t, srv, CreateEnrollmentTokenRequest{}), "node-1")
+	require.NoError(t, srv.workspaceRegistry.Update("ws-1", map[string]interface{}{"node_id": "node-1"}))
+
+	at := time.Now().Add(-time.Minute).Truncate(time.Second)
+	w := rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/nodes/node-1/heartbeat", NodeHeartbeat{
+		Activity: map[string]time.Time{"ws-1": at, "ws-2": at},
+	})
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	ws1, err := srv.workspaceRegistry.Get("ws-1")
+	require.NoError(t, err)
+	require.NotNil(t, ws1.LastActivity)
+	assert.True(t, ws1.LastActivity.Equal(at))
+
+	ws2, err := srv.workspaceRegistry.Get("ws-2")
+	require.NoError(t, err)
+	assert.Nil(t, ws2.LastActivity, "nodes only report activity for workspaces they host")
+
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/workspaces/ws-2/activity", WorkspaceActivityRequest{Source: ActivityService})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/workspaces/ws-1/activity", WorkspaceActivityRequest{Source: ActivityService})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
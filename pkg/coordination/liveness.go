@@ -0,0 +1,483 @@
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// NodeStatusOffline is set on nodes whose heartbeats stopped for longer than the node timeout
+const NodeStatusOffline = "offline"
+
+// WorkspaceStatusUnreachable is set on running workspaces whose node went offline
+const WorkspaceStatusUnreachable = "unreachable"
+
+// WorkspaceStatusMissing is set on workspaces whose node reports their container is gone
+const WorkspaceStatusMissing = "missing"
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultNodeTimeout         = 60 * time.Second
+
+	// workspaceRecreateTimeout bounds how long a node may take to recreate a rescheduled workspace
+	workspaceRecreateTimeout = 5 * time.Minute
+)
+
+// NodeHeartbeat is the body agents send to POST /api/v1/nodes/{id}/heartbeat
+type NodeHeartbeat struct {
+	Status   string               `json:"status,omitempty"`
+	Activity map[string]time.Time `json:"activity,omitempty"` // workspace ID -> last SSH, exec or service traffic
+	Drift    *WorkspaceDrift      `json:"drift,omitempty"`
+	Capacity *NodeCapacity        `json:"capacity,omitempty"`
+	Orphans  []OrphanedResource   `json:"orphans,omitempty"`
+
+	// ProtocolVersion is the agent protocol the node speaks; 0 means 1
+	ProtocolVersion int `json:"protocol_version,omitempty"`
+}
+
+// WorkspaceDrift is what an agent found when it reconciled its saved workspaces with its
+// providers after a restart
+type WorkspaceDrift struct {
+	Adopted  []string `json:"adopted,omitempty"`  // running but missing from the saved state
+	Vanished []string `json:"vanished,omitempty"` // saved but gone from the provider
+}
+
+// Kinds of orphaned resources found by agent garbage collection
+const (
+	OrphanSession   = "session"   // a provider session labelled for an unknown workspace
+	OrphanDisk      = "disk"      // VM files of an unknown workspace
+	OrphanWorkspace = "workspace" // a workspace whose creation failed
+	OrphanPort      = "port"      // an allocated port no workspace holds
+)
+
+// OrphanedResource is something an agent holds for a workspace that no longer exists. It
+// is deleted once DeleteAfter has passed.
+type OrphanedResource struct {
+	Kind        string    `json:"kind"`
+	Provider    string    `json:"provider,omitempty"`
+	ID          string    `json:"id"`
+	WorkspaceID string    `json:"workspace_id,omitempty"`
+	FirstSeen   time.Time `json:"first_seen"`
+	DeleteAfter time.Time `json:"delete_after"`
+	Deleted     bool      `json:"deleted,omitempty"`
+	Error       string    `json:"error,omitempty"` // why deleting it failed
+}
+
+// livenessSettings returns how often nodes are checked and how long a node may go without a heartbeat
+func (s *Server) livenessSettings() (interval, timeout time.Duration) {
+	interval = defaultHealthCheckInterval
+	if d, err := time.ParseDuration(s.config.Registry.HealthCheckInterval); err == nil && d > 0 {
+		interval = d
+	}
+
+	timeout = defaultNodeTimeout
+	if d, err := time.ParseDuration(s.config.Registry.NodeTimeout); err == nil && d > 0 {
+		timeout = d
+	}
+
+	return interval, timeout
+}
+
+// runNodeReaper marks nodes offline once their heartbeats stop, until stop is closed
+func (s *Server) runNodeReaper(stop <-chan struct{}) {
+	interval, timeout := s.livenessSettings()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.reapStaleNodes(now, timeout)
+		}
+	}
+}
+
+// reapStaleNodes marks nodes offline whose last heartbeat is older than timeout and
+// fails over their workspaces. It returns the IDs of the nodes it marked offline.
+func (s *Server) reapStaleNodes(now time.Time, timeout time.Duration) []string {
+	nodes, err := s.registry.List()
+	if err != nil {
+		log.Printf("Node reaper: failed to list nodes: %v", err)
+		return nil
+	}
+
+	var reaped []string
+	for _, node := range nodes {
+		if node.Status == NodeStatusOffline || now.Sub(node.LastSeen) <= timeout {
+			continue
+		}
+
+		// A heartbeat arriving since the list was read keeps the node online
+		lastSeen := node.LastSeen
+		updates := map[string]interface{}{"status": NodeStatusOffline, "last_seen": lastSeen, "if_last_seen": lastSeen}
+		if err := s.registry.Update(node.ID, updates); errors.Is(err, ErrNodeChanged) {
+			continue
+		} else if err != nil {
+			log.Printf("Node reaper: failed to mark node %s offline: %v", node.ID, err)
+			continue
+		}
+		reaped = append(reaped, node.ID)
+
+		log.Printf("Node %s missed heartbeats for %s, marked offline", node.ID, now.Sub(lastSeen).Round(time.Second))
+		s.broadcastEvent("node_offline", map[string]interface{}{
+			"node_id":   node.ID,
+			"last_seen": lastSeen,
+		})
+
+		s.failoverWorkspaces(node.ID, nodes, now, timeout)
+	}
+
+	return reaped
+}
+
+// failoverWorkspaces handles the running workspaces of an offline node. Stateless workspaces
+// are recreated on a healthy node when rescheduling is enabled; the rest are marked unreachable.
+func (s *Server) failoverWorkspaces(nodeID string, nodes []*Node, now time.Time, timeout time.Duration) {
+	workspaces, err := s.workspaceRegistry.List()
+	if err != nil {
+		log.Printf("Node reaper: failed to list workspaces: %v", err)
+		return
+	}
+
+	for _, ws := range workspaces {
+		if ws.NodeID == nil || *ws.NodeID != nodeID || ws.Status != "running" {
+			continue
+		}
+
+		if s.config.Registry.RescheduleStateless && ws.Stateless {
+			if target := pickFailoverNode(ws, nodeID, nodes, workspaces, now, timeout); target != nil {
+				updates := map[string]interface{}{"node_id": target.ID, "status": "pending"}
+				if err := s.workspaceRegistry.Update(ws.WorkspaceID, updates); err != nil {
+					log.Printf("Node reaper: failed to reschedule workspace %s: %v", ws.WorkspaceID, err)
+					continue
+				}
+
+				s.broadcastEvent("workspace_rescheduled", map[string]interface{}{
+					"workspace_id": ws.WorkspaceID,
+					"from_node":    nodeID,
+					"to_node":      target.ID,
+				})
+				go s.recreateWorkspace(*ws, target)
+
+				// Count the move so the next workspace sees the new load
+				ws.NodeID = &target.ID
+				continue
+			}
+		}
+
+		if err := s.workspaceRegistry.UpdateStatus(ws.WorkspaceID, WorkspaceStatusUnreachable); err != nil {
+			log.Printf("Node reaper: failed to mark workspace %s unreachable: %v", ws.WorkspaceID, err)
+			continue
+		}
+		s.broadcastEvent("workspace_unreachable", map[string]interface{}{
+			"workspace_id": ws.WorkspaceID,
+			"node_id":      nodeID,
+		})
+	}
+}
+
+// recreateWorkspace has the node a workspace was rescheduled to create it, then marks the
+// workspace running or failed. The workspace is left alone if it moved on in the meantime.
+func (s *Server) recreateWorkspace(ws DBWorkspace, node *Node) {
+	ctx, cancel := context.WithTimeout(context.Background(), workspaceRecreateTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.reaperStop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	command, err := s.workspaceCreateCommand(&ws)
+	var result *CommandResult
+	if err == nil {
+		result, err = s.dispatchCommand(ctx, node.ID, command)
+	}
+	if err == nil && result.Status != "success" {
+		err = fmt.Errorf("create %s: %s", result.Status, result.Error)
+	}
+
+	current, getErr := s.workspaceRegistry.Get(ws.WorkspaceID)
+	if getErr != nil || current.NodeID == nil || *current.NodeID != node.ID || current.Status != "pending" {
+		return
+	}
+
+	if err != nil {
+		log.Printf("Node %s failed to recreate workspace %s: %v", node.ID, ws.WorkspaceID, err)
+		if err := s.workspaceRegistry.UpdateStatus(ws.WorkspaceID, "error"); err != nil {
+			log.Printf("Failed to mark workspace %s failed: %v", ws.WorkspaceID, err)
+		}
+		s.broadcastEvent("workspace_reschedule_failed", map[string]interface{}{
+			"workspace_id": ws.WorkspaceID,
+			"node_id":      node.ID,
+			"error":        err.Error(),
+		})
+		return
+	}
+
+	updates := map[string]interface{}{"status": "running"}
+	var created struct {
+		SSHPort int `json:"ssh_port"`
+	}
+	if json.Unmarshal([]byte(result.Output), &created) == nil && created.SSHPort > 0 {
+		updates["ssh_port"] = created.SSHPort
+		updates["ssh_host"] = node.Address
+	}
+	if err := s.workspaceRegistry.Update(ws.WorkspaceID, updates); err != nil {
+		log.Printf("Failed to mark workspace %s running: %v", ws.WorkspaceID, err)
+		return
+	}
+	log.Printf("Workspace %s recreated on node %s", ws.WorkspaceID, node.ID)
+	s.broadcastEvent("workspace_recreated", map[string]interface{}{
+		"workspace_id": ws.WorkspaceID,
+		"node_id":      node.ID,
+	})
+}
+
+// workspaceCreateCommand builds the command that has a node create ws from its repository
+func (s *Server) workspaceCreateCommand(ws *DBWorkspace) (Command, error) {
+	owner, err := s.workspaceOwner(ws)
+	if err != nil {
+		return Command{}, err
+	}
+	sshPort := 22
+	if ws.SSHPort != nil {
+		sshPort = *ws.SSHPort
+	}
+	cpu, memoryMB, diskGB := ws.CPU, ws.MemoryMB, ws.DiskGB
+	if cpu == 0 {
+		cpu = defaultWorkspaceCPU
+	}
+	if memoryMB == 0 {
+		memoryMB = defaultWorkspaceMemoryMB
+	}
+	if diskGB == 0 {
+		diskGB = defaultWorkspaceDiskGB
+	}
+
+	return Command{
+		ID:     fmt.Sprintf("create_%d_%s", time.Now().UnixNano(), ws.WorkspaceID),
+		Type:   "workspace",
+		Action: "create",
+		Params: map[string]interface{}{
+			"workspace_id":   ws.WorkspaceID,
+			"workspace_name": ws.WorkspaceName,
+			"provider":       ws.Provider,
+			"image":          ws.Image,
+			"repository": map[string]interface{}{
+				"owner":  ws.RepoOwner,
+				"name":   ws.RepoName,
+				"url":    ws.RepoURL,
+				"branch": ws.RepoBranch,
+			},
+			"ssh": map[string]interface{}{
+				"port":    sshPort,
+				"user":    owner.Username,
+				"pub_key": owner.PublicKey,
+			},
+			"resources": map[string]interface{}{
+				"cpu":    cpu,
+				"memory": fmt.Sprintf("%dMB", memoryMB),
+				"disk":   fmt.Sprintf("%dGB", diskGB),
+			},
+		},
+		Timeout: workspaceRecreateTimeout,
+	}, nil
+}
+
+// pickFailoverNode returns the healthy node with the fewest workspaces that can run ws,
+// or nil when there is none
+func pickFailoverNode(ws *DBWorkspace, failedNodeID string, nodes []*Node, workspaces []*DBWorkspace, now time.Time, timeout time.Duration) *Node {
+	load := make(map[string]int)
+	for _, other := range workspaces {
+		if other.NodeID != nil {
+			load[*other.NodeID]++
+		}
+	}
+
+	candidates := make([]*Node, 0)
+	for _, node := range nodes {
+		if node.ID == failedNodeID || node.Status == NodeStatusOffline || node.Status == "stopped" {
+			continue
+		}
+		if now.Sub(node.LastSeen) > timeout {
+			continue
+		}
+		if ws.Provider != "" && node.Provider != "" && ws.Provider != node.Provider {
+			continue
+		}
+		if !node.Capacity.Fits(ws.CPU, ws.MemoryMB, ws.DiskGB) {
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if load[candidates[i].ID] != load[candidates[j].ID] {
+			return load[candidates[i].ID] < load[candidates[j].ID]
+		}
+		return candidates[i].ID < candidates[j].ID
+	})
+	return candidates[0]
+}
+
+// handleNodeHeartbeat records a heartbeat and brings an offline node back online
+func (s *Server) handleNodeHeartbeat(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if !s.authorizeNode(w, r, nodeID, ActionNodesAdmin) {
+		return
+	}
+
+	var heartbeat NodeHeartbeat
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&heartbeat); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	node, err := s.registry.Get(nodeID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Node not found: %v", err), http.StatusNotFound)
+		return
+	}
+	if !s.checkAgentProtocol(w, heartbeat.ProtocolVersion, nodeMetadataString(node, "os"), nodeMetadataString(node, "arch")) {
+		return
+	}
+	wasOffline := node.Status == NodeStatusOffline
+
+	updates := map[string]interface{}{}
+	switch {
+	case heartbeat.Status != "":
+		updates["status"] = heartbeat.Status
+	case wasOffline:
+		updates["status"] = "active"
+	}
+	if heartbeat.Capacity != nil {
+		updates["capacity"] = heartbeat.Capacity
+	}
+
+	if err := s.registry.Update(nodeID, updates); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to record heartbeat: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if len(heartbeat.Activity) > 0 {
+		s.recordNodeActivity(nodeID, heartbeat.Activity)
+	}
+	if heartbeat.Drift != nil {
+		s.recordWorkspaceDrift(nodeID, heartbeat.Drift)
+	}
+	if len(heartbeat.Orphans) > 0 {
+		log.Printf("Node %s reported %d orphaned resources", nodeID, len(heartbeat.Orphans))
+		s.broadcastEvent("orphaned_resources", map[string]interface{}{
+			"node_id": nodeID,
+			"orphans": heartbeat.Orphans,
+		})
+	}
+
+	if wasOffline {
+		log.Printf("Node %s is back online", nodeID)
+		s.broadcastEvent("node_online", map[string]interface{}{
+			"node_id": nodeID,
+		})
+		s.restoreWorkspaces(nodeID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreWorkspaces marks the unreachable workspaces of a recovered node as running again
+func (s *Server) restoreWorkspaces(nodeID string) {
+	workspaces, err := s.workspaceRegistry.List()
+	if err != nil {
+		log.Printf("Failed to list workspaces for node %s: %v", nodeID, err)
+		return
+	}
+
+	for _, ws := range workspaces {
+		if ws.NodeID == nil || *ws.NodeID != nodeID || ws.Status != WorkspaceStatusUnreachable {
+			continue
+		}
+		if err := s.workspaceRegistry.UpdateStatus(ws.WorkspaceID, "running"); err != nil {
+			log.Printf("Failed to restore workspace %s: %v", ws.WorkspaceID, err)
+			continue
+		}
+		s.broadcastEvent("workspace_reachable", map[string]interface{}{
+			"workspace_id": ws.WorkspaceID,
+			"node_id":      nodeID,
+		})
+	}
+}
+
+// NodeWorkspaces is the body of GET /api/v1/nodes/{id}/workspaces
+type NodeWorkspaces struct {
+	WorkspaceIDs []string `json:"workspace_ids"`
+}
+
+// handleNodeWorkspaces lists the workspaces registered on a node, so the node's garbage
+// collection leaves alone what the server still expects it to host
+// GET /api/v1/nodes/{id}/workspaces
+func (s *Server) handleNodeWorkspaces(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeNode(w, r, nodeID, ActionNodesAdmin) {
+		return
+	}
+
+	workspaces, err := s.workspaceRegistry.List()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list workspaces: %v", err), http.StatusInternalServerError)
+		return
+	}
+	resp := NodeWorkspaces{WorkspaceIDs: []string{}}
+	for _, ws := range workspaces {
+		if ws.NodeID != nil && *ws.NodeID == nodeID {
+			resp.WorkspaceIDs = append(resp.WorkspaceIDs, ws.WorkspaceID)
+		}
+	}
+	sort.Strings(resp.WorkspaceIDs)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// recordWorkspaceDrift marks the workspaces a node lost as missing. Workspaces the node
+// does not host are ignored.
+func (s *Server) recordWorkspaceDrift(nodeID string, drift *WorkspaceDrift) {
+	log.Printf("Node %s reconciled its workspaces: %d adopted, %d vanished", nodeID, len(drift.Adopted), len(drift.Vanished))
+	s.broadcastEvent("workspace_drift", map[string]interface{}{
+		"node_id":  nodeID,
+		"adopted":  drift.Adopted,
+		"vanished": drift.Vanished,
+	})
+
+	for _, workspaceID := range drift.Vanished {
+		ws, err := s.workspaceRegistry.Get(workspaceID)
+		if err != nil || ws.NodeID == nil || *ws.NodeID != nodeID || ws.Status == WorkspaceStatusMissing {
+			continue
+		}
+		if err := s.workspaceRegistry.UpdateStatus(workspaceID, WorkspaceStatusMissing); err != nil {
+			log.Printf("Failed to mark workspace %s missing: %v", workspaceID, err)
+			continue
+		}
+		s.broadcastEvent("workspace_missing", map[string]interface{}{
+			"workspace_id": workspaceID,
+			"node_id":      nodeID,
+		})
+	}
+}
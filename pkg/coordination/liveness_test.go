@@ -0,0 +1,325 @@
+package coordination
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// subscribeEvents registers a buffered event client on the server
+func subscribeEvents(srv *Server) chan Event {
+	events := make(chan Event, 32)
+	srv.clientsMu.Lock()
+	srv.clients[events] = true
+	srv.clientsMu.Unlock()
+	return events
+}
+
+func drainEventTypes(events chan Event) []string {
+	var types []string
+	for {
+		select {
+		case event := <-events:
+			types = append(types, event.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func createNodeWorkspace(t *testing.T, srv *Server, id, nodeID string, stateless bool) {
+	require.NoError(t, srv.workspaceRegistry.Create(&DBWorkspace{
+		WorkspaceID: id, UserID: "alice", WorkspaceName: id, Status: "running", Stateless: stateless,
+	}))
+	require.NoError(t, srv.workspaceRegistry.Update(id, map[string]interface{}{"node_id": nodeID}))
+}
+
+// answerWorkspaceCommand plays nodeID: it takes the next workspace command, acknowledges
+// it, checks its action and reports status for it
+func answerWorkspaceCommand(t *testing.T, srv *Server, nodeID, action, status string) {
+	t.Helper()
+	commands := srv.commandQueue.take(context.Background(), nodeID, nil, 5*time.Second)
+	if !assert.Len(t, commands, 1) {
+		return
+	}
+	cmd := commands[0]
+	srv.commandQueue.take(context.Background(), nodeID, []string{cmd.ID}, 0)
+	assert.Equal(t, "workspace", cmd.Type)
+	assert.Equal(t, action, cmd.Action)
+	srv.commandQueue.finish(cmd.ID)
+	result := CommandResult{ID: cmd.ID, NodeID: nodeID, Status: status, Finished: time.Now()}
+	if status != "success" {
+		result.Error = action + " failed"
+	}
+	srv.recordCommandResult(result)
+}
+
+func TestReapStaleNodes(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+	events := subscribeEvents(srv)
+
+	require.NoError(t, srv.registry.Register(&Node{ID: "stale", Status: "active"}))
+	require.NoError(t, srv.registry.Register(&Node{ID: "fresh", Status: "active"}))
+	createNodeWorkspace(t, srv, "ws-1", "stale", false)
+	createNodeWorkspace(t, srv, "ws-2", "stale", true)
+
+	stale, err := srv.registry.Get("stale")
+	require.NoError(t, err)
+	lastSeen := stale.LastSeen
+
+	now := time.Now().Add(2 * time.Minute)
+	require.NoError(t, srv.registry.Update("fresh", map[string]interface{}{"last_seen": now}))
+
+	reaped := srv.reapStaleNodes(now, time.Minute)
+	assert.Equal(t, []string{"stale"}, reaped)
+
+	node, err := srv.registry.Get("stale")
+	require.NoError(t, err)
+	assert.Equal(t, NodeStatusOffline, node.Status)
+	assert.Equal(t, lastSeen.Unix(), node.LastSeen.Unix(), "marking a node offline must keep its last heartbeat")
+
+	// Without rescheduling every running workspace becomes unreachable
+	for _, id := range []string{"ws-1", "ws-2"} {
+		ws, err := srv.workspaceRegistry.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, WorkspaceStatusUnreachable, ws.Status)
+	}
+
+	assert.Equal(t, []string{"node_offline", "workspace_unreachable", "workspace_unreachable"}, drainEventTypes(events))
+
+	// Nodes already offline are skipped; only the node that has since gone stale is reaped
+	assert.Equal(t, []string{"fresh"}, srv.reapStaleNodes(now.Add(time.Hour), time.Minute))
+}
+
+func TestReapStaleNodesReschedulesStatelessWorkspaces(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+	srv.config.Registry.RescheduleStateless = true
+	events := subscribeEvents(srv)
+
+	require.NoError(t, srv.registry.Register(&Node{ID: "stale", Status: "active"}))
+	require.NoError(t, srv.registry.Register(&Node{ID: "busy", Status: "active"}))
+	require.NoError(t, srv.registry.Register(&Node{ID: "idle", Status: "active", Address: "10.0.0.2"}))
+	require.NoError(t, srv.registry.Register(&Node{ID: "full", Status: "active", Capacity: &NodeCapacity{FreeSSHPorts: 0}}))
+	require.NoError(t, srv.registry.GetUserRegistry().Register(&User{ID: "alice", Username: "alice", PublicKey: "ssh-ed25519 AAAA alice"}))
+	createNodeWorkspace(t, srv, "ws-stateful", "stale", false)
+	createNodeWorkspace(t, srv, "ws-stateless", "stale", true)
+	createNodeWorkspace(t, srv, "ws-other", "busy", false)
+
+	now := time.Now().Add(2 * time.Minute)
+	require.NoError(t, srv.registry.Update("busy", map[string]interface{}{"last_seen": now}))
+	require.NoError(t, srv.registry.Update("idle", map[string]interface{}{"last_seen": now}))
+	require.NoError(t, srv.registry.Update("full", map[string]interface{}{"last_seen": now}))
+
+	assert.Equal(t, []string{"stale"}, srv.reapStaleNodes(now, time.Minute))
+
+	ws, err := srv.workspaceRegistry.Get("ws-stateless")
+	require.NoError(t, err)
+	assert.Equal(t, "pending", ws.Status)
+	require.NotNil(t, ws.NodeID)
+	assert.Equal(t, "idle", *ws.NodeID, "workspaces move to the least loaded healthy node with room")
+
+	ws, err = srv.workspaceRegistry.Get("ws-stateful")
+	require.NoError(t, err)
+	assert.Equal(t, WorkspaceStatusUnreachable, ws.Status)
+
+	assert.ElementsMatch(t, []string{"node_offline", "workspace_rescheduled", "workspace_unreachable"}, drainEventTypes(events))
+
+	// The new node is asked to create the workspace, and it runs once the node reports success
+	commands := srv.commandQueue.take(context.Background(), "idle", nil, 5*time.Second)
+	require.Len(t, commands, 1)
+	create := commands[0]
+	assert.Equal(t, "workspace", create.Type)
+	assert.Equal(t, "create", create.Action)
+	assert.Equal(t, "ws-stateless", create.Params["workspace_id"])
+	assert.Equal(t, map[string]interface{}{"port": 22, "user": "alice", "pub_key": "ssh-ed25519 AAAA alice"}, create.Params["ssh"])
+
+	srv.commandQueue.finish(create.ID)
+	srv.recordCommandResult(CommandResult{ID: create.ID, NodeID: "idle", Status: "success", Output: `{"ssh_port":2223}`})
+	assert.Eventually(t, func() bool {
+		ws, err := srv.workspaceRegistry.Get("ws-stateless")
+		return err == nil && ws.Status == "running"
+	}, 5*time.Second, 10*time.Millisecond)
+	ws, err = srv.workspaceRegistry.Get("ws-stateless")
+	require.NoError(t, err)
+	require.NotNil(t, ws.SSHPort)
+	assert.Equal(t, 2223, *ws.SSHPort)
+	require.NotNil(t, ws.SSHHost)
+	assert.Equal(t, "10.0.0.2", *ws.SSHHost)
+}
+
+func TestRescheduledWorkspaceFailsWhenNodeCannotCreateIt(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+	srv.config.Registry.RescheduleStateless = true
+	require.NoError(t, srv.registry.Register(&Node{ID: "stale", Status: "active"}))
+	require.NoError(t, srv.registry.Register(&Node{ID: "idle", Status: "active"}))
+	require.NoError(t, srv.registry.GetUserRegistry().Register(&User{ID: "alice", Username: "alice"}))
+	createNodeWorkspace(t, srv, "ws-1", "stale", true)
+
+	now := time.Now().Add(2 * time.Minute)
+	require.NoError(t, srv.registry.Update("idle", map[string]interface{}{"last_seen": now}))
+	srv.reapStaleNodes(now, time.Minute)
+
+	commands := srv.commandQueue.take(context.Background(), "idle", nil, 5*time.Second)
+	require.Len(t, commands, 1)
+	srv.recordCommandResult(CommandResult{ID: commands[0].ID, NodeID: "idle", Status: "failed", Error: "image is required"})
+	assert.Eventually(t, func() bool {
+		ws, err := srv.workspaceRegistry.Get("ws-1")
+		return err == nil && ws.Status == "error"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReapStaleNodesSkipsNodesThatHeartbeat(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+	require.NoError(t, srv.registry.Register(&Node{ID: "node-1", Status: "active"}))
+	node, err := srv.registry.Get("node-1")
+	require.NoError(t, err)
+
+	// A heartbeat landing between the reaper's read and its write wins
+	srv.registry = &heartbeatingRegistry{Registry: srv.registry}
+	assert.Empty(t, srv.reapStaleNodes(node.LastSeen.Add(time.Hour), time.Minute))
+
+	node, err = srv.registry.Get("node-1")
+	require.NoError(t, err)
+	assert.Equal(t, "active", node.Status)
+}
+
+// heartbeatingRegistry records a heartbeat for every node it lists
+type heartbeatingRegistry struct {
+	Registry
+}
+
+func (r *heartbeatingRegistry) List() ([]*Node, error) {
+	nodes, err := r.Registry.List()
+	if err != nil {
+		return nil, err
+	}
+	listed := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		copied := *node
+		listed = append(listed, &copied)
+		if err := r.Registry.Update(node.ID, map[string]interface{}{"last_seen": node.LastSeen.Add(time.Hour)}); err != nil {
+			return nil, err
+		}
+	}
+	return listed, nil
+}
+
+func TestHandleNodeHeartbeat(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/nodes/missing/heartbeat", nil)
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	require.NoError(t, srv.registry.Register(&Node{ID: "node-1", Status: "active"}))
+	createNodeWorkspace(t, srv, "ws-1", "node-1", false)
+	require.Len(t, srv.reapStaleNodes(time.Now().Add(time.Hour), time.Minute), 1)
+
+	events := subscribeEvents(srv)
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/nodes/node-1/heartbeat", bytes.NewReader([]byte(`{}`)))
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	node, err := srv.registry.Get("node-1")
+	require.NoError(t, err)
+	assert.Equal(t, "active", node.Status)
+	assert.WithinDuration(t, time.Now(), node.LastSeen, 5*time.Second)
+
+	ws, err := srv.workspaceRegistry.Get("ws-1")
+	require.NoError(t, err)
+	assert.Equal(t, "running", ws.Status)
+	assert.Equal(t, []string{"node_online", "workspace_reachable"}, drainEventTypes(events))
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/nodes/node-1/heartbeat", nil)
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestHeartbeatReportsWorkspaceDrift(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+	require.NoError(t, srv.registry.Register(&Node{ID: "node-1", Status: "active"}))
+	require.NoError(t, srv.registry.Register(&Node{ID: "node-2", Status: "active"}))
+	createNodeWorkspace(t, srv, "ws-1", "node-1", false)
+	createNodeWorkspace(t, srv, "ws-2", "node-2", false)
+
+	events := subscribeEvents(srv)
+	body := `{"drift":{"adopted":["ws-3"],"vanished":["ws-1","ws-2","ws-unknown"]}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/nodes/node-1/heartbeat", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	ws, err := srv.workspaceRegistry.Get("ws-1")
+	require.NoError(t, err)
+	assert.Equal(t, WorkspaceStatusMissing, ws.Status)
+
+	ws, err = srv.workspaceRegistry.Get("ws-2")
+	require.NoError(t, err)
+	assert.Equal(t, "running", ws.Status, "a node cannot report workspaces it does not host")
+
+	assert.Equal(t, []string{"workspace_drift", "workspace_missing"}, drainEventTypes(events))
+}
+
+func TestHeartbeatReportsOrphanedResources(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+	require.NoError(t, srv.registry.Register(&Node{ID: "node-1", Status: "active"}))
+
+	events := subscribeEvents(srv)
+	body := `{"orphans":[{"kind":"session","provider":"docker","id":"c2","workspace_id":"ws-leaked"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/nodes/node-1/heartbeat", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/nodes/node-1/heartbeat", bytes.NewReader([]byte(`{}`)))
+	w = httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	assert.Equal(t, []string{"orphaned_resources"}, drainEventTypes(events))
+}
+
+func TestLivenessSettings(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+	interval, timeout := srv.livenessSettings()
+	assert.Equal(t, defaultHealthCheckInterval, interval)
+	assert.Equal(t, defaultNodeTimeout, timeout)
+
+	srv.config.Registry.HealthCheckInterval = "2s"
+	srv.config.Registry.NodeTimeout = "15s"
+	interval, timeout = srv.livenessSettings()
+	assert.Equal(t, 2*time.Second, interval)
+	assert.Equal(t, 15*time.Second, timeout)
+}
+
+func TestNodeWorkspaces(t *testing.T) {
+	srv := newRBACTestServer(t)
+	credential := enrollTestNode(t, srv, createTestEnrollmentToken(t, srv, CreateEnrollmentTokenRequest{}), "node-1")
+	require.NoError(t, srv.workspaceRegistry.Update("ws-1", map[string]interface{}{"node_id": "node-1"}))
+	require.NoError(t, srv.workspaceRegistry.Update("ws-2", map[string]interface{}{"node_id": "node-2"}))
+
+	w := rbacRequest(t, srv, credential.Secret, http.MethodGet, "/api/v1/nodes/node-1/workspaces", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp NodeWorkspaces
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, []string{"ws-1"}, resp.WorkspaceIDs)
+
+	w = rbacRequest(t, srv, credential.Secret, http.MethodGet, "/api/v1/nodes/node-2/workspaces", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "nodes only see their own workspaces")
+	w = rbacRequest(t, srv, "alice-token", http.MethodGet, "/api/v1/nodes/node-1/workspaces", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodGet, "/api/v1/nodes/node-2/workspaces", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, []string{"ws-2"}, resp.WorkspaceIDs)
+}
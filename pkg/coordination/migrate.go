@@ -0,0 +1,487 @@
+package coordination
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Checksum returns a digest of the migration's Up SQL so edits to applied migrations can be
+// detected. Down is left out: fixing a rollback does not change what was applied.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationStatus describes the state of a single migration in a database
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Checksum  string     `json:"checksum"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Modified  bool       `json:"modified"` // applied checksum differs from the current migration
+	Unknown   bool       `json:"unknown"`  // applied in the database but not known to this binary
+}
+
+// appliedMigration is a row of the schema_version table
+type appliedMigration struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// migrationLockID is the PostgreSQL advisory lock key held while migrating,
+// so replicas starting at the same time don't apply a migration twice
+const migrationLockID int64 = 7461928305
+
+// Migrator applies and rolls back versioned schema migrations
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the given SQLite database and migrations
+func NewMigrator(db *sql.DB, migrations []Migration) *Migrator {
+	return newMigrator(db, dialectSQLite, migrations)
+}
+
+// NewPostgresMigrator creates a migrator for the given PostgreSQL database and migrations
+func NewPostgresMigrator(db *sql.DB, migrations []Migration) *Migrator {
+	return newMigrator(db, dialectPostgres, migrations)
+}
+
+func newMigrator(db *sql.DB, d dialect, migrations []Migration) *Migrator {
+	sorted := make([]Migration, 0, len(migrations))
+	for _, migration := range migrations {
+		sorted = append(sorted, migration.forDialect(d))
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return &Migrator{
+		db:         db,
+		dialect:    d,
+		migrations: sorted,
+	}
+}
+
+// LatestVersion returns the highest migration version known to this binary
+func (m *Migrator) LatestVersion() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// CurrentVersion returns the highest migration version applied to the database
+func (m *Migrator) CurrentVersion() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	return current, nil
+}
+
+// Status reports every known migration and any applied migration this binary doesn't know
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	known := make(map[int]bool)
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+		status := MigrationStatus{
+			Version:  migration.Version,
+			Name:     migration.Name,
+			Checksum: migration.Checksum(),
+		}
+		if row, ok := applied[migration.Version]; ok {
+			appliedAt := row.appliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+			status.Modified = row.checksum != status.Checksum
+		}
+		statuses = append(statuses, status)
+	}
+
+	for version, row := range applied {
+		if known[version] {
+			continue
+		}
+		appliedAt := row.appliedAt
+		statuses = append(statuses, MigrationStatus{
+			Version:   version,
+			Name:      row.name,
+			Checksum:  row.checksum,
+			Applied:   true,
+			AppliedAt: &appliedAt,
+			Unknown:   true,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// Up applies pending migrations up to and including target (0 means latest).
+// With dryRun set, the migrations that would run are returned without being applied.
+func (m *Migrator) Up(target int, dryRun bool) ([]Migration, error) {
+	if target == 0 {
+		target = m.LatestVersion()
+	}
+
+	unlock, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if !dryRun {
+		if err := m.ensureVersionTable(); err != nil {
+			return nil, err
+		}
+	}
+	applied, err := m.verify()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]Migration, 0)
+	for _, migration := range m.migrations {
+		if migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+
+	if dryRun {
+		return pending, nil
+	}
+
+	for _, migration := range pending {
+		if err := m.apply(migration); err != nil {
+			return nil, err
+		}
+	}
+
+	return pending, nil
+}
+
+// Down rolls back applied migrations newer than target, most recent first.
+// With dryRun set, the migrations that would be rolled back are returned without being reverted.
+func (m *Migrator) Down(target int, dryRun bool) ([]Migration, error) {
+	if target < 0 {
+		return nil, fmt.Errorf("target version must be non-negative, got %d", target)
+	}
+
+	unlock, err := m.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if !dryRun {
+		if err := m.ensureVersionTable(); err != nil {
+			return nil, err
+		}
+	}
+	applied, err := m.verify()
+	if err != nil {
+		return nil, err
+	}
+
+	rollback := make([]Migration, 0)
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version <= target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			rollback = append(rollback, migration)
+		}
+	}
+
+	if dryRun {
+		return rollback, nil
+	}
+
+	for _, migration := range rollback {
+		if err := m.revert(migration); err != nil {
+			return nil, err
+		}
+	}
+
+	return rollback, nil
+}
+
+// lock serializes schema changes across processes sharing a PostgreSQL database.
+// SQLite serializes writers itself, so no lock is taken there.
+func (m *Migrator) lock() (func(), error) {
+	if m.dialect != dialectPostgres {
+		return func() {}, nil
+	}
+
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+
+	return func() {
+		conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+		conn.Close()
+	}, nil
+}
+
+// verify checks applied migrations against the known ones before changing the schema
+func (m *Migrator) verify() (map[int]appliedMigration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]Migration)
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, row := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return nil, fmt.Errorf("database has migration %d (%s) which is unknown to this binary", version, row.name)
+		}
+		if row.checksum != migration.Checksum() {
+			return nil, fmt.Errorf("checksum mismatch for migration %d (%s): applied migration was modified", version, migration.Name)
+		}
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) apply(migration Migration) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(migration.Up); err != nil {
+		return fmt.Errorf("failed to execute migration %d: %w", migration.Version, err)
+	}
+
+	if _, err := tx.Exec(m.dialect.rebind("INSERT INTO schema_version (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"),
+		migration.Version, migration.Name, migration.Checksum(), time.Now()); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", migration.Version, err)
+	}
+
+	return nil
+}
+
+func (m *Migrator) revert(migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("migration %d (%s) cannot be rolled back", migration.Version, migration.Name)
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(migration.Down); err != nil {
+		return fmt.Errorf("failed to roll back migration %d: %w", migration.Version, err)
+	}
+
+	if _, err := tx.Exec(m.dialect.rebind("DELETE FROM schema_version WHERE version = ?"), migration.Version); err != nil {
+		return fmt.Errorf("failed to unrecord migration %d: %w", migration.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rollback of migration %d: %w", migration.Version, err)
+	}
+
+	return nil
+}
+
+// applied returns the applied migrations keyed by version without changing the database.
+// Versions only the legacy _schema_version table records count as applied with the
+// checksums of the migrations they name, until Up or Down adopts them.
+func (m *Migrator) applied() (map[int]appliedMigration, error) {
+	applied := make(map[int]appliedMigration)
+
+	exists, err := m.tableExists("schema_version")
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		rows, err := m.db.Query("SELECT version, name, checksum, applied_at FROM schema_version")
+		if err != nil {
+			return nil, fmt.Errorf("failed to query schema version: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var row appliedMigration
+			if err := rows.Scan(&row.version, &row.name, &row.checksum, &row.appliedAt); err != nil {
+				return nil, fmt.Errorf("failed to scan schema version: %w", err)
+			}
+			applied[row.version] = row
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	legacy, err := m.legacyVersions()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[int]Migration)
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+	for _, row := range legacy {
+		if _, ok := applied[row.version]; ok {
+			continue
+		}
+		if migration, ok := known[row.version]; ok {
+			row.name, row.checksum = migration.Name, migration.Checksum()
+		}
+		applied[row.version] = row
+	}
+
+	return applied, nil
+}
+
+// tableExists reports whether the database has a table called name
+func (m *Migrator) tableExists(name string) (bool, error) {
+	query := "SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if m.dialect == dialectPostgres {
+		query = "SELECT COUNT(1) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+	}
+
+	var count int
+	if err := m.db.QueryRow(query, name).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to look up table %s: %w", name, err)
+	}
+	return count > 0, nil
+}
+
+// ensureVersionTable creates schema_version and adopts the legacy table into it. Only
+// commands that change the schema call it, so status and dry runs leave the database alone.
+func (m *Migrator) ensureVersionTable() error {
+	timestampType := "TIMESTAMP"
+	if m.dialect == dialectPostgres {
+		timestampType = "TIMESTAMPTZ"
+	}
+
+	_, err := m.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at ` + timestampType + ` NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema version table: %w", err)
+	}
+
+	return m.adoptLegacyVersionTable()
+}
+
+// legacyVersions returns the versions recorded by the old _schema_version table, which
+// had no names or checksums. Only SQLite databases predate schema_version.
+func (m *Migrator) legacyVersions() ([]appliedMigration, error) {
+	if m.dialect != dialectSQLite {
+		return nil, nil
+	}
+	exists, err := m.tableExists("_schema_version")
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	rows, err := m.db.Query("SELECT version, applied_at FROM _schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query legacy schema version: %w", err)
+	}
+	defer rows.Close()
+
+	var legacy []appliedMigration
+	for rows.Next() {
+		var row appliedMigration
+		if err := rows.Scan(&row.version, &row.appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan legacy schema version: %w", err)
+		}
+		legacy = append(legacy, row)
+	}
+	return legacy, rows.Err()
+}
+
+// adoptLegacyVersionTable moves the versions of the old _schema_version table into
+// schema_version, then drops it
+func (m *Migrator) adoptLegacyVersionTable() error {
+	legacy, err := m.legacyVersions()
+	if err != nil || len(legacy) == 0 {
+		return err
+	}
+
+	known := make(map[int]Migration)
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, row := range legacy {
+		migration, ok := known[row.version]
+		if !ok {
+			return fmt.Errorf("legacy schema version %d is unknown to this binary", row.version)
+		}
+		if _, err := tx.Exec(m.dialect.rebind("INSERT INTO schema_version (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"),
+			migration.Version, migration.Name, migration.Checksum(), row.appliedAt); err != nil {
+			return fmt.Errorf("failed to adopt legacy schema version %d: %w", row.version, err)
+		}
+	}
+
+	if _, err := tx.Exec("DROP TABLE _schema_version"); err != nil {
+		return fmt.Errorf("failed to drop legacy schema version table: %w", err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,227 @@
+package coordination
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMigrationDB(t *testing.T) *sql.DB {
+	db, err := OpenSQLiteDatabase(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testMigrations() []Migration {
+	return []Migration{
+		{Version: 2, Name: "add_b", Up: "CREATE TABLE b (id TEXT);", Down: "DROP TABLE b;"},
+		{Version: 1, Name: "add_a", Up: "CREATE TABLE a (id TEXT);", Down: "DROP TABLE a;"},
+		{Version: 3, Name: "add_c", Up: "CREATE TABLE c (id TEXT);"},
+	}
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count))
+	return count > 0
+}
+
+func TestMigrator_UpAndStatus(t *testing.T) {
+	db := newTestMigrationDB(t)
+	migrator := NewMigrator(db, testMigrations())
+
+	assert.Equal(t, 3, migrator.LatestVersion())
+
+	pending, err := migrator.Up(0, true)
+	require.NoError(t, err)
+	require.Len(t, pending, 3)
+	assert.Equal(t, 1, pending[0].Version)
+	assert.False(t, tableExists(t, db, "a"), "dry run must not apply migrations")
+
+	applied, err := migrator.Up(2, false)
+	require.NoError(t, err)
+	assert.Len(t, applied, 2)
+	assert.True(t, tableExists(t, db, "b"))
+	assert.False(t, tableExists(t, db, "c"))
+
+	current, err := migrator.CurrentVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 2, current)
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	assert.True(t, statuses[0].Applied)
+	assert.NotNil(t, statuses[0].AppliedAt)
+	assert.True(t, statuses[1].Applied)
+	assert.False(t, statuses[2].Applied)
+
+	applied, err = migrator.Up(0, false)
+	require.NoError(t, err)
+	assert.Len(t, applied, 1)
+
+	applied, err = migrator.Up(0, false)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestMigrator_Down(t *testing.T) {
+	db := newTestMigrationDB(t)
+	migrator := NewMigrator(db, testMigrations()[:2])
+
+	_, err := migrator.Up(0, false)
+	require.NoError(t, err)
+
+	rollback, err := migrator.Down(0, true)
+	require.NoError(t, err)
+	require.Len(t, rollback, 2)
+	assert.Equal(t, 2, rollback[0].Version)
+	assert.True(t, tableExists(t, db, "b"), "dry run must not roll back migrations")
+
+	rollback, err = migrator.Down(1, false)
+	require.NoError(t, err)
+	assert.Len(t, rollback, 1)
+	assert.False(t, tableExists(t, db, "b"))
+	assert.True(t, tableExists(t, db, "a"))
+
+	current, err := migrator.CurrentVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 1, current)
+
+	_, err = migrator.Down(-1, false)
+	assert.Error(t, err)
+}
+
+func TestMigrator_DownWithoutRollback(t *testing.T) {
+	db := newTestMigrationDB(t)
+	migrator := NewMigrator(db, testMigrations())
+
+	_, err := migrator.Up(0, false)
+	require.NoError(t, err)
+
+	_, err = migrator.Down(2, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot be rolled back")
+	assert.True(t, tableExists(t, db, "c"))
+}
+
+func TestMigrator_ChecksumMismatch(t *testing.T) {
+	db := newTestMigrationDB(t)
+
+	_, err := NewMigrator(db, testMigrations()[1:2]).Up(0, false)
+	require.NoError(t, err)
+
+	modified := []Migration{{Version: 1, Name: "add_a", Up: "CREATE TABLE a (id TEXT, name TEXT);", Down: "DROP TABLE a;"}}
+	migrator := NewMigrator(db, modified)
+
+	_, err = migrator.Up(0, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	assert.True(t, statuses[0].Modified)
+}
+
+func TestMigrator_ChecksumIgnoresDown(t *testing.T) {
+	db := newTestMigrationDB(t)
+
+	_, err := NewMigrator(db, testMigrations()[1:2]).Up(0, false)
+	require.NoError(t, err)
+
+	fixed := testMigrations()[1:2]
+	fixed[0].Down = "DROP TABLE IF EXISTS a;"
+	migrator := NewMigrator(db, fixed)
+	_, err = migrator.Up(0, false)
+	require.NoError(t, err, "fixing a rollback does not mark the applied migration as modified")
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	assert.False(t, statuses[0].Modified)
+}
+
+func TestMigrator_UnknownVersion(t *testing.T) {
+	db := newTestMigrationDB(t)
+
+	_, err := NewMigrator(db, testMigrations()).Up(0, false)
+	require.NoError(t, err)
+
+	migrator := NewMigrator(db, testMigrations()[1:2])
+	_, err = migrator.Up(0, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown to this binary")
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	assert.True(t, statuses[2].Unknown)
+}
+
+func TestMigrator_StatusIsReadOnly(t *testing.T) {
+	db := newTestMigrationDB(t)
+	migrator := NewMigrator(db, testMigrations())
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, len(testMigrations()))
+	assert.False(t, statuses[0].Applied)
+	_, err = migrator.Up(0, true)
+	require.NoError(t, err)
+	_, err = migrator.Down(0, true)
+	require.NoError(t, err)
+	assert.False(t, tableExists(t, db, "schema_version"))
+}
+
+func TestMigrator_AdoptsLegacyVersionTable(t *testing.T) {
+	db := newTestMigrationDB(t)
+
+	_, err := db.Exec(`
+		CREATE TABLE _schema_version (version INTEGER PRIMARY KEY, applied_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+		INSERT INTO _schema_version (version) VALUES (1);
+	`)
+	require.NoError(t, err)
+	_, err = db.Exec(migrations[0].Up)
+	require.NoError(t, err)
+
+	migrator := NewMigrator(db, Migrations())
+	current, err := migrator.CurrentVersion()
+	require.NoError(t, err)
+	assert.Equal(t, 1, current)
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[0].Modified)
+	pending, err := migrator.Up(0, true)
+	require.NoError(t, err)
+	require.Len(t, pending, DBVersion-1)
+	assert.True(t, tableExists(t, db, "_schema_version"), "status and dry runs leave the database alone")
+	assert.False(t, tableExists(t, db, "schema_version"))
+
+	applied, err := migrator.Up(0, false)
+	require.NoError(t, err)
+	require.Len(t, applied, DBVersion-1)
+	assert.Equal(t, 2, applied[0].Version)
+	assert.False(t, tableExists(t, db, "_schema_version"))
+}
+
+func TestMigrations_RoundTrip(t *testing.T) {
+	db := newTestMigrationDB(t)
+	migrator := NewMigrator(db, Migrations())
+
+	_, err := migrator.Up(0, false)
+	require.NoError(t, err)
+	assert.True(t, tableExists(t, db, "workspaces"))
+
+	_, err = migrator.Down(0, false)
+	require.NoError(t, err)
+	assert.False(t, tableExists(t, db, "workspaces"))
+
+	_, err = migrator.Up(0, false)
+	require.NoError(t, err)
+	assert.True(t, tableExists(t, db, "workspaces"))
+}
@@ -18,22 +18,28 @@ type DBUser struct {
 
 // DBWorkspace represents an isolated development environment in the database
 type DBWorkspace struct {
-	WorkspaceID   string    `json:"workspace_id"`
-	UserID        string    `json:"user_id"`
-	WorkspaceName string    `json:"workspace_name"`
-	Status        string    `json:"status"`   // pending, creating, running, stopped, error
-	Provider      string    `json:"provider"` // lxc, docker, qemu
-	Image         string    `json:"image"`
-	SSHPort       *int      `json:"ssh_port,omitempty"`
-	SSHHost       *string   `json:"ssh_host,omitempty"`
-	NodeID        *string   `json:"node_id,omitempty"`
-	RepoOwner     string    `json:"repo_owner"`
-	RepoName      string    `json:"repo_name"`
-	RepoURL       string    `json:"repo_url"`
-	RepoBranch    string    `json:"repo_branch"`
-	RepoCommit    *string   `json:"repo_commit,omitempty"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	WorkspaceID    string     `json:"workspace_id"`
+	UserID         string     `json:"user_id"`
+	WorkspaceName  string     `json:"workspace_name"`
+	Status         string     `json:"status"`   // pending, creating, running, stopped, error, unreachable
+	Provider       string     `json:"provider"` // lxc, docker, qemu
+	Image          string     `json:"image"`
+	SSHPort        *int       `json:"ssh_port,omitempty"`
+	SSHHost        *string    `json:"ssh_host,omitempty"`
+	NodeID         *string    `json:"node_id,omitempty"`
+	RepoOwner      string     `json:"repo_owner"`
+	RepoName       string     `json:"repo_name"`
+	RepoURL        string     `json:"repo_url"`
+	RepoBranch     string     `json:"repo_branch"`
+	RepoCommit     *string    `json:"repo_commit,omitempty"`
+	Stateless      bool       `json:"stateless"`                  // can be recreated from its repository on another node
+	CPU            int        `json:"cpu,omitempty"`              // vCPUs reserved while active
+	MemoryMB       int64      `json:"memory_mb,omitempty"`        // memory reserved while active
+	DiskGB         int64      `json:"disk_gb,omitempty"`          // disk held until deleted
+	LastActivity   *time.Time `json:"last_activity_at,omitempty"` // last SSH, exec or service traffic
+	KeepAliveUntil *time.Time `json:"keep_alive_until,omitempty"` // idle stop and expiry are deferred until then
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
 }
 
 // DBService represents a service running in a workspace in the database
@@ -52,6 +58,102 @@ type DBService struct {
 	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
+// WorkspaceGrant gives a user other than the owner access to a workspace
+type WorkspaceGrant struct {
+	WorkspaceID string    `json:"workspace_id"`
+	Username    string    `json:"username"`
+	Role        string    `json:"role"` // collaborator, viewer
+	GrantedBy   string    `json:"granted_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// APIToken is a personal access token issued to a user. Only a hash of the secret is stored.
+type APIToken struct {
+	ID         string     `json:"id"`
+	Username   string     `json:"username"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // leading characters of the secret, to recognise the token
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"` // workspaces:read, workspaces:write, nodes:read, nodes:admin
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// EnrollmentToken lets a new agent register once and obtain its own node credential
+type EnrollmentToken struct {
+	ID        string     `json:"id"`
+	TokenHash string     `json:"-"`
+	NodeID    string     `json:"node_id,omitempty"` // when set, only this node may enroll
+	CreatedBy string     `json:"created_by,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	UsedBy    string     `json:"used_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// NodeCredential is the secret an agent authenticates with, bound to its node ID.
+// After a rotation the previous secret stays valid for a short grace period.
+type NodeCredential struct {
+	NodeID            string     `json:"node_id"`
+	SecretHash        string     `json:"-"`
+	PreviousHash      string     `json:"-"`
+	PreviousExpiresAt *time.Time `json:"previous_expires_at,omitempty"`
+	IssuedAt          time.Time  `json:"issued_at"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+}
+
+// AuditEntry records one mutating request to the coordination API
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Actor      string    `json:"actor"`
+	ActorKind  string    `json:"actor_kind"`
+	Action     string    `json:"action"`
+	Resource   string    `json:"resource,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	RequestID  string    `json:"request_id"`
+	SourceIP   string    `json:"source_ip"`
+	Status     int       `json:"status"`
+	Result     string    `json:"result"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// AuditFilter selects audit entries; zero fields match everything
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Resource string // matches the resource or anything below it
+	Result   string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// Matches reports whether entry satisfies the filter, ignoring Limit
+func (f AuditFilter) Matches(entry *AuditEntry) bool {
+	if f.Actor != "" && entry.Actor != f.Actor {
+		return false
+	}
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if f.Resource != "" && entry.Resource != f.Resource && !strings.HasPrefix(entry.Resource, f.Resource+"/") {
+		return false
+	}
+	if f.Result != "" && entry.Result != f.Result {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Timestamp.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
 // ValidationError represents a validation error
 type ValidationError struct {
 	Field   string
@@ -97,7 +199,7 @@ func (w *DBWorkspace) Validate() error {
 		return ValidationError{Field: "status", Message: "status is required"}
 	}
 	if !isValidWorkspaceStatus(w.Status) {
-		return ValidationError{Field: "status", Message: "status must be one of: pending, creating, running, stopped, error"}
+		return ValidationError{Field: "status", Message: "status must be one of: pending, creating, running, stopped, error, unreachable"}
 	}
 	if w.Provider == "" {
 		return ValidationError{Field: "provider", Message: "provider is required"}
@@ -143,13 +245,59 @@ func (s *DBService) Validate() error {
 	return nil
 }
 
+// Validate validates workspace grant data
+func (g *WorkspaceGrant) Validate() error {
+	if g.WorkspaceID == "" {
+		return ValidationError{Field: "workspace_id", Message: "workspace_id is required"}
+	}
+	if g.Username == "" {
+		return ValidationError{Field: "username", Message: "username is required"}
+	}
+	if !IsValidGrantRole(g.Role) {
+		return ValidationError{Field: "role", Message: "role must be one of: collaborator, viewer"}
+	}
+	return nil
+}
+
+// Validate validates API token data
+func (t *APIToken) Validate() error {
+	if t.ID == "" {
+		return ValidationError{Field: "id", Message: "id is required"}
+	}
+	if t.Username == "" {
+		return ValidationError{Field: "username", Message: "username is required"}
+	}
+	if t.Name == "" {
+		return ValidationError{Field: "name", Message: "name is required"}
+	}
+	if t.TokenHash == "" {
+		return ValidationError{Field: "token_hash", Message: "token_hash is required"}
+	}
+	if len(t.Scopes) == 0 {
+		return ValidationError{Field: "scopes", Message: "at least one scope is required"}
+	}
+	for _, scope := range t.Scopes {
+		if !IsValidScope(scope) {
+			return ValidationError{Field: "scopes", Message: "scopes must be among: workspaces:read, workspaces:write, nodes:read, nodes:admin"}
+		}
+	}
+	return nil
+}
+
+// Expired reports whether the token can no longer be used at now
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
 func isValidWorkspaceStatus(status string) bool {
 	validStatuses := map[string]bool{
-		"pending":  true,
-		"creating": true,
-		"running":  true,
-		"stopped":  true,
-		"error":    true,
+		"pending":     true,
+		"creating":    true,
+		"starting":    true,
+		"running":     true,
+		"stopped":     true,
+		"error":       true,
+		"unreachable": true,
 	}
 	return validStatuses[status]
 }
@@ -0,0 +1,367 @@
+package coordination
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Secret prefixes let authenticate route agent credentials without asking the identity provider
+const (
+	EnrollmentTokenPrefix = "nxe_"
+	NodeCredentialPrefix  = "nxn_"
+)
+
+const (
+	// nodeCredentialTTL is how long an issued node credential stays valid; agents rotate at half-life
+	nodeCredentialTTL = 30 * 24 * time.Hour
+	// credentialRotationGrace keeps the previous secret valid while a rotated one propagates
+	credentialRotationGrace = 10 * time.Minute
+	// defaultEnrollmentTTL applies when an enrollment token is created without expires_in
+	defaultEnrollmentTTL = time.Hour
+)
+
+// checkEnrollment reports why token cannot enroll nodeID at now, if it cannot
+func checkEnrollment(token *EnrollmentToken, nodeID string, now time.Time) error {
+	if token.UsedAt != nil {
+		return fmt.Errorf("enrollment token already used")
+	}
+	if !now.Before(token.ExpiresAt) {
+		return fmt.Errorf("enrollment token expired")
+	}
+	if token.NodeID != "" && token.NodeID != nodeID {
+		return fmt.Errorf("enrollment token is bound to node %s", token.NodeID)
+	}
+	return nil
+}
+
+// generateSecret returns a random secret with the given prefix
+func generateSecret(prefix string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
+
+// nodeCredentialStore returns the credential store of the registry, if it has one
+func (s *Server) nodeCredentialStore() (NodeCredentialStore, bool) {
+	store, ok := s.registry.(NodeCredentialStore)
+	return store, ok
+}
+
+// authenticateNodeCredential resolves a node credential to a principal that may only act as its node.
+// The previous secret of a rotated credential is accepted until its grace period ends.
+func (s *Server) authenticateNodeCredential(secret string) (*Principal, error) {
+	store, ok := s.nodeCredentialStore()
+	if !ok {
+		return nil, fmt.Errorf("node credentials are not supported by this registry")
+	}
+
+	hash := tokenDigest(secret)
+	credential, err := store.GetNodeCredentialByHash(hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	now := time.Now()
+	if hash == credential.SecretHash {
+		if !now.Before(credential.ExpiresAt) {
+			return nil, fmt.Errorf("node credential expired")
+		}
+	} else if credential.PreviousExpiresAt == nil || !now.Before(*credential.PreviousExpiresAt) {
+		return nil, fmt.Errorf("node credential rotated")
+	}
+
+	return &Principal{
+		Kind:     PrincipalNode,
+		Subject:  "node:" + credential.NodeID,
+		Username: credential.NodeID,
+		NodeID:   credential.NodeID,
+	}, nil
+}
+
+// authenticateEnrollmentToken resolves an enrollment token to a principal that may only
+// register a node. The token is consumed by the registration, not here.
+func (s *Server) authenticateEnrollmentToken(secret string) (*Principal, error) {
+	store, ok := s.nodeCredentialStore()
+	if !ok {
+		return nil, fmt.Errorf("node enrollment is not supported by this registry")
+	}
+
+	token, err := store.GetEnrollmentTokenByHash(tokenDigest(secret))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+	// The node ID is not known yet, so only usage and expiry are checked here
+	if err := checkEnrollment(token, token.NodeID, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		Kind:     PrincipalEnrollment,
+		Subject:  "enrollment:" + token.ID,
+		Username: "enrollment",
+		NodeID:   token.NodeID,
+		TokenID:  token.ID,
+	}, nil
+}
+
+// authorizeNode writes a 403 response and returns false unless the caller may act on nodeID.
+// Node agents may only act on themselves; everyone else needs action.
+func (s *Server) authorizeNode(w http.ResponseWriter, r *http.Request, nodeID, action string) bool {
+	p := s.caller(r)
+	if p == nil || p.Kind != PrincipalNode {
+		return s.authorize(w, r, action)
+	}
+	if p.NodeID == nodeID {
+		return true
+	}
+	sendM4JSONError(w, http.StatusForbidden, "forbidden", fmt.Sprintf("Node credential is not valid for node %s", nodeID), nil)
+	return false
+}
+
+// IssuedNodeCredential carries a node secret, which is only ever returned once
+type IssuedNodeCredential struct {
+	NodeID    string    `json:"node_id"`
+	Secret    string    `json:"secret"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NodeRegistration is the response to a node registration. Credential is set when the
+// node enrolled with an enrollment token, Update when the node runs another agent version
+// than the server wants.
+type NodeRegistration struct {
+	Node
+	Credential *IssuedNodeCredential `json:"credential,omitempty"`
+	Update     *AgentRelease         `json:"update,omitempty"`
+}
+
+// issueNodeCredential stores a fresh secret for nodeID. A replaced secret remains valid
+// for the rotation grace period when keepPrevious is set.
+func (s *Server) issueNodeCredential(store NodeCredentialStore, nodeID string, keepPrevious bool) (*IssuedNodeCredential, error) {
+	secret, err := generateSecret(NodeCredentialPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	credential := &NodeCredential{
+		NodeID:     nodeID,
+		SecretHash: tokenDigest(secret),
+		IssuedAt:   now,
+		ExpiresAt:  now.Add(nodeCredentialTTL),
+	}
+	if keepPrevious {
+		if current, err := store.GetNodeCredential(nodeID); err == nil {
+			graceEnds := now.Add(credentialRotationGrace)
+			credential.PreviousHash = current.SecretHash
+			credential.PreviousExpiresAt = &graceEnds
+		}
+	}
+
+	if err := store.SaveNodeCredential(credential); err != nil {
+		return nil, err
+	}
+	return &IssuedNodeCredential{NodeID: nodeID, Secret: secret, ExpiresAt: credential.ExpiresAt}, nil
+}
+
+// enrollNode consumes the enrollment token of the caller for nodeID. An unbound token cannot
+// take over a node that already holds a credential.
+func (s *Server) enrollNode(w http.ResponseWriter, p *Principal, nodeID string) (NodeCredentialStore, bool) {
+	store, ok := s.nodeCredentialStore()
+	if !ok {
+		sendM4JSONError(w, http.StatusNotImplemented, "not_supported", "Node enrollment is not supported by this registry", nil)
+		return nil, false
+	}
+	if nodeID == "" {
+		sendM4JSONError(w, http.StatusBadRequest, "missing_node_id", "Node ID required to enroll", nil)
+		return nil, false
+	}
+	if _, err := store.GetNodeCredential(nodeID); err == nil && p.NodeID != nodeID {
+		sendM4JSONError(w, http.StatusConflict, "node_enrolled", fmt.Sprintf("Node %s is already enrolled; revoke its credential first", nodeID), nil)
+		return nil, false
+	}
+
+	if _, err := store.ConsumeEnrollmentToken(p.TokenID, nodeID, time.Now()); err != nil {
+		sendM4JSONError(w, http.StatusForbidden, "enrollment_rejected", err.Error(), nil)
+		return nil, false
+	}
+	return store, true
+}
+
+// handleNodeCredential rotates (POST), inspects (GET) or revokes (DELETE) the credential of a node
+func (s *Server) handleNodeCredential(w http.ResponseWriter, r *http.Request, nodeID string) {
+	store, ok := s.nodeCredentialStore()
+	if !ok {
+		sendM4JSONError(w, http.StatusNotImplemented, "not_supported", "Node credentials are not supported by this registry", nil)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		// Only the node itself rotates, so a credential never leaves the agent that holds it
+		if p := s.caller(r); p != nil && p.Kind != PrincipalNode {
+			sendM4JSONError(w, http.StatusForbidden, "forbidden", "Only the node can rotate its credential", nil)
+			return
+		}
+		if !s.authorizeNode(w, r, nodeID, ActionNodesAdmin) {
+			return
+		}
+
+		issued, err := s.issueNodeCredential(store, nodeID, true)
+		if err != nil {
+			sendM4JSONError(w, http.StatusInternalServerError, "credential_failed", err.Error(), nil)
+			return
+		}
+
+		s.broadcastEvent("node_credential_rotated", map[string]interface{}{"node_id": nodeID})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(issued)
+
+	case http.MethodGet:
+		if !s.authorizeNode(w, r, nodeID, ActionNodesRead) {
+			return
+		}
+
+		credential, err := store.GetNodeCredential(nodeID)
+		if err != nil {
+			sendM4JSONError(w, http.StatusNotFound, "credential_not_found", err.Error(), nil)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(credential)
+
+	case http.MethodDelete:
+		if !s.authorize(w, r, ActionNodesAdmin) {
+			return
+		}
+
+		if err := store.DeleteNodeCredential(nodeID); err != nil {
+			sendM4JSONError(w, http.StatusNotFound, "credential_not_found", err.Error(), nil)
+			return
+		}
+
+		s.broadcastEvent("node_credential_revoked", map[string]interface{}{"node_id": nodeID})
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// CreateEnrollmentTokenRequest is the body of POST /api/v1/enrollment-tokens
+type CreateEnrollmentTokenRequest struct {
+	NodeID    string `json:"node_id,omitempty"`    // restrict the token to one node
+	ExpiresIn string `json:"expires_in,omitempty"` // Go duration; defaults to one hour
+}
+
+// CreateEnrollmentTokenResponse carries the enrollment secret, which is only ever returned once
+type CreateEnrollmentTokenResponse struct {
+	Token           string           `json:"token"`
+	EnrollmentToken *EnrollmentToken `json:"enrollment_token"`
+}
+
+// EnrollmentTokenListResponse is the body of GET /api/v1/enrollment-tokens
+type EnrollmentTokenListResponse struct {
+	Tokens []*EnrollmentToken `json:"tokens"`
+	Count  int                `json:"count"`
+}
+
+// handleEnrollmentTokensRequest routes /api/v1/enrollment-tokens and /api/v1/enrollment-tokens/{id}
+func (s *Server) handleEnrollmentTokensRequest(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.nodeCredentialStore()
+	if !ok {
+		sendM4JSONError(w, http.StatusNotImplemented, "not_supported", "Node enrollment is not supported by this registry", nil)
+		return
+	}
+	if !s.authorize(w, r, ActionNodesAdmin) {
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/enrollment-tokens"), "/")
+	if strings.Contains(id, "/") {
+		http.Error(w, "Invalid endpoint", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		s.handleCreateEnrollmentToken(w, r, store)
+	case r.Method == http.MethodGet && id == "":
+		tokens, err := store.ListEnrollmentTokens()
+		if err != nil {
+			sendM4JSONError(w, http.StatusInternalServerError, "list_failed", fmt.Sprintf("Failed to list enrollment tokens: %v", err), nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(EnrollmentTokenListResponse{Tokens: tokens, Count: len(tokens)})
+	case r.Method == http.MethodDelete && id != "":
+		if err := store.DeleteEnrollmentToken(id); err != nil {
+			sendM4JSONError(w, http.StatusNotFound, "token_not_found", err.Error(), nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleCreateEnrollmentToken(w http.ResponseWriter, r *http.Request, store NodeCredentialStore) {
+	var req CreateEnrollmentTokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendM4JSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body", map[string]interface{}{"error": err.Error()})
+			return
+		}
+	}
+
+	ttl := defaultEnrollmentTTL
+	if req.ExpiresIn != "" {
+		parsed, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || parsed <= 0 {
+			sendM4JSONError(w, http.StatusBadRequest, "invalid_expiry", fmt.Sprintf("Invalid expires_in: %s", req.ExpiresIn), nil)
+			return
+		}
+		ttl = parsed
+	}
+
+	secret, err := generateSecret(EnrollmentTokenPrefix)
+	if err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "token_generation_failed", err.Error(), nil)
+		return
+	}
+
+	now := time.Now()
+	token := &EnrollmentToken{
+		ID:        "enr_" + tokenDigest(secret)[:16],
+		TokenHash: tokenDigest(secret),
+		NodeID:    req.NodeID,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if p := s.caller(r); p != nil {
+		token.CreatedBy = p.Username
+	}
+
+	if err := store.CreateEnrollmentToken(token); err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "token_creation_failed", err.Error(), nil)
+		return
+	}
+
+	s.broadcastEvent("enrollment_token_created", map[string]interface{}{
+		"id":      token.ID,
+		"node_id": token.NodeID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateEnrollmentTokenResponse{Token: secret, EnrollmentToken: token})
+}
@@ -0,0 +1,144 @@
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestEnrollmentToken(t *testing.T, srv *Server, req CreateEnrollmentTokenRequest) string {
+	w := rbacRequest(t, srv, rbacAdminToken, http.MethodPost, "/api/v1/enrollment-tokens", req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var resp CreateEnrollmentTokenResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.True(t, strings.HasPrefix(resp.Token, EnrollmentTokenPrefix))
+	return resp.Token
+}
+
+func enrollTestNode(t *testing.T, srv *Server, enrollmentToken, nodeID string) *IssuedNodeCredential {
+	w := rbacRequest(t, srv, enrollmentToken, http.MethodPost, "/api/v1/nodes", map[string]interface{}{"id": nodeID, "name": nodeID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var registration NodeRegistration
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&registration))
+	assert.Equal(t, nodeID, registration.ID)
+	require.NotNil(t, registration.Credential)
+	assert.True(t, strings.HasPrefix(registration.Credential.Secret, NodeCredentialPrefix))
+	return registration.Credential
+}
+
+func TestNodeEnrollment(t *testing.T) {
+	srv := newRBACTestServer(t)
+
+	w := rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/enrollment-tokens", CreateEnrollmentTokenRequest{})
+	assert.Equal(t, http.StatusForbidden, w.Code, "developers cannot create enrollment tokens")
+
+	enrollment := createTestEnrollmentToken(t, srv, CreateEnrollmentTokenRequest{})
+
+	w = rbacRequest(t, srv, enrollment, http.MethodGet, "/api/v1/nodes", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "enrollment tokens can only register")
+
+	credential := enrollTestNode(t, srv, enrollment, "node-1")
+	assert.Equal(t, "node-1", credential.NodeID)
+
+	w = rbacRequest(t, srv, enrollment, http.MethodPost, "/api/v1/nodes", map[string]interface{}{"id": "node-2"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "enrollment tokens are single use")
+
+	stored, err := srv.registry.(NodeCredentialStore).GetNodeCredential("node-1")
+	require.NoError(t, err)
+	assert.NotEqual(t, credential.Secret, stored.SecretHash, "only the hash is stored")
+
+	bound := createTestEnrollmentToken(t, srv, CreateEnrollmentTokenRequest{NodeID: "node-3"})
+	w = rbacRequest(t, srv, bound, http.MethodPost, "/api/v1/nodes", map[string]interface{}{"id": "node-4"})
+	assert.Equal(t, http.StatusForbidden, w.Code, "bound tokens only enroll their node")
+	enrollTestNode(t, srv, bound, "node-3")
+
+	takeover := createTestEnrollmentToken(t, srv, CreateEnrollmentTokenRequest{})
+	w = rbacRequest(t, srv, takeover, http.MethodPost, "/api/v1/nodes", map[string]interface{}{"id": "node-1"})
+	assert.Equal(t, http.StatusConflict, w.Code, "unbound tokens cannot take over an enrolled node")
+
+	expired := createTestEnrollmentToken(t, srv, CreateEnrollmentTokenRequest{ExpiresIn: "1ms"})
+	time.Sleep(5 * time.Millisecond)
+	w = rbacRequest(t, srv, expired, http.MethodPost, "/api/v1/nodes", map[string]interface{}{"id": "node-5"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestNodeCredentialScope(t *testing.T) {
+	srv := newRBACTestServer(t)
+	require.NoError(t, srv.registry.Register(&Node{ID: "node-2", Name: "node-2"}))
+	credential := enrollTestNode(t, srv, createTestEnrollmentToken(t, srv, CreateEnrollmentTokenRequest{}), "node-1")
+
+	w := rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/nodes/node-1/heartbeat", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPut, "/api/v1/nodes/node-1", map[string]interface{}{"status": "online"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/nodes", map[string]interface{}{"id": "node-1"})
+	assert.Equal(t, http.StatusOK, w.Code, "nodes re-register themselves")
+
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/nodes/node-2/heartbeat", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "nodes cannot act for other nodes")
+	w = rbacRequest(t, srv, credential.Secret, http.MethodDelete, "/api/v1/nodes/node-2", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	srv.commandQueue.enqueue("node-1", Command{ID: "cmd-1", Type: "system", Action: "status"})
+	srv.commandQueue.enqueue("node-2", Command{ID: "cmd-2", Type: "system", Action: "status"})
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/commands/cmd-2/result", CommandResult{ID: "cmd-2", NodeID: "node-1"})
+	assert.Equal(t, http.StatusForbidden, w.Code, "nodes only report results of commands sent to them")
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/commands/cmd-3/result", CommandResult{ID: "cmd-3", NodeID: "node-1"})
+	assert.Equal(t, http.StatusForbidden, w.Code, "or of commands the server never sent")
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/commands/cmd-1/result", CommandResult{ID: "cmd-1", NodeID: "node-2"})
+	assert.Equal(t, http.StatusAccepted, w.Code, "the recorded target wins over the body")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err := srv.waitForCommandResult(ctx, "cmd-1")
+	require.NoError(t, err)
+	assert.Equal(t, "node-1", result.NodeID)
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/commands/cmd-1/result", CommandResult{ID: "cmd-1", NodeID: "node-1"})
+	assert.Equal(t, http.StatusForbidden, w.Code, "a result is only accepted once")
+
+	w = rbacRequest(t, srv, credential.Secret, http.MethodGet, "/api/v1/workspaces", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "node credentials carry no user permissions")
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/enrollment-tokens", CreateEnrollmentTokenRequest{})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestNodeCredentialRotateAndRevoke(t *testing.T) {
+	srv := newRBACTestServer(t)
+	credential := enrollTestNode(t, srv, createTestEnrollmentToken(t, srv, CreateEnrollmentTokenRequest{}), "node-1")
+
+	w := rbacRequest(t, srv, rbacAdminToken, http.MethodPost, "/api/v1/nodes/node-1/credential", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only the node rotates its credential")
+
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/nodes/node-1/credential", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var rotated IssuedNodeCredential
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rotated))
+	assert.NotEqual(t, credential.Secret, rotated.Secret)
+
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/nodes/node-1/heartbeat", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, "the previous secret works during the grace period")
+
+	stored, err := srv.registry.(NodeCredentialStore).GetNodeCredential("node-1")
+	require.NoError(t, err)
+	graceEnded := time.Now().Add(-time.Second)
+	stored.PreviousExpiresAt = &graceEnded
+	w = rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/nodes/node-1/heartbeat", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = rbacRequest(t, srv, rotated.Secret, http.MethodGet, "/api/v1/nodes/node-1/credential", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "secret_hash")
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodDelete, "/api/v1/nodes/node-1/credential", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodDelete, "/api/v1/nodes/node-1/credential", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = rbacRequest(t, srv, rotated.Secret, http.MethodPost, "/api/v1/nodes/node-1/heartbeat", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "revoked nodes are rejected immediately")
+}
@@ -0,0 +1,350 @@
+package coordination
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenAPIPath is where the server publishes the OpenAPI document of its API
+const OpenAPIPath = "/api/v1/openapi.json"
+
+// apiVersion is the version reported in the OpenAPI document
+const apiVersion = "1.0.0"
+
+// apiParam is a query parameter of an API operation
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// apiOperation describes one method on one route. Request and Response are values of the
+// Go types exchanged as JSON; their schemas are derived from the types' json tags.
+type apiOperation struct {
+	ID          string
+	Method      string
+	Path        string
+	Tag         string
+	Summary     string
+	Query       []apiParam
+	Request     interface{}
+	Status      int
+	Response    interface{}
+	ContentType string // of the response, when it is not JSON
+}
+
+// apiOperations lists every operation served by the routes in setupRoutes. The client
+// package has a method named after each operation ID, except the browser-only OAuth callback.
+var apiOperations = []apiOperation{
+	// Nodes
+	{ID: "registerNode", Method: http.MethodPost, Path: "/api/v1/nodes", Tag: "nodes", Summary: "Register a node; enrolling nodes also receive their credential", Request: Node{}, Status: http.StatusOK, Response: NodeRegistration{}},
+	{ID: "listNodes", Method: http.MethodGet, Path: "/api/v1/nodes", Tag: "nodes", Summary: "List nodes", Status: http.StatusOK, Response: NodeListResponse{}},
+	{ID: "getNode", Method: http.MethodGet, Path: "/api/v1/nodes/{id}", Tag: "nodes", Summary: "Get a node", Status: http.StatusOK, Response: Node{}},
+	{ID: "updateNode", Method: http.MethodPut, Path: "/api/v1/nodes/{id}", Tag: "nodes", Summary: "Update fields of a node", Request: map[string]interface{}{}, Status: http.StatusOK, Response: Node{}},
+	{ID: "unregisterNode", Method: http.MethodDelete, Path: "/api/v1/nodes/{id}", Tag: "nodes", Summary: "Unregister a node", Status: http.StatusNoContent},
+	{ID: "getNodeStatus", Method: http.MethodGet, Path: "/api/v1/nodes/{id}/status", Tag: "nodes", Summary: "Get the status of a node", Status: http.StatusOK, Response: NodeStatusResponse{}},
+	{ID: "sendHeartbeat", Method: http.MethodPost, Path: "/api/v1/nodes/{id}/heartbeat", Tag: "nodes", Summary: "Report that a node is alive", Request: NodeHeartbeat{}, Status: http.StatusNoContent},
+	{ID: "rotateNodeCredential", Method: http.MethodPost, Path: "/api/v1/nodes/{id}/credential", Tag: "nodes", Summary: "Exchange a node credential for a new one", Status: http.StatusOK, Response: IssuedNodeCredential{}},
+	{ID: "getNodeCredential", Method: http.MethodGet, Path: "/api/v1/nodes/{id}/credential", Tag: "nodes", Summary: "Get the credential metadata of a node", Status: http.StatusOK, Response: NodeCredential{}},
+	{ID: "revokeNodeCredential", Method: http.MethodDelete, Path: "/api/v1/nodes/{id}/credential", Tag: "nodes", Summary: "Revoke the credential of a node", Status: http.StatusNoContent},
+	{ID: "listNodeWorkspaces", Method: http.MethodGet, Path: "/api/v1/nodes/{id}/workspaces", Tag: "nodes", Summary: "List the workspaces registered on a node", Status: http.StatusOK, Response: NodeWorkspaces{}},
+	{ID: "sendCommand", Method: http.MethodPost, Path: "/api/v1/nodes/{id}/commands", Tag: "commands", Summary: "Queue a command for a node; its output and result follow on the output endpoint", Request: Command{}, Status: http.StatusAccepted, Response: Command{}},
+	{ID: "pollNodeCommands", Method: http.MethodGet, Path: "/api/v1/nodes/{id}/commands", Tag: "commands", Summary: "Wait for the commands sent to a node", Query: []apiParam{
+		{"wait", "string", "How long to wait for a command, e.g. 20s (at most 1m)"},
+		{"ack", "string", "Comma-separated IDs of the commands received from the previous poll; unacknowledged commands are delivered again"},
+	}, Status: http.StatusOK, Response: NodeCommands{}},
+	{ID: "reportCommandOutput", Method: http.MethodPost, Path: "/api/v1/commands/{id}/output", Tag: "commands", Summary: "Report a chunk of the output of a running command", Request: CommandOutput{}, Status: http.StatusAccepted},
+	{ID: "followCommandOutput", Method: http.MethodGet, Path: "/api/v1/commands/{id}/output", Tag: "commands", Summary: "Stream the output of a command as server-sent events, ending with its result", Status: http.StatusOK, Response: CommandStreamEvent{}, ContentType: "text/event-stream"},
+	{ID: "getCommandGitHubToken", Method: http.MethodGet, Path: "/api/v1/commands/{id}/github-token", Tag: "commands", Summary: "Get the GitHub token for a workspace command, as the node running it", Status: http.StatusOK, Response: CommandGitHubToken{}},
+	{ID: "reportCommandResult", Method: http.MethodPost, Path: "/api/v1/commands/{id}/result", Tag: "commands", Summary: "Report the result of a command", Request: CommandResult{}, Status: http.StatusAccepted},
+	{ID: "getAgentRelease", Method: http.MethodGet, Path: "/api/v1/agent/release", Tag: "nodes", Summary: "Get the agent release nodes on a platform should run", Query: []apiParam{
+		{"os", "string", "Operating system of the node, e.g. linux"},
+		{"arch", "string", "Architecture of the node, e.g. amd64"},
+	}, Status: http.StatusOK, Response: AgentRelease{}},
+	{ID: "downloadAgentBinary", Method: http.MethodGet, Path: "/api/v1/agent/binary/{os}/{arch}", Tag: "nodes", Summary: "Download the agent binary hosted by the server", Status: http.StatusOK, ContentType: "application/octet-stream"},
+	{ID: "listServices", Method: http.MethodGet, Path: "/api/v1/services", Tag: "nodes", Summary: "List services by node", Status: http.StatusOK, Response: ServiceListResponse{}},
+
+	// Users
+	{ID: "registerUser", Method: http.MethodPost, Path: "/api/v1/users", Tag: "users", Summary: "Register a user", Request: User{}, Status: http.StatusOK, Response: User{}},
+	{ID: "listUsers", Method: http.MethodGet, Path: "/api/v1/users", Tag: "users", Summary: "List users", Status: http.StatusOK, Response: UserListResponse{}},
+	{ID: "getUser", Method: http.MethodGet, Path: "/api/v1/users/{username}", Tag: "users", Summary: "Get a user", Status: http.StatusOK, Response: User{}},
+	{ID: "deleteUser", Method: http.MethodDelete, Path: "/api/v1/users/{username}", Tag: "users", Summary: "Delete a user and revoke their API tokens", Status: http.StatusNoContent},
+	{ID: "setUserRole", Method: http.MethodPut, Path: "/api/v1/users/{username}/role", Tag: "users", Summary: "Change the role of a user", Request: SetRoleRequest{}, Status: http.StatusOK, Response: User{}},
+	{ID: "getUserUsage", Method: http.MethodGet, Path: "/api/v1/users/{username}/usage", Tag: "users", Summary: "Get a user's usage against their quotas", Status: http.StatusOK, Response: QuotaUsageResponse{}},
+	{ID: "registerGitHubUser", Method: http.MethodPost, Path: "/api/v1/users/register-github", Tag: "users", Summary: "Register a user by GitHub identity and SSH key", Request: M4RegisterGitHubUserRequest{}, Status: http.StatusCreated, Response: M4RegisterGitHubUserResponse{}},
+
+	// Workspaces
+	{ID: "createWorkspace", Method: http.MethodPost, Path: "/api/v1/workspaces/create-from-repo", Tag: "workspaces", Summary: "Create a workspace from a repository", Request: M4CreateWorkspaceRequest{}, Status: http.StatusAccepted, Response: M4CreateWorkspaceResponse{}},
+	{ID: "listWorkspaces", Method: http.MethodGet, Path: "/api/v1/workspaces", Tag: "workspaces", Summary: "List the workspaces visible to the caller", Query: []apiParam{
+		{"limit", "integer", "Maximum number of workspaces, default 50"},
+		{"offset", "integer", "Number of workspaces to skip"},
+	}, Status: http.StatusOK, Response: M4ListWorkspacesResponse{}},
+	{ID: "getWorkspaceStatus", Method: http.MethodGet, Path: "/api/v1/workspaces/{id}/status", Tag: "workspaces", Summary: "Get the status of a workspace", Status: http.StatusOK, Response: M4WorkspaceStatusResponse{}},
+	{ID: "stopWorkspace", Method: http.MethodPost, Path: "/api/v1/workspaces/{id}/stop", Tag: "workspaces", Summary: "Stop a workspace", Status: http.StatusOK, Response: M4StopWorkspaceResponse{}},
+	{ID: "startWorkspace", Method: http.MethodPost, Path: "/api/v1/workspaces/{id}/start", Tag: "workspaces", Summary: "Start a stopped workspace", Status: http.StatusOK, Response: M4StartWorkspaceResponse{}},
+	{ID: "deleteWorkspace", Method: http.MethodDelete, Path: "/api/v1/workspaces/{id}", Tag: "workspaces", Summary: "Delete a workspace", Status: http.StatusOK, Response: M4DeleteWorkspaceResponse{}},
+	{ID: "recordWorkspaceActivity", Method: http.MethodPost, Path: "/api/v1/workspaces/{id}/activity", Tag: "workspaces", Summary: "Record activity in a workspace", Request: WorkspaceActivityRequest{}, Status: http.StatusNoContent},
+	{ID: "extendWorkspace", Method: http.MethodPost, Path: "/api/v1/workspaces/{id}/extend", Tag: "workspaces", Summary: "Keep a workspace alive for longer", Request: ExtendWorkspaceRequest{}, Status: http.StatusOK, Response: ExtendWorkspaceResponse{}},
+	{ID: "workspaceGit", Method: http.MethodPost, Path: "/api/v1/workspaces/{id}/git", Tag: "workspaces", Summary: "Run git status, fetch, checkout, pull or push in a workspace", Request: WorkspaceGitRequest{}, Status: http.StatusOK, Response: WorkspaceGitResult{}},
+	{ID: "listGrants", Method: http.MethodGet, Path: "/api/v1/workspaces/{id}/grants", Tag: "workspaces", Summary: "List the grants of a workspace", Status: http.StatusOK, Response: GrantListResponse{}},
+	{ID: "addGrant", Method: http.MethodPost, Path: "/api/v1/workspaces/{id}/grants", Tag: "workspaces", Summary: "Grant a user access to a workspace", Request: GrantRequest{}, Status: http.StatusCreated, Response: WorkspaceGrant{}},
+	{ID: "removeGrant", Method: http.MethodDelete, Path: "/api/v1/workspaces/{id}/grants/{username}", Tag: "workspaces", Summary: "Revoke a user's access to a workspace", Status: http.StatusNoContent},
+
+	// Tokens
+	{ID: "createAPIToken", Method: http.MethodPost, Path: "/api/v1/tokens", Tag: "tokens", Summary: "Create an API token", Request: CreateAPITokenRequest{}, Status: http.StatusCreated, Response: CreateAPITokenResponse{}},
+	{ID: "listAPITokens", Method: http.MethodGet, Path: "/api/v1/tokens", Tag: "tokens", Summary: "List API tokens", Query: []apiParam{
+		{"username", "string", "List the tokens of another user (admin only)"},
+	}, Status: http.StatusOK, Response: APITokenListResponse{}},
+	{ID: "revokeAPIToken", Method: http.MethodDelete, Path: "/api/v1/tokens/{id}", Tag: "tokens", Summary: "Revoke an API token", Status: http.StatusNoContent},
+	{ID: "createEnrollmentToken", Method: http.MethodPost, Path: "/api/v1/enrollment-tokens", Tag: "tokens", Summary: "Create a one-time node enrollment token", Request: CreateEnrollmentTokenRequest{}, Status: http.StatusCreated, Response: CreateEnrollmentTokenResponse{}},
+	{ID: "listEnrollmentTokens", Method: http.MethodGet, Path: "/api/v1/enrollment-tokens", Tag: "tokens", Summary: "List enrollment tokens", Status: http.StatusOK, Response: EnrollmentTokenListResponse{}},
+	{ID: "deleteEnrollmentToken", Method: http.MethodDelete, Path: "/api/v1/enrollment-tokens/{id}", Tag: "tokens", Summary: "Delete an enrollment token", Status: http.StatusNoContent},
+
+	// Administration
+	{ID: "exportState", Method: http.MethodGet, Path: "/api/v1/admin/export", Tag: "admin", Summary: "Export the server state", Query: []apiParam{
+		{"secrets", "boolean", "Include GitHub installation tokens"},
+	}, Status: http.StatusOK, Response: Snapshot{}},
+	{ID: "importState", Method: http.MethodPost, Path: "/api/v1/admin/import", Tag: "admin", Summary: "Import an exported snapshot", Request: Snapshot{}, Status: http.StatusOK, Response: ImportResult{}},
+	{ID: "listAudit", Method: http.MethodGet, Path: "/api/v1/audit", Tag: "admin", Summary: "List audit entries, newest first", Query: []apiParam{
+		{"actor", "string", "Only entries by this actor"},
+		{"action", "string", "Only entries with this action"},
+		{"resource", "string", "Only entries for this resource or below it"},
+		{"result", "string", "success, denied or error"},
+		{"since", "string", "RFC 3339 time or a duration counted back from now"},
+		{"until", "string", "RFC 3339 time or a duration counted back from now"},
+		{"limit", "integer", "Maximum number of entries"},
+	}, Status: http.StatusOK, Response: AuditListResponse{}},
+
+	// Server
+	{ID: "health", Method: http.MethodGet, Path: "/health", Tag: "server", Summary: "Check server health", Status: http.StatusOK, Response: HealthResponse{}},
+	{ID: "metrics", Method: http.MethodGet, Path: "/metrics", Tag: "server", Summary: "Get node and connection counts", Status: http.StatusOK, Response: map[string]interface{}{}},
+	{ID: "events", Method: http.MethodGet, Path: "/ws", Tag: "server", Summary: "Stream server events as server-sent events", Status: http.StatusOK, Response: Event{}, ContentType: "text/event-stream"},
+	{ID: "openAPI", Method: http.MethodGet, Path: OpenAPIPath, Tag: "server", Summary: "Get this OpenAPI document", Status: http.StatusOK, Response: map[string]interface{}{}},
+
+	// GitHub
+	{ID: "gitHubOAuthCallback", Method: http.MethodGet, Path: "/auth/github/callback", Tag: "github", Summary: "Complete GitHub OAuth in the browser", Query: []apiParam{
+		{"code", "string", "Authorization code"},
+		{"state", "string", "State returned by getGitHubOAuthURL"},
+		{"error", "string", "Error reported by GitHub"},
+	}, Status: http.StatusOK, Response: GitHubOAuthCallbackResponse{}},
+	{ID: "getGitHubToken", Method: http.MethodGet, Path: "/api/github/token", Tag: "github", Summary: "Get the caller's GitHub installation token", Status: http.StatusOK, Response: GitHubTokenResponse{}},
+	{ID: "getGitHubOAuthURL", Method: http.MethodPost, Path: "/api/github/oauth-url", Tag: "github", Summary: "Get a GitHub OAuth authorization URL", Request: GitHubOAuthURLRequest{}, Status: http.StatusOK, Response: GitHubOAuthURLResponse{}},
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument []byte
+)
+
+// OpenAPISpec returns the OpenAPI 3 document describing the coordination API
+func OpenAPISpec() []byte {
+	openAPIOnce.Do(func() {
+		openAPIDocument, _ = json.MarshalIndent(buildOpenAPISpec(apiOperations), "", "  ")
+	})
+	return openAPIDocument
+}
+
+// handleOpenAPI serves the OpenAPI document
+// GET /api/v1/openapi.json
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(OpenAPISpec())
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// buildOpenAPISpec assembles the document for operations
+func buildOpenAPISpec(operations []apiOperation) map[string]interface{} {
+	schemas := &schemaRegistry{components: map[string]interface{}{}}
+	errorSchema := schemas.schemaOf(reflect.TypeOf(M4ErrorResponse{}))
+
+	paths := map[string]interface{}{}
+	for _, op := range operations {
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+
+		var params []interface{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name": match[1], "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]interface{}{
+				"name": q.Name, "in": "query", "description": q.Description,
+				"schema": map[string]interface{}{"type": q.Type},
+			})
+		}
+
+		success := map[string]interface{}{"description": http.StatusText(op.Status)}
+		if op.Response != nil {
+			contentType := op.ContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			success["content"] = map[string]interface{}{
+				contentType: map[string]interface{}{"schema": schemas.schemaOf(reflect.TypeOf(op.Response))},
+			}
+		} else if op.ContentType != "" {
+			// Raw downloads
+			success["content"] = map[string]interface{}{
+				op.ContentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+			}
+		}
+
+		operation := map[string]interface{}{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"responses": map[string]interface{}{
+				strconv.Itoa(op.Status): success,
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": errorSchema},
+					},
+				},
+			},
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemas.schemaOf(reflect.TypeOf(op.Request))},
+				},
+			}
+		}
+
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Nexus Coordination API",
+			"version":     apiVersion,
+			"description": "Manages nodes, users and workspaces. Errors from M4 endpoints carry an M4ErrorResponse; older endpoints answer with plain text.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"bearerAuth": []string{}}},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaRegistry collects the named struct schemas referenced by the document
+type schemaRegistry struct {
+	components map[string]interface{}
+}
+
+// schemaOf returns the JSON schema of t. Named structs are added to the components once
+// and referenced from then on.
+func (r *schemaRegistry) schemaOf(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		// time.Duration is sent as nanoseconds
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": r.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": r.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return r.structSchema(t)
+		}
+		if _, ok := r.components[t.Name()]; !ok {
+			r.components[t.Name()] = map[string]interface{}{} // placeholder for recursive types
+			r.components[t.Name()] = r.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema describes the JSON encoding of a struct, inlining embedded structs
+func (r *schemaRegistry) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	r.addFields(t, properties, &required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (r *schemaRegistry) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				r.addFields(embedded, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = r.schemaOf(field.Type)
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
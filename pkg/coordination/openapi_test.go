@@ -0,0 +1,250 @@
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registeredRoutes returns the patterns registered in setupRoutes, each with the handler
+// methods of the Server serving it
+func registeredRoutes(t *testing.T) map[string]string {
+	file, err := parser.ParseFile(token.NewFileSet(), "registry.go", nil, 0)
+	require.NoError(t, err)
+
+	routes := make(map[string]string)
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "setupRoutes" {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 2 {
+				return true
+			}
+			if sel, ok := call.Fun.(*ast.SelectorExpr); !ok || sel.Sel.Name != "HandleFunc" {
+				return true
+			}
+			handler, ok := call.Args[1].(*ast.SelectorExpr)
+			require.True(t, ok, "routes are served by Server methods")
+			switch arg := call.Args[0].(type) {
+			case *ast.BasicLit:
+				pattern, err := strconv.Unquote(arg.Value)
+				require.NoError(t, err)
+				routes[pattern] = handler.Sel.Name
+			case *ast.Ident:
+				require.Equal(t, "OpenAPIPath", arg.Name)
+				routes[OpenAPIPath] = handler.Sel.Name
+			}
+			return true
+		})
+	}
+	require.NotEmpty(t, routes)
+	return routes
+}
+
+// routeSegment matches the string literals a router compares path segments against
+var routeSegment = regexp.MustCompile(`^/?([a-z][a-z-]*)$`)
+
+// routerSegments returns the path segments the given router functions dispatch on
+func routerSegments(t *testing.T, routers map[string]bool) []string {
+	pkgs, err := parser.ParseDir(token.NewFileSet(), ".", func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	require.NoError(t, err)
+
+	seen := make(map[string]bool)
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv == nil || !routers[fn.Name.Name] {
+					continue
+				}
+				ast.Inspect(fn.Body, func(n ast.Node) bool {
+					lit, ok := n.(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						return true
+					}
+					value, err := strconv.Unquote(lit.Value)
+					if err == nil {
+						if m := routeSegment.FindStringSubmatch(value); m != nil {
+							seen[m[1]] = true
+						}
+					}
+					return true
+				})
+			}
+		}
+	}
+
+	segments := make([]string, 0, len(seen))
+	for segment := range seen {
+		segments = append(segments, segment)
+	}
+	sort.Strings(segments)
+	return segments
+}
+
+// specOperation finds the operation of the OpenAPI document serving method on a concrete path
+func specOperation(method, path string) (apiOperation, bool) {
+	segments := strings.Split(path, "/")
+	for _, op := range apiOperations {
+		if op.Method != method {
+			continue
+		}
+		template := strings.Split(op.Path, "/")
+		if len(template) != len(segments) {
+			continue
+		}
+		matched := true
+		for i, part := range template {
+			isParam := strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}")
+			if part != segments[i] && (!isParam || segments[i] == "") {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return op, true
+		}
+	}
+	return apiOperation{}, false
+}
+
+// samplePath fills the parameters of an operation path with sample values
+func samplePath(template string) string {
+	return regexp.MustCompile(`\{[^}]+\}`).ReplaceAllString(template, "probe")
+}
+
+// routed reports whether the router served method on path, rather than answering that
+// there is no such route or method
+func routed(t *testing.T, srv *Server, method, path string) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // handlers that wait for nodes or stream output return at once
+
+	req := httptest.NewRequest(method, path, strings.NewReader("{}")).WithContext(ctx)
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, req)
+
+	switch body := strings.TrimSpace(w.Body.String()); {
+	case w.Code == http.StatusMethodNotAllowed:
+		return false
+	case w.Code == http.StatusNotFound && (body == "404 page not found" || body == "Invalid endpoint"):
+		return false
+	}
+	return true
+}
+
+var probeMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+func TestOpenAPICoversEveryRoute(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+	routes := registeredRoutes(t)
+
+	// Every operation is served by the router
+	for _, op := range apiOperations {
+		path := samplePath(op.Path)
+		if op.Path == "/api/v1/agent/binary/{os}/{arch}" {
+			path = "/api/v1/agent/binary/linux/amd64"
+		}
+		assert.True(t, routed(t, srv, op.Method, path), "operation %s (%s %s) is not served", op.ID, op.Method, op.Path)
+	}
+
+	// Every method on every path the routers dispatch on is an operation
+	routers := make(map[string]bool)
+	for pattern, handler := range routes {
+		if strings.HasSuffix(pattern, "/") {
+			routers[handler] = true
+		}
+	}
+	segments := routerSegments(t, routers)
+	require.Contains(t, segments, "git", "the segments routers dispatch on are found")
+
+	var paths []string
+	for pattern := range routes {
+		if !strings.HasSuffix(pattern, "/") {
+			paths = append(paths, pattern)
+			continue
+		}
+		paths = append(paths, pattern+"probe", pattern+"probe/probe")
+		for _, segment := range segments {
+			paths = append(paths,
+				pattern+segment,
+				pattern+"probe/"+segment,
+				pattern+"probe/"+segment+"/probe",
+			)
+		}
+	}
+	for _, path := range paths {
+		for _, method := range probeMethods {
+			if !routed(t, srv, method, path) {
+				continue
+			}
+			_, ok := specOperation(method, path)
+			assert.True(t, ok, "%s %s is served but has no operation in the OpenAPI document", method, path)
+		}
+	}
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	srv := newTestServer(t, &Config{})
+	w := httptest.NewRecorder()
+	srv.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, OpenAPIPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var spec map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &spec))
+	assert.Equal(t, "3.0.3", spec["openapi"])
+
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	create := schemas["M4CreateWorkspaceRequest"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"$ref": "#/components/schemas/M4Resources"}, create["resources"])
+	assert.Equal(t, map[string]interface{}{"$ref": "#/components/schemas/M4Repository"}, create["repo"])
+
+	registration := schemas["NodeRegistration"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Contains(t, registration, "credential")
+	assert.Contains(t, registration, "address", "embedded structs are inlined")
+
+	token := schemas["APIToken"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.NotContains(t, token, "TokenHash", "fields hidden from JSON are not described")
+
+	// Every reference resolves to a component
+	var refs []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				refs = append(refs, ref)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(spec)
+	require.NotEmpty(t, refs)
+	for _, ref := range refs {
+		assert.Contains(t, schemas, strings.TrimPrefix(ref, "#/components/schemas/"))
+	}
+}
@@ -0,0 +1,344 @@
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Workspace sizes assumed when a create request does not specify resources
+const (
+	defaultWorkspaceCPU      = 2
+	defaultWorkspaceMemoryMB = 4096
+	defaultWorkspaceDiskGB   = 20
+)
+
+// Quota error codes returned when a request would exceed a limit
+const (
+	QuotaWorkspacesExceeded = "workspace_quota_exceeded"
+	QuotaRunningExceeded    = "running_quota_exceeded"
+	QuotaCPUExceeded        = "cpu_quota_exceeded"
+	QuotaMemoryExceeded     = "memory_quota_exceeded"
+	QuotaDiskExceeded       = "disk_quota_exceeded"
+)
+
+// QuotaScopeUser names the per-user quota scope; team scopes are "team:<name>"
+const QuotaScopeUser = "user"
+
+// QuotaUsage is the workspace usage counted against a quota. CPU and memory are reserved
+// while a workspace is active; disk is held until the workspace is deleted.
+type QuotaUsage struct {
+	Workspaces int   `json:"workspaces"`
+	Running    int   `json:"running"`
+	CPU        int   `json:"cpu"`
+	MemoryMB   int64 `json:"memory_mb"`
+	DiskGB     int64 `json:"disk_gb"`
+}
+
+// QuotaScope is a set of limits together with the usage counted against them
+type QuotaScope struct {
+	Scope  string      `json:"scope"`
+	Limits QuotaLimits `json:"limits"`
+	Usage  QuotaUsage  `json:"usage"`
+}
+
+// QuotaUsageResponse is the body of GET /api/v1/users/{username}/usage
+type QuotaUsageResponse struct {
+	Username string       `json:"username"`
+	Scopes   []QuotaScope `json:"scopes"`
+}
+
+// isActiveWorkspace reports whether a workspace in status holds CPU and memory
+func isActiveWorkspace(status string) bool {
+	switch status {
+	case "pending", "creating", "starting", "running", "unreachable":
+		return true
+	}
+	return false
+}
+
+// add counts ws against the usage
+func (u *QuotaUsage) add(ws *DBWorkspace) {
+	u.Workspaces++
+	u.DiskGB += ws.DiskGB
+	if isActiveWorkspace(ws.Status) {
+		u.Running++
+		u.CPU += ws.CPU
+		u.MemoryMB += ws.MemoryMB
+	}
+}
+
+// quotaViolation describes the first limit a request would exceed
+type quotaViolation struct {
+	Code      string
+	Limit     string
+	Unit      string
+	Scope     string
+	Current   int64
+	Requested int64
+	Max       int64
+}
+
+// check returns the first limit that usage plus requested would exceed. Only dimensions
+// the request increases are checked, so a scope already over a lowered limit can still
+// stop or delete workspaces.
+func (l QuotaLimits) check(scope string, usage, requested QuotaUsage) *quotaViolation {
+	dimensions := []quotaViolation{
+		{QuotaWorkspacesExceeded, "max_workspaces", "workspaces", scope, int64(usage.Workspaces), int64(requested.Workspaces), int64(l.MaxWorkspaces)},
+		{QuotaRunningExceeded, "max_running", "running workspaces", scope, int64(usage.Running), int64(requested.Running), int64(l.MaxRunning)},
+		{QuotaCPUExceeded, "max_cpu", "vCPUs", scope, int64(usage.CPU), int64(requested.CPU), int64(l.MaxCPU)},
+		{QuotaMemoryExceeded, "max_memory_mb", "MB of memory", scope, usage.MemoryMB, requested.MemoryMB, l.MaxMemoryMB},
+		{QuotaDiskExceeded, "max_disk_gb", "GB of disk", scope, usage.DiskGB, requested.DiskGB, l.MaxDiskGB},
+	}
+	for _, d := range dimensions {
+		if d.Max > 0 && d.Requested > 0 && d.Current+d.Requested > d.Max {
+			return &d
+		}
+	}
+	return nil
+}
+
+// sendQuotaError writes a 403 response describing v
+func sendQuotaError(w http.ResponseWriter, v *quotaViolation) {
+	message := fmt.Sprintf("Quota exceeded for %s: %d of %d %s in use, %d more requested", v.Scope, v.Current, v.Max, v.Unit, v.Requested)
+	sendM4JSONError(w, http.StatusForbidden, v.Code, message, map[string]interface{}{
+		"limit":     v.Limit,
+		"scope":     v.Scope,
+		"current":   v.Current,
+		"requested": v.Requested,
+		"max":       v.Max,
+	})
+}
+
+// usageOf sums the workspaces owned by the given user IDs
+func (s *Server) usageOf(userIDs ...string) (QuotaUsage, error) {
+	var usage QuotaUsage
+	for _, id := range userIDs {
+		workspaces, err := s.workspaceRegistry.ListByUser(id)
+		if err != nil {
+			return QuotaUsage{}, fmt.Errorf("failed to list workspaces: %w", err)
+		}
+		for _, ws := range workspaces {
+			usage.add(ws)
+		}
+	}
+	return usage, nil
+}
+
+// quotaScopes returns the limits that apply to user with their current usage: the user's
+// own limits first, then every team the user belongs to, in name order
+func (s *Server) quotaScopes(user *User) ([]QuotaScope, error) {
+	quotas := s.config.Quotas
+
+	limits, ok := quotas.Users[user.Username]
+	if !ok {
+		limits = quotas.Default
+	}
+	usage, err := s.usageOf(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	scopes := []QuotaScope{{Scope: QuotaScopeUser, Limits: limits, Usage: usage}}
+
+	names := make([]string, 0, len(quotas.Teams))
+	for name, team := range quotas.Teams {
+		if slices.Contains(team.Members, user.Username) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	userRegistry := s.registry.GetUserRegistry()
+	for _, name := range names {
+		team := quotas.Teams[name]
+		var memberIDs []string
+		for _, member := range team.Members {
+			// Members who have not registered yet hold no workspaces
+			if u, err := userRegistry.GetByUsername(member); err == nil {
+				memberIDs = append(memberIDs, u.ID)
+			}
+		}
+		usage, err := s.usageOf(memberIDs...)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, QuotaScope{Scope: "team:" + name, Limits: team.QuotaLimits, Usage: usage})
+	}
+
+	return scopes, nil
+}
+
+// checkQuota returns the first limit user would exceed by adding requested to their usage
+func (s *Server) checkQuota(user *User, requested QuotaUsage) (*quotaViolation, error) {
+	scopes, err := s.quotaScopes(user)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		if v := scope.Limits.check(scope.Scope, scope.Usage, requested); v != nil {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+// workspaceOwner returns the user that owns ws
+func (s *Server) workspaceOwner(ws *DBWorkspace) (*User, error) {
+	users, err := s.registry.GetUserRegistry().List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	for _, user := range users {
+		if user.ID == ws.UserID {
+			return user, nil
+		}
+	}
+	return nil, fmt.Errorf("user not found: %s", ws.UserID)
+}
+
+// handleUserUsage reports a user's workspace usage against every quota that applies to them
+// GET /api/v1/users/{username}/usage
+func (s *Server) handleUserUsage(w http.ResponseWriter, r *http.Request, username string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isCaller(r, username) && !s.authorize(w, r, ActionUsersRead) {
+		return
+	}
+
+	user, err := s.registry.GetUserRegistry().GetByUsername(username)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "user_not_found", fmt.Sprintf("User not found: %s", username), nil)
+		return
+	}
+
+	scopes, err := s.quotaScopes(user)
+	if err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "usage_failed", fmt.Sprintf("Failed to compute usage: %v", err), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(QuotaUsageResponse{Username: username, Scopes: scopes})
+}
+
+// M4StartWorkspaceResponse is the body returned by POST /api/v1/workspaces/{id}/start
+type M4StartWorkspaceResponse struct {
+	WorkspaceID string    `json:"workspace_id"`
+	Status      string    `json:"status"`
+	StartedAt   time.Time `json:"started_at"`
+}
+
+// handleM4StartWorkspace starts a stopped workspace if its owner has running, CPU and memory quota left
+// POST /api/v1/workspaces/{id}/start
+func (s *Server) handleM4StartWorkspace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	workspaceID := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/workspaces/"), "/")[0]
+	if workspaceID == "" {
+		sendM4JSONError(w, http.StatusBadRequest, "missing_id", "Workspace ID required", nil)
+		return
+	}
+
+	ws, err := s.workspaceRegistry.Get(workspaceID)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "workspace_not_found", fmt.Sprintf("Workspace not found: %s", workspaceID), nil)
+		return
+	}
+
+	if !s.authorizeWorkspace(w, r, ws, GrantCollaborator) {
+		return
+	}
+
+	if !isActiveWorkspace(ws.Status) && !s.startWorkspace(w, r, ws) {
+		return
+	}
+
+	ws, err = s.workspaceRegistry.Get(workspaceID)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "workspace_not_found", fmt.Sprintf("Workspace not found: %s", workspaceID), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(M4StartWorkspaceResponse{
+		WorkspaceID: workspaceID,
+		Status:      ws.Status,
+		StartedAt:   time.Now(),
+	})
+}
+
+// startWorkspace has the node hosting a stopped workspace start it. The workspace is held
+// as starting, so it counts against the quota while the node works, and only marked
+// running once the node confirms; otherwise it goes back to its previous status and the
+// failure is written to w.
+func (s *Server) startWorkspace(w http.ResponseWriter, r *http.Request, ws *DBWorkspace) bool {
+	if ws.NodeID == nil || *ws.NodeID == "" {
+		sendM4JSONError(w, http.StatusConflict, "workspace_not_placed", "Workspace is not running on a node", nil)
+		return false
+	}
+
+	s.quotaMu.Lock()
+	// Another request may have started it since ws was read
+	if current, err := s.workspaceRegistry.Get(ws.WorkspaceID); err == nil && isActiveWorkspace(current.Status) {
+		s.quotaMu.Unlock()
+		return true
+	}
+	owner, err := s.workspaceOwner(ws)
+	if err != nil {
+		s.quotaMu.Unlock()
+		sendM4JSONError(w, http.StatusInternalServerError, "start_failed", fmt.Sprintf("Failed to start workspace: %v", err), nil)
+		return false
+	}
+	violation, err := s.checkQuota(owner, QuotaUsage{Running: 1, CPU: ws.CPU, MemoryMB: ws.MemoryMB})
+	if err != nil {
+		s.quotaMu.Unlock()
+		sendM4JSONError(w, http.StatusInternalServerError, "start_failed", fmt.Sprintf("Failed to check quota: %v", err), nil)
+		return false
+	}
+	if violation != nil {
+		s.quotaMu.Unlock()
+		sendQuotaError(w, violation)
+		return false
+	}
+	previous := ws.Status
+	err = s.workspaceRegistry.UpdateStatus(ws.WorkspaceID, "starting")
+	s.quotaMu.Unlock()
+	if err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "start_failed", fmt.Sprintf("Failed to start workspace: %v", err), nil)
+		return false
+	}
+
+	// Waiting for the node may outlast the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err := s.runWorkspaceAction(r.Context(), ws, "start"); err != nil {
+		if restoreErr := s.workspaceRegistry.UpdateStatus(ws.WorkspaceID, previous); restoreErr != nil {
+			log.Printf("Failed to restore status of workspace %s: %v", ws.WorkspaceID, restoreErr)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			sendM4JSONError(w, http.StatusGatewayTimeout, "node_timeout", fmt.Sprintf("Node %s did not report the result in time", *ws.NodeID), nil)
+		} else {
+			sendM4JSONError(w, http.StatusBadGateway, "start_failed", fmt.Sprintf("Failed to start workspace: %v", err), nil)
+		}
+		return false
+	}
+
+	// Starting counts as activity, so a long-idle workspace is not stopped again straight away
+	updates := map[string]interface{}{"status": "running", "last_activity_at": time.Now()}
+	if err := s.workspaceRegistry.Update(ws.WorkspaceID, updates); err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "start_failed", fmt.Sprintf("Failed to start workspace: %v", err), nil)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package coordination
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func decodeM4Error(t *testing.T, body []byte) M4ErrorResponse {
+	var resp M4ErrorResponse
+	require.NoError(t, json.Unmarshal(body, &resp))
+	return resp
+}
+
+func TestQuotaLimitsCheck(t *testing.T) {
+	limits := QuotaLimits{MaxWorkspaces: 3, MaxCPU: 8, MaxDiskGB: 100}
+
+	assert.Nil(t, limits.check(QuotaScopeUser, QuotaUsage{Workspaces: 2, CPU: 4}, QuotaUsage{Workspaces: 1, CPU: 4}))
+
+	v := limits.check(QuotaScopeUser, QuotaUsage{Workspaces: 3}, QuotaUsage{Workspaces: 1})
+	require.NotNil(t, v)
+	assert.Equal(t, QuotaWorkspacesExceeded, v.Code)
+	assert.Equal(t, int64(3), v.Max)
+
+	v = limits.check(QuotaScopeUser, QuotaUsage{CPU: 6}, QuotaUsage{CPU: 4})
+	require.NotNil(t, v)
+	assert.Equal(t, QuotaCPUExceeded, v.Code)
+
+	assert.Nil(t, limits.check(QuotaScopeUser, QuotaUsage{Workspaces: 5, CPU: 10}, QuotaUsage{Running: 1}),
+		"only dimensions the request increases are checked")
+	assert.Nil(t, QuotaLimits{}.check(QuotaScopeUser, QuotaUsage{Workspaces: 100}, QuotaUsage{Workspaces: 1}),
+		"zero limits are unlimited")
+}
+
+func TestQuotaConfigYAML(t *testing.T) {
+	var cfg Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+quotas:
+  default:
+    max_workspaces: 5
+    max_running: 2
+  users:
+    alice:
+      max_cpu: 16
+  teams:
+    core:
+      members: [alice, bob]
+      max_memory_mb: 32768
+      max_disk_gb: 500
+`), &cfg))
+
+	assert.Equal(t, QuotaLimits{MaxWorkspaces: 5, MaxRunning: 2}, cfg.Quotas.Default)
+	assert.Equal(t, QuotaLimits{MaxCPU: 16}, cfg.Quotas.Users["alice"])
+	assert.Equal(t, TeamQuota{
+		Members:     []string{"alice", "bob"},
+		QuotaLimits: QuotaLimits{MaxMemoryMB: 32768, MaxDiskGB: 500},
+	}, cfg.Quotas.Teams["core"])
+}
+
+func TestQuotaCreateWorkspace(t *testing.T) {
+	srv := newRBACTestServer(t)
+	srv.config.Quotas = QuotaConfig{
+		Default: QuotaLimits{MaxWorkspaces: 2},
+		Users:   map[string]QuotaLimits{"bob": {MaxMemoryMB: 2048}},
+	}
+
+	srv.gitHubInstallationsMu.Lock()
+	for _, name := range []string{"alice", "bob"} {
+		srv.gitHubInstallations[name] = &GitHubInstallation{
+			UserID:         "sub-" + name,
+			GitHubUsername: name,
+			Token:          "test-token",
+			TokenExpiresAt: time.Now().Add(time.Hour),
+		}
+	}
+	srv.gitHubInstallationsMu.Unlock()
+
+	create := func(username, name string, resources *M4Resources) *M4CreateWorkspaceRequest {
+		return &M4CreateWorkspaceRequest{
+			GitHubUsername: username,
+			WorkspaceName:  name,
+			Provider:       "lxc",
+			Repository:     M4Repository{Owner: "org", Name: "project"},
+			Resources:      resources,
+		}
+	}
+
+	w := rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/create-from-repo", create("alice", "second", nil))
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/create-from-repo", create("alice", "third", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	resp := decodeM4Error(t, w.Body.Bytes())
+	assert.Equal(t, QuotaWorkspacesExceeded, resp.Error)
+	assert.Equal(t, QuotaScopeUser, resp.Details["scope"])
+	assert.EqualValues(t, 2, resp.Details["max"])
+
+	w = rbacRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/create-from-repo", create("bob", "big", nil))
+	require.Equal(t, http.StatusForbidden, w.Code, "the default size exceeds bob's memory limit")
+	assert.Equal(t, QuotaMemoryExceeded, decodeM4Error(t, w.Body.Bytes()).Error)
+
+	w = rbacRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/create-from-repo", create("bob", "small", &M4Resources{CPU: 1, MemoryMB: 1024}))
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var created M4CreateWorkspaceResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	ws, err := srv.workspaceRegistry.Get(created.WorkspaceID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, ws.CPU)
+	assert.Equal(t, int64(1024), ws.MemoryMB)
+	assert.Equal(t, int64(defaultWorkspaceDiskGB), ws.DiskGB)
+}
+
+func TestQuotaStartWorkspace(t *testing.T) {
+	srv := newRBACTestServer(t)
+	srv.config.Quotas = QuotaConfig{
+		Teams: map[string]TeamQuota{
+			"core": {Members: []string{"alice", "bob"}, QuotaLimits: QuotaLimits{MaxRunning: 2}},
+		},
+	}
+	require.NoError(t, srv.registry.Register(&Node{ID: "node-1", Status: "active"}))
+	nodeID := "node-1"
+	require.NoError(t, srv.workspaceRegistry.Create(&DBWorkspace{WorkspaceID: "ws-3", UserID: "sub-alice", WorkspaceName: "spike", Status: "stopped", NodeID: &nodeID}))
+
+	w := rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-3/start", nil)
+	require.Equal(t, http.StatusForbidden, w.Code, "ws-1 and bob's ws-2 fill the team's running quota")
+	resp := decodeM4Error(t, w.Body.Bytes())
+	assert.Equal(t, QuotaRunningExceeded, resp.Error)
+	assert.Equal(t, "team:core", resp.Details["scope"])
+
+	w = rbacRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/ws-2/stop", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The workspace stays stopped when the node cannot start it
+	go answerWorkspaceCommand(t, srv, "node-1", "start", "failed")
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-3/start", nil)
+	require.Equal(t, http.StatusBadGateway, w.Code, w.Body.String())
+	ws, err := srv.workspaceRegistry.Get("ws-3")
+	require.NoError(t, err)
+	assert.Equal(t, "stopped", ws.Status)
+
+	go answerWorkspaceCommand(t, srv, "node-1", "start", "success")
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-3/start", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var started M4StartWorkspaceResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&started))
+	assert.Equal(t, "running", started.Status)
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-3/start", nil)
+	assert.Equal(t, http.StatusOK, w.Code, "starting a running workspace is a no-op")
+
+	w = rbacRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/ws-3/start", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only collaborators start a workspace")
+
+	require.NoError(t, srv.workspaceRegistry.Create(&DBWorkspace{WorkspaceID: "ws-4", UserID: "sub-alice", WorkspaceName: "unplaced", Status: "stopped"}))
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-4/start", nil)
+	assert.Equal(t, http.StatusConflict, w.Code, "a workspace must be on a node to start")
+}
+
+func TestUserUsage(t *testing.T) {
+	srv := newRBACTestServer(t)
+	srv.config.Quotas = QuotaConfig{
+		Default: QuotaLimits{MaxWorkspaces: 5, MaxCPU: 8},
+		Teams: map[string]TeamQuota{
+			"core": {Members: []string{"alice", "bob", "dave"}, QuotaLimits: QuotaLimits{MaxDiskGB: 100}},
+			"ops":  {Members: []string{"carol"}},
+		},
+	}
+	ws, err := srv.workspaceRegistry.Get("ws-1")
+	require.NoError(t, err)
+	ws.CPU, ws.MemoryMB, ws.DiskGB = 2, 4096, 20
+	require.NoError(t, srv.workspaceRegistry.Create(&DBWorkspace{WorkspaceID: "ws-3", UserID: "sub-bob", Status: "stopped", CPU: 4, DiskGB: 30}))
+
+	w := rbacRequest(t, srv, "alice-token", http.MethodGet, "/api/v1/users/alice/usage", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var usage QuotaUsageResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&usage))
+	require.Len(t, usage.Scopes, 2)
+	assert.Equal(t, QuotaScope{
+		Scope:  QuotaScopeUser,
+		Limits: QuotaLimits{MaxWorkspaces: 5, MaxCPU: 8},
+		Usage:  QuotaUsage{Workspaces: 1, Running: 1, CPU: 2, MemoryMB: 4096, DiskGB: 20},
+	}, usage.Scopes[0])
+	assert.Equal(t, QuotaScope{
+		Scope:  "team:core",
+		Limits: QuotaLimits{MaxDiskGB: 100},
+		Usage:  QuotaUsage{Workspaces: 3, Running: 2, CPU: 2, MemoryMB: 4096, DiskGB: 50},
+	}, usage.Scopes[1], "stopped workspaces hold disk but not CPU")
+
+	w = rbacRequest(t, srv, "bob-token", http.MethodGet, "/api/v1/users/alice/usage", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodGet, "/api/v1/users/alice/usage", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodGet, "/api/v1/users/nobody/usage", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
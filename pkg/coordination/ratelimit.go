@@ -0,0 +1,214 @@
+package coordination
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucketSweepInterval is how often buckets that have refilled completely are forgotten
+const bucketSweepInterval = time.Minute
+
+// expensivePaths are endpoints that do costly work or talk to GitHub on every call
+var expensivePaths = []string{
+	"/api/v1/workspaces/create-from-repo",
+	"/api/v1/users/register-github",
+	"/auth/",
+	"/api/github/",
+}
+
+// longLivedPaths hold their connection open and are left out of the concurrency cap
+var longLivedPaths = []string{"/ws"}
+
+func isExpensive(path string) bool {
+	for _, prefix := range expensivePaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLongLived(r *http.Request) bool {
+	for _, p := range longLivedPaths {
+		if r.URL.Path == p {
+			return true
+		}
+	}
+	if r.Method != http.MethodGet {
+		return false
+	}
+	// Nodes polling for commands, GET /api/v1/nodes/{id}/commands
+	if strings.HasPrefix(r.URL.Path, "/api/v1/nodes/") && strings.HasSuffix(r.URL.Path, "/commands") {
+		return true
+	}
+	// Following command output, GET /api/v1/commands/{id}/output
+	return strings.HasPrefix(r.URL.Path, "/api/v1/commands/") && strings.HasSuffix(r.URL.Path, "/output")
+}
+
+// tokenBucket holds the requests a client may still make
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one token bucket per key
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// newRateLimiter returns nil when cfg does not limit anything
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	if cfg.RequestsPerSecond <= 0 {
+		return nil
+	}
+	burst := float64(cfg.Burst)
+	if burst < 1 {
+		burst = math.Max(1, math.Ceil(cfg.RequestsPerSecond))
+	}
+	return &rateLimiter{
+		rate:    cfg.RequestsPerSecond,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from the bucket of key. When the bucket is empty it returns false
+// and how long until the next token is available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > bucketSweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep forgets buckets that would be full by now, which behave the same as new ones
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// requestLimits are the limits applied to every API request
+type requestLimits struct {
+	perToken     *rateLimiter
+	perIP        *rateLimiter
+	expensive    *rateLimiter
+	maxBodyBytes int64
+	slots        chan struct{} // nil when concurrency is unlimited
+}
+
+func newRequestLimits(cfg LimitsConfig) *requestLimits {
+	limits := &requestLimits{
+		perToken:     newRateLimiter(cfg.PerToken),
+		perIP:        newRateLimiter(cfg.PerIP),
+		expensive:    newRateLimiter(cfg.Expensive),
+		maxBodyBytes: cfg.MaxBodyBytes,
+	}
+	if cfg.MaxConcurrent > 0 {
+		limits.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return limits
+}
+
+// clientKey identifies the client of r for rate limiting: its bearer token when it sent
+// one, otherwise its address. Tokens are keyed by digest so they are not kept in memory.
+func clientKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		return "token:" + tokenDigest(token)
+	}
+	return "ip:" + sourceIP(r)
+}
+
+// sendTooManyRequests writes a 429 response telling the client when to retry
+func sendTooManyRequests(w http.ResponseWriter, code, message string, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	sendM4JSONError(w, http.StatusTooManyRequests, code, message, map[string]interface{}{
+		"retry_after_seconds": seconds,
+	})
+}
+
+// limitsMiddleware rejects requests over the configured rates, body size or concurrency
+func (s *Server) limitsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limits := s.limits
+		now := time.Now()
+
+		if limits.perIP != nil {
+			if ok, wait := limits.perIP.allow(sourceIP(r), now); !ok {
+				sendTooManyRequests(w, "rate_limited", "Too many requests from this address", wait)
+				return
+			}
+		}
+
+		key := clientKey(r)
+		if limits.perToken != nil && strings.HasPrefix(key, "token:") {
+			if ok, wait := limits.perToken.allow(key, now); !ok {
+				sendTooManyRequests(w, "rate_limited", "Too many requests for this token", wait)
+				return
+			}
+		}
+
+		if limits.expensive != nil && isExpensive(r.URL.Path) {
+			if ok, wait := limits.expensive.allow(key, now); !ok {
+				sendTooManyRequests(w, "rate_limited", fmt.Sprintf("Too many requests to %s", r.URL.Path), wait)
+				return
+			}
+		}
+
+		if limits.maxBodyBytes > 0 && r.Body != nil {
+			if r.ContentLength > limits.maxBodyBytes {
+				sendM4JSONError(w, http.StatusRequestEntityTooLarge, "request_too_large", "Request body too large", map[string]interface{}{
+					"max_bytes": limits.maxBodyBytes,
+				})
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limits.maxBodyBytes)
+		}
+
+		if limits.slots != nil && !isLongLived(r) {
+			select {
+			case limits.slots <- struct{}{}:
+				defer func() { <-limits.slots }()
+			default:
+				sendTooManyRequests(w, "server_busy", "Too many concurrent requests", time.Second)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
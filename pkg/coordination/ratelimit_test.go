@@ -0,0 +1,123 @@
+package coordination
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	limiter := newRateLimiter(RateLimitConfig{RequestsPerSecond: 2, Burst: 3})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		ok, _ := limiter.allow("client", now)
+		assert.True(t, ok, "burst request %d", i)
+	}
+	ok, wait := limiter.allow("client", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	ok, _ = limiter.allow("other", now)
+	assert.True(t, ok, "each key has its own bucket")
+
+	ok, _ = limiter.allow("client", now.Add(500*time.Millisecond))
+	assert.True(t, ok, "tokens refill at the configured rate")
+
+	limiter.sweep(now.Add(time.Hour))
+	assert.Empty(t, limiter.buckets, "full buckets are forgotten")
+
+	assert.Nil(t, newRateLimiter(RateLimitConfig{}), "a zero rate disables the limiter")
+}
+
+// newLimitedServer returns the server router behind limits, plus a /slow endpoint that
+// blocks until release is closed
+func newLimitedServer(t *testing.T, limits LimitsConfig) (http.Handler, chan struct{}) {
+	srv := newTestServer(t, &Config{})
+	srv.limits = newRequestLimits(limits)
+
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	mux.Handle("/", srv.router)
+	return srv.limitsMiddleware(mux), release
+}
+
+func limitedRequest(handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = "192.0.2.1:1234"
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestLimitsMiddlewareRates(t *testing.T) {
+	handler, _ := newLimitedServer(t, LimitsConfig{
+		PerToken:  RateLimitConfig{RequestsPerSecond: 1, Burst: 2},
+		Expensive: RateLimitConfig{RequestsPerSecond: 0.1, Burst: 1},
+	})
+
+	assert.Equal(t, http.StatusOK, limitedRequest(handler, http.MethodGet, "/health", "token-a", "").Code)
+	assert.Equal(t, http.StatusOK, limitedRequest(handler, http.MethodGet, "/health", "token-a", "").Code)
+	w := limitedRequest(handler, http.MethodGet, "/health", "token-a", "")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "rate_limited")
+
+	assert.Equal(t, http.StatusOK, limitedRequest(handler, http.MethodGet, "/health", "token-b", "").Code, "tokens are limited separately")
+	assert.Equal(t, http.StatusOK, limitedRequest(handler, http.MethodGet, "/health", "", "").Code, "anonymous requests are not limited per token")
+
+	w = limitedRequest(handler, http.MethodPost, "/api/github/oauth-url", "", "")
+	assert.NotEqual(t, http.StatusTooManyRequests, w.Code)
+	w = limitedRequest(handler, http.MethodPost, "/api/github/oauth-url", "", "")
+	require.Equal(t, http.StatusTooManyRequests, w.Code, "expensive endpoints have a stricter limit")
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+}
+
+func TestLimitsMiddlewarePerIP(t *testing.T) {
+	handler, _ := newLimitedServer(t, LimitsConfig{PerIP: RateLimitConfig{RequestsPerSecond: 1, Burst: 1}})
+
+	assert.Equal(t, http.StatusOK, limitedRequest(handler, http.MethodGet, "/health", "token-a", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, limitedRequest(handler, http.MethodGet, "/health", "token-b", "").Code,
+		"a new token does not escape the address limit")
+}
+
+func TestLimitsMiddlewareBodySize(t *testing.T) {
+	handler, _ := newLimitedServer(t, LimitsConfig{MaxBodyBytes: 16})
+
+	w := limitedRequest(handler, http.MethodPost, "/api/v1/users", "", strings.Repeat("x", 17))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "request_too_large")
+
+	w = limitedRequest(handler, http.MethodPost, "/api/v1/users", "", `{"username":"a"}`)
+	assert.NotEqual(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestLimitsMiddlewareConcurrency(t *testing.T) {
+	handler, release := newLimitedServer(t, LimitsConfig{MaxConcurrent: 1})
+
+	done := make(chan struct{})
+	go func() {
+		limitedRequest(handler, http.MethodGet, "/slow", "", "")
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		w := limitedRequest(handler, http.MethodGet, "/health", "", "")
+		return w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "1"
+	}, time.Second, 5*time.Millisecond)
+
+	close(release)
+	<-done
+	assert.Equal(t, http.StatusOK, limitedRequest(handler, http.MethodGet, "/health", "", "").Code)
+}
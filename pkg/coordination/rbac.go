@@ -0,0 +1,331 @@
+package coordination
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// User roles
+const (
+	// RoleAdmin manages users, roles and server state and has full access
+	RoleAdmin = "admin"
+	// RoleOperator manages nodes and every workspace
+	RoleOperator = "operator"
+	// RoleDeveloper creates workspaces and uses the ones they own or were granted
+	RoleDeveloper = "developer"
+	// RoleViewer has read-only access to nodes and the workspaces they were granted
+	RoleViewer = "viewer"
+)
+
+// Workspace grant roles, in increasing order of access
+const (
+	GrantViewer       = "viewer"
+	GrantCollaborator = "collaborator"
+	grantOwner        = "owner"
+)
+
+// Actions checked against the role of the caller
+const (
+	ActionNodesRead       = "nodes:read"
+	ActionNodesAdmin      = "nodes:admin"
+	ActionWorkspacesRead  = "workspaces:read"
+	ActionWorkspacesWrite = "workspaces:write"
+	ActionUsersRead       = "users:read"
+	ActionUsersAdmin      = "users:admin"
+	ActionAdmin           = "admin"
+)
+
+var rolePermissions = map[string]map[string]bool{
+	RoleAdmin: {
+		ActionNodesRead: true, ActionNodesAdmin: true,
+		ActionWorkspacesRead: true, ActionWorkspacesWrite: true,
+		ActionUsersRead: true, ActionUsersAdmin: true,
+		ActionAdmin: true,
+	},
+	RoleOperator: {
+		ActionNodesRead: true, ActionNodesAdmin: true,
+		ActionWorkspacesRead: true, ActionWorkspacesWrite: true,
+		ActionUsersRead: true,
+	},
+	RoleDeveloper: {
+		ActionNodesRead:      true,
+		ActionWorkspacesRead: true, ActionWorkspacesWrite: true,
+	},
+	RoleViewer: {
+		ActionNodesRead:      true,
+		ActionWorkspacesRead: true,
+	},
+}
+
+var grantLevels = map[string]int{
+	GrantViewer:       1,
+	GrantCollaborator: 2,
+	grantOwner:        3,
+}
+
+// IsValidRole reports whether role is a known user role
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// IsValidGrantRole reports whether role can be granted on a workspace
+func IsValidGrantRole(role string) bool {
+	return role == GrantViewer || role == GrantCollaborator
+}
+
+// RoleAllows reports whether a user role permits an action
+func RoleAllows(role, action string) bool {
+	return rolePermissions[role][action]
+}
+
+// caller returns the authenticated principal of the request, or nil when auth is disabled
+func (s *Server) caller(r *http.Request) *Principal {
+	if !s.config.Auth.Enabled {
+		return nil
+	}
+	principal, _ := PrincipalFromContext(r.Context())
+	return principal
+}
+
+// principalRole returns the current role of the principal. The static server token acts
+// as admin; users fall back to viewer when they are no longer registered. Node agents and
+// enrollment tokens have no role and are only allowed through authorizeNode.
+func (s *Server) principalRole(p *Principal) string {
+	switch p.Kind {
+	case PrincipalService:
+		return RoleAdmin
+	case PrincipalNode, PrincipalEnrollment:
+		return ""
+	}
+
+	user, err := s.registry.GetUserRegistry().GetByUsername(p.Username)
+	if err != nil || !IsValidRole(user.Role) {
+		return RoleViewer
+	}
+	return user.Role
+}
+
+// principalAllows reports whether both the role and, for API tokens, the scopes of p permit action
+func (s *Server) principalAllows(p *Principal, action string) bool {
+	return RoleAllows(s.principalRole(p), action) && scopesAllow(p.Scopes, action)
+}
+
+// can reports whether the caller of r may perform action. Every request passes when auth is disabled.
+func (s *Server) can(r *http.Request, action string) bool {
+	p := s.caller(r)
+	return p == nil || s.principalAllows(p, action)
+}
+
+// isCaller reports whether the caller of r is the named user. Every request passes when auth is disabled.
+func (s *Server) isCaller(r *http.Request, username string) bool {
+	p := s.caller(r)
+	return p == nil || (p.Kind == PrincipalUser && p.Username == username)
+}
+
+// authorize writes a 403 response and returns false unless the caller may perform action
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, action string) bool {
+	if s.can(r, action) {
+		return true
+	}
+	sendForbidden(w, action)
+	return false
+}
+
+// workspaceGrantLevel returns how much access p has to ws through ownership or grants
+func (s *Server) workspaceGrantLevel(p *Principal, ws *DBWorkspace) int {
+	if p.UserID != "" && ws.UserID == p.UserID {
+		return grantLevels[grantOwner]
+	}
+
+	grant, err := s.workspaceRegistry.GetGrant(ws.WorkspaceID, p.Username)
+	if err != nil {
+		return 0
+	}
+	return grantLevels[grant.Role]
+}
+
+// canAccessWorkspace reports whether the caller of r holds at least the given grant role on ws.
+// Admins and operators can access every workspace; everyone else needs ownership or a grant,
+// capped by what their user role and token scopes allow.
+func (s *Server) canAccessWorkspace(r *http.Request, ws *DBWorkspace, level string) bool {
+	p := s.caller(r)
+	if p == nil {
+		return true
+	}
+
+	action := ActionWorkspacesWrite
+	if level == GrantViewer {
+		action = ActionWorkspacesRead
+	}
+
+	if !s.principalAllows(p, action) {
+		return false
+	}
+	role := s.principalRole(p)
+	if role == RoleAdmin || role == RoleOperator {
+		return true
+	}
+
+	return s.workspaceGrantLevel(p, ws) >= grantLevels[level]
+}
+
+// authorizeWorkspace writes a 403 response and returns false unless the caller holds level on ws
+func (s *Server) authorizeWorkspace(w http.ResponseWriter, r *http.Request, ws *DBWorkspace, level string) bool {
+	if s.canAccessWorkspace(r, ws, level) {
+		return true
+	}
+	sendM4JSONError(w, http.StatusForbidden, "forbidden", fmt.Sprintf("Access to workspace %s denied", ws.WorkspaceID), map[string]interface{}{
+		"required": level,
+	})
+	return false
+}
+
+func sendForbidden(w http.ResponseWriter, action string) {
+	sendM4JSONError(w, http.StatusForbidden, "forbidden", "Permission denied", map[string]interface{}{
+		"required": action,
+	})
+}
+
+// SetRoleRequest is the body of PUT /api/v1/users/{username}/role
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// handleSetUserRole changes the role of a user
+// PUT /api/v1/users/{username}/role
+func (s *Server) handleSetUserRole(w http.ResponseWriter, r *http.Request, username string) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorize(w, r, ActionUsersAdmin) {
+		return
+	}
+
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if !IsValidRole(req.Role) {
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_role", fmt.Sprintf("Invalid role: %s", req.Role), map[string]interface{}{
+			"allowed": []string{RoleAdmin, RoleOperator, RoleDeveloper, RoleViewer},
+		})
+		return
+	}
+
+	userRegistry := s.registry.GetUserRegistry()
+	if err := userRegistry.SetRole(username, req.Role); err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "user_not_found", err.Error(), nil)
+		return
+	}
+
+	user, err := userRegistry.GetByUsername(username)
+	if err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "lookup_failed", err.Error(), nil)
+		return
+	}
+
+	s.broadcastEvent("user_role_changed", map[string]interface{}{
+		"username": username,
+		"role":     req.Role,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// GrantRequest is the body of POST /api/v1/workspaces/{id}/grants
+type GrantRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// GrantListResponse is the body of GET /api/v1/workspaces/{id}/grants
+type GrantListResponse struct {
+	Grants []*WorkspaceGrant `json:"grants"`
+	Count  int               `json:"count"`
+}
+
+// handleWorkspaceGrants lists, adds and removes the grants of a workspace
+// GET    /api/v1/workspaces/{id}/grants
+// POST   /api/v1/workspaces/{id}/grants
+// DELETE /api/v1/workspaces/{id}/grants/{username}
+func (s *Server) handleWorkspaceGrants(w http.ResponseWriter, r *http.Request, workspaceID, username string) {
+	ws, err := s.workspaceRegistry.Get(workspaceID)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "workspace_not_found", fmt.Sprintf("Workspace not found: %s", workspaceID), nil)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && username == "":
+		if !s.authorizeWorkspace(w, r, ws, GrantViewer) {
+			return
+		}
+
+		grants, err := s.workspaceRegistry.ListGrants(workspaceID)
+		if err != nil {
+			sendM4JSONError(w, http.StatusInternalServerError, "list_failed", fmt.Sprintf("Failed to list grants: %v", err), nil)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GrantListResponse{Grants: grants, Count: len(grants)})
+
+	case r.Method == http.MethodPost && username == "":
+		if !s.authorizeWorkspace(w, r, ws, grantOwner) {
+			return
+		}
+
+		var req GrantRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendM4JSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body", map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if req.Role == "" {
+			req.Role = GrantCollaborator
+		}
+		if _, err := s.registry.GetUserRegistry().GetByUsername(req.Username); err != nil {
+			sendM4JSONError(w, http.StatusBadRequest, "user_not_found", fmt.Sprintf("User not registered: %s", req.Username), nil)
+			return
+		}
+
+		grant := &WorkspaceGrant{WorkspaceID: workspaceID, Username: req.Username, Role: req.Role}
+		if p := s.caller(r); p != nil {
+			grant.GrantedBy = p.Username
+		}
+		if err := s.workspaceRegistry.SaveGrant(grant); err != nil {
+			sendM4JSONError(w, http.StatusBadRequest, "invalid_grant", err.Error(), nil)
+			return
+		}
+
+		s.broadcastEvent("workspace_grant_added", grant)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(grant)
+
+	case r.Method == http.MethodDelete && username != "":
+		if !s.authorizeWorkspace(w, r, ws, grantOwner) {
+			return
+		}
+
+		if err := s.workspaceRegistry.DeleteGrant(workspaceID, username); err != nil {
+			sendM4JSONError(w, http.StatusNotFound, "grant_not_found", err.Error(), nil)
+			return
+		}
+
+		s.broadcastEvent("workspace_grant_removed", map[string]interface{}{
+			"workspace_id": workspaceID,
+			"username":     username,
+		})
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,174 @@
+package coordination
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rbacAdminToken = "static-agent-token-0123456789"
+
+// newRBACTestServer returns a server with alice (developer) owning ws-1, bob (developer)
+// and carol (viewer), each signing in with the token named after them
+func newRBACTestServer(t *testing.T) *Server {
+	srv, verifier := newAuthTestServer(t)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		verifier.idTokens[name+"-token"] = testClaims("sub-"+name, name)
+	}
+
+	users := srv.registry.GetUserRegistry()
+	require.NoError(t, users.Register(&User{ID: "sub-alice", Username: "alice"}))
+	require.NoError(t, users.Register(&User{ID: "sub-bob", Username: "bob"}))
+	require.NoError(t, users.Register(&User{ID: "sub-carol", Username: "carol", Role: RoleViewer}))
+	require.NoError(t, srv.workspaceRegistry.Create(&DBWorkspace{WorkspaceID: "ws-1", UserID: "sub-alice", WorkspaceName: "feature", Status: "running"}))
+	require.NoError(t, srv.workspaceRegistry.Create(&DBWorkspace{WorkspaceID: "ws-2", UserID: "sub-bob", WorkspaceName: "bugfix", Status: "running"}))
+	return srv
+}
+
+func rbacRequest(t *testing.T, srv *Server, token, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	srv.authMiddleware(srv.router).ServeHTTP(w, req)
+	return w
+}
+
+func TestRBACNodes(t *testing.T) {
+	srv := newRBACTestServer(t)
+
+	w := rbacRequest(t, srv, "carol-token", http.MethodGet, "/api/v1/nodes", nil)
+	assert.Equal(t, http.StatusOK, w.Code, "viewers can list nodes")
+
+	node := map[string]interface{}{"id": "node-1", "name": "node-1"}
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/nodes", node)
+	assert.Equal(t, http.StatusForbidden, w.Code, "developers cannot register nodes")
+
+	require.NoError(t, srv.registry.GetUserRegistry().SetRole("alice", RoleOperator))
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/nodes", node)
+	assert.Equal(t, http.StatusOK, w.Code, "operators manage nodes")
+
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodDelete, "/api/v1/nodes/node-1", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestRBACUsers(t *testing.T) {
+	srv := newRBACTestServer(t)
+
+	w := rbacRequest(t, srv, "alice-token", http.MethodGet, "/api/v1/users", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = rbacRequest(t, srv, "alice-token", http.MethodGet, "/api/v1/users/alice", nil)
+	assert.Equal(t, http.StatusOK, w.Code, "users can read themselves")
+	w = rbacRequest(t, srv, "alice-token", http.MethodGet, "/api/v1/users/bob", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodPut, "/api/v1/users/alice/role", SetRoleRequest{Role: RoleAdmin})
+	assert.Equal(t, http.StatusForbidden, w.Code, "users cannot promote themselves")
+
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodPut, "/api/v1/users/alice/role", SetRoleRequest{Role: "root"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodPut, "/api/v1/users/alice/role", SetRoleRequest{Role: RoleAdmin})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodGet, "/api/v1/users", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = rbacRequest(t, srv, "alice-token", http.MethodDelete, "/api/v1/users/carol", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = rbacRequest(t, srv, "bob-token", http.MethodGet, "/api/v1/admin/export", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestRBACRegisterUser(t *testing.T) {
+	srv := newRBACTestServer(t)
+	users := srv.registry.GetUserRegistry()
+
+	w := rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/users", User{ID: "sub-bob", Username: "alice", Role: RoleAdmin})
+	assert.Equal(t, http.StatusConflict, w.Code, "users cannot re-register themselves")
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/users", User{Username: "dave"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	alice, err := users.GetByUsername("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "sub-alice", alice.ID)
+	assert.Equal(t, RoleDeveloper, alice.Role)
+
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodPost, "/api/v1/users", User{Username: "bob", Role: RoleAdmin})
+	assert.Equal(t, http.StatusConflict, w.Code, "admins cannot overwrite users either")
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodPost, "/api/v1/users", User{ID: "sub-dave", Username: "dave", Role: RoleOperator})
+	require.Equal(t, http.StatusOK, w.Code)
+	dave, err := users.GetByUsername("dave")
+	require.NoError(t, err)
+	assert.Equal(t, "sub-dave", dave.ID)
+	assert.Equal(t, RoleOperator, dave.Role)
+}
+
+func TestRBACWorkspaceGrants(t *testing.T) {
+	srv := newRBACTestServer(t)
+
+	w := rbacRequest(t, srv, "bob-token", http.MethodGet, "/api/v1/workspaces/ws-1/status", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "bob cannot see alice's workspace")
+
+	w = rbacRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/ws-1/grants", GrantRequest{Username: "bob"})
+	assert.Equal(t, http.StatusForbidden, w.Code, "only owners share workspaces")
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-1/grants", GrantRequest{Username: "bob", Role: GrantViewer})
+	require.Equal(t, http.StatusCreated, w.Code)
+	var grant WorkspaceGrant
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&grant))
+	assert.Equal(t, "alice", grant.GrantedBy)
+
+	w = rbacRequest(t, srv, "bob-token", http.MethodGet, "/api/v1/workspaces/ws-1/status", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = rbacRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/ws-1/stop", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "viewer grants are read-only")
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-1/grants", GrantRequest{Username: "bob"})
+	require.Equal(t, http.StatusCreated, w.Code, "grants default to collaborator")
+	w = rbacRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/ws-1/stop", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = rbacRequest(t, srv, "bob-token", http.MethodDelete, "/api/v1/workspaces/ws-1", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only owners delete workspaces")
+
+	w = rbacRequest(t, srv, "bob-token", http.MethodGet, "/api/v1/workspaces", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list M4ListWorkspacesResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	assert.Equal(t, 2, list.Total)
+
+	w = rbacRequest(t, srv, "carol-token", http.MethodGet, "/api/v1/workspaces", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	assert.Equal(t, 0, list.Total, "carol has no grants")
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodDelete, "/api/v1/workspaces/ws-1/grants/bob", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = rbacRequest(t, srv, "bob-token", http.MethodGet, "/api/v1/workspaces/ws-1/status", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodDelete, "/api/v1/workspaces/ws-1", nil)
+	assert.Equal(t, http.StatusOK, w.Code, "admins can delete any workspace")
+}
+
+func TestRBACViewerCannotWrite(t *testing.T) {
+	srv := newRBACTestServer(t)
+	require.NoError(t, srv.workspaceRegistry.SaveGrant(&WorkspaceGrant{WorkspaceID: "ws-1", Username: "carol", Role: GrantCollaborator}))
+
+	w := rbacRequest(t, srv, "carol-token", http.MethodGet, "/api/v1/workspaces/ws-1/status", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = rbacRequest(t, srv, "carol-token", http.MethodPost, "/api/v1/workspaces/ws-1/stop", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "the viewer role caps collaborator grants")
+}
@@ -2,14 +2,18 @@ package coordination
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/nexus/nexus/pkg/github"
+	"github.com/nexus/nexus/pkg/paths"
 	"github.com/nexus/nexus/pkg/provider"
 	"github.com/nexus/nexus/pkg/provider/lxc"
 )
@@ -27,6 +31,7 @@ type Node struct {
 	Capabilities map[string]interface{} `json:"capabilities,omitempty"`
 	Services     map[string]NodeService `json:"services,omitempty"`
 	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	Capacity     *NodeCapacity          `json:"capacity,omitempty"`
 	CreatedAt    time.Time              `json:"created_at"`
 	UpdatedAt    time.Time              `json:"updated_at"`
 }
@@ -52,10 +57,14 @@ type HealthStatus struct {
 
 // User represents a user in the coordination system
 type User struct {
-	ID          string    `json:"id"`
+	ID string `json:"id"`
+	// Issuer is the OIDC issuer that vouches for ID as a subject; empty for users
+	// created before issuers were recorded or registered by an administrator
+	Issuer      string    `json:"issuer,omitempty"`
 	Username    string    `json:"username"`
 	PublicKey   string    `json:"public_key"`
 	WorkspaceID string    `json:"workspace_id"`
+	Role        string    `json:"role"` // admin, operator, developer, viewer
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -101,23 +110,84 @@ type Registry interface {
 type UserRegistry interface {
 	Register(user *User) error
 	GetByUsername(username string) (*User, error)
+	// GetBySubject finds the user an OIDC issuer identifies by subject. Users with
+	// no recorded issuer match any issuer.
+	GetBySubject(issuer, subject string) (*User, error)
 	GetByWorkspace(workspaceID string) ([]*User, error)
 	List() ([]*User, error)
+	SetRole(username, role string) error
 	Delete(username string) error
 }
 
+// GitHubStore persists GitHub installations and forks alongside a Registry
+type GitHubStore interface {
+	StoreGitHubInstallation(installation *GitHubInstallation) error
+	GetGitHubInstallation(userID string) (*GitHubInstallation, error)
+	DeleteGitHubInstallation(userID string) error
+	ListGitHubInstallations() ([]*GitHubInstallation, error)
+	StoreGitHubFork(fork *GitHubFork) error
+	GetGitHubFork(userID, originalOwner, originalRepo string) (*GitHubFork, error)
+	ListGitHubForks() ([]*GitHubFork, error)
+}
+
+// APITokenStore persists personal API tokens alongside a Registry
+type APITokenStore interface {
+	CreateAPIToken(token *APIToken) error
+	GetAPIToken(id string) (*APIToken, error)
+	GetAPITokenByHash(hash string) (*APIToken, error)
+	ListAPITokens(username string) ([]*APIToken, error)
+	TouchAPIToken(id string, usedAt time.Time) error
+	DeleteAPIToken(id string) error
+}
+
+// NodeCredentialStore persists enrollment tokens and per-node agent credentials alongside a Registry
+type NodeCredentialStore interface {
+	CreateEnrollmentToken(token *EnrollmentToken) error
+	ListEnrollmentTokens() ([]*EnrollmentToken, error)
+	GetEnrollmentTokenByHash(hash string) (*EnrollmentToken, error)
+	// ConsumeEnrollmentToken marks an unused, unexpired token as used by nodeID
+	ConsumeEnrollmentToken(id, nodeID string, now time.Time) (*EnrollmentToken, error)
+	DeleteEnrollmentToken(id string) error
+	SaveNodeCredential(credential *NodeCredential) error
+	GetNodeCredential(nodeID string) (*NodeCredential, error)
+	GetNodeCredentialByHash(hash string) (*NodeCredential, error)
+	DeleteNodeCredential(nodeID string) error
+}
+
+// AuditStore persists the append-only audit trail alongside a Registry
+type AuditStore interface {
+	AppendAudit(entry *AuditEntry) error
+	// ListAudit returns matching entries, newest first
+	ListAudit(filter AuditFilter) ([]*AuditEntry, error)
+}
+
 // InMemoryRegistry provides an in-memory implementation of Registry
 type InMemoryRegistry struct {
-	nodes        map[string]*Node
-	userRegistry UserRegistry
-	nodeMutex    sync.RWMutex
+	nodes         map[string]*Node
+	userRegistry  UserRegistry
+	nodeMutex     sync.RWMutex
+	installations map[string]*GitHubInstallation
+	forks         map[string]*GitHubFork
+	gitHubMutex   sync.RWMutex
+	apiTokens     map[string]*APIToken
+	apiTokenMutex sync.RWMutex
+	enrollments   map[string]*EnrollmentToken
+	credentials   map[string]*NodeCredential
+	credMutex     sync.RWMutex
+	audit         []*AuditEntry
+	auditMutex    sync.RWMutex
 }
 
 // NewInMemoryRegistry creates a new in-memory node registry
 func NewInMemoryRegistry() *InMemoryRegistry {
 	return &InMemoryRegistry{
-		nodes:        make(map[string]*Node),
-		userRegistry: NewInMemoryUserRegistry(),
+		nodes:         make(map[string]*Node),
+		userRegistry:  NewInMemoryUserRegistry(),
+		installations: make(map[string]*GitHubInstallation),
+		forks:         make(map[string]*GitHubFork),
+		apiTokens:     make(map[string]*APIToken),
+		enrollments:   make(map[string]*EnrollmentToken),
+		credentials:   make(map[string]*NodeCredential),
 	}
 }
 
@@ -176,15 +246,58 @@ func (r *InMemoryRegistry) Update(id string, updates map[string]interface{}) err
 	if !exists {
 		return fmt.Errorf("node not found: %s", id)
 	}
+	if err := checkNodeUpdate(node, updates); err != nil {
+		return err
+	}
+
+	applyNodeUpdates(node, updates)
+	node.UpdatedAt = time.Now()
+	if _, ok := updates["last_seen"].(time.Time); !ok {
+		node.LastSeen = time.Now()
+	}
+	return nil
+}
+
+// ErrUserExists is returned when registering a user whose username or ID is taken
+var ErrUserExists = errors.New("user already exists")
+
+// ErrNodeChanged is returned by a conditional node update when the node sent a heartbeat
+// after it was read
+var ErrNodeChanged = errors.New("node changed since it was read")
+
+// checkNodeUpdate checks the "if_last_seen" update key, which makes an update conditional
+// on the node's last heartbeat still being the given time
+func checkNodeUpdate(node *Node, updates map[string]interface{}) error {
+	if lastSeen, ok := updates["if_last_seen"].(time.Time); ok && !node.LastSeen.Equal(lastSeen) {
+		return fmt.Errorf("%w: %s", ErrNodeChanged, node.ID)
+	}
+	return nil
+}
 
+// applyNodeUpdates applies the supported update keys to a node.
+// Updates refresh LastSeen unless they carry an explicit "last_seen" time.
+func applyNodeUpdates(node *Node, updates map[string]interface{}) {
 	for key, value := range updates {
 		switch key {
+		case "last_seen":
+			if lastSeen, ok := value.(time.Time); ok {
+				node.LastSeen = lastSeen
+			}
 		case "status":
-			node.Status = value.(string)
+			if status, ok := value.(string); ok {
+				node.Status = status
+			}
 		case "address":
-			node.Address = value.(string)
+			if address, ok := value.(string); ok {
+				node.Address = address
+			}
 		case "port":
-			node.Port = value.(int)
+			switch port := value.(type) {
+			case int:
+				node.Port = port
+			case float64:
+				node.Port = int(port)
+			}
 		case "labels":
 			if m, ok := value.(map[string]string); ok {
 				node.Labels = m
@@ -201,12 +314,12 @@ func (r *InMemoryRegistry) Update(id string, updates map[string]interface{}) err
 			if m, ok := value.(map[string]interface{}); ok {
 				node.Metadata = m
 			}
+		case "capacity":
+			if capacity, ok := value.(*NodeCapacity); ok {
+				node.Capacity = capacity
+			}
 		}
 	}
-
-	node.UpdatedAt = time.Now()
-	node.LastSeen = time.Now()
-	return nil
 }
 
 func (r *InMemoryRegistry) SetStatus(id, status string) error {
@@ -242,6 +355,329 @@ func (r *InMemoryRegistry) GetUserRegistry() UserRegistry {
 	return r.userRegistry
 }
 
+func (r *InMemoryRegistry) StoreGitHubInstallation(installation *GitHubInstallation) error {
+	if err := installation.Validate(); err != nil {
+		return err
+	}
+
+	r.gitHubMutex.Lock()
+	defer r.gitHubMutex.Unlock()
+
+	installation.UpdatedAt = time.Now()
+	r.installations[installation.UserID] = installation
+	return nil
+}
+
+func (r *InMemoryRegistry) GetGitHubInstallation(userID string) (*GitHubInstallation, error) {
+	r.gitHubMutex.RLock()
+	defer r.gitHubMutex.RUnlock()
+
+	installation, exists := r.installations[userID]
+	if !exists {
+		return nil, fmt.Errorf("GitHub installation not found for user: %s", userID)
+	}
+	return installation, nil
+}
+
+func (r *InMemoryRegistry) DeleteGitHubInstallation(userID string) error {
+	r.gitHubMutex.Lock()
+	defer r.gitHubMutex.Unlock()
+
+	delete(r.installations, userID)
+	return nil
+}
+
+func (r *InMemoryRegistry) ListGitHubInstallations() ([]*GitHubInstallation, error) {
+	r.gitHubMutex.RLock()
+	defer r.gitHubMutex.RUnlock()
+
+	installations := make([]*GitHubInstallation, 0, len(r.installations))
+	for _, installation := range r.installations {
+		installations = append(installations, installation)
+	}
+	return installations, nil
+}
+
+func (r *InMemoryRegistry) StoreGitHubFork(fork *GitHubFork) error {
+	if err := fork.Validate(); err != nil {
+		return err
+	}
+
+	r.gitHubMutex.Lock()
+	defer r.gitHubMutex.Unlock()
+
+	key := fork.UserID + "/" + fork.OriginalOwner + "/" + fork.OriginalRepo
+	if _, exists := r.forks[key]; !exists {
+		r.forks[key] = fork
+	}
+	return nil
+}
+
+func (r *InMemoryRegistry) GetGitHubFork(userID, originalOwner, originalRepo string) (*GitHubFork, error) {
+	r.gitHubMutex.RLock()
+	defer r.gitHubMutex.RUnlock()
+
+	fork, exists := r.forks[userID+"/"+originalOwner+"/"+originalRepo]
+	if !exists {
+		return nil, fmt.Errorf("GitHub fork not found")
+	}
+	return fork, nil
+}
+
+func (r *InMemoryRegistry) ListGitHubForks() ([]*GitHubFork, error) {
+	r.gitHubMutex.RLock()
+	defer r.gitHubMutex.RUnlock()
+
+	forks := make([]*GitHubFork, 0, len(r.forks))
+	for _, fork := range r.forks {
+		forks = append(forks, fork)
+	}
+	return forks, nil
+}
+
+func (r *InMemoryRegistry) CreateAPIToken(token *APIToken) error {
+	if err := token.Validate(); err != nil {
+		return err
+	}
+
+	r.apiTokenMutex.Lock()
+	defer r.apiTokenMutex.Unlock()
+
+	for _, existing := range r.apiTokens {
+		if existing.ID == token.ID {
+			return fmt.Errorf("API token already exists: %s", token.ID)
+		}
+		if existing.Username == token.Username && existing.Name == token.Name {
+			return fmt.Errorf("API token already exists: %s", token.Name)
+		}
+	}
+
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	r.apiTokens[token.ID] = token
+	return nil
+}
+
+func (r *InMemoryRegistry) GetAPIToken(id string) (*APIToken, error) {
+	r.apiTokenMutex.RLock()
+	defer r.apiTokenMutex.RUnlock()
+
+	token, exists := r.apiTokens[id]
+	if !exists {
+		return nil, fmt.Errorf("API token not found: %s", id)
+	}
+	return token, nil
+}
+
+func (r *InMemoryRegistry) GetAPITokenByHash(hash string) (*APIToken, error) {
+	r.apiTokenMutex.RLock()
+	defer r.apiTokenMutex.RUnlock()
+
+	for _, token := range r.apiTokens {
+		if token.TokenHash == hash {
+			return token, nil
+		}
+	}
+	return nil, fmt.Errorf("API token not found")
+}
+
+func (r *InMemoryRegistry) ListAPITokens(username string) ([]*APIToken, error) {
+	r.apiTokenMutex.RLock()
+	defer r.apiTokenMutex.RUnlock()
+
+	tokens := make([]*APIToken, 0)
+	for _, token := range r.apiTokens {
+		if username == "" || token.Username == username {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Username != tokens[j].Username {
+			return tokens[i].Username < tokens[j].Username
+		}
+		return tokens[i].Name < tokens[j].Name
+	})
+	return tokens, nil
+}
+
+func (r *InMemoryRegistry) TouchAPIToken(id string, usedAt time.Time) error {
+	r.apiTokenMutex.Lock()
+	defer r.apiTokenMutex.Unlock()
+
+	token, exists := r.apiTokens[id]
+	if !exists {
+		return fmt.Errorf("API token not found: %s", id)
+	}
+	token.LastUsedAt = &usedAt
+	return nil
+}
+
+func (r *InMemoryRegistry) DeleteAPIToken(id string) error {
+	r.apiTokenMutex.Lock()
+	defer r.apiTokenMutex.Unlock()
+
+	if _, exists := r.apiTokens[id]; !exists {
+		return fmt.Errorf("API token not found: %s", id)
+	}
+	delete(r.apiTokens, id)
+	return nil
+}
+
+func (r *InMemoryRegistry) CreateEnrollmentToken(token *EnrollmentToken) error {
+	if token.ID == "" || token.TokenHash == "" {
+		return fmt.Errorf("enrollment token ID and hash are required")
+	}
+
+	r.credMutex.Lock()
+	defer r.credMutex.Unlock()
+
+	if _, exists := r.enrollments[token.ID]; exists {
+		return fmt.Errorf("enrollment token already exists: %s", token.ID)
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	r.enrollments[token.ID] = token
+	return nil
+}
+
+func (r *InMemoryRegistry) ListEnrollmentTokens() ([]*EnrollmentToken, error) {
+	r.credMutex.RLock()
+	defer r.credMutex.RUnlock()
+
+	tokens := make([]*EnrollmentToken, 0, len(r.enrollments))
+	for _, token := range r.enrollments {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (r *InMemoryRegistry) GetEnrollmentTokenByHash(hash string) (*EnrollmentToken, error) {
+	r.credMutex.RLock()
+	defer r.credMutex.RUnlock()
+
+	for _, token := range r.enrollments {
+		if token.TokenHash == hash {
+			return token, nil
+		}
+	}
+	return nil, fmt.Errorf("enrollment token not found")
+}
+
+func (r *InMemoryRegistry) ConsumeEnrollmentToken(id, nodeID string, now time.Time) (*EnrollmentToken, error) {
+	r.credMutex.Lock()
+	defer r.credMutex.Unlock()
+
+	token, exists := r.enrollments[id]
+	if !exists {
+		return nil, fmt.Errorf("enrollment token not found: %s", id)
+	}
+	if err := checkEnrollment(token, nodeID, now); err != nil {
+		return nil, err
+	}
+	token.UsedAt = &now
+	token.UsedBy = nodeID
+	return token, nil
+}
+
+func (r *InMemoryRegistry) DeleteEnrollmentToken(id string) error {
+	r.credMutex.Lock()
+	defer r.credMutex.Unlock()
+
+	if _, exists := r.enrollments[id]; !exists {
+		return fmt.Errorf("enrollment token not found: %s", id)
+	}
+	delete(r.enrollments, id)
+	return nil
+}
+
+func (r *InMemoryRegistry) SaveNodeCredential(credential *NodeCredential) error {
+	if credential.NodeID == "" || credential.SecretHash == "" {
+		return fmt.Errorf("node credential node ID and hash are required")
+	}
+
+	r.credMutex.Lock()
+	defer r.credMutex.Unlock()
+
+	r.credentials[credential.NodeID] = credential
+	return nil
+}
+
+func (r *InMemoryRegistry) GetNodeCredential(nodeID string) (*NodeCredential, error) {
+	r.credMutex.RLock()
+	defer r.credMutex.RUnlock()
+
+	credential, exists := r.credentials[nodeID]
+	if !exists {
+		return nil, fmt.Errorf("node credential not found: %s", nodeID)
+	}
+	return credential, nil
+}
+
+func (r *InMemoryRegistry) GetNodeCredentialByHash(hash string) (*NodeCredential, error) {
+	r.credMutex.RLock()
+	defer r.credMutex.RUnlock()
+
+	for _, credential := range r.credentials {
+		if credential.SecretHash == hash || (credential.PreviousHash != "" && credential.PreviousHash == hash) {
+			return credential, nil
+		}
+	}
+	return nil, fmt.Errorf("node credential not found")
+}
+
+func (r *InMemoryRegistry) DeleteNodeCredential(nodeID string) error {
+	r.credMutex.Lock()
+	defer r.credMutex.Unlock()
+
+	if _, exists := r.credentials[nodeID]; !exists {
+		return fmt.Errorf("node credential not found: %s", nodeID)
+	}
+	delete(r.credentials, nodeID)
+	return nil
+}
+
+// maxInMemoryAuditEntries bounds the audit trail kept by the in-memory registry
+const maxInMemoryAuditEntries = 10000
+
+func (r *InMemoryRegistry) AppendAudit(entry *AuditEntry) error {
+	r.auditMutex.Lock()
+	defer r.auditMutex.Unlock()
+
+	if len(r.audit) > 0 {
+		entry.ID = r.audit[len(r.audit)-1].ID + 1
+	} else {
+		entry.ID = 1
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+
+	r.audit = append(r.audit, entry)
+	if len(r.audit) > maxInMemoryAuditEntries {
+		r.audit = r.audit[len(r.audit)-maxInMemoryAuditEntries:]
+	}
+	return nil
+}
+
+func (r *InMemoryRegistry) ListAudit(filter AuditFilter) ([]*AuditEntry, error) {
+	r.auditMutex.RLock()
+	defer r.auditMutex.RUnlock()
+
+	entries := make([]*AuditEntry, 0)
+	for i := len(r.audit) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) >= filter.Limit {
+			break
+		}
+		if filter.Matches(r.audit[i]) {
+			entries = append(entries, r.audit[i])
+		}
+	}
+	return entries, nil
+}
+
 // InMemoryUserRegistry provides an in-memory implementation of UserRegistry
 type InMemoryUserRegistry struct {
 	users map[string]*User
@@ -267,10 +703,29 @@ func (r *InMemoryUserRegistry) Register(user *User) error {
 	if user.ID == "" {
 		user.ID = fmt.Sprintf("user_%d_%s", time.Now().Unix(), user.Username)
 	}
+	if user.Role == "" {
+		user.Role = RoleDeveloper
+	}
+	if !IsValidRole(user.Role) {
+		return fmt.Errorf("invalid role: %s", user.Role)
+	}
+	if _, exists := r.users[user.Username]; exists {
+		return fmt.Errorf("%w: %s", ErrUserExists, user.Username)
+	}
+	for _, existing := range r.users {
+		if existing.ID == user.ID {
+			return fmt.Errorf("%w: %s", ErrUserExists, user.ID)
+		}
+	}
 
+	// Imported users keep their timestamps
 	now := time.Now()
-	user.CreatedAt = now
-	user.UpdatedAt = now
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = user.CreatedAt
+	}
 
 	r.users[user.Username] = user
 	return nil
@@ -288,6 +743,19 @@ func (r *InMemoryUserRegistry) GetByUsername(username string) (*User, error) {
 	return user, nil
 }
 
+// GetBySubject retrieves the user an OIDC issuer identifies by subject
+func (r *InMemoryUserRegistry) GetBySubject(issuer, subject string) (*User, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, user := range r.users {
+		if user.ID == subject && (user.Issuer == issuer || user.Issuer == "") {
+			return user, nil
+		}
+	}
+	return nil, fmt.Errorf("user not found: %s", subject)
+}
+
 // GetByWorkspace retrieves all users for a workspace
 func (r *InMemoryUserRegistry) GetByWorkspace(workspaceID string) ([]*User, error) {
 	r.mutex.RLock()
@@ -314,6 +782,25 @@ func (r *InMemoryUserRegistry) List() ([]*User, error) {
 	return users, nil
 }
 
+// SetRole changes the role of a user
+func (r *InMemoryUserRegistry) SetRole(username, role string) error {
+	if !IsValidRole(role) {
+		return fmt.Errorf("invalid role: %s", role)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	user, exists := r.users[username]
+	if !exists {
+		return fmt.Errorf("user not found: %s", username)
+	}
+
+	user.Role = role
+	user.UpdatedAt = time.Now()
+	return nil
+}
+
 // Delete removes a user by username
 func (r *InMemoryUserRegistry) Delete(username string) error {
 	r.mutex.Lock()
@@ -333,11 +820,22 @@ type Server struct {
 	clients               map[chan Event]bool
 	clientsMu             sync.Mutex
 	commandCh             chan CommandResult
+	commandStreams        *commandStreams
+	commandQueue          *commandQueue
 	provider              provider.Provider
 	appConfig             *github.AppConfig
 	oauthStateStore       *OAuthStateStore
 	gitHubInstallations   map[string]*GitHubInstallation
 	gitHubInstallationsMu sync.RWMutex
+	reaperStop            chan struct{}
+	reaperStopOnce        sync.Once
+	oidc                  tokenVerifier
+	principals            *principalCache
+	quotaMu               sync.Mutex           // serializes quota checks with the workspace changes they admit
+	lifecycleWarnings     map[string]time.Time // idle and expiry deadlines already warned about
+	lifecycleMu           sync.Mutex
+	limits                *requestLimits
+	agentChecksums        checksumCache
 }
 
 // OAuthStateStore stores OAuth state tokens with expiration for CSRF protection
@@ -403,37 +901,44 @@ type Event struct {
 	Data      interface{} `json:"data"`
 }
 
-// NewServer creates a new coordination server
-func NewServer(cfg *Config) *Server {
-	var registry Registry
-
-	if dbPath := os.Getenv("DB_PATH"); dbPath != "" {
-		sqliteRegistry, err := NewSQLiteRegistry(dbPath)
-		if err != nil {
-			fmt.Printf("Warning: failed to initialize SQLite registry, falling back to in-memory: %v\n", err)
-			registry = NewInMemoryRegistry()
-		} else {
-			registry = sqliteRegistry
+// NewServer creates a coordination server. It fails when the configured storage cannot be
+// opened; only storage picked up implicitly from DB_PATH falls back to memory.
+func NewServer(cfg *Config) (*Server, error) {
+	registry, workspaceRegistry, err := NewStorage(cfg.Registry.Storage)
+	if err != nil {
+		if cfg.Registry.Storage.Type != "" {
+			return nil, fmt.Errorf("failed to initialize %s storage: %w", cfg.Registry.Storage.Type, err)
 		}
-	} else {
+		fmt.Printf("Warning: failed to initialize storage from DB_PATH, falling back to in-memory: %v\n", err)
 		registry = NewInMemoryRegistry()
+		workspaceRegistry = NewInMemoryWorkspaceRegistry()
 	}
 
 	srv := &Server{
 		config:              cfg,
 		registry:            registry,
-		workspaceRegistry:   NewInMemoryWorkspaceRegistry(),
+		workspaceRegistry:   workspaceRegistry,
 		router:              http.NewServeMux(),
 		clients:             make(map[chan Event]bool),
 		commandCh:           make(chan CommandResult, 100),
+		commandStreams:      newCommandStreams(),
+		commandQueue:        newCommandQueue(),
 		oauthStateStore:     NewOAuthStateStore(5 * time.Minute),
 		gitHubInstallations: make(map[string]*GitHubInstallation),
+		reaperStop:          make(chan struct{}),
+		principals:          newPrincipalCache(),
+		lifecycleWarnings:   make(map[string]time.Time),
+		limits:              newRequestLimits(cfg.Server.Limits),
 	}
 
 	if err := srv.initializeProvider(); err != nil {
 		fmt.Printf("Warning: failed to initialize provider: %v\n", err)
 	}
 
+	if err := srv.initializeOIDC(); err != nil {
+		fmt.Printf("Warning: OIDC authentication unavailable: %v\n", err)
+	}
+
 	appConfig, err := github.NewAppConfig()
 	if err != nil {
 		fmt.Printf("Warning: GitHub App not configured: %v\n", err)
@@ -442,7 +947,45 @@ func NewServer(cfg *Config) *Server {
 	}
 
 	srv.setupRoutes()
-	return srv
+	return srv, nil
+}
+
+// NewStorage creates the node and workspace registries selected by the storage configuration.
+// When no type is configured, the DB_PATH environment variable selects SQLite as before.
+func NewStorage(storage StorageConfig) (Registry, WorkspaceRegistry, error) {
+	storageType := storage.Type
+	if storageType == "" && os.Getenv("DB_PATH") != "" {
+		storageType = "sqlite"
+	}
+
+	switch storageType {
+	case "", "memory":
+		return NewInMemoryRegistry(), NewInMemoryWorkspaceRegistry(), nil
+	case "sqlite":
+		dbPath := DatabasePath(storage)
+		if err := paths.EnsureDir(filepath.Dir(dbPath)); err != nil {
+			return nil, nil, err
+		}
+
+		sqliteRegistry, err := NewSQLiteRegistry(dbPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sqliteRegistry, sqliteRegistry.GetWorkspaceRegistry(), nil
+	case "postgres":
+		dsn := PostgresDSN(storage)
+		if dsn == "" {
+			return nil, nil, fmt.Errorf("postgres storage requires a dsn")
+		}
+
+		postgresRegistry, err := NewPostgresRegistry(dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		return postgresRegistry, postgresRegistry.GetWorkspaceRegistry(), nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported storage type: %s", storageType)
+	}
 }
 
 func (s *Server) initializeProvider() error {
@@ -479,9 +1022,21 @@ func (s *Server) setupRoutes() {
 	s.router.HandleFunc("/api/v1/users", s.handleUsersRequest)
 	s.router.HandleFunc("/api/v1/users/", s.handleUserRequest)
 	s.router.HandleFunc("/api/v1/workspaces/", s.handleM4WorkspacesRouter)
+	s.router.HandleFunc("/api/v1/tokens", s.handleTokensRequest)
+	s.router.HandleFunc("/api/v1/tokens/", s.handleTokensRequest)
+	s.router.HandleFunc("/api/v1/enrollment-tokens", s.handleEnrollmentTokensRequest)
+	s.router.HandleFunc("/api/v1/enrollment-tokens/", s.handleEnrollmentTokensRequest)
+	s.router.HandleFunc("/api/v1/agent/release", s.handleAgentRelease)
+	s.router.HandleFunc("/api/v1/agent/binary/", s.handleAgentBinary)
+
+	// Administration
+	s.router.HandleFunc("/api/v1/admin/export", s.handleAdminExport)
+	s.router.HandleFunc("/api/v1/admin/import", s.handleAdminImport)
+	s.router.HandleFunc("/api/v1/audit", s.handleListAudit)
 
 	s.router.HandleFunc("/health", s.handleHealth)
 	s.router.HandleFunc("/metrics", s.handleMetrics)
+	s.router.HandleFunc(OpenAPIPath, s.handleOpenAPI)
 
 	s.router.HandleFunc("/ws", s.handleWebSocket)
 
@@ -517,9 +1072,30 @@ func (s *Server) handleNodeRequest(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(path, "/")
 	nodeID := parts[0]
 
+	if len(parts) == 2 && parts[1] == "heartbeat" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleNodeHeartbeat(w, r, nodeID)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "credential" {
+		s.handleNodeCredential(w, r, nodeID)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "workspaces" {
+		s.handleNodeWorkspaces(w, r, nodeID)
+		return
+	}
+
 	// Check if this is a command request
-	if len(parts) >= 3 && parts[1] == "commands" {
+	if len(parts) == 2 && parts[1] == "commands" {
 		switch r.Method {
+		case http.MethodGet:
+			s.handlePollCommands(w, r, nodeID)
 		case http.MethodPost:
 			s.handleSendCommand(w, r, nodeID)
 		default:
@@ -528,13 +1104,23 @@ func (s *Server) handleNodeRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(parts) == 2 && parts[1] == "status" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.handleGetNodeStatus(w, r, nodeID)
+		return
+	}
+
+	if len(parts) != 1 {
+		http.Error(w, "Invalid endpoint", http.StatusNotFound)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		if strings.HasSuffix(r.URL.Path, "/status") {
-			s.handleGetNodeStatus(w, r, nodeID)
-		} else {
-			s.handleGetNode(w, r, nodeID)
-		}
+		s.handleGetNode(w, r, nodeID)
 	case http.MethodPut:
 		s.handleUpdateNode(w, r, nodeID)
 	case http.MethodDelete:
@@ -556,27 +1142,42 @@ func (s *Server) handleCommandResultRequest(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	commandID := strings.Split(path, "/")[0]
-
-	switch r.Method {
-	case http.MethodPost:
-		if strings.HasSuffix(r.URL.Path, "/result") {
-			s.handleCommandResult(w, r, commandID)
-		} else {
-			http.Error(w, "Invalid endpoint", http.StatusBadRequest)
-		}
-	default:
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		http.Error(w, "Invalid endpoint", http.StatusNotFound)
+		return
+	}
+	commandID := parts[0]
+
+	switch {
+	case r.Method == http.MethodPost && parts[1] == "result":
+		s.handleCommandResult(w, r, commandID)
+	case r.Method == http.MethodPost && parts[1] == "output":
+		s.handleCommandOutput(w, r, commandID)
+	case r.Method == http.MethodGet && parts[1] == "output":
+		s.handleFollowCommandOutput(w, r, commandID)
+	case r.Method == http.MethodGet && parts[1] == "github-token":
+		s.handleCommandGitHubToken(w, r, commandID)
+	case parts[1] == "result" || parts[1] == "output" || parts[1] == "github-token":
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "Invalid endpoint", http.StatusNotFound)
 	}
 }
 
+// Handler returns the API behind the server's middleware, for serving it outside Start
+func (s *Server) Handler() http.Handler {
+	// Logging wraps the limits and auth layers so their rejections are logged and audited too
+	return s.corsMiddleware(s.loggingMiddleware(s.limitsMiddleware(s.authMiddleware(s.router))))
+}
+
 // Start starts the coordination server
 func (s *Server) Start() error {
 	addr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.Port)
 
 	s.httpSrv = &http.Server{
 		Addr:         addr,
-		Handler:      s.corsMiddleware(s.authMiddleware(s.loggingMiddleware(s.router))),
+		Handler:      s.Handler(),
 		ReadTimeout:  s.parseTimeout(s.config.Server.ReadTimeout),
 		WriteTimeout: s.parseTimeout(s.config.Server.WriteTimeout),
 		IdleTimeout:  s.parseTimeout(s.config.Server.IdleTimeout),
@@ -585,11 +1186,19 @@ func (s *Server) Start() error {
 	// Start event broadcaster
 	go s.broadcastResults()
 
+	// Mark nodes offline when their heartbeats stop
+	go s.runNodeReaper(s.reaperStop)
+
+	// Stop idle workspaces and delete expired ones
+	go s.runLifecycleReaper(s.reaperStop)
+
 	return s.httpSrv.ListenAndServe()
 }
 
 // Stop stops the coordination server
 func (s *Server) Stop(ctx context.Context) error {
+	s.reaperStopOnce.Do(func() { close(s.reaperStop) })
+
 	if s.httpSrv != nil {
 		return s.httpSrv.Shutdown(ctx)
 	}
@@ -610,7 +1219,14 @@ func (s *Server) GetRegistry() Registry {
 
 func (s *Server) broadcastResults() {
 	for result := range s.commandCh {
-		s.broadcastEvent("command_result", result)
+		s.broadcastEvent("command_result", CommandResultEvent{
+			CommandID: result.ID,
+			NodeID:    result.NodeID,
+			Type:      result.Command.Type,
+			Action:    result.Command.Action,
+			Status:    result.Status,
+			Duration:  result.Duration,
+		})
 	}
 }
 
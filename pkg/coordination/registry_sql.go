@@ -0,0 +1,1099 @@
+package coordination
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialect captures the differences between the SQL databases the registry runs on
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite"
+	dialectPostgres dialect = "postgres"
+)
+
+// rebind rewrites ? placeholders into the bind syntax of the dialect
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	inString := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			inString = !inString
+			b.WriteRune(c)
+		case c == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// forUpdate returns the row locking clause for reads that precede a write
+func (d dialect) forUpdate() string {
+	if d == dialectPostgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// nodeColumns lists the node columns in the order scanNode expects them
+const nodeColumns = `id, name, provider, status, address, port,
+	labels, capabilities, services, metadata, capacity, last_seen, created_at, updated_at`
+
+// sqlDB runs queries written with ? placeholders against a database of the given dialect
+type sqlDB struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (c sqlDB) exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.db.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c sqlDB) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.Query(c.dialect.rebind(query), args...)
+}
+
+func (c sqlDB) queryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRow(c.dialect.rebind(query), args...)
+}
+
+// SQLRegistry persists nodes, users and GitHub data in a SQL database.
+// It backs both the SQLite and the PostgreSQL storage types.
+type SQLRegistry struct {
+	sqlDB
+	userRegistry      UserRegistry
+	workspaceRegistry WorkspaceRegistry
+	mutex             sync.RWMutex
+}
+
+// newSQLRegistry migrates the database to the latest schema and wires up the registries sharing it
+func newSQLRegistry(db *sql.DB, d dialect) (*SQLRegistry, error) {
+	registry := &SQLRegistry{
+		sqlDB: sqlDB{db: db, dialect: d},
+	}
+
+	if err := registry.runMigrations(); err != nil {
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	registry.userRegistry = &SQLUserRegistry{sqlDB: registry.sqlDB}
+	registry.workspaceRegistry = newSQLWorkspaceRegistry(db, d)
+
+	return registry, nil
+}
+
+// Close closes the underlying database connection
+func (r *SQLRegistry) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLRegistry) runMigrations() error {
+	_, err := newMigrator(r.db, r.dialect, migrations).Up(0, false)
+	return err
+}
+
+func scanNode(row rowScanner) (*Node, error) {
+	var node Node
+	var name, provider, status, address sql.NullString
+	var port sql.NullInt64
+	var labels, capabilities, services, metadata, capacity sql.NullString
+	var lastSeen sql.NullTime
+
+	err := row.Scan(
+		&node.ID, &name, &provider, &status, &address, &port,
+		&labels, &capabilities, &services, &metadata, &capacity, &lastSeen, &node.CreatedAt, &node.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	node.Name = name.String
+	node.Provider = provider.String
+	node.Status = status.String
+	node.Address = address.String
+	node.Port = int(port.Int64)
+	node.LastSeen = lastSeen.Time
+
+	for _, field := range []struct {
+		column string
+		value  sql.NullString
+		dest   interface{}
+	}{
+		{"labels", labels, &node.Labels},
+		{"capabilities", capabilities, &node.Capabilities},
+		{"services", services, &node.Services},
+		{"metadata", metadata, &node.Metadata},
+		{"capacity", capacity, &node.Capacity},
+	} {
+		if !field.value.Valid || field.value.String == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(field.value.String), field.dest); err != nil {
+			return nil, fmt.Errorf("failed to decode node %s: %w", field.column, err)
+		}
+	}
+
+	return &node, nil
+}
+
+// nodeValues returns the column values of a node in nodeColumns order
+func nodeValues(node *Node) ([]interface{}, error) {
+	values := []interface{}{node.ID, node.Name, node.Provider, node.Status, node.Address, node.Port}
+	for _, field := range []interface{}{node.Labels, node.Capabilities, node.Services, node.Metadata, node.Capacity} {
+		data, err := json.Marshal(field)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode node: %w", err)
+		}
+		values = append(values, string(data))
+	}
+	return append(values, node.LastSeen, node.CreatedAt, node.UpdatedAt), nil
+}
+
+func (r *SQLRegistry) Register(node *Node) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if node.ID == "" {
+		return fmt.Errorf("node ID cannot be empty")
+	}
+
+	now := time.Now()
+	node.CreatedAt = now
+	node.UpdatedAt = now
+	node.LastSeen = now
+
+	values, err := nodeValues(node)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.exec(`
+		INSERT INTO nodes (`+nodeColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			name = excluded.name,
+			provider = excluded.provider,
+			status = excluded.status,
+			address = excluded.address,
+			port = excluded.port,
+			labels = excluded.labels,
+			capabilities = excluded.capabilities,
+			services = excluded.services,
+			metadata = excluded.metadata,
+			capacity = excluded.capacity,
+			last_seen = excluded.last_seen,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at
+	`, values...)
+	if err != nil {
+		return fmt.Errorf("failed to register node: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLRegistry) Unregister(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, err := r.exec("DELETE FROM nodes WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to unregister node: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLRegistry) Get(id string) (*Node, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	node, err := scanNode(r.queryRow("SELECT "+nodeColumns+" FROM nodes WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("node not found: %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node: %w", err)
+	}
+
+	return node, nil
+}
+
+func (r *SQLRegistry) List() ([]*Node, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	rows, err := r.query("SELECT " + nodeColumns + " FROM nodes ORDER BY created_at, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make([]*Node, 0)
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan node: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes, rows.Err()
+}
+
+func (r *SQLRegistry) Update(id string, updates map[string]interface{}) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	node, err := scanNode(tx.QueryRow(r.dialect.rebind("SELECT "+nodeColumns+" FROM nodes WHERE id = ?"+r.dialect.forUpdate()), id))
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("node not found: %s", id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get node: %w", err)
+	}
+	if err := checkNodeUpdate(node, updates); err != nil {
+		return err
+	}
+
+	applyNodeUpdates(node, updates)
+	node.UpdatedAt = time.Now()
+	if _, ok := updates["last_seen"].(time.Time); !ok {
+		node.LastSeen = time.Now()
+	}
+
+	values, err := nodeValues(node)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(r.dialect.rebind(`
+		UPDATE nodes SET
+			name = ?, provider = ?, status = ?, address = ?, port = ?,
+			labels = ?, capabilities = ?, services = ?, metadata = ?, capacity = ?,
+			last_seen = ?, created_at = ?, updated_at = ?
+		WHERE id = ?
+	`), append(values[1:], id)...)
+	if err != nil {
+		return fmt.Errorf("failed to update node: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLRegistry) SetStatus(id, status string) error {
+	return r.Update(id, map[string]interface{}{"status": status})
+}
+
+func (r *SQLRegistry) GetByLabel(key, value string) ([]*Node, error) {
+	all, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*Node
+	for _, node := range all {
+		if node.Labels[key] == value {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+func (r *SQLRegistry) GetByCapability(capability string) ([]*Node, error) {
+	all, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*Node
+	for _, node := range all {
+		if _, exists := node.Capabilities[capability]; exists {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+func (r *SQLRegistry) GetUserRegistry() UserRegistry {
+	return r.userRegistry
+}
+
+// GetWorkspaceRegistry returns the workspace registry sharing this database
+func (r *SQLRegistry) GetWorkspaceRegistry() WorkspaceRegistry {
+	return r.workspaceRegistry
+}
+
+func (r *SQLRegistry) StoreGitHubInstallation(installation *GitHubInstallation) error {
+	if err := installation.Validate(); err != nil {
+		return err
+	}
+
+	_, err := r.exec(`
+		INSERT INTO github_installations (
+			installation_id, user_id, github_user_id, github_username, 
+			repo_full_name, token, token_expires_at, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			token = excluded.token,
+			token_expires_at = excluded.token_expires_at,
+			github_username = excluded.github_username,
+			updated_at = CURRENT_TIMESTAMP
+	`, installation.InstallationID, installation.UserID, installation.GitHubUserID,
+		installation.GitHubUsername, installation.RepoFullName, installation.Token,
+		installation.TokenExpiresAt, time.Now(), time.Now())
+
+	if err != nil {
+		return fmt.Errorf("failed to store GitHub installation: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLRegistry) GetGitHubInstallation(userID string) (*GitHubInstallation, error) {
+	var installation GitHubInstallation
+
+	err := r.queryRow(`
+		SELECT installation_id, user_id, github_user_id, github_username, 
+		       repo_full_name, token, token_expires_at, created_at, updated_at
+		FROM github_installations
+		WHERE user_id = ?
+	`, userID).Scan(
+		&installation.InstallationID,
+		&installation.UserID,
+		&installation.GitHubUserID,
+		&installation.GitHubUsername,
+		&installation.RepoFullName,
+		&installation.Token,
+		&installation.TokenExpiresAt,
+		&installation.CreatedAt,
+		&installation.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("GitHub installation not found for user: %s", userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get GitHub installation: %w", err)
+	}
+
+	return &installation, nil
+}
+
+func (r *SQLRegistry) DeleteGitHubInstallation(userID string) error {
+	_, err := r.exec("DELETE FROM github_installations WHERE user_id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete GitHub installation: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLRegistry) StoreGitHubFork(fork *GitHubFork) error {
+	if err := fork.Validate(); err != nil {
+		return err
+	}
+
+	_, err := r.exec(`
+		INSERT INTO github_forks (user_id, original_owner, original_repo, fork_owner, fork_url, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, original_owner, original_repo) DO NOTHING
+	`, fork.UserID, fork.OriginalOwner, fork.OriginalRepo, fork.ForkOwner, fork.ForkURL, fork.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to store GitHub fork: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLRegistry) GetGitHubFork(userID, originalOwner, originalRepo string) (*GitHubFork, error) {
+	var fork GitHubFork
+
+	err := r.queryRow(`
+		SELECT user_id, original_owner, original_repo, fork_owner, fork_url, created_at
+		FROM github_forks
+		WHERE user_id = ? AND original_owner = ? AND original_repo = ?
+	`, userID, originalOwner, originalRepo).Scan(
+		&fork.UserID,
+		&fork.OriginalOwner,
+		&fork.OriginalRepo,
+		&fork.ForkOwner,
+		&fork.ForkURL,
+		&fork.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("GitHub fork not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get GitHub fork: %w", err)
+	}
+
+	return &fork, nil
+}
+
+func (r *SQLRegistry) ListGitHubInstallations() ([]*GitHubInstallation, error) {
+	rows, err := r.query(`
+		SELECT installation_id, user_id, github_user_id, github_username,
+		       repo_full_name, token, token_expires_at, created_at, updated_at
+		FROM github_installations
+		ORDER BY user_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list GitHub installations: %w", err)
+	}
+	defer rows.Close()
+
+	installations := make([]*GitHubInstallation, 0)
+	for rows.Next() {
+		var installation GitHubInstallation
+		var installationID sql.NullInt64
+		var repoFullName sql.NullString
+		if err := rows.Scan(
+			&installationID,
+			&installation.UserID,
+			&installation.GitHubUserID,
+			&installation.GitHubUsername,
+			&repoFullName,
+			&installation.Token,
+			&installation.TokenExpiresAt,
+			&installation.CreatedAt,
+			&installation.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan GitHub installation: %w", err)
+		}
+		installation.InstallationID = installationID.Int64
+		installation.RepoFullName = repoFullName.String
+		installations = append(installations, &installation)
+	}
+
+	return installations, rows.Err()
+}
+
+func (r *SQLRegistry) ListGitHubForks() ([]*GitHubFork, error) {
+	rows, err := r.query(`
+		SELECT user_id, original_owner, original_repo, fork_owner, fork_url, created_at
+		FROM github_forks
+		ORDER BY user_id, original_owner, original_repo
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list GitHub forks: %w", err)
+	}
+	defer rows.Close()
+
+	forks := make([]*GitHubFork, 0)
+	for rows.Next() {
+		var fork GitHubFork
+		if err := rows.Scan(
+			&fork.UserID,
+			&fork.OriginalOwner,
+			&fork.OriginalRepo,
+			&fork.ForkOwner,
+			&fork.ForkURL,
+			&fork.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan GitHub fork: %w", err)
+		}
+		forks = append(forks, &fork)
+	}
+
+	return forks, rows.Err()
+}
+
+// SQLUserRegistry persists users in a SQL database
+type SQLUserRegistry struct {
+	sqlDB
+	mu sync.RWMutex
+}
+
+const userColumns = "id, issuer, username, public_key, workspace_id, role, created_at, updated_at"
+
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	if err := row.Scan(&user.ID, &user.Issuer, &user.Username, &user.PublicKey, &user.WorkspaceID, &user.Role, &user.CreatedAt, &user.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *SQLUserRegistry) Register(user *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user.Username == "" {
+		return fmt.Errorf("username cannot be empty")
+	}
+
+	if user.ID == "" {
+		user.ID = fmt.Sprintf("user_%d_%s", time.Now().Unix(), user.Username)
+	}
+	if user.Role == "" {
+		user.Role = RoleDeveloper
+	}
+	if !IsValidRole(user.Role) {
+		return fmt.Errorf("invalid role: %s", user.Role)
+	}
+
+	var exists int
+	if err := r.queryRow("SELECT COUNT(1) FROM users WHERE username = ? OR id = ?", user.Username, user.ID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check user: %w", err)
+	}
+	if exists > 0 {
+		return fmt.Errorf("%w: %s", ErrUserExists, user.Username)
+	}
+
+	// Imported users keep their timestamps
+	now := time.Now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = user.CreatedAt
+	}
+
+	_, err := r.exec(`
+		INSERT INTO users (`+userColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, user.ID, user.Issuer, user.Username, user.PublicKey, user.WorkspaceID, user.Role, user.CreatedAt, user.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to register user: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLUserRegistry) GetByUsername(username string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, err := scanUser(r.queryRow(`
+		SELECT `+userColumns+`
+		FROM users
+		WHERE username = ?
+	`, username))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found: %s", username)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (r *SQLUserRegistry) GetBySubject(issuer, subject string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, err := scanUser(r.queryRow(`
+		SELECT `+userColumns+`
+		FROM users
+		WHERE id = ? AND (issuer = ? OR issuer = '')
+	`, subject, issuer))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found: %s", subject)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (r *SQLUserRegistry) GetByWorkspace(workspaceID string) ([]*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.queryUsers(`
+		SELECT `+userColumns+`
+		FROM users
+		WHERE workspace_id = ?
+	`, workspaceID)
+}
+
+func (r *SQLUserRegistry) List() ([]*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.queryUsers(`
+		SELECT ` + userColumns + `
+		FROM users
+	`)
+}
+
+func (r *SQLUserRegistry) queryUsers(query string, args ...interface{}) ([]*User, error) {
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (r *SQLUserRegistry) SetRole(username, role string) error {
+	if !IsValidRole(role) {
+		return fmt.Errorf("invalid role: %s", role)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result, err := r.exec("UPDATE users SET role = ?, updated_at = ? WHERE username = ?", role, time.Now(), username)
+	if err != nil {
+		return fmt.Errorf("failed to set user role: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("user not found: %s", username)
+	}
+
+	return nil
+}
+
+func (r *SQLUserRegistry) Delete(username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.exec("DELETE FROM users WHERE username = ?", username)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	return nil
+}
+
+const apiTokenColumns = "id, username, name, prefix, token_hash, scopes, expires_at, last_used_at, created_at"
+
+func scanAPIToken(row rowScanner) (*APIToken, error) {
+	var token APIToken
+	var prefix, scopes sql.NullString
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&token.ID, &token.Username, &token.Name, &prefix, &token.TokenHash,
+		&scopes, &expiresAt, &lastUsedAt, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Prefix = prefix.String
+	token.Scopes = strings.Split(scopes.String, ",")
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return &token, nil
+}
+
+func (r *SQLRegistry) CreateAPIToken(token *APIToken) error {
+	if err := token.Validate(); err != nil {
+		return err
+	}
+
+	var exists int
+	if err := r.queryRow("SELECT COUNT(1) FROM api_tokens WHERE id = ? OR (username = ? AND name = ?)",
+		token.ID, token.Username, token.Name).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check API token: %w", err)
+	}
+	if exists > 0 {
+		return fmt.Errorf("API token already exists: %s", token.Name)
+	}
+
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+
+	_, err := r.exec(`
+		INSERT INTO api_tokens (`+apiTokenColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, token.ID, token.Username, token.Name, token.Prefix, token.TokenHash,
+		strings.Join(token.Scopes, ","), token.ExpiresAt, token.LastUsedAt, token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create API token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLRegistry) GetAPIToken(id string) (*APIToken, error) {
+	token, err := scanAPIToken(r.queryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("API token not found: %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API token: %w", err)
+	}
+	return token, nil
+}
+
+func (r *SQLRegistry) GetAPITokenByHash(hash string) (*APIToken, error) {
+	token, err := scanAPIToken(r.queryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", hash))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("API token not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API token: %w", err)
+	}
+	return token, nil
+}
+
+func (r *SQLRegistry) ListAPITokens(username string) ([]*APIToken, error) {
+	query := "SELECT " + apiTokenColumns + " FROM api_tokens"
+	var args []interface{}
+	if username != "" {
+		query += " WHERE username = ?"
+		args = append(args, username)
+	}
+
+	rows, err := r.query(query+" ORDER BY username, name", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]*APIToken, 0)
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (r *SQLRegistry) TouchAPIToken(id string, usedAt time.Time) error {
+	result, err := r.exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", usedAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to update API token: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("API token not found: %s", id)
+	}
+	return nil
+}
+
+func (r *SQLRegistry) DeleteAPIToken(id string) error {
+	result, err := r.exec("DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete API token: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("API token not found: %s", id)
+	}
+	return nil
+}
+
+const enrollmentTokenColumns = "id, token_hash, node_id, created_by, expires_at, used_at, used_by, created_at"
+
+func scanEnrollmentToken(row rowScanner) (*EnrollmentToken, error) {
+	var token EnrollmentToken
+	var nodeID, createdBy, usedBy sql.NullString
+	var usedAt sql.NullTime
+	err := row.Scan(&token.ID, &token.TokenHash, &nodeID, &createdBy, &token.ExpiresAt, &usedAt, &usedBy, &token.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	token.NodeID = nodeID.String
+	token.CreatedBy = createdBy.String
+	token.UsedBy = usedBy.String
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	return &token, nil
+}
+
+func (r *SQLRegistry) CreateEnrollmentToken(token *EnrollmentToken) error {
+	if token.ID == "" || token.TokenHash == "" {
+		return fmt.Errorf("enrollment token ID and hash are required")
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+
+	_, err := r.exec(`
+		INSERT INTO enrollment_tokens (`+enrollmentTokenColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, token.ID, token.TokenHash, token.NodeID, token.CreatedBy, token.ExpiresAt, token.UsedAt, token.UsedBy, token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create enrollment token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLRegistry) ListEnrollmentTokens() ([]*EnrollmentToken, error) {
+	rows, err := r.query("SELECT " + enrollmentTokenColumns + " FROM enrollment_tokens ORDER BY created_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list enrollment tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]*EnrollmentToken, 0)
+	for rows.Next() {
+		token, err := scanEnrollmentToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan enrollment token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (r *SQLRegistry) GetEnrollmentTokenByHash(hash string) (*EnrollmentToken, error) {
+	token, err := scanEnrollmentToken(r.queryRow("SELECT "+enrollmentTokenColumns+" FROM enrollment_tokens WHERE token_hash = ?", hash))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("enrollment token not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get enrollment token: %w", err)
+	}
+	return token, nil
+}
+
+func (r *SQLRegistry) ConsumeEnrollmentToken(id, nodeID string, now time.Time) (*EnrollmentToken, error) {
+	token, err := scanEnrollmentToken(r.queryRow("SELECT "+enrollmentTokenColumns+" FROM enrollment_tokens WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("enrollment token not found: %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get enrollment token: %w", err)
+	}
+	if err := checkEnrollment(token, nodeID, now); err != nil {
+		return nil, err
+	}
+
+	// The used_at guard makes concurrent enrollments with the same token race safely
+	result, err := r.exec("UPDATE enrollment_tokens SET used_at = ?, used_by = ? WHERE id = ? AND used_at IS NULL", now, nodeID, token.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume enrollment token: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return nil, fmt.Errorf("enrollment token already used")
+	}
+
+	token.UsedAt = &now
+	token.UsedBy = nodeID
+	return token, nil
+}
+
+func (r *SQLRegistry) DeleteEnrollmentToken(id string) error {
+	result, err := r.exec("DELETE FROM enrollment_tokens WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete enrollment token: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("enrollment token not found: %s", id)
+	}
+	return nil
+}
+
+const nodeCredentialColumns = "node_id, secret_hash, previous_hash, previous_expires_at, issued_at, expires_at"
+
+func scanNodeCredential(row rowScanner) (*NodeCredential, error) {
+	var credential NodeCredential
+	var previousHash sql.NullString
+	var previousExpiresAt sql.NullTime
+	err := row.Scan(&credential.NodeID, &credential.SecretHash, &previousHash, &previousExpiresAt,
+		&credential.IssuedAt, &credential.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	credential.PreviousHash = previousHash.String
+	if previousExpiresAt.Valid {
+		credential.PreviousExpiresAt = &previousExpiresAt.Time
+	}
+	return &credential, nil
+}
+
+func (r *SQLRegistry) SaveNodeCredential(credential *NodeCredential) error {
+	if credential.NodeID == "" || credential.SecretHash == "" {
+		return fmt.Errorf("node credential node ID and hash are required")
+	}
+
+	var previousHash interface{}
+	if credential.PreviousHash != "" {
+		previousHash = credential.PreviousHash
+	}
+
+	_, err := r.exec(`
+		INSERT INTO node_credentials (`+nodeCredentialColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(node_id) DO UPDATE SET
+			secret_hash = excluded.secret_hash,
+			previous_hash = excluded.previous_hash,
+			previous_expires_at = excluded.previous_expires_at,
+			issued_at = excluded.issued_at,
+			expires_at = excluded.expires_at
+	`, credential.NodeID, credential.SecretHash, previousHash, credential.PreviousExpiresAt,
+		credential.IssuedAt, credential.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save node credential: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLRegistry) GetNodeCredential(nodeID string) (*NodeCredential, error) {
+	credential, err := scanNodeCredential(r.queryRow("SELECT "+nodeCredentialColumns+" FROM node_credentials WHERE node_id = ?", nodeID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("node credential not found: %s", nodeID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node credential: %w", err)
+	}
+	return credential, nil
+}
+
+func (r *SQLRegistry) GetNodeCredentialByHash(hash string) (*NodeCredential, error) {
+	credential, err := scanNodeCredential(r.queryRow(
+		"SELECT "+nodeCredentialColumns+" FROM node_credentials WHERE secret_hash = ? OR previous_hash = ?", hash, hash))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("node credential not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node credential: %w", err)
+	}
+	return credential, nil
+}
+
+func (r *SQLRegistry) DeleteNodeCredential(nodeID string) error {
+	result, err := r.exec("DELETE FROM node_credentials WHERE node_id = ?", nodeID)
+	if err != nil {
+		return fmt.Errorf("failed to delete node credential: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("node credential not found: %s", nodeID)
+	}
+	return nil
+}
+
+const auditColumns = "id, timestamp, actor, actor_kind, action, resource, method, path, request_id, source_ip, status, result, duration_ms"
+
+func scanAuditEntry(row rowScanner) (*AuditEntry, error) {
+	var entry AuditEntry
+	var resource sql.NullString
+	err := row.Scan(&entry.ID, &entry.Timestamp, &entry.Actor, &entry.ActorKind, &entry.Action, &resource,
+		&entry.Method, &entry.Path, &entry.RequestID, &entry.SourceIP, &entry.Status, &entry.Result, &entry.DurationMs)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Resource = resource.String
+	return &entry, nil
+}
+
+func (r *SQLRegistry) AppendAudit(entry *AuditEntry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+
+	err := r.queryRow(`
+		INSERT INTO audit_log (timestamp, actor, actor_kind, action, resource, method, path, request_id, source_ip, status, result, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id
+	`, entry.Timestamp, entry.Actor, entry.ActorKind, entry.Action, entry.Resource, entry.Method, entry.Path,
+		entry.RequestID, entry.SourceIP, entry.Status, entry.Result, entry.DurationMs).Scan(&entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed to append audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// likeEscaper escapes the LIKE wildcards in a literal prefix
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (r *SQLRegistry) ListAudit(filter AuditFilter) ([]*AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Resource != "" {
+		conditions = append(conditions, `(resource = ? OR resource LIKE ? ESCAPE '\')`)
+		args = append(args, filter.Resource, likeEscaper.Replace(filter.Resource)+"/%")
+	}
+	if filter.Result != "" {
+		conditions = append(conditions, "result = ?")
+		args = append(args, filter.Result)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "timestamp < ?")
+		args = append(args, filter.Until)
+	}
+
+	query := "SELECT " + auditColumns + " FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]*AuditEntry, 0)
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -11,8 +11,6 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
-
-	"github.com/nexus/nexus/pkg/paths"
 )
 
 // ServerInfo contains server runtime information
@@ -29,25 +27,23 @@ type ServerInfo struct {
 
 // StartServer starts the coordination server with proper lifecycle management
 func StartServer(configPath string) error {
-	projectRoot := paths.GetProjectRoot()
-
-	if dbPath := os.Getenv("DB_PATH"); dbPath == "" {
-		dbPath = paths.GetDatabasePath(projectRoot)
-		os.Setenv("DB_PATH", dbPath)
-
-		if err := paths.EnsureDir(paths.GetDataDir(projectRoot)); err != nil {
-			return fmt.Errorf("failed to create data directory: %w", err)
-		}
-	}
-
 	// Load configuration
 	cfg, err := LoadConfig(configPath)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// The server persists to SQLite unless told otherwise, at the path DatabasePath
+	// resolves, which is also where `nexus coordination migrate` and export look
+	if cfg.Registry.Storage.Type == "" {
+		cfg.Registry.Storage.Type = defaultStorageType
+	}
+
 	// Create server instance
-	srv := NewServer(cfg)
+	srv, err := NewServer(cfg)
+	if err != nil {
+		return err
+	}
 
 	// Start server in goroutine
 	serverErr := make(chan error, 1)
@@ -124,11 +120,11 @@ func ValidateConfig(cfg *Config) error {
 	}
 
 	if cfg.Auth.Enabled {
-		if cfg.Auth.JWTSecret == "" {
-			return fmt.Errorf("JWT secret is required when auth is enabled")
+		if cfg.Auth.OIDC == nil && cfg.Server.AuthToken == "" {
+			return fmt.Errorf("an OIDC issuer or server auth token is required when auth is enabled")
 		}
-		if len(cfg.Auth.JWTSecret) < 16 {
-			return fmt.Errorf("JWT secret must be at least 16 characters long")
+		if cfg.Server.AuthToken != "" && len(cfg.Server.AuthToken) < 16 {
+			return fmt.Errorf("server auth token must be at least 16 characters long")
 		}
 	}
 
@@ -155,12 +151,16 @@ func GenerateDefaultConfig(path string) error {
 	cfg.Server.ReadTimeout = "30s"
 	cfg.Server.WriteTimeout = "30s"
 	cfg.Server.IdleTimeout = "120s"
+	cfg.Server.Limits.PerIP = RateLimitConfig{RequestsPerSecond: 50, Burst: 100}
+	cfg.Server.Limits.Expensive = RateLimitConfig{RequestsPerSecond: 0.5, Burst: 5}
+	cfg.Server.Limits.MaxBodyBytes = 10 << 20
+	cfg.Server.Limits.MaxConcurrent = 512
 
-	cfg.Registry.Provider = "memory"
 	cfg.Registry.SyncInterval = "30s"
 	cfg.Registry.HealthCheckInterval = "10s"
 	cfg.Registry.NodeTimeout = "60s"
 	cfg.Registry.MaxRetries = 3
+	cfg.Registry.Storage.Type = defaultStorageType
 
 	cfg.WebSocket.Enabled = true
 	cfg.WebSocket.Path = "/ws"
@@ -182,31 +182,70 @@ func GenerateDefaultConfig(path string) error {
 	return SaveConfig(cfg, path)
 }
 
-// BackupRegistry creates a backup of the current registry state
-func (s *Server) BackupRegistry() ([]byte, error) {
-	nodes, err := s.registry.List()
+// ExportSnapshot exports the registries together with the GitHub installations held by the
+// server. GitHub installation tokens are only included with includeSecrets.
+func (s *Server) ExportSnapshot(includeSecrets bool) (*Snapshot, error) {
+	snapshot, err := ExportSnapshot(s.registry, s.workspaceRegistry)
 	if err != nil {
 		return nil, err
 	}
 
-	backup := map[string]interface{}{
-		"timestamp": time.Now(),
-		"version":   "1.0",
-		"nodes":     nodes,
+	exported := make(map[string]bool)
+	for _, installation := range snapshot.GitHubInstallations {
+		exported[installation.UserID] = true
+	}
+
+	s.gitHubInstallationsMu.RLock()
+	for _, installation := range s.gitHubInstallations {
+		if !exported[installation.UserID] {
+			snapshot.GitHubInstallations = append(snapshot.GitHubInstallations, installation)
+		}
+	}
+	s.gitHubInstallationsMu.RUnlock()
+
+	if !includeSecrets {
+		snapshot.Redact()
 	}
+	return snapshot, nil
+}
+
+// ImportSnapshot imports a snapshot into the registries and the server's GitHub installations
+func (s *Server) ImportSnapshot(snapshot *Snapshot) (*ImportResult, error) {
+	result, err := ImportSnapshot(s.registry, s.workspaceRegistry, snapshot)
+	if err != nil {
+		return result, err
+	}
+
+	s.gitHubInstallationsMu.Lock()
+	for _, installation := range snapshot.GitHubInstallations {
+		if installation.Token != "" {
+			s.gitHubInstallations[installation.UserID] = installation
+		}
+	}
+	s.gitHubInstallationsMu.Unlock()
 
-	return json.MarshalIndent(backup, "", "  ")
+	return result, nil
 }
 
-// RestoreRegistry restores registry state from backup
+// BackupRegistry creates a JSON backup of the current registry state, secrets included
+// so RestoreRegistry brings everything back
+func (s *Server) BackupRegistry() ([]byte, error) {
+	snapshot, err := s.ExportSnapshot(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(snapshot, "", "  ")
+}
+
+// RestoreRegistry restores registry state from a JSON backup
 func (s *Server) RestoreRegistry(backupData []byte) error {
-	var backup map[string]interface{}
-	if err := json.Unmarshal(backupData, &backup); err != nil {
+	var snapshot Snapshot
+	if err := json.Unmarshal(backupData, &snapshot); err != nil {
 		return fmt.Errorf("failed to parse backup data: %w", err)
 	}
 
-	nodesData, ok := backup["nodes"].([]interface{})
-	if !ok {
+	if snapshot.Nodes == nil {
 		return fmt.Errorf("invalid backup format: missing nodes data")
 	}
 
@@ -217,22 +256,13 @@ func (s *Server) RestoreRegistry(backupData []byte) error {
 		memRegistry.nodeMutex.Unlock()
 	}
 
-	// Restore nodes
-	for _, nodeData := range nodesData {
-		nodeJSON, _ := json.Marshal(nodeData)
-		var node Node
-		if err := json.Unmarshal(nodeJSON, &node); err != nil {
-			log.Printf("Failed to restore node from backup: %v", err)
-			continue
-		}
-
-		if err := s.registry.Register(&node); err != nil {
-			log.Printf("Failed to register restored node %s: %v", node.ID, err)
-			continue
-		}
+	result, err := s.ImportSnapshot(&snapshot)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Registry restored from backup with %d nodes", len(nodesData))
+	log.Printf("Registry restored from backup with %d nodes, %d users, %d workspaces",
+		result.Nodes, result.Users, result.Workspaces)
 	return nil
 }
 
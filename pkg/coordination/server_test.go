@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -21,36 +22,15 @@ func TestValidateConfig(t *testing.T) {
 		{
 			name: "valid config",
 			cfg: &Config{
-				Server: struct {
-					Host         string `yaml:"host,omitempty"`
-					Port         int    `yaml:"port,omitempty"`
-					AuthToken    string `yaml:"auth_token,omitempty"`
-					JWTSecret    string `yaml:"jwt_secret,omitempty"`
-					ReadTimeout  string `yaml:"read_timeout,omitempty"`
-					WriteTimeout string `yaml:"write_timeout,omitempty"`
-					IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-				}{Host: "localhost", Port: 3001},
-				Auth: struct {
-					Enabled     bool     `yaml:"enabled,omitempty"`
-					JWTSecret   string   `yaml:"jwt_secret,omitempty"`
-					TokenExpiry string   `yaml:"token_expiry,omitempty"`
-					AllowedIPs  []string `yaml:"allowed_ips,omitempty"`
-				}{Enabled: false},
+				Server: ServerConfig{Host: "localhost", Port: 3001},
+				Auth:   AuthConfig{Enabled: false},
 			},
 			wantErr: false,
 		},
 		{
 			name: "invalid port too low",
 			cfg: &Config{
-				Server: struct {
-					Host         string `yaml:"host,omitempty"`
-					Port         int    `yaml:"port,omitempty"`
-					AuthToken    string `yaml:"auth_token,omitempty"`
-					JWTSecret    string `yaml:"jwt_secret,omitempty"`
-					ReadTimeout  string `yaml:"read_timeout,omitempty"`
-					WriteTimeout string `yaml:"write_timeout,omitempty"`
-					IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-				}{Host: "localhost", Port: 0},
+				Server: ServerConfig{Host: "localhost", Port: 0},
 			},
 			wantErr: true,
 			errMsg:  "port must be between 1 and 65535",
@@ -58,15 +38,7 @@ func TestValidateConfig(t *testing.T) {
 		{
 			name: "empty host",
 			cfg: &Config{
-				Server: struct {
-					Host         string `yaml:"host,omitempty"`
-					Port         int    `yaml:"port,omitempty"`
-					AuthToken    string `yaml:"auth_token,omitempty"`
-					JWTSecret    string `yaml:"jwt_secret,omitempty"`
-					ReadTimeout  string `yaml:"read_timeout,omitempty"`
-					WriteTimeout string `yaml:"write_timeout,omitempty"`
-					IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-				}{Host: "", Port: 3001},
+				Server: ServerConfig{Host: "", Port: 3001},
 			},
 			wantErr: true,
 			errMsg:  "server host cannot be empty",
@@ -74,67 +46,26 @@ func TestValidateConfig(t *testing.T) {
 		{
 			name: "negative max retries",
 			cfg: &Config{
-				Server: struct {
-					Host         string `yaml:"host,omitempty"`
-					Port         int    `yaml:"port,omitempty"`
-					AuthToken    string `yaml:"auth_token,omitempty"`
-					JWTSecret    string `yaml:"jwt_secret,omitempty"`
-					ReadTimeout  string `yaml:"read_timeout,omitempty"`
-					WriteTimeout string `yaml:"write_timeout,omitempty"`
-					IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-				}{Host: "localhost", Port: 3001},
-				Registry: struct {
-					Provider            string        `yaml:"provider,omitempty"`
-					SyncInterval        string        `yaml:"sync_interval,omitempty"`
-					HealthCheckInterval string        `yaml:"health_check_interval,omitempty"`
-					NodeTimeout         string        `yaml:"node_timeout,omitempty"`
-					MaxRetries          int           `yaml:"max_retries,omitempty"`
-					Storage             StorageConfig `yaml:"storage,omitempty"`
-				}{MaxRetries: -1},
+				Server:   ServerConfig{Host: "localhost", Port: 3001},
+				Registry: RegistryConfig{MaxRetries: -1},
 			},
 			wantErr: true,
 			errMsg:  "max_retries must be non-negative",
 		},
 		{
-			name: "auth enabled without secret",
+			name: "auth enabled without credentials",
 			cfg: &Config{
-				Server: struct {
-					Host         string `yaml:"host,omitempty"`
-					Port         int    `yaml:"port,omitempty"`
-					AuthToken    string `yaml:"auth_token,omitempty"`
-					JWTSecret    string `yaml:"jwt_secret,omitempty"`
-					ReadTimeout  string `yaml:"read_timeout,omitempty"`
-					WriteTimeout string `yaml:"write_timeout,omitempty"`
-					IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-				}{Host: "localhost", Port: 3001},
-				Auth: struct {
-					Enabled     bool     `yaml:"enabled,omitempty"`
-					JWTSecret   string   `yaml:"jwt_secret,omitempty"`
-					TokenExpiry string   `yaml:"token_expiry,omitempty"`
-					AllowedIPs  []string `yaml:"allowed_ips,omitempty"`
-				}{Enabled: true, JWTSecret: ""},
+				Server: ServerConfig{Host: "localhost", Port: 3001},
+				Auth:   AuthConfig{Enabled: true, JWTSecret: ""},
 			},
 			wantErr: true,
-			errMsg:  "JWT secret is required",
+			errMsg:  "OIDC issuer or server auth token is required",
 		},
 		{
-			name: "jwt secret too short",
+			name: "server auth token too short",
 			cfg: &Config{
-				Server: struct {
-					Host         string `yaml:"host,omitempty"`
-					Port         int    `yaml:"port,omitempty"`
-					AuthToken    string `yaml:"auth_token,omitempty"`
-					JWTSecret    string `yaml:"jwt_secret,omitempty"`
-					ReadTimeout  string `yaml:"read_timeout,omitempty"`
-					WriteTimeout string `yaml:"write_timeout,omitempty"`
-					IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-				}{Host: "localhost", Port: 3001},
-				Auth: struct {
-					Enabled     bool     `yaml:"enabled,omitempty"`
-					JWTSecret   string   `yaml:"jwt_secret,omitempty"`
-					TokenExpiry string   `yaml:"token_expiry,omitempty"`
-					AllowedIPs  []string `yaml:"allowed_ips,omitempty"`
-				}{Enabled: true, JWTSecret: "short"},
+				Server: ServerConfig{Host: "localhost", Port: 3001, AuthToken: "short"},
+				Auth:   AuthConfig{Enabled: true},
 			},
 			wantErr: true,
 			errMsg:  "must be at least 16 characters",
@@ -193,22 +124,23 @@ func TestGenerateDefaultConfig(t *testing.T) {
 
 	assert.Equal(t, "0.0.0.0", cfg.Server.Host)
 	assert.Equal(t, 3001, cfg.Server.Port)
-	assert.Equal(t, "memory", cfg.Registry.Provider)
+	assert.Equal(t, defaultStorageType, cfg.Registry.Storage.Type, "the generated config says what the server uses")
+	assert.Empty(t, cfg.Registry.Provider)
 	assert.True(t, cfg.WebSocket.Enabled)
 	assert.False(t, cfg.Auth.Enabled)
 }
 
+func TestLoadConfigRejectsRegistryProvider(t *testing.T) {
+	configPath := t.TempDir() + "/config.yaml"
+	require.NoError(t, os.WriteFile(configPath, []byte("registry:\n  provider: memory\n"), 0600))
+
+	_, err := LoadConfig(configPath)
+	assert.ErrorContains(t, err, "registry.provider is no longer supported")
+}
+
 func TestServerGetServerInfo(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	info, err := srv.GetServerInfo()
@@ -221,16 +153,8 @@ func TestServerGetServerInfo(t *testing.T) {
 }
 
 func TestServerBackupRegistry(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	backup, err := srv.BackupRegistry()
@@ -246,16 +170,8 @@ func TestServerBackupRegistry(t *testing.T) {
 }
 
 func TestServerRestoreRegistry(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	backup := []byte(`{"timestamp":"2024-01-01T00:00:00Z","version":"1.0","nodes":[]}`)
@@ -264,16 +180,8 @@ func TestServerRestoreRegistry(t *testing.T) {
 }
 
 func TestServerGetStats(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	stats := srv.GetStats()
@@ -284,22 +192,9 @@ func TestServerGetStats(t *testing.T) {
 }
 
 func TestServerHealthCheck(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
-		WebSocket: struct {
-			Enabled    bool     `yaml:"enabled,omitempty"`
-			Path       string   `yaml:"path,omitempty"`
-			Origins    []string `yaml:"origins,omitempty"`
-			PingPeriod string   `yaml:"ping_period,omitempty"`
-		}{Enabled: true},
+	srv := newTestServer(t, &Config{
+		Server:    ServerConfig{Host: "localhost", Port: 3001},
+		WebSocket: WebSocketConfig{Enabled: true},
 	})
 
 	health := srv.HealthCheck()
@@ -309,16 +204,8 @@ func TestServerHealthCheck(t *testing.T) {
 }
 
 func TestHandleListNodes(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("GET", "/api/v1/nodes", nil)
@@ -335,16 +222,8 @@ func TestHandleListNodes(t *testing.T) {
 }
 
 func TestHandleGetNode(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("GET", "/api/v1/nodes/nonexistent", nil)
@@ -355,16 +234,8 @@ func TestHandleGetNode(t *testing.T) {
 }
 
 func TestHandleHealth(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("GET", "/api/v1/health", nil)
@@ -380,16 +251,8 @@ func TestHandleHealth(t *testing.T) {
 }
 
 func TestHandleMetrics(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("GET", "/api/v1/metrics", nil)
@@ -406,16 +269,8 @@ func TestHandleMetrics(t *testing.T) {
 }
 
 func TestHandleListServices(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	req := httptest.NewRequest("GET", "/api/v1/services", nil)
@@ -426,16 +281,8 @@ func TestHandleListServices(t *testing.T) {
 }
 
 func TestHandleRegisterNode(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	nodeData := map[string]interface{}{
@@ -483,23 +330,10 @@ func TestAuthMiddleware(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cfg := &Config{
-				Server: struct {
-					Host         string `yaml:"host,omitempty"`
-					Port         int    `yaml:"port,omitempty"`
-					AuthToken    string `yaml:"auth_token,omitempty"`
-					JWTSecret    string `yaml:"jwt_secret,omitempty"`
-					ReadTimeout  string `yaml:"read_timeout,omitempty"`
-					WriteTimeout string `yaml:"write_timeout,omitempty"`
-					IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-				}{Host: "localhost", Port: 3001, AuthToken: "test-token"},
-				Auth: struct {
-					Enabled     bool     `yaml:"enabled,omitempty"`
-					JWTSecret   string   `yaml:"jwt_secret,omitempty"`
-					TokenExpiry string   `yaml:"token_expiry,omitempty"`
-					AllowedIPs  []string `yaml:"allowed_ips,omitempty"`
-				}{Enabled: tt.authEnabled},
+				Server: ServerConfig{Host: "localhost", Port: 3001, AuthToken: "test-token"},
+				Auth:   AuthConfig{Enabled: tt.authEnabled},
 			}
-			srv := NewServer(cfg)
+			srv := newTestServer(t, cfg)
 
 			handler := srv.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusOK)
@@ -517,16 +351,8 @@ func TestAuthMiddleware(t *testing.T) {
 }
 
 func TestCORSMiddleware(t *testing.T) {
-	srv := NewServer(&Config{
-		Server: struct {
-			Host         string `yaml:"host,omitempty"`
-			Port         int    `yaml:"port,omitempty"`
-			AuthToken    string `yaml:"auth_token,omitempty"`
-			JWTSecret    string `yaml:"jwt_secret,omitempty"`
-			ReadTimeout  string `yaml:"read_timeout,omitempty"`
-			WriteTimeout string `yaml:"write_timeout,omitempty"`
-			IdleTimeout  string `yaml:"idle_timeout,omitempty"`
-		}{Host: "localhost", Port: 3001},
+	srv := newTestServer(t, &Config{
+		Server: ServerConfig{Host: "localhost", Port: 3001},
 	})
 
 	handler := srv.corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,650 @@
+package coordination
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storageBackend opens empty registries for one run of the conformance suite
+type storageBackend struct {
+	name string
+	open func(t *testing.T) (Registry, WorkspaceRegistry)
+}
+
+// requirePostgres is set by building the tests with -tags postgres, as CI does. The suite
+// then fails rather than leaving PostgreSQL out when NEXUS_TEST_POSTGRES_DSN is not set.
+var requirePostgres bool
+
+// storageBackends returns every backend the suite runs against. PostgreSQL is
+// included when NEXUS_TEST_POSTGRES_DSN points at a server or the postgres build tag is
+// set; each run gets its own schema.
+func storageBackends() []storageBackend {
+	backends := []storageBackend{
+		{
+			name: "memory",
+			open: func(t *testing.T) (Registry, WorkspaceRegistry) {
+				return NewInMemoryRegistry(), NewInMemoryWorkspaceRegistry()
+			},
+		},
+		{
+			name: "sqlite",
+			open: func(t *testing.T) (Registry, WorkspaceRegistry) {
+				registry, err := NewSQLiteRegistry(t.TempDir() + "/nexus.db")
+				require.NoError(t, err)
+				t.Cleanup(func() { registry.Close() })
+				return registry, registry.GetWorkspaceRegistry()
+			},
+		},
+	}
+
+	if dsn := os.Getenv("NEXUS_TEST_POSTGRES_DSN"); dsn != "" || requirePostgres {
+		backends = append(backends, storageBackend{
+			name: "postgres",
+			open: func(t *testing.T) (Registry, WorkspaceRegistry) {
+				if dsn == "" {
+					t.Fatal("NEXUS_TEST_POSTGRES_DSN must point at a PostgreSQL server when testing with -tags postgres")
+				}
+				return openTestPostgresRegistry(t, dsn)
+			},
+		})
+	}
+
+	return backends
+}
+
+func openTestPostgresRegistry(t *testing.T, dsn string) (Registry, WorkspaceRegistry) {
+	admin, err := OpenPostgresDatabase(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("nexus_test_%d", time.Now().UnixNano())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	require.NoError(t, err)
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	separator := " "
+	if strings.Contains(dsn, "://") {
+		separator = "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+	}
+
+	registry, err := NewPostgresRegistry(dsn + separator + "search_path=" + schema)
+	require.NoError(t, err)
+	t.Cleanup(func() { registry.Close() })
+	return registry, registry.GetWorkspaceRegistry()
+}
+
+func TestStorageConformance(t *testing.T) {
+	for _, backend := range storageBackends() {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			t.Run("Nodes", func(t *testing.T) {
+				registry, _ := backend.open(t)
+				testNodeConformance(t, registry)
+			})
+			t.Run("Users", func(t *testing.T) {
+				registry, _ := backend.open(t)
+				testUserConformance(t, registry.GetUserRegistry())
+			})
+			t.Run("Workspaces", func(t *testing.T) {
+				_, workspaceRegistry := backend.open(t)
+				testWorkspaceConformance(t, workspaceRegistry)
+			})
+			t.Run("Services", func(t *testing.T) {
+				_, workspaceRegistry := backend.open(t)
+				testServiceConformance(t, workspaceRegistry)
+			})
+			t.Run("Grants", func(t *testing.T) {
+				_, workspaceRegistry := backend.open(t)
+				testGrantConformance(t, workspaceRegistry)
+			})
+			t.Run("APITokens", func(t *testing.T) {
+				registry, _ := backend.open(t)
+				store, ok := registry.(APITokenStore)
+				require.True(t, ok, "registry must implement APITokenStore")
+				testAPITokenConformance(t, store)
+			})
+			t.Run("NodeCredentials", func(t *testing.T) {
+				registry, _ := backend.open(t)
+				store, ok := registry.(NodeCredentialStore)
+				require.True(t, ok, "registry must implement NodeCredentialStore")
+				testNodeCredentialConformance(t, store)
+			})
+			t.Run("Audit", func(t *testing.T) {
+				registry, _ := backend.open(t)
+				store, ok := registry.(AuditStore)
+				require.True(t, ok, "registry must implement AuditStore")
+				testAuditConformance(t, store)
+			})
+			t.Run("GitHub", func(t *testing.T) {
+				registry, _ := backend.open(t)
+				store, ok := registry.(GitHubStore)
+				require.True(t, ok, "registry must implement GitHubStore")
+				testGitHubConformance(t, store)
+			})
+		})
+	}
+}
+
+func testNodeConformance(t *testing.T, registry Registry) {
+	require.Error(t, registry.Register(&Node{Name: "no-id"}))
+
+	require.NoError(t, registry.Register(&Node{
+		ID:           "node-1",
+		Name:         "builder",
+		Provider:     "lxc",
+		Status:       "online",
+		Address:      "10.0.0.1",
+		Port:         8080,
+		Labels:       map[string]string{"region": "hk"},
+		Capabilities: map[string]interface{}{"docker": true},
+	}))
+	require.NoError(t, registry.Register(&Node{ID: "node-2", Name: "runner", Status: "online"}))
+
+	node, err := registry.Get("node-1")
+	require.NoError(t, err)
+	assert.Equal(t, "builder", node.Name)
+	assert.Equal(t, "lxc", node.Provider)
+	assert.Equal(t, "10.0.0.1", node.Address)
+	assert.Equal(t, 8080, node.Port)
+	assert.Equal(t, "hk", node.Labels["region"])
+	assert.Equal(t, true, node.Capabilities["docker"])
+	assert.False(t, node.LastSeen.IsZero())
+
+	nodes, err := registry.List()
+	require.NoError(t, err)
+	assert.Len(t, nodes, 2)
+
+	capacity := &NodeCapacity{Total: NodeResources{CPU: 8}, Allocatable: NodeResources{CPU: 6}, FreeSSHPorts: 70}
+	require.NoError(t, registry.Update("node-1", map[string]interface{}{
+		"port":     9090,
+		"labels":   map[string]string{"region": "sg"},
+		"capacity": capacity,
+	}))
+	require.NoError(t, registry.SetStatus("node-1", "offline"))
+	assert.Error(t, registry.Update("missing", map[string]interface{}{"status": "online"}))
+
+	node, err = registry.Get("node-1")
+	require.NoError(t, err)
+	assert.Equal(t, "offline", node.Status)
+	assert.Equal(t, 9090, node.Port)
+	assert.Equal(t, capacity, node.Capacity)
+
+	// Conditional updates only apply while the node's last heartbeat is unchanged
+	stale := node.LastSeen.Add(-time.Minute)
+	err = registry.Update("node-1", map[string]interface{}{"status": "gone", "if_last_seen": stale})
+	assert.ErrorIs(t, err, ErrNodeChanged)
+	require.NoError(t, registry.Update("node-1", map[string]interface{}{"status": "online", "last_seen": node.LastSeen, "if_last_seen": node.LastSeen}))
+	node, err = registry.Get("node-1")
+	require.NoError(t, err)
+	assert.Equal(t, "online", node.Status)
+	require.NoError(t, registry.SetStatus("node-1", "offline"))
+
+	byLabel, err := registry.GetByLabel("region", "sg")
+	require.NoError(t, err)
+	require.Len(t, byLabel, 1)
+	assert.Equal(t, "node-1", byLabel[0].ID)
+
+	byCapability, err := registry.GetByCapability("docker")
+	require.NoError(t, err)
+	require.Len(t, byCapability, 1)
+	assert.Equal(t, "node-1", byCapability[0].ID)
+
+	require.NoError(t, registry.Unregister("node-1"))
+	_, err = registry.Get("node-1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "node not found")
+}
+
+func testUserConformance(t *testing.T, users UserRegistry) {
+	require.Error(t, users.Register(&User{ID: "no-name"}))
+
+	alice := &User{Username: "alice", PublicKey: "ssh-ed25519 AAAA alice", WorkspaceID: "ws-1"}
+	require.NoError(t, users.Register(alice))
+	assert.NotEmpty(t, alice.ID)
+	require.NoError(t, users.Register(&User{ID: "user-bob", Username: "bob", WorkspaceID: "ws-2"}))
+
+	user, err := users.GetByUsername("alice")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, user.ID)
+	assert.Equal(t, "ssh-ed25519 AAAA alice", user.PublicKey)
+	assert.Equal(t, RoleDeveloper, user.Role)
+
+	require.Error(t, users.Register(&User{Username: "mallory", Role: "root"}))
+	err = users.Register(&User{ID: "user-mallory", Username: "alice", Role: RoleAdmin})
+	assert.ErrorIs(t, err, ErrUserExists, "usernames are unique")
+	err = users.Register(&User{ID: "user-bob", Username: "mallory", Role: RoleAdmin})
+	assert.ErrorIs(t, err, ErrUserExists, "IDs are unique")
+	user, err = users.GetByUsername("alice")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, user.ID, "a refused registration leaves the user untouched")
+	assert.Equal(t, RoleDeveloper, user.Role)
+	_, err = users.GetByUsername("mallory")
+	assert.Error(t, err)
+
+	require.NoError(t, users.Register(&User{ID: "sub-carol", Issuer: "https://issuer.example.com", Username: "carol"}))
+	user, err = users.GetBySubject("https://issuer.example.com", "sub-carol")
+	require.NoError(t, err)
+	assert.Equal(t, "carol", user.Username)
+	assert.Equal(t, "https://issuer.example.com", user.Issuer)
+	_, err = users.GetBySubject("https://other.example.com", "sub-carol")
+	assert.Error(t, err, "subjects are scoped to their issuer")
+	user, err = users.GetBySubject("https://issuer.example.com", "user-bob")
+	require.NoError(t, err, "users without an issuer match any issuer")
+	assert.Equal(t, "bob", user.Username)
+	_, err = users.GetBySubject("https://issuer.example.com", "bob")
+	assert.Error(t, err, "usernames are not subjects")
+
+	require.NoError(t, users.SetRole("alice", RoleOperator))
+	require.Error(t, users.SetRole("alice", "root"))
+	assert.Error(t, users.SetRole("missing", RoleViewer))
+
+	user, err = users.GetByUsername("alice")
+	require.NoError(t, err)
+	assert.Equal(t, RoleOperator, user.Role)
+
+	inWorkspace, err := users.GetByWorkspace("ws-2")
+	require.NoError(t, err)
+	require.Len(t, inWorkspace, 1)
+	assert.Equal(t, "user-bob", inWorkspace[0].ID)
+
+	all, err := users.List()
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	require.NoError(t, users.Delete("alice"))
+	_, err = users.GetByUsername("alice")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "user not found")
+}
+
+func testWorkspaceConformance(t *testing.T, workspaces WorkspaceRegistry) {
+	commit := "abc123"
+	require.NoError(t, workspaces.Create(&DBWorkspace{
+		WorkspaceID:   "ws-1",
+		UserID:        "alice",
+		WorkspaceName: "feature",
+		Status:        "creating",
+		Provider:      "lxc",
+		RepoOwner:     "oursky",
+		RepoName:      "epson-eshop",
+		RepoBranch:    "main",
+		RepoCommit:    &commit,
+		Stateless:     true,
+		CPU:           2,
+		MemoryMB:      4096,
+		DiskGB:        20,
+	}))
+	require.NoError(t, workspaces.Create(&DBWorkspace{WorkspaceID: "ws-2", UserID: "bob", WorkspaceName: "bugfix", Status: "running"}))
+
+	err := workspaces.Create(&DBWorkspace{WorkspaceID: "ws-1", UserID: "alice", WorkspaceName: "dup"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "workspace already exists")
+	require.Error(t, workspaces.Create(&DBWorkspace{WorkspaceName: "no-id"}))
+
+	ws, err := workspaces.Get("ws-1")
+	require.NoError(t, err)
+	assert.Equal(t, "feature", ws.WorkspaceName)
+	assert.Equal(t, "oursky", ws.RepoOwner)
+	require.NotNil(t, ws.RepoCommit)
+	assert.Equal(t, commit, *ws.RepoCommit)
+	assert.True(t, ws.Stateless)
+	assert.Equal(t, 2, ws.CPU)
+	assert.Equal(t, int64(4096), ws.MemoryMB)
+	assert.Equal(t, int64(20), ws.DiskGB)
+	assert.Nil(t, ws.SSHPort)
+
+	ws, err = workspaces.GetByUserAndName("bob", "bugfix")
+	require.NoError(t, err)
+	assert.Equal(t, "ws-2", ws.WorkspaceID)
+	_, err = workspaces.GetByUserAndName("bob", "feature")
+	assert.Error(t, err)
+
+	byUser, err := workspaces.ListByUser("alice")
+	require.NoError(t, err)
+	require.Len(t, byUser, 1)
+	assert.Equal(t, "ws-1", byUser[0].WorkspaceID)
+
+	all, err := workspaces.List()
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	require.NoError(t, workspaces.UpdateStatus("ws-1", "running"))
+	require.NoError(t, workspaces.UpdateSSHPort("ws-1", 2222, "10.0.0.1"))
+	require.NoError(t, workspaces.Update("ws-1", map[string]interface{}{"node_id": "node-1"}))
+	assert.Error(t, workspaces.UpdateStatus("missing", "running"))
+
+	activeAt := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	keepAlive := activeAt.Add(8 * time.Hour)
+	require.NoError(t, workspaces.Update("ws-1", map[string]interface{}{"last_activity_at": activeAt, "keep_alive_until": keepAlive}))
+
+	ws, err = workspaces.Get("ws-1")
+	require.NoError(t, err)
+	assert.Equal(t, "running", ws.Status)
+	require.NotNil(t, ws.SSHPort)
+	assert.Equal(t, 2222, *ws.SSHPort)
+	require.NotNil(t, ws.SSHHost)
+	assert.Equal(t, "10.0.0.1", *ws.SSHHost)
+	require.NotNil(t, ws.NodeID)
+	assert.Equal(t, "node-1", *ws.NodeID)
+	require.NotNil(t, ws.LastActivity)
+	assert.True(t, activeAt.Equal(*ws.LastActivity))
+	require.NotNil(t, ws.KeepAliveUntil)
+	assert.True(t, keepAlive.Equal(*ws.KeepAliveUntil))
+
+	require.NoError(t, workspaces.Delete("ws-1"))
+	_, err = workspaces.Get("ws-1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "workspace not found")
+}
+
+func testServiceConformance(t *testing.T, workspaces WorkspaceRegistry) {
+	require.NoError(t, workspaces.Create(&DBWorkspace{WorkspaceID: "ws-1", UserID: "alice", WorkspaceName: "feature", Status: "running"}))
+
+	require.Error(t, workspaces.SaveService(&DBService{WorkspaceID: "ws-1", ServiceName: "web"}))
+
+	web := &DBService{
+		ServiceID: "ws-1-web", WorkspaceID: "ws-1", ServiceName: "web",
+		Command: "npm start", Port: 3000, Status: "pending", DependsOn: []string{"db"},
+	}
+	require.NoError(t, workspaces.SaveService(web))
+	require.NoError(t, workspaces.SaveService(&DBService{
+		ServiceID: "ws-1-db", WorkspaceID: "ws-1", ServiceName: "db",
+		Command: "postgres", Port: 5432, Status: "running",
+	}))
+
+	web.Status = "running"
+	localPort := 33000
+	web.LocalPort = &localPort
+	require.NoError(t, workspaces.SaveService(web))
+
+	services, err := workspaces.ListServices("ws-1")
+	require.NoError(t, err)
+	require.Len(t, services, 2)
+	assert.Equal(t, "db", services[0].ServiceName)
+	assert.Equal(t, "web", services[1].ServiceName)
+	assert.Equal(t, "running", services[1].Status)
+	assert.Equal(t, []string{"db"}, services[1].DependsOn)
+	require.NotNil(t, services[1].LocalPort)
+	assert.Equal(t, 33000, *services[1].LocalPort)
+
+	require.NoError(t, workspaces.Delete("ws-1"))
+	services, err = workspaces.ListServices("ws-1")
+	require.NoError(t, err)
+	assert.Empty(t, services)
+}
+
+func testGrantConformance(t *testing.T, workspaces WorkspaceRegistry) {
+	require.NoError(t, workspaces.Create(&DBWorkspace{WorkspaceID: "ws-1", UserID: "alice", WorkspaceName: "feature", Status: "running"}))
+
+	require.Error(t, workspaces.SaveGrant(&WorkspaceGrant{WorkspaceID: "missing", Username: "bob", Role: GrantViewer}))
+	require.Error(t, workspaces.SaveGrant(&WorkspaceGrant{WorkspaceID: "ws-1", Username: "bob", Role: "owner"}))
+
+	require.NoError(t, workspaces.SaveGrant(&WorkspaceGrant{WorkspaceID: "ws-1", Username: "carol", Role: GrantViewer, GrantedBy: "alice"}))
+	require.NoError(t, workspaces.SaveGrant(&WorkspaceGrant{WorkspaceID: "ws-1", Username: "bob", Role: GrantViewer}))
+	require.NoError(t, workspaces.SaveGrant(&WorkspaceGrant{WorkspaceID: "ws-1", Username: "bob", Role: GrantCollaborator}))
+
+	grant, err := workspaces.GetGrant("ws-1", "bob")
+	require.NoError(t, err)
+	assert.Equal(t, GrantCollaborator, grant.Role)
+	assert.False(t, grant.CreatedAt.IsZero())
+	_, err = workspaces.GetGrant("ws-1", "dave")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "grant not found")
+
+	grants, err := workspaces.ListGrants("ws-1")
+	require.NoError(t, err)
+	require.Len(t, grants, 2)
+	assert.Equal(t, "bob", grants[0].Username)
+	assert.Equal(t, "carol", grants[1].Username)
+	assert.Equal(t, "alice", grants[1].GrantedBy)
+
+	require.NoError(t, workspaces.DeleteGrant("ws-1", "carol"))
+	assert.Error(t, workspaces.DeleteGrant("ws-1", "carol"))
+
+	require.NoError(t, workspaces.Delete("ws-1"))
+	grants, err = workspaces.ListGrants("ws-1")
+	require.NoError(t, err)
+	assert.Empty(t, grants)
+}
+
+func testAPITokenConformance(t *testing.T, store APITokenStore) {
+	require.Error(t, store.CreateAPIToken(&APIToken{ID: "tok-0", Username: "alice", Name: "ci", TokenHash: "h0"}))
+	require.Error(t, store.CreateAPIToken(&APIToken{ID: "tok-0", Username: "alice", Name: "ci", TokenHash: "h0", Scopes: []string{"users:admin"}}))
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	require.NoError(t, store.CreateAPIToken(&APIToken{
+		ID: "tok-1", Username: "alice", Name: "ci", Prefix: "nxp_1234", TokenHash: "h1",
+		Scopes: []string{ActionWorkspacesRead, ActionNodesAdmin}, ExpiresAt: &expires,
+	}))
+	require.NoError(t, store.CreateAPIToken(&APIToken{ID: "tok-2", Username: "alice", Name: "backup", TokenHash: "h2", Scopes: []string{ActionWorkspacesRead}}))
+	require.NoError(t, store.CreateAPIToken(&APIToken{ID: "tok-3", Username: "bob", Name: "ci", TokenHash: "h3", Scopes: []string{ActionNodesRead}}))
+
+	err := store.CreateAPIToken(&APIToken{ID: "tok-4", Username: "alice", Name: "ci", TokenHash: "h4", Scopes: []string{ActionNodesRead}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+
+	token, err := store.GetAPITokenByHash("h1")
+	require.NoError(t, err)
+	assert.Equal(t, "tok-1", token.ID)
+	assert.Equal(t, "nxp_1234", token.Prefix)
+	assert.Equal(t, []string{ActionWorkspacesRead, ActionNodesAdmin}, token.Scopes)
+	require.NotNil(t, token.ExpiresAt)
+	assert.True(t, expires.Equal(*token.ExpiresAt))
+	assert.Nil(t, token.LastUsedAt)
+	_, err = store.GetAPITokenByHash("missing")
+	assert.Error(t, err)
+
+	usedAt := time.Now().Truncate(time.Second)
+	require.NoError(t, store.TouchAPIToken("tok-1", usedAt))
+	assert.Error(t, store.TouchAPIToken("missing", usedAt))
+	token, err = store.GetAPIToken("tok-1")
+	require.NoError(t, err)
+	require.NotNil(t, token.LastUsedAt)
+	assert.True(t, usedAt.Equal(*token.LastUsedAt))
+
+	tokens, err := store.ListAPITokens("alice")
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, "backup", tokens[0].Name)
+	assert.Equal(t, "ci", tokens[1].Name)
+	tokens, err = store.ListAPITokens("")
+	require.NoError(t, err)
+	assert.Len(t, tokens, 3)
+
+	require.NoError(t, store.DeleteAPIToken("tok-1"))
+	assert.Error(t, store.DeleteAPIToken("tok-1"))
+	_, err = store.GetAPIToken("tok-1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "API token not found")
+}
+
+func testNodeCredentialConformance(t *testing.T, store NodeCredentialStore) {
+	now := time.Now().Truncate(time.Second)
+	require.Error(t, store.CreateEnrollmentToken(&EnrollmentToken{ID: "enr-0"}))
+	require.NoError(t, store.CreateEnrollmentToken(&EnrollmentToken{ID: "enr-1", TokenHash: "e1", ExpiresAt: now.Add(time.Hour), CreatedBy: "admin"}))
+	require.NoError(t, store.CreateEnrollmentToken(&EnrollmentToken{ID: "enr-2", TokenHash: "e2", NodeID: "node-2", ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, store.CreateEnrollmentToken(&EnrollmentToken{ID: "enr-3", TokenHash: "e3", ExpiresAt: now.Add(-time.Minute)}))
+
+	token, err := store.GetEnrollmentTokenByHash("e1")
+	require.NoError(t, err)
+	assert.Equal(t, "enr-1", token.ID)
+	assert.Equal(t, "admin", token.CreatedBy)
+	assert.Nil(t, token.UsedAt)
+	_, err = store.GetEnrollmentTokenByHash("missing")
+	assert.Error(t, err)
+
+	token, err = store.ConsumeEnrollmentToken("enr-1", "node-1", now)
+	require.NoError(t, err)
+	assert.Equal(t, "node-1", token.UsedBy)
+	_, err = store.ConsumeEnrollmentToken("enr-1", "node-1", now)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already used")
+	_, err = store.ConsumeEnrollmentToken("enr-2", "node-1", now)
+	assert.Error(t, err, "bound tokens only enroll their node")
+	_, err = store.ConsumeEnrollmentToken("enr-3", "node-3", now)
+	assert.Error(t, err, "expired tokens cannot enroll")
+	_, err = store.ConsumeEnrollmentToken("missing", "node-1", now)
+	assert.Error(t, err)
+
+	token, err = store.GetEnrollmentTokenByHash("e1")
+	require.NoError(t, err)
+	require.NotNil(t, token.UsedAt)
+	assert.True(t, now.Equal(*token.UsedAt))
+
+	tokens, err := store.ListEnrollmentTokens()
+	require.NoError(t, err)
+	assert.Len(t, tokens, 3)
+	require.NoError(t, store.DeleteEnrollmentToken("enr-3"))
+	assert.Error(t, store.DeleteEnrollmentToken("enr-3"))
+
+	require.Error(t, store.SaveNodeCredential(&NodeCredential{NodeID: "node-1"}))
+	require.NoError(t, store.SaveNodeCredential(&NodeCredential{NodeID: "node-1", SecretHash: "s1", IssuedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	credential, err := store.GetNodeCredentialByHash("s1")
+	require.NoError(t, err)
+	assert.Equal(t, "node-1", credential.NodeID)
+	assert.Empty(t, credential.PreviousHash)
+	assert.Nil(t, credential.PreviousExpiresAt)
+
+	graceEnds := now.Add(time.Minute)
+	require.NoError(t, store.SaveNodeCredential(&NodeCredential{
+		NodeID: "node-1", SecretHash: "s2", PreviousHash: "s1", PreviousExpiresAt: &graceEnds,
+		IssuedAt: now, ExpiresAt: now.Add(2 * time.Hour),
+	}))
+	credential, err = store.GetNodeCredential("node-1")
+	require.NoError(t, err)
+	assert.Equal(t, "s2", credential.SecretHash)
+	assert.True(t, now.Add(2*time.Hour).Equal(credential.ExpiresAt))
+	require.NotNil(t, credential.PreviousExpiresAt)
+	assert.True(t, graceEnds.Equal(*credential.PreviousExpiresAt))
+	credential, err = store.GetNodeCredentialByHash("s1")
+	require.NoError(t, err, "the previous secret still resolves")
+	assert.Equal(t, "node-1", credential.NodeID)
+
+	require.NoError(t, store.DeleteNodeCredential("node-1"))
+	assert.Error(t, store.DeleteNodeCredential("node-1"))
+	_, err = store.GetNodeCredentialByHash("s2")
+	assert.Error(t, err)
+	_, err = store.GetNodeCredential("node-1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "node credential not found")
+}
+
+func testAuditConformance(t *testing.T, store AuditStore) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	entries := []*AuditEntry{
+		{Timestamp: base, Actor: "alice", ActorKind: PrincipalUser, Action: "workspaces.delete", Resource: "workspaces/ws-1",
+			Method: http.MethodDelete, Path: "/api/v1/workspaces/ws-1", RequestID: "req-1", SourceIP: "10.0.0.1", Status: 204, Result: AuditResultSuccess, DurationMs: 12},
+		{Timestamp: base.Add(time.Minute), Actor: "bob", ActorKind: PrincipalUser, Action: "workspaces.grants", Resource: "workspaces/ws-10",
+			Method: http.MethodPost, Path: "/api/v1/workspaces/ws-10/grants", RequestID: "req-2", SourceIP: "10.0.0.2", Status: 403, Result: AuditResultDenied},
+		{Timestamp: base.Add(2 * time.Minute), Actor: "admin", ActorKind: PrincipalService, Action: "nodes.commands", Resource: "nodes/node-1",
+			Method: http.MethodPost, Path: "/api/v1/nodes/node-1/commands/run", RequestID: "req-3", SourceIP: "10.0.0.3", Status: 200, Result: AuditResultSuccess},
+		{Timestamp: base.Add(3 * time.Minute), Actor: "alice", ActorKind: PrincipalUser, Action: "workspaces.stop", Resource: "workspaces/ws-1",
+			Method: http.MethodPost, Path: "/api/v1/workspaces/ws-1/stop", RequestID: "req-4", SourceIP: "10.0.0.1", Status: 500, Result: AuditResultFailure},
+	}
+	for _, entry := range entries {
+		require.NoError(t, store.AppendAudit(entry))
+		assert.NotZero(t, entry.ID)
+	}
+	assert.Less(t, entries[0].ID, entries[1].ID)
+
+	all, err := store.ListAudit(AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 4)
+	assert.Equal(t, "req-4", all[0].RequestID, "newest first")
+	assert.Equal(t, "req-1", all[3].RequestID)
+	assert.Equal(t, "workspaces/ws-1", all[3].Resource)
+	assert.Equal(t, "10.0.0.1", all[3].SourceIP)
+	assert.Equal(t, 204, all[3].Status)
+	assert.Equal(t, int64(12), all[3].DurationMs)
+	assert.True(t, base.Equal(all[3].Timestamp))
+
+	filtered, err := store.ListAudit(AuditFilter{Actor: "alice"})
+	require.NoError(t, err)
+	assert.Len(t, filtered, 2)
+	filtered, err = store.ListAudit(AuditFilter{Resource: "workspaces/ws-1"})
+	require.NoError(t, err)
+	assert.Len(t, filtered, 2, "resource filters do not match workspaces/ws-10")
+	filtered, err = store.ListAudit(AuditFilter{Resource: "nodes"})
+	require.NoError(t, err)
+	assert.Len(t, filtered, 1, "resource filters match nested resources")
+	filtered, err = store.ListAudit(AuditFilter{Action: "workspaces.delete", Result: AuditResultSuccess})
+	require.NoError(t, err)
+	assert.Len(t, filtered, 1)
+	filtered, err = store.ListAudit(AuditFilter{Since: base.Add(time.Minute), Until: base.Add(3 * time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, filtered, 2)
+	assert.Equal(t, "req-3", filtered[0].RequestID)
+	filtered, err = store.ListAudit(AuditFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, filtered, 1)
+	assert.Equal(t, "req-4", filtered[0].RequestID)
+}
+
+func testGitHubConformance(t *testing.T, store GitHubStore) {
+	require.NoError(t, store.StoreGitHubInstallation(&GitHubInstallation{
+		InstallationID: 42, UserID: "alice", GitHubUserID: 1, GitHubUsername: "alice",
+		RepoFullName: "oursky/epson-eshop", Token: "gho_first", TokenExpiresAt: time.Now().Add(time.Hour),
+	}))
+	require.NoError(t, store.StoreGitHubInstallation(&GitHubInstallation{
+		InstallationID: 42, UserID: "alice", GitHubUserID: 1, GitHubUsername: "alice",
+		RepoFullName: "oursky/epson-eshop", Token: "gho_second", TokenExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	installation, err := store.GetGitHubInstallation("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "gho_second", installation.Token)
+	assert.Equal(t, int64(42), installation.InstallationID)
+
+	installations, err := store.ListGitHubInstallations()
+	require.NoError(t, err)
+	assert.Len(t, installations, 1)
+
+	fork := &GitHubFork{
+		UserID: "alice", OriginalOwner: "oursky", OriginalRepo: "epson-eshop",
+		ForkOwner: "alice", ForkURL: "https://github.com/alice/epson-eshop.git", CreatedAt: time.Now(),
+	}
+	require.NoError(t, store.StoreGitHubFork(fork))
+	require.NoError(t, store.StoreGitHubFork(fork))
+
+	retrieved, err := store.GetGitHubFork("alice", "oursky", "epson-eshop")
+	require.NoError(t, err)
+	assert.Equal(t, fork.ForkURL, retrieved.ForkURL)
+	_, err = store.GetGitHubFork("alice", "oursky", "missing")
+	assert.Error(t, err)
+
+	forks, err := store.ListGitHubForks()
+	require.NoError(t, err)
+	assert.Len(t, forks, 1)
+
+	require.NoError(t, store.DeleteGitHubInstallation("alice"))
+	_, err = store.GetGitHubInstallation("alice")
+	assert.Error(t, err)
+}
+
+func TestDialectRebind(t *testing.T) {
+	query := "SELECT id FROM nodes WHERE status = ? AND name <> '?' AND port = ?"
+	assert.Equal(t, query, dialectSQLite.rebind(query))
+	assert.Equal(t, "SELECT id FROM nodes WHERE status = $1 AND name <> '?' AND port = $2", dialectPostgres.rebind(query))
+}
+
+func TestMigrationsForDialect(t *testing.T) {
+	for _, migration := range Migrations() {
+		sqlite := migration.forDialect(dialectSQLite)
+		postgres := migration.forDialect(dialectPostgres)
+
+		assert.Equal(t, migration.Up, sqlite.Up, "migration %d", migration.Version)
+		assert.NotContains(t, postgres.Up, "DATETIME", "migration %d uses a SQLite-only type on PostgreSQL", migration.Version)
+		assert.Equal(t, sqlite.Version, postgres.Version)
+		assert.Equal(t, sqlite.Name, postgres.Name)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build postgres
+
+package coordination
+
+// Building with -tags postgres makes the storage conformance suite run against
+// PostgreSQL, failing when no server is configured
+func init() {
+	requirePostgres = true
+}
@@ -0,0 +1,281 @@
+package coordination
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APITokenPrefix marks personal API tokens so they are not sent to the identity provider
+const APITokenPrefix = "nxp_"
+
+// apiTokenTouchInterval limits how often last-used timestamps are written back
+const apiTokenTouchInterval = time.Minute
+
+// APITokenScopes are the scopes a personal API token can be issued with
+var APITokenScopes = []string{ActionWorkspacesRead, ActionWorkspacesWrite, ActionNodesRead, ActionNodesAdmin}
+
+// impliedScopes lists the scope each scope includes
+var impliedScopes = map[string]string{
+	ActionWorkspacesWrite: ActionWorkspacesRead,
+	ActionNodesAdmin:      ActionNodesRead,
+}
+
+// IsValidScope reports whether scope can be granted to an API token
+func IsValidScope(scope string) bool {
+	for _, s := range APITokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// scopesAllow reports whether a set of token scopes covers action. A nil set is unrestricted.
+func scopesAllow(scopes []string, action string) bool {
+	if scopes == nil {
+		return true
+	}
+	for _, scope := range scopes {
+		if scope == action || impliedScopes[scope] == action {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAPIToken returns the digest under which a token secret is stored
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateAPIToken returns a new random token secret and its ID
+func generateAPIToken() (secret, id string, err error) {
+	buf := make([]byte, 40)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return APITokenPrefix + hex.EncodeToString(buf[:32]), "tok_" + hex.EncodeToString(buf[32:]), nil
+}
+
+// apiTokenStore returns the token store of the registry, if it has one
+func (s *Server) apiTokenStore() (APITokenStore, bool) {
+	store, ok := s.registry.(APITokenStore)
+	return store, ok
+}
+
+// authenticateAPIToken resolves a personal API token to a principal limited to the token's scopes
+func (s *Server) authenticateAPIToken(secret string) (*Principal, error) {
+	store, ok := s.apiTokenStore()
+	if !ok {
+		return nil, fmt.Errorf("API tokens are not supported by this registry")
+	}
+
+	token, err := store.GetAPITokenByHash(hashAPIToken(secret))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	now := time.Now()
+	if token.Expired(now) {
+		return nil, fmt.Errorf("token expired")
+	}
+
+	user, err := s.registry.GetUserRegistry().GetByUsername(token.Username)
+	if err != nil {
+		return nil, fmt.Errorf("token owner %s no longer exists", token.Username)
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= apiTokenTouchInterval {
+		if err := store.TouchAPIToken(token.ID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Principal{
+		Kind:     PrincipalUser,
+		Subject:  user.ID,
+		Username: user.Username,
+		UserID:   user.ID,
+		Scopes:   token.Scopes,
+		TokenID:  token.ID,
+	}, nil
+}
+
+// CreateAPITokenRequest is the body of POST /api/v1/tokens
+type CreateAPITokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expires_in,omitempty"` // Go duration, e.g. "720h"; empty never expires
+	Username  string   `json:"username,omitempty"`   // admins may issue tokens for other users
+}
+
+// CreateAPITokenResponse carries the token secret, which is only ever returned once
+type CreateAPITokenResponse struct {
+	Token    string    `json:"token"`
+	APIToken *APIToken `json:"api_token"`
+}
+
+// APITokenListResponse is the body of GET /api/v1/tokens
+type APITokenListResponse struct {
+	Tokens []*APIToken `json:"tokens"`
+	Count  int         `json:"count"`
+}
+
+// handleTokensRequest routes /api/v1/tokens and /api/v1/tokens/{id}
+func (s *Server) handleTokensRequest(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.apiTokenStore()
+	if !ok {
+		sendM4JSONError(w, http.StatusNotImplemented, "not_supported", "API tokens are not supported by this registry", nil)
+		return
+	}
+
+	// Tokens cannot mint or revoke tokens, so a leaked token cannot entrench itself
+	if p := s.caller(r); p != nil && p.Scopes != nil {
+		sendM4JSONError(w, http.StatusForbidden, "forbidden", "API tokens cannot manage API tokens", nil)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/tokens"), "/")
+	if strings.Contains(id, "/") {
+		http.Error(w, "Invalid endpoint", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		s.handleCreateAPIToken(w, r, store)
+	case r.Method == http.MethodGet && id == "":
+		s.handleListAPITokens(w, r, store)
+	case r.Method == http.MethodDelete && id != "":
+		s.handleDeleteAPIToken(w, r, store, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// tokenOwner resolves whose tokens a request manages: the caller's own, or those of
+// the requested user for admins and callers without a user identity
+func (s *Server) tokenOwner(w http.ResponseWriter, r *http.Request, requested string) (string, bool) {
+	p := s.caller(r)
+	if p != nil && p.Kind == PrincipalUser && (requested == "" || requested == p.Username) {
+		return p.Username, true
+	}
+
+	if requested == "" {
+		sendM4JSONError(w, http.StatusBadRequest, "missing_username", "Username required", nil)
+		return "", false
+	}
+	if !s.authorize(w, r, ActionUsersAdmin) {
+		return "", false
+	}
+	return requested, true
+}
+
+func (s *Server) handleCreateAPIToken(w http.ResponseWriter, r *http.Request, store APITokenStore) {
+	var req CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body", map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	username, ok := s.tokenOwner(w, r, req.Username)
+	if !ok {
+		return
+	}
+	if _, err := s.registry.GetUserRegistry().GetByUsername(username); err != nil {
+		sendM4JSONError(w, http.StatusBadRequest, "user_not_found", fmt.Sprintf("User not registered: %s", username), nil)
+		return
+	}
+
+	secret, id, err := generateAPIToken()
+	if err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "token_generation_failed", err.Error(), nil)
+		return
+	}
+
+	token := &APIToken{
+		ID:        id,
+		Username:  username,
+		Name:      req.Name,
+		Prefix:    secret[:len(APITokenPrefix)+8],
+		TokenHash: hashAPIToken(secret),
+		Scopes:    req.Scopes,
+	}
+	if req.ExpiresIn != "" {
+		ttl, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			sendM4JSONError(w, http.StatusBadRequest, "invalid_expiry", fmt.Sprintf("Invalid expires_in: %s", req.ExpiresIn), nil)
+			return
+		}
+		expiresAt := time.Now().Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := token.Validate(); err != nil {
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_token", err.Error(), map[string]interface{}{
+			"allowed_scopes": APITokenScopes,
+		})
+		return
+	}
+	if err := store.CreateAPIToken(token); err != nil {
+		sendM4JSONError(w, http.StatusConflict, "token_exists", err.Error(), nil)
+		return
+	}
+
+	s.broadcastEvent("api_token_created", map[string]interface{}{
+		"id":       token.ID,
+		"username": token.Username,
+		"name":     token.Name,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPITokenResponse{Token: secret, APIToken: token})
+}
+
+func (s *Server) handleListAPITokens(w http.ResponseWriter, r *http.Request, store APITokenStore) {
+	username, ok := s.tokenOwner(w, r, r.URL.Query().Get("username"))
+	if !ok {
+		return
+	}
+
+	tokens, err := store.ListAPITokens(username)
+	if err != nil {
+		sendM4JSONError(w, http.StatusInternalServerError, "list_failed", fmt.Sprintf("Failed to list API tokens: %v", err), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APITokenListResponse{Tokens: tokens, Count: len(tokens)})
+}
+
+func (s *Server) handleDeleteAPIToken(w http.ResponseWriter, r *http.Request, store APITokenStore, id string) {
+	token, err := store.GetAPIToken(id)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "token_not_found", err.Error(), nil)
+		return
+	}
+
+	if !s.isCaller(r, token.Username) && !s.authorize(w, r, ActionUsersAdmin) {
+		return
+	}
+
+	if err := store.DeleteAPIToken(id); err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "token_not_found", err.Error(), nil)
+		return
+	}
+
+	s.broadcastEvent("api_token_revoked", map[string]interface{}{
+		"id":       token.ID,
+		"username": token.Username,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,124 @@
+package coordination
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestAPIToken(t *testing.T, srv *Server, bearer string, req CreateAPITokenRequest) CreateAPITokenResponse {
+	w := rbacRequest(t, srv, bearer, http.MethodPost, "/api/v1/tokens", req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var resp CreateAPITokenResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	return resp
+}
+
+func TestAPITokenLifecycle(t *testing.T) {
+	srv := newRBACTestServer(t)
+
+	created := createTestAPIToken(t, srv, "alice-token", CreateAPITokenRequest{
+		Name:      "ci",
+		Scopes:    []string{ActionWorkspacesRead},
+		ExpiresIn: "24h",
+	})
+	assert.True(t, strings.HasPrefix(created.Token, APITokenPrefix))
+	assert.True(t, strings.HasPrefix(created.Token, created.APIToken.Prefix))
+	assert.Equal(t, "alice", created.APIToken.Username)
+	require.NotNil(t, created.APIToken.ExpiresAt)
+
+	stored, err := srv.registry.(APITokenStore).GetAPIToken(created.APIToken.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, created.Token, stored.TokenHash, "only the hash is stored")
+	assert.Nil(t, stored.LastUsedAt)
+
+	w := rbacRequest(t, srv, created.Token, http.MethodGet, "/api/v1/workspaces/ws-1/status", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	stored, err = srv.registry.(APITokenStore).GetAPIToken(created.APIToken.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, stored.LastUsedAt, "use is recorded")
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodGet, "/api/v1/tokens", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Tokens []map[string]interface{} `json:"tokens"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	require.Len(t, list.Tokens, 1)
+	assert.NotContains(t, list.Tokens[0], "token_hash")
+
+	w = rbacRequest(t, srv, "bob-token", http.MethodDelete, "/api/v1/tokens/"+created.APIToken.ID, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "users cannot revoke other users' tokens")
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodDelete, "/api/v1/tokens/"+created.APIToken.ID, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = rbacRequest(t, srv, created.Token, http.MethodGet, "/api/v1/workspaces/ws-1/status", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "revoked tokens are rejected immediately")
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	srv := newRBACTestServer(t)
+
+	readOnly := createTestAPIToken(t, srv, "alice-token", CreateAPITokenRequest{Name: "read", Scopes: []string{ActionWorkspacesRead}})
+	w := rbacRequest(t, srv, readOnly.Token, http.MethodGet, "/api/v1/workspaces", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = rbacRequest(t, srv, readOnly.Token, http.MethodPost, "/api/v1/workspaces/ws-1/stop", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "read scope cannot stop workspaces")
+	w = rbacRequest(t, srv, readOnly.Token, http.MethodGet, "/api/v1/nodes", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "token is not scoped for nodes")
+
+	write := createTestAPIToken(t, srv, "alice-token", CreateAPITokenRequest{Name: "write", Scopes: []string{ActionWorkspacesWrite}})
+	w = rbacRequest(t, srv, write.Token, http.MethodPost, "/api/v1/workspaces/ws-1/stop", nil)
+	assert.Equal(t, http.StatusOK, w.Code, "write implies read and operate")
+
+	nodes := createTestAPIToken(t, srv, "alice-token", CreateAPITokenRequest{Name: "nodes", Scopes: []string{ActionNodesAdmin}})
+	w = rbacRequest(t, srv, nodes.Token, http.MethodPost, "/api/v1/nodes", map[string]interface{}{"id": "node-1"})
+	assert.Equal(t, http.StatusForbidden, w.Code, "scopes cannot exceed the developer role")
+
+	w = rbacRequest(t, srv, write.Token, http.MethodPost, "/api/v1/tokens", CreateAPITokenRequest{Name: "more", Scopes: []string{ActionWorkspacesRead}})
+	assert.Equal(t, http.StatusForbidden, w.Code, "tokens cannot mint tokens")
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/tokens", CreateAPITokenRequest{Name: "bad", Scopes: []string{ActionUsersAdmin}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/tokens", CreateAPITokenRequest{Name: "read", Scopes: []string{ActionWorkspacesRead}})
+	assert.Equal(t, http.StatusConflict, w.Code, "names are unique per user")
+}
+
+func TestAPITokenExpiryAndOwner(t *testing.T) {
+	srv := newRBACTestServer(t)
+
+	created := createTestAPIToken(t, srv, rbacAdminToken, CreateAPITokenRequest{
+		Name: "bot", Username: "bob", Scopes: []string{ActionWorkspacesRead},
+	})
+	assert.Equal(t, "bob", created.APIToken.Username, "admins issue tokens for other users")
+
+	w := rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/tokens", CreateAPITokenRequest{
+		Name: "bot", Username: "bob", Scopes: []string{ActionWorkspacesRead},
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	store := srv.registry.(APITokenStore)
+	token, err := store.GetAPIToken(created.APIToken.ID)
+	require.NoError(t, err)
+	expired := time.Now().Add(-time.Minute)
+	token.ExpiresAt = &expired
+
+	w = rbacRequest(t, srv, created.Token, http.MethodGet, "/api/v1/workspaces", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	token.ExpiresAt = nil
+	w = rbacRequest(t, srv, created.Token, http.MethodGet, "/api/v1/workspaces", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodDelete, "/api/v1/users/bob", nil)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = rbacRequest(t, srv, created.Token, http.MethodGet, "/api/v1/workspaces", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "deleting a user revokes their tokens")
+}
@@ -0,0 +1,205 @@
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Git operations a node can run in a workspace's checkout
+const (
+	GitStatus   = "status"
+	GitFetch    = "fetch"
+	GitCheckout = "checkout"
+	GitPull     = "pull"
+	GitPush     = "push"
+)
+
+// gitCommandTimeout bounds how long a git operation may run on the node
+const gitCommandTimeout = 2 * time.Minute
+
+// WorkspaceGitRequest is the body of POST /api/v1/workspaces/{id}/git
+type WorkspaceGitRequest struct {
+	Operation string `json:"operation"`        // status, fetch, checkout, pull or push
+	Branch    string `json:"branch,omitempty"` // required for checkout
+	Remote    string `json:"remote,omitempty"` // defaults to origin
+	Create    bool   `json:"create,omitempty"` // checkout -b
+}
+
+// CommandGitHubToken is the body of GET /api/v1/commands/{id}/github-token
+type CommandGitHubToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// WorkspaceGitResult reports a git operation run in a workspace
+type WorkspaceGitResult struct {
+	WorkspaceID string        `json:"workspace_id"`
+	NodeID      string        `json:"node_id"`
+	Operation   string        `json:"operation"`
+	Status      string        `json:"status"` // success, failed or timeout
+	Output      string        `json:"output"`
+	Error       string        `json:"error,omitempty"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// validate checks the request and fills in defaults
+func (r *WorkspaceGitRequest) validate() error {
+	switch r.Operation {
+	case GitStatus, GitFetch, GitPull, GitPush:
+	case GitCheckout:
+		if r.Branch == "" {
+			return fmt.Errorf("checkout requires a branch")
+		}
+	default:
+		return fmt.Errorf("unknown git operation %q", r.Operation)
+	}
+	if r.Create && r.Operation != GitCheckout {
+		return fmt.Errorf("create only applies to checkout")
+	}
+	if r.Remote == "" {
+		r.Remote = "origin"
+	}
+	for _, ref := range []string{r.Branch, r.Remote} {
+		if !validGitRef(ref) {
+			return fmt.Errorf("invalid ref %q", ref)
+		}
+	}
+	return nil
+}
+
+// validGitRef rejects names git would read as options or refuse as refs
+func validGitRef(ref string) bool {
+	if ref == "" {
+		return true
+	}
+	return !strings.HasPrefix(ref, "-") &&
+		!strings.Contains(ref, "..") &&
+		!strings.ContainsAny(ref, " \t\n~^:?*[\\")
+}
+
+// handleWorkspaceGit runs a git operation in a workspace's checkout on its node. The node
+// fetches the owner's GitHub installation token for the remote while running it.
+// POST /api/v1/workspaces/{id}/git
+func (s *Server) handleWorkspaceGit(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req WorkspaceGitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		sendM4JSONError(w, http.StatusBadRequest, "invalid_git_request", err.Error(), nil)
+		return
+	}
+
+	ws, err := s.workspaceRegistry.Get(workspaceID)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "workspace_not_found", fmt.Sprintf("Workspace not found: %s", workspaceID), nil)
+		return
+	}
+	if !s.authorizeWorkspace(w, r, ws, GrantCollaborator) {
+		return
+	}
+	if ws.NodeID == nil || *ws.NodeID == "" {
+		sendM4JSONError(w, http.StatusConflict, "workspace_not_placed", "Workspace is not running on a node", nil)
+		return
+	}
+	nodeID := *ws.NodeID
+
+	command := Command{
+		ID:     fmt.Sprintf("git_%d_%s", time.Now().UnixNano(), workspaceID),
+		Type:   "workspace",
+		Action: "git",
+		Params: map[string]interface{}{
+			"workspace_id": workspaceID,
+			"operation":    req.Operation,
+			"branch":       req.Branch,
+			"remote":       req.Remote,
+			"create":       req.Create,
+		},
+		Timeout: gitCommandTimeout,
+	}
+	if p := s.caller(r); p != nil {
+		command.User = p.Username
+	}
+
+	// Waiting for the node may outlast the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	ctx, cancel := context.WithTimeout(r.Context(), gitCommandTimeout+30*time.Second)
+	defer cancel()
+	result, err := s.dispatchCommand(ctx, nodeID, command)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		sendM4JSONError(w, http.StatusGatewayTimeout, "node_timeout", fmt.Sprintf("Node %s did not report the result in time", nodeID), nil)
+		return
+	case err != nil:
+		// Most likely the caller went away; answer anyway in case it is still listening
+		sendM4JSONError(w, http.StatusBadGateway, "dispatch_failed", fmt.Sprintf("Git %s on node %s did not complete: %v", req.Operation, nodeID, err), nil)
+		return
+	}
+
+	resp := WorkspaceGitResult{
+		WorkspaceID: workspaceID,
+		NodeID:      nodeID,
+		Operation:   req.Operation,
+		Status:      result.Status,
+		Output:      result.Output,
+		Error:       result.Error,
+		Duration:    result.Duration,
+	}
+	log.Printf("Workspace %s: git %s on node %s: %s", workspaceID, req.Operation, nodeID, result.Status)
+	s.broadcastEvent("workspace_git", map[string]interface{}{
+		"workspace_id": workspaceID,
+		"node_id":      nodeID,
+		"operation":    req.Operation,
+		"branch":       req.Branch,
+		"status":       result.Status,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleCommandGitHubToken hands the node running a workspace command the GitHub
+// installation token of the workspace's owner. The token never travels in the command
+// itself, so it cannot be read from queues, results or events, and only the node the
+// command was sent to can fetch it while the command is outstanding.
+// GET /api/v1/commands/{id}/github-token
+func (s *Server) handleCommandGitHubToken(w http.ResponseWriter, r *http.Request, commandID string) {
+	cmd, ok := s.commandQueue.command(commandID)
+	if p := s.caller(r); p != nil && (p.Kind != PrincipalNode || !ok || cmd.Target != p.NodeID) {
+		sendM4JSONError(w, http.StatusForbidden, "forbidden", "Only the node running a command can fetch its GitHub token", nil)
+		return
+	}
+	if !ok || cmd.Type != "workspace" {
+		sendM4JSONError(w, http.StatusNotFound, "command_not_found", fmt.Sprintf("No workspace command %s is outstanding", commandID), nil)
+		return
+	}
+
+	workspaceID, _ := cmd.Params["workspace_id"].(string)
+	ws, err := s.workspaceRegistry.Get(workspaceID)
+	if err != nil {
+		sendM4JSONError(w, http.StatusNotFound, "workspace_not_found", fmt.Sprintf("Workspace not found: %s", workspaceID), nil)
+		return
+	}
+	s.gitHubInstallationsMu.RLock()
+	installation, ok := s.gitHubInstallations[ws.UserID]
+	s.gitHubInstallationsMu.RUnlock()
+	if !ok || installation.Token == "" {
+		sendM4JSONError(w, http.StatusNotFound, "github_token_not_found", fmt.Sprintf("The owner of workspace %s has no GitHub installation", workspaceID), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CommandGitHubToken{Token: installation.Token, ExpiresAt: installation.TokenExpiresAt})
+}
@@ -0,0 +1,136 @@
+package coordination
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandQueue(t *testing.T) {
+	q := newCommandQueue()
+	assert.Nil(t, q.take(context.Background(), "node-1", nil, 10*time.Millisecond), "an empty queue waits out the poll")
+
+	done := make(chan []Command)
+	go func() { done <- q.take(context.Background(), "node-1", nil, 5*time.Second) }()
+	time.Sleep(10 * time.Millisecond)
+	q.enqueue("node-1", Command{ID: "cmd-1"})
+	select {
+	case commands := <-done:
+		require.Len(t, commands, 1)
+		assert.Equal(t, "cmd-1", commands[0].ID)
+	case <-time.After(time.Second):
+		t.Fatal("enqueueing did not wake the waiting poll")
+	}
+
+	q.enqueue("node-1", Command{ID: "cmd-2"})
+	commands := q.take(context.Background(), "node-1", nil, 0)
+	require.Len(t, commands, 2, "commands are delivered again until acknowledged")
+	assert.Equal(t, []string{"cmd-1", "cmd-2"}, []string{commands[0].ID, commands[1].ID})
+	commands = q.take(context.Background(), "node-1", []string{"cmd-1"}, 0)
+	require.Len(t, commands, 1)
+	assert.Equal(t, "cmd-2", commands[0].ID)
+	assert.Nil(t, q.take(context.Background(), "node-1", []string{"cmd-2"}, 0))
+
+	q.enqueue("node-1", Command{ID: "cmd-3"})
+	q.enqueue("node-1", Command{ID: "cmd-4"})
+	q.enqueue("node-2", Command{ID: "cmd-5"})
+	assert.True(t, q.cancel("node-1", "cmd-3"))
+	assert.False(t, q.cancel("node-1", "cmd-3"))
+	require.Len(t, q.take(context.Background(), "node-1", nil, 0), 1)
+	assert.False(t, q.cancel("node-1", "cmd-4"), "cmd-4 was picked up")
+	assert.Nil(t, q.take(context.Background(), "node-1", nil, 0), "withdrawn commands are not delivered again")
+	assert.Len(t, q.take(context.Background(), "node-2", nil, 0), 1, "queues are per node")
+}
+
+func TestWorkspaceGit(t *testing.T) {
+	srv := newRBACTestServer(t)
+	credential := enrollTestNode(t, srv, createTestEnrollmentToken(t, srv, CreateEnrollmentTokenRequest{}), "node-1")
+	require.NoError(t, srv.workspaceRegistry.Update("ws-1", map[string]interface{}{"node_id": "node-1"}))
+	srv.gitHubInstallationsMu.Lock()
+	srv.gitHubInstallations["sub-alice"] = &GitHubInstallation{UserID: "sub-alice", Token: "ghs_secret"}
+	srv.gitHubInstallationsMu.Unlock()
+
+	for _, req := range []WorkspaceGitRequest{
+		{Operation: "rebase"},
+		{Operation: GitCheckout},
+		{Operation: GitPull, Branch: "--upload-pack=touch /tmp/x"},
+		{Operation: GitPush, Branch: "main", Create: true},
+	} {
+		w := rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-1/git", req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "%+v", req)
+	}
+	w := rbacRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/ws-1/git", WorkspaceGitRequest{Operation: GitStatus})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = rbacRequest(t, srv, "bob-token", http.MethodPost, "/api/v1/workspaces/ws-2/git", WorkspaceGitRequest{Operation: GitStatus})
+	assert.Equal(t, http.StatusConflict, w.Code, "ws-2 is not on a node")
+
+	// Play the node: lose the first poll's response, take the command again and report its result
+	go func() {
+		w := rbacRequest(t, srv, credential.Secret, http.MethodGet, "/api/v1/nodes/node-1/commands?wait=5s", nil)
+		if !assert.Equal(t, http.StatusOK, w.Code) {
+			return
+		}
+		w = rbacRequest(t, srv, credential.Secret, http.MethodGet, "/api/v1/nodes/node-1/commands?wait=0s", nil)
+		if !assert.Equal(t, http.StatusOK, w.Code) {
+			return
+		}
+		var polled NodeCommands
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&polled))
+		require.Len(t, polled.Commands, 1, "unacknowledged commands are delivered again")
+		cmd := polled.Commands[0]
+		assert.Equal(t, "workspace", cmd.Type)
+		assert.Equal(t, "git", cmd.Action)
+		assert.Equal(t, "ws-1", cmd.Params["workspace_id"])
+		assert.Equal(t, "origin", cmd.Params["remote"])
+		assert.NotContains(t, w.Body.String(), "ghs_secret", "the token is not queued with the command")
+
+		w = rbacRequest(t, srv, rbacAdminToken, http.MethodGet, "/api/v1/commands/"+cmd.ID+"/github-token", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, "only the node running the command gets the token")
+		w = rbacRequest(t, srv, credential.Secret, http.MethodGet, "/api/v1/commands/"+cmd.ID+"/github-token", nil)
+		if assert.Equal(t, http.StatusOK, w.Code, w.Body.String()) {
+			var token CommandGitHubToken
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&token))
+			assert.Equal(t, "ghs_secret", token.Token, "the node authenticates with the owner's token")
+		}
+
+		rbacRequest(t, srv, credential.Secret, http.MethodPost, "/api/v1/commands/"+cmd.ID+"/result", CommandResult{
+			ID:       cmd.ID,
+			NodeID:   "node-1",
+			Status:   "success",
+			Output:   "Already up to date.\n",
+			Finished: time.Now(),
+		})
+	}()
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodPost, "/api/v1/workspaces/ws-1/git", WorkspaceGitRequest{Operation: GitPull, Branch: "main"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "ghs_secret")
+	var result WorkspaceGitResult
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+	assert.Equal(t, WorkspaceGitResult{WorkspaceID: "ws-1", NodeID: "node-1", Operation: GitPull, Status: "success", Output: "Already up to date.\n"}, result)
+
+	w = rbacRequest(t, srv, "alice-token", http.MethodGet, "/api/v1/nodes/node-1/commands?wait=0s", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "only the node may take its commands")
+	w = rbacRequest(t, srv, rbacAdminToken, http.MethodGet, "/api/v1/nodes/node-1/commands?wait=0s", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "not even admins")
+
+	assert.Empty(t, srv.commandQueue.sent, "finished commands are forgotten")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/workspaces/ws-1/git", strings.NewReader(`{"operation":"status"}`)).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer alice-token")
+	recorder := httptest.NewRecorder()
+	srv.authMiddleware(srv.router).ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadGateway, recorder.Code, "every failure gets a response")
+	assert.Empty(t, srv.commandQueue.pending["node-1"], "the command is withdrawn")
+	w = rbacRequest(t, srv, credential.Secret, http.MethodGet, "/api/v1/commands/git_1_ws-1/github-token", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "tokens are only handed out for outstanding commands")
+}
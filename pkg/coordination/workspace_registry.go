@@ -2,6 +2,7 @@ package coordination
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 )
@@ -16,16 +17,26 @@ type WorkspaceRegistry interface {
 	UpdateStatus(id, status string) error
 	UpdateSSHPort(id string, port int, host string) error
 	Delete(id string) error
+	SaveService(svc *DBService) error
+	ListServices(workspaceID string) ([]*DBService, error)
+	SaveGrant(grant *WorkspaceGrant) error
+	DeleteGrant(workspaceID, username string) error
+	ListGrants(workspaceID string) ([]*WorkspaceGrant, error)
+	GetGrant(workspaceID, username string) (*WorkspaceGrant, error)
 }
 
 type InMemoryWorkspaceRegistry struct {
 	workspaces map[string]*DBWorkspace
+	services   map[string]*DBService
+	grants     map[string]map[string]*WorkspaceGrant // workspace ID -> username -> grant
 	mu         sync.RWMutex
 }
 
 func NewInMemoryWorkspaceRegistry() WorkspaceRegistry {
 	return &InMemoryWorkspaceRegistry{
 		workspaces: make(map[string]*DBWorkspace),
+		services:   make(map[string]*DBService),
+		grants:     make(map[string]map[string]*WorkspaceGrant),
 	}
 }
 
@@ -41,9 +52,14 @@ func (r *InMemoryWorkspaceRegistry) Create(ws *DBWorkspace) error {
 		return fmt.Errorf("workspace already exists: %s", ws.WorkspaceID)
 	}
 
+	// Imported workspaces keep their timestamps, which the lifecycle clocks run from
 	now := time.Now()
-	ws.CreatedAt = now
-	ws.UpdatedAt = now
+	if ws.CreatedAt.IsZero() {
+		ws.CreatedAt = now
+	}
+	if ws.UpdatedAt.IsZero() {
+		ws.UpdatedAt = ws.CreatedAt
+	}
 
 	r.workspaces[ws.WorkspaceID] = ws
 	return nil
@@ -118,6 +134,12 @@ func (r *InMemoryWorkspaceRegistry) Update(id string, updates map[string]interfa
 		case "node_id":
 			nodeID := value.(string)
 			ws.NodeID = &nodeID
+		case "last_activity_at":
+			at := value.(time.Time)
+			ws.LastActivity = &at
+		case "keep_alive_until":
+			until := value.(time.Time)
+			ws.KeepAliveUntil = &until
 		}
 	}
 
@@ -149,5 +171,111 @@ func (r *InMemoryWorkspaceRegistry) Delete(id string) error {
 	defer r.mu.Unlock()
 
 	delete(r.workspaces, id)
+	delete(r.grants, id)
+	for serviceID, svc := range r.services {
+		if svc.WorkspaceID == id {
+			delete(r.services, serviceID)
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryWorkspaceRegistry) SaveService(svc *DBService) error {
+	if err := svc.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if existing, exists := r.services[svc.ServiceID]; exists {
+		svc.CreatedAt = existing.CreatedAt
+	} else if svc.CreatedAt.IsZero() {
+		svc.CreatedAt = now
+	}
+	svc.UpdatedAt = now
+
+	r.services[svc.ServiceID] = svc
 	return nil
 }
+
+func (r *InMemoryWorkspaceRegistry) ListServices(workspaceID string) ([]*DBService, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	services := make([]*DBService, 0)
+	for _, svc := range r.services {
+		if svc.WorkspaceID == workspaceID {
+			services = append(services, svc)
+		}
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ServiceName < services[j].ServiceName
+	})
+	return services, nil
+}
+
+func (r *InMemoryWorkspaceRegistry) SaveGrant(grant *WorkspaceGrant) error {
+	if err := grant.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.workspaces[grant.WorkspaceID]; !exists {
+		return fmt.Errorf("workspace not found: %s", grant.WorkspaceID)
+	}
+
+	grants, exists := r.grants[grant.WorkspaceID]
+	if !exists {
+		grants = make(map[string]*WorkspaceGrant)
+		r.grants[grant.WorkspaceID] = grants
+	}
+
+	if existing, exists := grants[grant.Username]; exists {
+		grant.CreatedAt = existing.CreatedAt
+	} else if grant.CreatedAt.IsZero() {
+		grant.CreatedAt = time.Now()
+	}
+
+	grants[grant.Username] = grant
+	return nil
+}
+
+func (r *InMemoryWorkspaceRegistry) DeleteGrant(workspaceID, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.grants[workspaceID][username]; !exists {
+		return fmt.Errorf("grant not found: %s on %s", username, workspaceID)
+	}
+	delete(r.grants[workspaceID], username)
+	return nil
+}
+
+func (r *InMemoryWorkspaceRegistry) ListGrants(workspaceID string) ([]*WorkspaceGrant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	grants := make([]*WorkspaceGrant, 0, len(r.grants[workspaceID]))
+	for _, grant := range r.grants[workspaceID] {
+		grants = append(grants, grant)
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].Username < grants[j].Username
+	})
+	return grants, nil
+}
+
+func (r *InMemoryWorkspaceRegistry) GetGrant(workspaceID, username string) (*WorkspaceGrant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	grant, exists := r.grants[workspaceID][username]
+	if !exists {
+		return nil, fmt.Errorf("grant not found: %s on %s", username, workspaceID)
+	}
+	return grant, nil
+}
@@ -0,0 +1,437 @@
+package coordination
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// workspaceColumns lists the workspace columns in the order scanWorkspace expects them
+const workspaceColumns = `id, user_id, workspace_name, status, provider, image,
+	repo_owner, repo_name, repo_url, repo_branch, repo_commit,
+	ssh_port, ssh_host, node_id, stateless, cpu, memory_mb, disk_gb,
+	last_activity_at, keep_alive_until, created_at, updated_at`
+
+// serviceColumns lists the service columns in the order scanService expects them
+const serviceColumns = `id, workspace_id, service_name, command, port, local_port,
+	status, health_status, last_health_check, depends_on, created_at, updated_at`
+
+// grantColumns lists the workspace grant columns in the order scanGrant expects them
+const grantColumns = "workspace_id, username, role, granted_by, created_at"
+
+// SQLWorkspaceRegistry persists workspaces and their services in a SQL database
+type SQLWorkspaceRegistry struct {
+	sqlDB
+	mu sync.RWMutex
+}
+
+// NewSQLiteWorkspaceRegistry creates a workspace registry backed by an already migrated SQLite database
+func NewSQLiteWorkspaceRegistry(db *sql.DB) *SQLWorkspaceRegistry {
+	return newSQLWorkspaceRegistry(db, dialectSQLite)
+}
+
+// NewPostgresWorkspaceRegistry creates a workspace registry backed by an already migrated PostgreSQL database
+func NewPostgresWorkspaceRegistry(db *sql.DB) *SQLWorkspaceRegistry {
+	return newSQLWorkspaceRegistry(db, dialectPostgres)
+}
+
+func newSQLWorkspaceRegistry(db *sql.DB, d dialect) *SQLWorkspaceRegistry {
+	return &SQLWorkspaceRegistry{sqlDB: sqlDB{db: db, dialect: d}}
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWorkspace(row rowScanner) (*DBWorkspace, error) {
+	var ws DBWorkspace
+	var status, provider, image sql.NullString
+	var repoOwner, repoName, repoURL, repoBranch, repoCommit sql.NullString
+	var sshPort sql.NullInt64
+	var sshHost, nodeID sql.NullString
+	var lastActivity, keepAliveUntil sql.NullTime
+
+	err := row.Scan(
+		&ws.WorkspaceID, &ws.UserID, &ws.WorkspaceName, &status, &provider, &image,
+		&repoOwner, &repoName, &repoURL, &repoBranch, &repoCommit,
+		&sshPort, &sshHost, &nodeID, &ws.Stateless, &ws.CPU, &ws.MemoryMB, &ws.DiskGB,
+		&lastActivity, &keepAliveUntil, &ws.CreatedAt, &ws.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ws.Status = status.String
+	ws.Provider = provider.String
+	ws.Image = image.String
+	ws.RepoOwner = repoOwner.String
+	ws.RepoName = repoName.String
+	ws.RepoURL = repoURL.String
+	ws.RepoBranch = repoBranch.String
+	if repoCommit.Valid {
+		ws.RepoCommit = &repoCommit.String
+	}
+	if sshPort.Valid {
+		port := int(sshPort.Int64)
+		ws.SSHPort = &port
+	}
+	if sshHost.Valid {
+		ws.SSHHost = &sshHost.String
+	}
+	if nodeID.Valid {
+		ws.NodeID = &nodeID.String
+	}
+	if lastActivity.Valid {
+		ws.LastActivity = &lastActivity.Time
+	}
+	if keepAliveUntil.Valid {
+		ws.KeepAliveUntil = &keepAliveUntil.Time
+	}
+
+	return &ws, nil
+}
+
+func scanService(row rowScanner) (*DBService, error) {
+	var svc DBService
+	var localPort sql.NullInt64
+	var status, healthStatus, dependsOn sql.NullString
+	var lastHealthCheck sql.NullTime
+
+	err := row.Scan(
+		&svc.ServiceID, &svc.WorkspaceID, &svc.ServiceName, &svc.Command, &svc.Port, &localPort,
+		&status, &healthStatus, &lastHealthCheck, &dependsOn, &svc.CreatedAt, &svc.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.Status = status.String
+	svc.HealthStatus = healthStatus.String
+	svc.DependsOn = ParseDependsOn(dependsOn.String)
+	if localPort.Valid {
+		port := int(localPort.Int64)
+		svc.LocalPort = &port
+	}
+	if lastHealthCheck.Valid {
+		svc.LastHealthCheck = &lastHealthCheck.Time
+	}
+
+	return &svc, nil
+}
+
+func (r *SQLWorkspaceRegistry) Create(ws *DBWorkspace) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ws.WorkspaceID == "" {
+		return fmt.Errorf("workspace ID cannot be empty")
+	}
+
+	var exists int
+	if err := r.queryRow("SELECT COUNT(1) FROM workspaces WHERE id = ?", ws.WorkspaceID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check workspace: %w", err)
+	}
+	if exists > 0 {
+		return fmt.Errorf("workspace already exists: %s", ws.WorkspaceID)
+	}
+
+	// Imported workspaces keep their timestamps, which the lifecycle clocks run from
+	now := time.Now()
+	if ws.CreatedAt.IsZero() {
+		ws.CreatedAt = now
+	}
+	if ws.UpdatedAt.IsZero() {
+		ws.UpdatedAt = ws.CreatedAt
+	}
+
+	_, err := r.exec(`
+		INSERT INTO workspaces (`+workspaceColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, ws.WorkspaceID, ws.UserID, ws.WorkspaceName, ws.Status, ws.Provider, ws.Image,
+		ws.RepoOwner, ws.RepoName, ws.RepoURL, ws.RepoBranch, ws.RepoCommit,
+		ws.SSHPort, ws.SSHHost, ws.NodeID, ws.Stateless, ws.CPU, ws.MemoryMB, ws.DiskGB,
+		ws.LastActivity, ws.KeepAliveUntil, ws.CreatedAt, ws.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLWorkspaceRegistry) Get(id string) (*DBWorkspace, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ws, err := scanWorkspace(r.queryRow("SELECT "+workspaceColumns+" FROM workspaces WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("workspace not found: %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workspace: %w", err)
+	}
+
+	return ws, nil
+}
+
+func (r *SQLWorkspaceRegistry) GetByUserAndName(userID, name string) (*DBWorkspace, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ws, err := scanWorkspace(r.queryRow(
+		"SELECT "+workspaceColumns+" FROM workspaces WHERE user_id = ? AND workspace_name = ?", userID, name))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("workspace not found for user %s: %s", userID, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workspace: %w", err)
+	}
+
+	return ws, nil
+}
+
+func (r *SQLWorkspaceRegistry) List() ([]*DBWorkspace, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.queryWorkspaces("SELECT " + workspaceColumns + " FROM workspaces ORDER BY created_at")
+}
+
+func (r *SQLWorkspaceRegistry) ListByUser(userID string) ([]*DBWorkspace, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.queryWorkspaces("SELECT "+workspaceColumns+" FROM workspaces WHERE user_id = ? ORDER BY created_at", userID)
+}
+
+func (r *SQLWorkspaceRegistry) queryWorkspaces(query string, args ...interface{}) ([]*DBWorkspace, error) {
+	rows, err := r.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+	defer rows.Close()
+
+	workspaces := make([]*DBWorkspace, 0)
+	for rows.Next() {
+		ws, err := scanWorkspace(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan workspace: %w", err)
+		}
+		workspaces = append(workspaces, ws)
+	}
+
+	return workspaces, rows.Err()
+}
+
+func (r *SQLWorkspaceRegistry) Update(id string, updates map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	setClauses := ""
+	args := make([]interface{}, 0, len(updates)+2)
+	for key, value := range updates {
+		switch key {
+		case "status", "ssh_port", "ssh_host", "node_id", "last_activity_at", "keep_alive_until":
+			setClauses += key + " = ?, "
+			args = append(args, value)
+		}
+	}
+	setClauses += "updated_at = ?"
+	args = append(args, time.Now(), id)
+
+	result, err := r.exec("UPDATE workspaces SET "+setClauses+" WHERE id = ?", args...)
+	if err != nil {
+		return fmt.Errorf("failed to update workspace: %w", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("workspace not found: %s", id)
+	}
+
+	return nil
+}
+
+func (r *SQLWorkspaceRegistry) UpdateStatus(id, status string) error {
+	return r.Update(id, map[string]interface{}{"status": status})
+}
+
+func (r *SQLWorkspaceRegistry) UpdateSSHPort(id string, port int, host string) error {
+	return r.Update(id, map[string]interface{}{"ssh_port": port, "ssh_host": host})
+}
+
+func (r *SQLWorkspaceRegistry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(r.dialect.rebind("DELETE FROM services WHERE workspace_id = ?"), id); err != nil {
+		return fmt.Errorf("failed to delete workspace services: %w", err)
+	}
+	if _, err := tx.Exec(r.dialect.rebind("DELETE FROM workspace_grants WHERE workspace_id = ?"), id); err != nil {
+		return fmt.Errorf("failed to delete workspace grants: %w", err)
+	}
+	if _, err := tx.Exec(r.dialect.rebind("DELETE FROM workspaces WHERE id = ?"), id); err != nil {
+		return fmt.Errorf("failed to delete workspace: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLWorkspaceRegistry) SaveService(svc *DBService) error {
+	if err := svc.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if svc.CreatedAt.IsZero() {
+		svc.CreatedAt = now
+	}
+	svc.UpdatedAt = now
+
+	_, err := r.exec(`
+		INSERT INTO services (`+serviceColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			command = excluded.command,
+			port = excluded.port,
+			local_port = excluded.local_port,
+			status = excluded.status,
+			health_status = excluded.health_status,
+			last_health_check = excluded.last_health_check,
+			depends_on = excluded.depends_on,
+			updated_at = excluded.updated_at
+	`, svc.ServiceID, svc.WorkspaceID, svc.ServiceName, svc.Command, svc.Port, svc.LocalPort,
+		svc.Status, svc.HealthStatus, svc.LastHealthCheck, StringifyDependsOn(svc.DependsOn),
+		svc.CreatedAt, svc.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save service: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLWorkspaceRegistry) ListServices(workspaceID string) ([]*DBService, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rows, err := r.query("SELECT "+serviceColumns+" FROM services WHERE workspace_id = ? ORDER BY service_name", workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+	defer rows.Close()
+
+	services := make([]*DBService, 0)
+	for rows.Next() {
+		svc, err := scanService(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan service: %w", err)
+		}
+		services = append(services, svc)
+	}
+
+	return services, rows.Err()
+}
+
+func scanGrant(row rowScanner) (*WorkspaceGrant, error) {
+	var grant WorkspaceGrant
+	var grantedBy sql.NullString
+	if err := row.Scan(&grant.WorkspaceID, &grant.Username, &grant.Role, &grantedBy, &grant.CreatedAt); err != nil {
+		return nil, err
+	}
+	grant.GrantedBy = grantedBy.String
+	return &grant, nil
+}
+
+func (r *SQLWorkspaceRegistry) SaveGrant(grant *WorkspaceGrant) error {
+	if err := grant.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var exists int
+	if err := r.queryRow("SELECT COUNT(1) FROM workspaces WHERE id = ?", grant.WorkspaceID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to get workspace: %w", err)
+	}
+	if exists == 0 {
+		return fmt.Errorf("workspace not found: %s", grant.WorkspaceID)
+	}
+
+	if grant.CreatedAt.IsZero() {
+		grant.CreatedAt = time.Now()
+	}
+
+	_, err := r.exec(`
+		INSERT INTO workspace_grants (`+grantColumns+`)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(workspace_id, username) DO UPDATE SET
+			role = excluded.role,
+			granted_by = excluded.granted_by
+	`, grant.WorkspaceID, grant.Username, grant.Role, grant.GrantedBy, grant.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save grant: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SQLWorkspaceRegistry) DeleteGrant(workspaceID, username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result, err := r.exec("DELETE FROM workspace_grants WHERE workspace_id = ? AND username = ?", workspaceID, username)
+	if err != nil {
+		return fmt.Errorf("failed to delete grant: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("grant not found: %s on %s", username, workspaceID)
+	}
+
+	return nil
+}
+
+func (r *SQLWorkspaceRegistry) ListGrants(workspaceID string) ([]*WorkspaceGrant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rows, err := r.query("SELECT "+grantColumns+" FROM workspace_grants WHERE workspace_id = ? ORDER BY username", workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grants: %w", err)
+	}
+	defer rows.Close()
+
+	grants := make([]*WorkspaceGrant, 0)
+	for rows.Next() {
+		grant, err := scanGrant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan grant: %w", err)
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+func (r *SQLWorkspaceRegistry) GetGrant(workspaceID, username string) (*WorkspaceGrant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	grant, err := scanGrant(r.queryRow("SELECT "+grantColumns+" FROM workspace_grants WHERE workspace_id = ? AND username = ?", workspaceID, username))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("grant not found: %s on %s", username, workspaceID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get grant: %w", err)
+	}
+
+	return grant, nil
+}
@@ -0,0 +1,215 @@
+package coordination
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSQLiteWorkspaceRegistry(t *testing.T) (*SQLRegistry, WorkspaceRegistry) {
+	registry, err := NewSQLiteRegistry(t.TempDir() + "/test.db")
+	require.NoError(t, err)
+	t.Cleanup(func() { registry.Close() })
+	return registry, registry.GetWorkspaceRegistry()
+}
+
+func TestSQLiteWorkspaceRegistry_CreateAndGet(t *testing.T) {
+	_, reg := newTestSQLiteWorkspaceRegistry(t)
+
+	ws := &DBWorkspace{
+		WorkspaceID:   "ws-123",
+		UserID:        "user-1",
+		WorkspaceName: "test",
+		Status:        "creating",
+		Provider:      "lxc",
+		Image:         "ubuntu:22.04",
+		RepoOwner:     "oursky",
+		RepoName:      "epson-eshop",
+		RepoURL:       "https://github.com/oursky/epson-eshop.git",
+		RepoBranch:    "main",
+	}
+	require.NoError(t, reg.Create(ws))
+	assert.False(t, ws.CreatedAt.IsZero())
+
+	err := reg.Create(&DBWorkspace{WorkspaceID: "ws-123", UserID: "user-1", WorkspaceName: "dup"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "workspace already exists")
+
+	err = reg.Create(&DBWorkspace{WorkspaceName: "no-id"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "workspace ID cannot be empty")
+
+	retrieved, err := reg.Get("ws-123")
+	require.NoError(t, err)
+	assert.Equal(t, "test", retrieved.WorkspaceName)
+	assert.Equal(t, "oursky", retrieved.RepoOwner)
+	assert.Nil(t, retrieved.SSHPort)
+	assert.Nil(t, retrieved.NodeID)
+
+	byName, err := reg.GetByUserAndName("user-1", "test")
+	require.NoError(t, err)
+	assert.Equal(t, "ws-123", byName.WorkspaceID)
+
+	_, err = reg.Get("missing")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "workspace not found")
+}
+
+func TestSQLiteWorkspaceRegistry_Update(t *testing.T) {
+	_, reg := newTestSQLiteWorkspaceRegistry(t)
+
+	require.NoError(t, reg.Create(&DBWorkspace{WorkspaceID: "ws-1", UserID: "user-1", WorkspaceName: "one", Status: "creating"}))
+
+	require.NoError(t, reg.UpdateStatus("ws-1", "running"))
+	require.NoError(t, reg.UpdateSSHPort("ws-1", 2222, "node-a.local"))
+	require.NoError(t, reg.Update("ws-1", map[string]interface{}{"node_id": "node-a", "ignored": true}))
+
+	ws, err := reg.Get("ws-1")
+	require.NoError(t, err)
+	assert.Equal(t, "running", ws.Status)
+	require.NotNil(t, ws.SSHPort)
+	assert.Equal(t, 2222, *ws.SSHPort)
+	require.NotNil(t, ws.SSHHost)
+	assert.Equal(t, "node-a.local", *ws.SSHHost)
+	require.NotNil(t, ws.NodeID)
+	assert.Equal(t, "node-a", *ws.NodeID)
+
+	err = reg.UpdateStatus("missing", "running")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "workspace not found")
+}
+
+func TestSQLiteWorkspaceRegistry_ListAndDelete(t *testing.T) {
+	_, reg := newTestSQLiteWorkspaceRegistry(t)
+
+	require.NoError(t, reg.Create(&DBWorkspace{WorkspaceID: "ws-1", UserID: "user-1", WorkspaceName: "one"}))
+	require.NoError(t, reg.Create(&DBWorkspace{WorkspaceID: "ws-2", UserID: "user-1", WorkspaceName: "two"}))
+	require.NoError(t, reg.Create(&DBWorkspace{WorkspaceID: "ws-3", UserID: "user-2", WorkspaceName: "three"}))
+
+	all, err := reg.List()
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	byUser, err := reg.ListByUser("user-1")
+	require.NoError(t, err)
+	assert.Len(t, byUser, 2)
+
+	require.NoError(t, reg.SaveService(&DBService{
+		ServiceID: "ws-1-web", WorkspaceID: "ws-1", ServiceName: "web",
+		Command: "npm start", Port: 3000, Status: "pending",
+	}))
+
+	require.NoError(t, reg.Delete("ws-1"))
+	_, err = reg.Get("ws-1")
+	assert.Error(t, err)
+
+	services, err := reg.ListServices("ws-1")
+	require.NoError(t, err)
+	assert.Empty(t, services)
+}
+
+func TestSQLiteWorkspaceRegistry_Services(t *testing.T) {
+	_, reg := newTestSQLiteWorkspaceRegistry(t)
+
+	require.NoError(t, reg.Create(&DBWorkspace{WorkspaceID: "ws-1", UserID: "user-1", WorkspaceName: "one"}))
+
+	web := &DBService{
+		ServiceID:    "ws-1-web",
+		WorkspaceID:  "ws-1",
+		ServiceName:  "web",
+		Command:      "npm start",
+		Port:         3000,
+		Status:       "pending",
+		HealthStatus: "unknown",
+		DependsOn:    []string{"api", "db"},
+	}
+	require.NoError(t, reg.SaveService(web))
+	require.NoError(t, reg.SaveService(&DBService{
+		ServiceID: "ws-1-api", WorkspaceID: "ws-1", ServiceName: "api",
+		Command: "go run .", Port: 4000, Status: "running",
+	}))
+
+	err := reg.SaveService(&DBService{ServiceID: "bad", WorkspaceID: "ws-1", ServiceName: "bad", Command: "x", Port: 0, Status: "pending"})
+	require.Error(t, err)
+
+	localPort := 23000
+	web.Status = "running"
+	web.HealthStatus = "healthy"
+	web.LocalPort = &localPort
+	require.NoError(t, reg.SaveService(web))
+
+	services, err := reg.ListServices("ws-1")
+	require.NoError(t, err)
+	require.Len(t, services, 2)
+	assert.Equal(t, "api", services[0].ServiceName)
+	assert.Equal(t, "web", services[1].ServiceName)
+	assert.Equal(t, "running", services[1].Status)
+	assert.Equal(t, "healthy", services[1].HealthStatus)
+	assert.Equal(t, []string{"api", "db"}, services[1].DependsOn)
+	require.NotNil(t, services[1].LocalPort)
+	assert.Equal(t, 23000, *services[1].LocalPort)
+}
+
+func TestSQLiteWorkspaceRegistry_Persistence(t *testing.T) {
+	dbFile := t.TempDir() + "/test.db"
+
+	{
+		registry, err := NewSQLiteRegistry(dbFile)
+		require.NoError(t, err)
+		reg := registry.GetWorkspaceRegistry()
+		require.NoError(t, reg.Create(&DBWorkspace{WorkspaceID: "ws-1", UserID: "user-1", WorkspaceName: "one", Status: "running"}))
+		require.NoError(t, reg.SaveService(&DBService{
+			ServiceID: "ws-1-web", WorkspaceID: "ws-1", ServiceName: "web",
+			Command: "npm start", Port: 3000, Status: "running",
+		}))
+		require.NoError(t, registry.Close())
+	}
+
+	{
+		registry, err := NewSQLiteRegistry(dbFile)
+		require.NoError(t, err)
+		defer registry.Close()
+		reg := registry.GetWorkspaceRegistry()
+
+		ws, err := reg.Get("ws-1")
+		require.NoError(t, err)
+		assert.Equal(t, "running", ws.Status)
+
+		services, err := reg.ListServices("ws-1")
+		require.NoError(t, err)
+		assert.Len(t, services, 1)
+	}
+}
+
+func TestNewStorage(t *testing.T) {
+	t.Setenv("DB_PATH", "")
+
+	registry, workspaceRegistry, err := NewStorage(StorageConfig{})
+	require.NoError(t, err)
+	assert.IsType(t, &InMemoryRegistry{}, registry)
+	assert.IsType(t, &InMemoryWorkspaceRegistry{}, workspaceRegistry)
+
+	registry, workspaceRegistry, err = NewStorage(StorageConfig{Type: "sqlite", Path: t.TempDir() + "/nexus.db"})
+	require.NoError(t, err)
+	assert.IsType(t, &SQLRegistry{}, registry)
+	assert.IsType(t, &SQLWorkspaceRegistry{}, workspaceRegistry)
+	registry.(*SQLRegistry).Close()
+
+	dbPath := filepath.Join(t.TempDir(), "data", "nexus.db")
+	t.Setenv("DB_PATH", dbPath)
+	registry, _, err = NewStorage(StorageConfig{Type: "sqlite"})
+	require.NoError(t, err, "without a path the database goes where the server keeps it")
+	registry.(*SQLRegistry).Close()
+	assert.FileExists(t, dbPath)
+
+	t.Setenv("DATABASE_URL", "")
+	_, _, err = NewStorage(StorageConfig{Type: "postgres"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires a dsn")
+
+	_, _, err = NewStorage(StorageConfig{Type: "etcd"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported storage type")
+}
@@ -279,3 +279,28 @@ func TestWorkspaceRegistryUpdateSSHPortNotFound(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "workspace not found")
 }
+
+func TestWorkspaceRegistryServices(t *testing.T) {
+	reg := NewInMemoryWorkspaceRegistry()
+	require.NoError(t, reg.Create(&DBWorkspace{WorkspaceID: "ws-1", UserID: "user-1", WorkspaceName: "one"}))
+
+	require.NoError(t, reg.SaveService(&DBService{
+		ServiceID: "ws-1-web", WorkspaceID: "ws-1", ServiceName: "web",
+		Command: "npm start", Port: 3000, Status: "pending",
+	}))
+	require.NoError(t, reg.SaveService(&DBService{
+		ServiceID: "ws-1-api", WorkspaceID: "ws-1", ServiceName: "api",
+		Command: "go run .", Port: 4000, Status: "running",
+	}))
+	require.Error(t, reg.SaveService(&DBService{ServiceID: "ws-1-bad", WorkspaceID: "ws-1"}))
+
+	services, err := reg.ListServices("ws-1")
+	require.NoError(t, err)
+	require.Len(t, services, 2)
+	assert.Equal(t, "api", services[0].ServiceName)
+
+	require.NoError(t, reg.Delete("ws-1"))
+	services, err = reg.ListServices("ws-1")
+	require.NoError(t, err)
+	assert.Empty(t, services)
+}
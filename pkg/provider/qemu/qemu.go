@@ -233,6 +233,23 @@ func (p *QEMUProvider) List(ctx context.Context) ([]provider.Session, error) {
 	return sessions, nil
 }
 
+// Disks returns the sessions that have VM files under the base directory, whether or not
+// their VM is running
+func (p *QEMUProvider) Disks(ctx context.Context) ([]string, error) {
+	output, err := p.execRemote(ctx, fmt.Sprintf("[ ! -d %s ] || ls -1 %s", p.baseDir, p.baseDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VM directories: %w", err)
+	}
+
+	var sessionIDs []string
+	for _, id := range strings.Split(strings.TrimSpace(output), "\n") {
+		if id != "" {
+			sessionIDs = append(sessionIDs, id)
+		}
+	}
+	return sessionIDs, nil
+}
+
 // Helper methods
 
 func (p *QEMUProvider) execRemote(ctx context.Context, cmd string) (string, error) {
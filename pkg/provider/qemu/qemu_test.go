@@ -394,6 +394,22 @@ func TestQEMUProvider_List_Empty(t *testing.T) {
 	assert.NotNil(t, sessions)
 }
 
+// TestQEMUProvider_Disks tests listing VM directories, including ones without a running VM
+func TestQEMUProvider_Disks(t *testing.T) {
+	ctx := context.Background()
+	p := &QEMUProvider{baseDir: filepath.Join(t.TempDir(), "qemu")}
+
+	disks, err := p.Disks(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, disks, "a missing base directory has no disks")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(p.baseDir, "ws-1"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(p.baseDir, "ws-2"), 0755))
+	disks, err = p.Disks(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ws-1", "ws-2"}, disks)
+}
+
 // TestQEMUProvider_List_WithVMs tests listing with running VMs
 func TestQEMUProvider_List_WithVMs(t *testing.T) {
 	if testing.Short() {